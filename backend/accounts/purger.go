@@ -0,0 +1,58 @@
+// Package accounts runs the background job that permanently deletes accounts
+// once their deletion grace period is over.
+package accounts
+
+import (
+	"context"
+	"log"
+	"tempo-backend/db"
+	"time"
+)
+
+const (
+	// purgeInterval is how often the purger looks for accounts to delete.
+	purgeInterval = time.Hour
+	// purgeBatchSize is how many accounts are deleted in one transaction.
+	purgeBatchSize = 10
+)
+
+// Purger deletes accounts whose deletion is due. Any number of backend
+// instances can run one: each account is only ever claimed by one of them.
+type Purger struct {
+	store *db.UserStore
+}
+
+func NewPurger(store *db.UserStore) *Purger {
+	return &Purger{store: store}
+}
+
+// Run purges due accounts until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		p.purge(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge deletes every account that is due, a batch at a time.
+func (p *Purger) purge(ctx context.Context) {
+	for ctx.Err() == nil {
+		purged, err := p.store.PurgeDeletedUsers(purgeBatchSize)
+		if err != nil {
+			log.Printf("Error purging deleted accounts: %v", err)
+			return
+		}
+		if purged > 0 {
+			log.Printf("Purged %d deleted accounts", purged)
+		}
+		if purged < purgeBatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tempo-backend/db"
+	"tempo-backend/types"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// accessTokenPrefix starts every personal access token, which tells them
+	// apart from access JWTs and makes them easy to spot in secret scanners.
+	accessTokenPrefix = "tempo_pat_"
+	// accessTokenDisplayLength is how much of a token is kept to identify it.
+	accessTokenDisplayLength = len(accessTokenPrefix) + 8
+	// maxAccessTokenDays is the longest lifetime a token with an expiry may have.
+	maxAccessTokenDays = 3650
+)
+
+type AccessTokenHandler struct {
+	store *db.AccessTokenStore
+}
+
+func NewAccessTokenHandler(store *db.AccessTokenStore) *AccessTokenHandler {
+	return &AccessTokenHandler{store: store}
+}
+
+// HandleCreateAccessToken creates a personal access token. The response is
+// the only time the token itself is shown.
+func (h *AccessTokenHandler) HandleCreateAccessToken(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	var payload types.CreateAccessTokenPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" || len(payload.Name) > 100 {
+		return echo.NewHTTPError(http.StatusBadRequest, "name must be between 1 and 100 characters")
+	}
+	if len(payload.Scopes) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "At least one scope is required")
+	}
+	var scopes []string
+	for _, scope := range payload.Scopes {
+		if !slices.Contains(types.AccessTokenScopes, scope) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid scope: "+scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	var expiresAt *time.Time
+	if payload.ExpiresInDays != nil {
+		if *payload.ExpiresInDays < 1 || *payload.ExpiresInDays > maxAccessTokenDays {
+			return echo.NewHTTPError(http.StatusBadRequest, "expiresInDays must be between 1 and "+strconv.Itoa(maxAccessTokenDays))
+		}
+		t := time.Now().AddDate(0, 0, *payload.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		log.Printf("Error generating access token: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create token")
+	}
+	raw := accessTokenPrefix + secret
+
+	token, err := h.store.CreateAccessToken(userID, payload.Name, raw[:accessTokenDisplayLength], hashToken(raw), scopes, expiresAt)
+	if err != nil {
+		log.Printf("Error creating access token: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create token")
+	}
+	return c.JSON(http.StatusCreated, types.CreatedAccessToken{AccessToken: *token, Token: raw})
+}
+
+// HandleGetAccessTokens lists the user's personal access tokens.
+func (h *AccessTokenHandler) HandleGetAccessTokens(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	tokens, err := h.store.GetAccessTokens(userID)
+	if err != nil {
+		log.Printf("Error getting access tokens: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve tokens")
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// HandleDeleteAccessToken revokes one of the user's personal access tokens.
+func (h *AccessTokenHandler) HandleDeleteAccessToken(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	tokenID, err := strconv.Atoi(c.Param("tokenId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid token ID")
+	}
+
+	err = h.store.DeleteAccessToken(tokenID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Token not found")
+	}
+	if err != nil {
+		log.Printf("Error deleting access token: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not revoke token")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"tempo-backend/db"
+	"tempo-backend/types"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// changeEmailTTL is how long the link confirming a new email address works.
+	changeEmailTTL = 24 * time.Hour
+	// accountDeletionGrace is how long a deleted account is kept before it is purged.
+	accountDeletionGrace = 30 * 24 * time.Hour
+)
+
+// localePattern accepts BCP 47 language tags such as "en", "pt-BR" or "zh-Hant-TW".
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// HandleGetProfile returns the user's profile.
+func (h *UserHandler) HandleGetProfile(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	profile, err := h.store.GetProfile(userID)
+	if err != nil {
+		log.Printf("Error getting profile: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve profile")
+	}
+	return c.JSON(http.StatusOK, profile)
+}
+
+// HandleUpdateProfile changes the user's profile. A new email address is
+// sent a confirmation link and only replaces the current one once it is used.
+func (h *UserHandler) HandleUpdateProfile(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	var payload types.UpdateProfilePayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.Username != nil {
+		username := strings.TrimSpace(*payload.Username)
+		if username == "" || len(username) > 100 {
+			return echo.NewHTTPError(http.StatusBadRequest, "username must be between 1 and 100 characters")
+		}
+		payload.Username = &username
+	}
+	if payload.DisplayName != nil {
+		displayName := strings.TrimSpace(*payload.DisplayName)
+		if len(displayName) > 100 {
+			return echo.NewHTTPError(http.StatusBadRequest, "displayName must be at most 100 characters")
+		}
+		payload.DisplayName = &displayName
+	}
+	if payload.Timezone != nil {
+		// "Local" would mean the server's zone.
+		if _, err := time.LoadLocation(*payload.Timezone); err != nil || *payload.Timezone == "" || *payload.Timezone == "Local" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid timezone")
+		}
+	}
+	if payload.Locale != nil && (len(*payload.Locale) > 35 || !localePattern.MatchString(*payload.Locale)) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid locale")
+	}
+
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update profile")
+	}
+	var newEmail string
+	if payload.Email != nil && !strings.EqualFold(strings.TrimSpace(*payload.Email), user.Email) {
+		newEmail = strings.TrimSpace(*payload.Email)
+		if !strings.Contains(newEmail, "@") || len(newEmail) > 255 || strings.ContainsAny(newEmail, " \r\n") {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid email")
+		}
+		inUse, err := h.store.EmailInUse(newEmail, userID)
+		if err != nil {
+			log.Printf("Error checking email: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not update profile")
+		}
+		if inUse {
+			return echo.NewHTTPError(http.StatusConflict, "Email is already in use")
+		}
+	}
+
+	err = h.store.UpdateProfile(userID, payload)
+	if errors.Is(err, db.ErrUsernameTaken) {
+		return echo.NewHTTPError(http.StatusConflict, "Username is already taken")
+	}
+	if err != nil {
+		log.Printf("Error updating profile: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update profile")
+	}
+
+	if newEmail != "" {
+		err = h.sendTokenEmail(user, newEmail, db.TokenPurposeChangeEmail, "change_email", "/confirm-email", changeEmailTTL)
+		if errors.Is(err, db.ErrTokenRateLimited) {
+			return echo.NewHTTPError(http.StatusTooManyRequests, "Too many email changes, try again later")
+		}
+		if err != nil {
+			log.Printf("Error sending email change confirmation: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not send confirmation email")
+		}
+	}
+
+	return h.HandleGetProfile(c)
+}
+
+// HandleConfirmEmailChange makes the new address the user's email with the
+// token from the confirmation email.
+func (h *UserHandler) HandleConfirmEmailChange(c echo.Context) error {
+	var payload types.VerifyEmailPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.Token == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "token is required")
+	}
+
+	err := h.store.ConfirmEmailChange(hashToken(payload.Token))
+	if errors.Is(err, db.ErrInvalidToken) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid or expired token")
+	}
+	if errors.Is(err, db.ErrEmailTaken) {
+		return echo.NewHTTPError(http.StatusConflict, "Email is already in use")
+	}
+	if err != nil {
+		log.Printf("Error confirming email change: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not change email")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleChangePassword sets a new password and logs out the user's other sessions.
+func (h *UserHandler) HandleChangePassword(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	sessionID := c.Get("sessionID").(int)
+
+	var payload types.ChangePasswordPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if len(payload.NewPassword) < minPasswordLength {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+	}
+
+	err := h.store.ChangePassword(userID, sessionID, payload.CurrentPassword, payload.NewPassword)
+	if errors.Is(err, db.ErrWrongPassword) {
+		return echo.NewHTTPError(http.StatusForbidden, "Current password is incorrect")
+	}
+	if err != nil {
+		log.Printf("Error changing password: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not change password")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleDeleteAccount schedules the user's account for deletion. It is purged
+// after a grace period, during which logging in and cancelling keeps it.
+func (h *UserHandler) HandleDeleteAccount(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	sessionID := c.Get("sessionID").(int)
+
+	var payload types.DeleteAccountPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+
+	scheduledAt, err := h.store.ScheduleDeletion(userID, sessionID, payload.Password, accountDeletionGrace)
+	if errors.Is(err, db.ErrWrongPassword) {
+		return echo.NewHTTPError(http.StatusForbidden, "Password is incorrect")
+	}
+	if err != nil {
+		log.Printf("Error scheduling account deletion: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not delete account")
+	}
+	return c.JSON(http.StatusAccepted, types.AccountDeletion{DeletionScheduledAt: scheduledAt})
+}
+
+// HandleCancelDeletion keeps an account that was scheduled for deletion.
+func (h *UserHandler) HandleCancelDeletion(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	err := h.store.CancelDeletion(userID)
+	if errors.Is(err, db.ErrDeletionNotScheduled) {
+		return echo.NewHTTPError(http.StatusNotFound, "Account is not scheduled for deletion")
+	}
+	if err != nil {
+		log.Printf("Error cancelling account deletion: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not cancel account deletion")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -1,66 +1,214 @@
 package api
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
+
+	"tempo-backend/db"
+	"tempo-backend/types"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
 // JWTAuthMiddleware creates an Echo middleware function for JWT authentication.
-func JWTAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		authHeader := c.Request().Header.Get("Authorization")
-		if authHeader == "" {
-			return echo.NewHTTPError(http.StatusUnauthorized, "missing authorization header")
-		}
+// Besides checking the signature it looks up the token's session, so access
+// tokens stop working as soon as their session is revoked.
+func JWTAuthMiddleware(sessions *db.SessionStore) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authHeader := c.Request().Header.Get("Authorization")
+			if authHeader == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing authorization header")
+			}
 
-		headerParts := strings.Split(authHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			return echo.NewHTTPError(http.StatusUnauthorized, "invalid authorization header format")
-		}
+			headerParts := strings.Split(authHeader, " ")
+			if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid authorization header format")
+			}
 
-		tokenString := headerParts[1]
-		token, err := validateJWT(tokenString)
-		if err != nil || !token.Valid {
-			return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
-		}
+			tokenString := headerParts[1]
+			token, err := validateJWT(tokenString)
+			if err != nil || !token.Valid {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+			}
+
+			// Extract claims from the token
+			claims, ok := token.Claims.(jwt.MapClaims)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid token claims")
+			}
+
+			// Extract userID and sessionID and convert them to integers
+			userIDFloat, ok := claims["userID"].(float64)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid user ID in token")
+			}
+			userID := int(userIDFloat)
+
+			sessionIDFloat, ok := claims["sid"].(float64)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid session ID in token")
+			}
+			sessionID := int(sessionIDFloat)
+
+			active, err := sessions.IsSessionActive(sessionID, userID)
+			if err != nil {
+				log.Printf("Error checking session %d: %v", sessionID, err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "could not verify session")
+			}
+			if !active {
+				return echo.NewHTTPError(http.StatusUnauthorized, "session has been revoked")
+			}
 
-		// Extract claims from the token
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return echo.NewHTTPError(http.StatusUnauthorized, "invalid token claims")
+			// Set the userID and sessionID in the context for downstream handlers
+			c.Set("userID", userID)
+			c.Set("sessionID", sessionID)
+			if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+				c.Set("tokenExpiresAt", exp.Time)
+			}
+
+			return next(c)
 		}
+	}
+}
+
+// ScopedAuthMiddleware authenticates like JWTAuthMiddleware, and also accepts
+// personal access tokens whose scopes cover every one of resources ("todos",
+// "notes" or "journal"): read access for GET and HEAD requests, write access
+// for anything else. Routes without it can't be used with access tokens.
+func ScopedAuthMiddleware(sessions *db.SessionStore, tokens *db.AccessTokenStore, resources ...string) echo.MiddlewareFunc {
+	sessionAuth := JWTAuthMiddleware(sessions)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withSession := sessionAuth(next)
+		return func(c echo.Context) error {
+			raw, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+			if !ok || !strings.HasPrefix(raw, accessTokenPrefix) {
+				return withSession(c)
+			}
+
+			token, err := tokens.AuthenticateAccessToken(hashToken(raw))
+			if errors.Is(err, db.ErrNotFound) {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+			}
+			if err != nil {
+				log.Printf("Error authenticating access token: %v", err)
+				return echo.NewHTTPError(http.StatusInternalServerError, "could not verify token")
+			}
 
-		// Extract userID and convert it to an integer
-		userIDFloat, ok := claims["userID"].(float64)
-		if !ok {
-			return echo.NewHTTPError(http.StatusUnauthorized, "invalid user ID in token")
+			access := ":write"
+			if method := c.Request().Method; method == http.MethodGet || method == http.MethodHead {
+				access = ":read"
+			}
+			for _, resource := range resources {
+				if !types.HasScope(token.Scopes, resource+access) {
+					return echo.NewHTTPError(http.StatusForbidden, "token lacks the "+resource+access+" scope")
+				}
+			}
+
+			c.Set("userID", token.UserID)
+			c.Set("accessTokenID", token.ID)
+			if token.ExpiresAt != nil {
+				c.Set("tokenExpiresAt", *token.ExpiresAt)
+			}
+			return next(c)
 		}
-		userID := int(userIDFloat)
+	}
+}
 
-		// Set the userID in the context for downstream handlers
-		c.Set("userID", userID)
+// TokenFromQuery lets clients that can't set request headers, such as the
+// browser's EventSource and WebSocket, pass their access token as
+// ?access_token=. It goes in front of JWTAuthMiddleware, and only on routes
+// that need it, since URLs are more likely than headers to end up in logs.
+func TokenFromQuery(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if token := c.QueryParam("access_token"); token != "" && c.Request().Header.Get("Authorization") == "" {
+			c.Request().Header.Set("Authorization", "Bearer "+token)
+		}
+		return next(c)
+	}
+}
 
+// RedactAccessToken hides ?access_token= in the request URI that
+// middleware.Logger() writes to the log. It goes right after the logger; the
+// token can still be read with QueryParam, which uses the parsed URL.
+func RedactAccessToken(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if query := req.URL.Query(); query.Has("access_token") {
+			query.Set("access_token", "REDACTED")
+			redacted := *req.URL
+			redacted.RawQuery = query.Encode()
+			req.RequestURI = redacted.RequestURI()
+		}
 		return next(c)
 	}
 }
 
-// validateJWT parses and validates a JWT token string.
-func validateJWT(tokenString string) (*jwt.Token, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
+// jwtSecret returns the HMAC key used to sign access tokens.
+func jwtSecret() []byte {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
 		log.Fatal("JWT_SECRET environment variable is not set")
 	}
+	return []byte(secret)
+}
 
+// validateJWT parses and validates a JWT token string.
+func validateJWT(tokenString string) (*jwt.Token, error) {
 	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(jwtSecret), nil
+		return jwtSecret(), nil
 	})
 }
+
+// generateAccessToken signs a short-lived access token bound to a session.
+func generateAccessToken(userID, sessionID int) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["userID"] = userID
+	claims["sid"] = sessionID
+	claims["exp"] = time.Now().Add(accessTokenTTL).Unix()
+	return token.SignedString(jwtSecret())
+}
+
+// generateRefreshToken returns a random opaque refresh token and the hash to store for it.
+func generateRefreshToken() (token, hash string, err error) {
+	token, err = randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	return token, hashToken(token), nil
+}
+
+// randomToken returns 32 random bytes as unpadded base64url.
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the hex SHA-256 of a token. Refresh tokens are high-entropy,
+// so a fast unsalted hash is enough to keep them useless if the table leaks.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
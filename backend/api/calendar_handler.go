@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"tempo-backend/db"
+	"tempo-backend/ical"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// calendarFeedPrefix starts every calendar feed token.
+	calendarFeedPrefix = "tempo_cal_"
+	// calendarFeedDisplayLength is how much of a feed token is kept to identify it.
+	calendarFeedDisplayLength = len(calendarFeedPrefix) + 8
+)
+
+type CalendarHandler struct {
+	store   *db.CalendarStore
+	baseURL string // The API's public URL, which feed URLs start with
+}
+
+func NewCalendarHandler(store *db.CalendarStore, baseURL string) *CalendarHandler {
+	return &CalendarHandler{store: store, baseURL: baseURL}
+}
+
+// HandleCreateCalendarFeed gives the user a new secret feed URL, replacing
+// any they had. The response is the only time the URL is shown.
+func (h *CalendarHandler) HandleCreateCalendarFeed(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	secret, err := randomToken()
+	if err != nil {
+		log.Printf("Error generating calendar feed token: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create calendar feed")
+	}
+	token := calendarFeedPrefix + secret
+
+	feed, err := h.store.CreateCalendarFeed(userID, token[:calendarFeedDisplayLength], hashToken(token))
+	if err != nil {
+		log.Printf("Error creating calendar feed: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create calendar feed")
+	}
+	feed.URL = h.baseURL + "/ical/" + token + "/tasks.ics"
+	return c.JSON(http.StatusCreated, feed)
+}
+
+// HandleGetCalendarFeed returns the user's calendar feed, without its URL.
+func (h *CalendarHandler) HandleGetCalendarFeed(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	feed, err := h.store.GetCalendarFeed(userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "No calendar feed")
+	}
+	if err != nil {
+		log.Printf("Error getting calendar feed: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve calendar feed")
+	}
+	return c.JSON(http.StatusOK, feed)
+}
+
+// HandleDeleteCalendarFeed turns off the user's calendar feed.
+func (h *CalendarHandler) HandleDeleteCalendarFeed(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	err := h.store.DeleteCalendarFeed(userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "No calendar feed")
+	}
+	if err != nil {
+		log.Printf("Error deleting calendar feed: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not delete calendar feed")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleCalendarFeed serves the calendar of the user whose feed token is in
+// the URL, for calendar apps that can't log in. Items with due dates are
+// events, or tasks with ?type=todo; ?journal=1 adds journal entries.
+func (h *CalendarHandler) HandleCalendarFeed(c echo.Context) error {
+	var asTasks bool
+	switch c.QueryParam("type") {
+	case "", "event":
+	case "todo":
+		asTasks = true
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "type must be event or todo")
+	}
+	includeJournal := c.QueryParam("journal") == "1" || c.QueryParam("journal") == "true"
+
+	userID, err := h.store.AuthenticateCalendarFeed(hashToken(c.Param("token")))
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Calendar not found")
+	}
+	if err != nil {
+		log.Printf("Error authenticating calendar feed: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve calendar")
+	}
+	cal, err := h.store.GetCalendar(userID, includeJournal)
+	if err != nil {
+		log.Printf("Error getting calendar: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve calendar")
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/calendar; charset=utf-8")
+	res.Header().Set(echo.HeaderContentDisposition, `inline; filename="tasks.ics"`)
+	res.Header().Set("Cache-Control", "private, max-age=300")
+	res.WriteHeader(http.StatusOK)
+	if err := ical.WriteFeed(res, cal, asTasks, time.Now()); err != nil {
+		log.Printf("Error writing calendar: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"tempo-backend/types"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Entity tags are the record's version number, bumped by the store on every
+// update. Clients send the tag back in If-Match to have a write refused with
+// 412 if someone else got there first, and in If-None-Match to skip
+// re-downloading a record that hasn't changed.
+const (
+	headerETag        = "ETag"
+	headerIfMatch     = "If-Match"
+	headerIfNoneMatch = "If-None-Match"
+)
+
+func versionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatchVersion returns the version a write is conditional on, or nil if the
+// request has no If-Match header or matches any version with "*". A header
+// that isn't one of our tags yields a version that never matches.
+func ifMatchVersion(c echo.Context) *int {
+	header := strings.TrimSpace(c.Request().Header.Get(headerIfMatch))
+	if header == "" || header == "*" {
+		return nil
+	}
+	version := -1
+	tag := strings.TrimSpace(strings.Split(header, ",")[0])
+	if v, err := strconv.Atoi(strings.Trim(tag, `"`)); err == nil && strings.HasPrefix(tag, `"`) {
+		version = v
+	}
+	return &version
+}
+
+// respondWithETag writes body as JSON tagged with etag, or 304 Not Modified
+// when a GET's If-None-Match already holds that tag.
+func respondWithETag(c echo.Context, status int, etag string, body interface{}) error {
+	c.Response().Header().Set(headerETag, etag)
+	if c.Request().Method == http.MethodGet && etagListMatches(c.Request().Header.Get(headerIfNoneMatch), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSON(status, body)
+}
+
+// preconditionFailed answers a stale write with 412 and the server's current
+// copy of the record, so the client can merge and retry.
+func preconditionFailed(c echo.Context, current interface{}, version int) error {
+	c.Response().Header().Set(headerETag, versionETag(version))
+	return c.JSON(http.StatusPreconditionFailed, current)
+}
+
+// etagListMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison the header calls for.
+func etagListMatches(header, etag string) bool {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return false
+	}
+	if header == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// listETag tags a list together with its items, so the tag changes whenever
+// any item is added, removed or updated. items must be the flat list; the tag
+// is weak because their layout depends on the nest option.
+func listETag(list *types.TodoList, items []types.TodoItem, nested bool) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d:%t", list.Version, nested)
+	for _, item := range items {
+		fmt.Fprintf(h, ";%d:%d", item.ID, item.Version)
+	}
+	return fmt.Sprintf(`W/"%d-%x"`, list.Version, h.Sum64())
+}
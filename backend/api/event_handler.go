@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"tempo-backend/db"
+	"tempo-backend/events"
+	"tempo-backend/types"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// eventHeartbeat is how often an idle stream sends something, so proxies
+	// don't time it out and dead connections are noticed.
+	eventHeartbeat = 25 * time.Second
+	// eventWriteTimeout drops a client that stops reading instead of letting
+	// it hold the stream open forever.
+	eventWriteTimeout = 10 * time.Second
+	// eventBatchSize is how many changes are read at a time when catching up.
+	eventBatchSize = 100
+)
+
+// EventHandler streams changes to the user's lists, items, notes and journal
+// entries as they happen. Events come from the sync feed, so a client that
+// reconnects with the cursor of the last event it saw misses nothing.
+type EventHandler struct {
+	hub      *events.Hub
+	sync     *db.SyncStore
+	sessions *db.SessionStore
+	tokens   *db.AccessTokenStore
+}
+
+func NewEventHandler(hub *events.Hub, sync *db.SyncStore, sessions *db.SessionStore, tokens *db.AccessTokenStore) *EventHandler {
+	return &EventHandler{hub: hub, sync: sync, sessions: sessions, tokens: tokens}
+}
+
+// eventSink is where a stream writes: an SSE response or a WebSocket.
+type eventSink interface {
+	send(event types.ChangeEvent) error
+	heartbeat() error
+}
+
+// HandleEvents streams changes as Server-Sent Events. The stream resumes after
+// the Last-Event-ID header (or ?lastEventId=) if given, else starts from now.
+func (h *EventHandler) HandleEvents(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	lastEventID := c.Request().Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.QueryParam("lastEventId")
+	}
+
+	wake, unsubscribe := h.hub.Subscribe(userID)
+	defer unsubscribe()
+	cursor, err := h.startCursor(userID, lastEventID)
+	if err != nil {
+		return err
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no") // Stop nginx from buffering the stream
+	res.WriteHeader(http.StatusOK)
+
+	sink := &sseSink{res: res, rc: http.NewResponseController(res)}
+	if err := sink.write("retry: 3000\n\n"); err != nil {
+		return nil
+	}
+	h.stream(c.Request().Context(), userID, cursor, wake, h.stillAuthorized(c), sink)
+	return nil
+}
+
+// HandleEventsWebSocket streams the same events over a WebSocket, one JSON
+// message per event. Resume with ?lastEventId=.
+func (h *EventHandler) HandleEventsWebSocket(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	authorized := h.stillAuthorized(c)
+
+	wake, unsubscribe := h.hub.Subscribe(userID)
+	defer unsubscribe()
+	cursor, err := h.startCursor(userID, c.QueryParam("lastEventId"))
+	if err != nil {
+		return err
+	}
+
+	server := websocket.Server{
+		// The default handshake insists on an Origin header. Requests here
+		// are authenticated by token rather than cookie, so any origin will do.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			ctx, cancel := context.WithCancel(c.Request().Context())
+			defer cancel()
+			// Clients don't send anything; reading just notices when they go away.
+			go func() {
+				io.Copy(io.Discard, ws)
+				cancel()
+			}()
+			h.stream(ctx, userID, cursor, wake, authorized, &wsSink{ws: ws})
+		},
+	}
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// startCursor validates a client's resume cursor, or returns one for the
+// user's latest change if there isn't one.
+func (h *EventHandler) startCursor(userID int, lastEventID string) (string, error) {
+	if lastEventID != "" {
+		// Reading nothing checks the cursor without sending anything yet.
+		if _, _, err := h.sync.GetChangeEvents(userID, lastEventID, 0); errors.Is(err, db.ErrInvalidSyncCursor) {
+			return "", echo.NewHTTPError(http.StatusBadRequest, "Invalid Last-Event-ID")
+		}
+		return lastEventID, nil
+	}
+	cursor, err := h.sync.GetLatestCursor(userID)
+	if err != nil {
+		log.Printf("Error getting latest sync cursor: %v", err)
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Could not start event stream")
+	}
+	return cursor, nil
+}
+
+// stillAuthorized returns a check that the credentials a stream was opened
+// with still hold: the token hasn't expired, and its session or personal
+// access token hasn't been revoked. A stream outlives the request that
+// authenticated it, so it runs the check on every heartbeat.
+func (h *EventHandler) stillAuthorized(c echo.Context) func() (bool, error) {
+	userID := c.Get("userID").(int)
+	expiresAt, _ := c.Get("tokenExpiresAt").(time.Time)
+	sessionID, isSession := c.Get("sessionID").(int)
+	tokenID, _ := c.Get("accessTokenID").(int)
+	return func() (bool, error) {
+		if !expiresAt.IsZero() && !time.Now().Before(expiresAt) {
+			return false, nil
+		}
+		if isSession {
+			return h.sessions.IsSessionActive(sessionID, userID)
+		}
+		return h.tokens.IsAccessTokenActive(tokenID, userID)
+	}
+}
+
+// stream sends every change after cursor, then waits to be woken for more,
+// until ctx ends, the client can't keep up or authorized reports that its
+// credentials no longer hold. The client then reconnects with fresh ones.
+func (h *EventHandler) stream(ctx context.Context, userID int, cursor string, wake <-chan struct{},
+	authorized func() (bool, error), sink eventSink) {
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		for more := true; more; {
+			var batch []types.ChangeEvent
+			var err error
+			batch, more, err = h.sync.GetChangeEvents(userID, cursor, eventBatchSize)
+			if err != nil {
+				// The client reconnects and resumes from the last event it got.
+				log.Printf("Error reading change events: %v", err)
+				return
+			}
+			for _, event := range batch {
+				if err := sink.send(event); err != nil {
+					return
+				}
+				cursor = event.Cursor
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-heartbeat.C:
+			ok, err := authorized()
+			if err != nil {
+				log.Printf("Error checking event stream credentials: %v", err)
+			}
+			if !ok {
+				return
+			}
+			if err := sink.heartbeat(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+type sseSink struct {
+	res *echo.Response
+	rc  *http.ResponseController
+}
+
+func (s *sseSink) send(event types.ChangeEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.write(fmt.Sprintf("id: %s\nevent: change\ndata: %s\n\n", event.Cursor, data))
+}
+
+func (s *sseSink) heartbeat() error {
+	return s.write(": heartbeat\n\n")
+}
+
+func (s *sseSink) write(chunk string) error {
+	if err := s.rc.SetWriteDeadline(time.Now().Add(eventWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if _, err := io.WriteString(s.res, chunk); err != nil {
+		return err
+	}
+	s.res.Flush()
+	return nil
+}
+
+type wsSink struct {
+	ws *websocket.Conn
+}
+
+func (s *wsSink) send(event types.ChangeEvent) error {
+	s.ws.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+	return websocket.JSON.Send(s.ws, event)
+}
+
+func (s *wsSink) heartbeat() error {
+	s.ws.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+	s.ws.PayloadType = websocket.PingFrame
+	defer func() { s.ws.PayloadType = websocket.TextFrame }()
+	_, err := s.ws.Write(nil)
+	return err
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"tempo-backend/db"
+	"tempo-backend/exports"
+	"tempo-backend/types"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ExportHandler struct {
+	store  *db.ExportStore
+	worker *exports.Worker
+}
+
+func NewExportHandler(store *db.ExportStore, worker *exports.Worker) *ExportHandler {
+	return &ExportHandler{store: store, worker: worker}
+}
+
+// HandleCreateExport queues an export of everything the user owns. Only one
+// export is built at a time; asking again while one is returns that one.
+func (h *ExportHandler) HandleCreateExport(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	export, err := h.store.CreateExport(userID)
+	if err != nil {
+		log.Printf("Error creating export: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not start export")
+	}
+	h.worker.Wake()
+	return c.JSON(http.StatusAccepted, export)
+}
+
+// HandleGetExport returns an export's status or, with ?download=1 or an
+// Accept header asking for a ZIP, streams its archive once it is ready.
+func (h *ExportHandler) HandleGetExport(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	exportID, err := strconv.Atoi(c.Param("exportId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid export ID")
+	}
+
+	export, err := h.store.GetExport(exportID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Export not found")
+	}
+	if err != nil {
+		log.Printf("Error getting export: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve export")
+	}
+
+	download := c.QueryParam("download") != "" || strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "application/zip")
+	if !download {
+		return c.JSON(http.StatusOK, export)
+	}
+	if export.Status != types.ExportStatusReady {
+		return echo.NewHTTPError(http.StatusConflict, "Export is not ready")
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/zip")
+	res.Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="tempo-export-%s.zip"`, export.CreatedAt.UTC().Format("2006-01-02")))
+	res.Header().Set(echo.HeaderContentLength, strconv.FormatInt(*export.SizeBytes, 10))
+	res.WriteHeader(http.StatusOK)
+	if err := h.store.StreamExport(export.ID, res); err != nil {
+		// The status is already sent; the short body tells the client it failed.
+		log.Printf("Error streaming export %d: %v", export.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"tempo-backend/db"
+	"tempo-backend/imports"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxImportUploadSize is the largest archive that can be uploaded for import.
+const maxImportUploadSize = 200 << 20
+
+// maxCalendarUploadSize is the largest iCalendar file that can be uploaded for import.
+const maxCalendarUploadSize = 10 << 20
+
+type ImportHandler struct {
+	store *db.ImportStore
+}
+
+func NewImportHandler(store *db.ImportStore) *ImportHandler {
+	return &ImportHandler{store: store}
+}
+
+// HandleImportMarkdown imports a ZIP archive of Markdown files as notes. The
+// archive is sent as the "file" field of a multipart form or as the body
+// itself. ?notebookId= imports it inside one of the user's notebooks.
+func (h *ImportHandler) HandleImportMarkdown(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	var notebookID *int
+	if s := c.QueryParam("notebookId"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid notebook ID")
+		}
+		notebookID = &id
+	}
+
+	archive, size, cleanup, err := receiveArchive(c)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Archive is too large")
+	}
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	zr, err := zip.NewReader(archive, size)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Upload must be a ZIP archive")
+	}
+	report, err := imports.ImportMarkdown(h.store, userID, notebookID, zr)
+	if errors.Is(err, db.ErrNotebookNotFound) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Notebook not found")
+	}
+	if errors.Is(err, imports.ErrTooManyFiles) || errors.Is(err, imports.ErrImportTooLarge) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Archive is too large: "+err.Error())
+	}
+	if err != nil {
+		log.Printf("Error importing Markdown: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not import notes")
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// HandleImportCalendar imports the events and tasks of an iCalendar (.ics)
+// file as to-do items. The file is sent as the "file" field of a multipart
+// form or as the body itself. ?listId= imports it into one of the user's
+// lists; otherwise a new list is created.
+func (h *ImportHandler) HandleImportCalendar(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	var listID *int
+	if s := c.QueryParam("listId"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid list ID")
+		}
+		listID = &id
+	}
+
+	data, filename, err := receiveCalendar(c)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Calendar is too large")
+	}
+	if err != nil {
+		return err
+	}
+
+	report, err := imports.ImportCalendar(h.store, userID, listID, bytes.NewReader(data), filename)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusBadRequest, "List not found")
+	}
+	if errors.Is(err, imports.ErrInvalidCalendar) || errors.Is(err, imports.ErrTooManyEntries) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Could not import calendar: "+err.Error())
+	}
+	if err != nil {
+		log.Printf("Error importing calendar: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not import calendar")
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// receiveCalendar reads the uploaded calendar, from a multipart form's "file"
+// field or the request body, and the name of the file it was uploaded as.
+func receiveCalendar(c echo.Context) ([]byte, string, error) {
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxCalendarUploadSize)
+
+	var r io.Reader = req.Body
+	var filename string
+	if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		fh, err := c.FormFile("file")
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, "", err
+		}
+		if err != nil {
+			return nil, "", echo.NewHTTPError(http.StatusBadRequest, "An iCalendar file is required in the file field")
+		}
+		defer req.MultipartForm.RemoveAll()
+		f, err := fh.Open()
+		if err != nil {
+			log.Printf("Error opening upload: %v", err)
+			return nil, "", echo.NewHTTPError(http.StatusInternalServerError, "Could not read upload")
+		}
+		defer f.Close()
+		r, filename = f, fh.Filename
+	}
+
+	data, err := io.ReadAll(r)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, "", err
+	}
+	if err != nil {
+		return nil, "", echo.NewHTTPError(http.StatusBadRequest, "Could not read upload")
+	}
+	return data, filename, nil
+}
+
+// receiveArchive returns the uploaded archive, from a multipart form's "file"
+// field or the request body, which is spooled to a temporary file so it isn't
+// held in memory. cleanup removes whatever was stored.
+func receiveArchive(c echo.Context) (io.ReaderAt, int64, func(), error) {
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxImportUploadSize)
+
+	if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		fh, err := c.FormFile("file")
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, 0, nil, err
+		}
+		if err != nil {
+			return nil, 0, nil, echo.NewHTTPError(http.StatusBadRequest, "A ZIP archive is required in the file field")
+		}
+		f, err := fh.Open()
+		if err != nil {
+			log.Printf("Error opening upload: %v", err)
+			return nil, 0, nil, echo.NewHTTPError(http.StatusInternalServerError, "Could not read upload")
+		}
+		return f, fh.Size, func() {
+			f.Close()
+			if req.MultipartForm != nil {
+				req.MultipartForm.RemoveAll()
+			}
+		}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "tempo-import-*.zip")
+	if err != nil {
+		log.Printf("Error creating temporary file: %v", err)
+		return nil, 0, nil, echo.NewHTTPError(http.StatusInternalServerError, "Could not read upload")
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, req.Body)
+	if err != nil {
+		cleanup()
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, 0, nil, err
+		}
+		return nil, 0, nil, echo.NewHTTPError(http.StatusBadRequest, "Could not read upload")
+	}
+	return tmp, size, cleanup, nil
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -37,17 +38,45 @@ func (h *JournalHandler) HandleCreateJournalEntry(c echo.Context) error {
 		log.Printf("Error creating journal entry: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Could not create journal entry")
 	}
-	return c.JSON(http.StatusCreated, entry)
+	return respondWithETag(c, http.StatusCreated, versionETag(entry.Version), entry)
 }
 
+// HandleGetJournalEntries returns a page of the user's entries, newest first.
+// ?from= and ?to= (YYYY-MM-DD, inclusive) narrow them to a range of dates and
+// ?mood= to one mood.
 func (h *JournalHandler) HandleGetJournalEntries(c echo.Context) error {
 	userID := c.Get("userID").(int)
-	entries, err := h.store.GetJournalEntriesByUser(userID)
+	var filter db.JournalFilter
+	if from := c.QueryParam("from"); from != "" {
+		d, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid from date, expected YYYY-MM-DD")
+		}
+		filter.From = &d
+	}
+	if to := c.QueryParam("to"); to != "" {
+		d, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid to date, expected YYYY-MM-DD")
+		}
+		filter.To = &d
+	}
+	filter.Mood = c.QueryParam("mood")
+	opts, err := pageOptions(c)
+	if err != nil {
+		return err
+	}
+
+	entries, next, err := h.store.GetJournalEntriesByUser(userID, filter, opts)
+	if perr := pageError(err); perr != nil {
+		return perr
+	}
 	if err != nil {
 		log.Printf("Error getting journal entries: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve journal entries")
 	}
-	return c.JSON(http.StatusOK, entries)
+	setNextPageLink(c, next)
+	return c.JSON(http.StatusOK, types.Page[types.JournalEntry]{Items: entries, NextCursor: next})
 }
 
 func (h *JournalHandler) HandleGetJournalEntry(c echo.Context) error {
@@ -58,11 +87,15 @@ func (h *JournalHandler) HandleGetJournalEntry(c echo.Context) error {
 	}
 
 	entry, err := h.store.GetJournalEntryByID(entryID, userID)
-	if err != nil {
+	if errors.Is(err, db.ErrNotFound) {
 		return echo.NewHTTPError(http.StatusNotFound, "Journal entry not found")
 	}
+	if err != nil {
+		log.Printf("Error getting journal entry: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve journal entry")
+	}
 
-	return c.JSON(http.StatusOK, entry)
+	return respondWithETag(c, http.StatusOK, versionETag(entry.Version), entry)
 }
 
 func (h *JournalHandler) HandleUpdateJournalEntry(c echo.Context) error {
@@ -77,12 +110,23 @@ func (h *JournalHandler) HandleUpdateJournalEntry(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
 	}
 
-	entry, err := h.store.UpdateJournalEntry(entryID, userID, payload)
+	entry, err := h.store.UpdateJournalEntry(entryID, userID, payload, ifMatchVersion(c))
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Journal entry not found")
+	}
+	if errors.Is(err, db.ErrVersionConflict) {
+		current, err := h.store.GetJournalEntryByID(entryID, userID)
+		if err != nil {
+			log.Printf("Error getting journal entry: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve journal entry")
+		}
+		return preconditionFailed(c, current, current.Version)
+	}
 	if err != nil {
 		log.Printf("Error updating journal entry: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update journal entry")
 	}
-	return c.JSON(http.StatusOK, entry)
+	return respondWithETag(c, http.StatusOK, versionETag(entry.Version), entry)
 }
 
 func (h *JournalHandler) HandleDeleteJournalEntry(c echo.Context) error {
@@ -92,7 +136,7 @@ func (h *JournalHandler) HandleDeleteJournalEntry(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid entry ID")
 	}
 
-	err = h.store.DeleteJournalEntry(entryID, userID)
+	err = h.store.DeleteJournalEntry(entryID, userID, nil)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "Journal entry not found or not authorized")
 	}
@@ -1,10 +1,13 @@
 package api
 
 import (
+	"errors"
 	"log"
+	"mime"
 	"net/http"
 	"strconv"
 	"tempo-backend/db"
+	"tempo-backend/diff"
 	"tempo-backend/types"
 
 	"github.com/labstack/echo/v4"
@@ -29,21 +32,56 @@ func (h *NoteHandler) HandleCreateNote(c echo.Context) error {
 	}
 
 	note, err := h.store.CreateNote(payload, userID)
+	if errors.Is(err, db.ErrNotebookNotFound) || errors.Is(err, db.ErrInvalidTag) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 	if err != nil {
 		log.Printf("Error creating note: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Could not create note")
 	}
-	return c.JSON(http.StatusCreated, note)
+	return respondWithETag(c, http.StatusCreated, versionETag(note.Version), note)
 }
 
 func (h *NoteHandler) HandleGetNotes(c echo.Context) error {
 	userID := c.Get("userID").(int)
-	notes, err := h.store.GetNotesByUser(userID)
+
+	// ?q= switches to full-text search, returning ranked results with highlights.
+	if q := c.QueryParam("q"); q != "" {
+		results, err := h.store.SearchNotes(userID, q)
+		if errors.Is(err, db.ErrEmptySearch) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Search query is empty")
+		}
+		if err != nil {
+			log.Printf("Error searching notes: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not search notes")
+		}
+		return c.JSON(http.StatusOK, results)
+	}
+
+	var filter db.NoteFilter
+	if notebookID := c.QueryParam("notebookId"); notebookID != "" {
+		id, err := strconv.Atoi(notebookID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid notebook ID")
+		}
+		filter.NotebookID = id
+	}
+	filter.Tag = c.QueryParam("tag")
+	opts, err := pageOptions(c)
+	if err != nil {
+		return err
+	}
+
+	notes, next, err := h.store.GetNotesByUser(userID, filter, opts)
+	if perr := pageError(err); perr != nil {
+		return perr
+	}
 	if err != nil {
 		log.Printf("Error getting notes: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve notes")
 	}
-	return c.JSON(http.StatusOK, notes)
+	setNextPageLink(c, next)
+	return c.JSON(http.StatusOK, types.Page[types.Note]{Items: notes, NextCursor: next})
 }
 
 func (h *NoteHandler) HandleGetNote(c echo.Context) error {
@@ -54,11 +92,15 @@ func (h *NoteHandler) HandleGetNote(c echo.Context) error {
 	}
 
 	note, err := h.store.GetNoteByID(noteID, userID)
-	if err != nil {
+	if errors.Is(err, db.ErrNotFound) {
 		return echo.NewHTTPError(http.StatusNotFound, "Note not found")
 	}
+	if err != nil {
+		log.Printf("Error getting note: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve note")
+	}
 
-	return c.JSON(http.StatusOK, note)
+	return respondWithETag(c, http.StatusOK, versionETag(note.Version), note)
 }
 
 func (h *NoteHandler) HandleUpdateNote(c echo.Context) error {
@@ -73,12 +115,26 @@ func (h *NoteHandler) HandleUpdateNote(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
 	}
 
-	note, err := h.store.UpdateNote(noteID, userID, payload)
+	note, err := h.store.UpdateNote(noteID, userID, payload, ifMatchVersion(c))
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Note not found")
+	}
+	if errors.Is(err, db.ErrVersionConflict) {
+		current, err := h.store.GetNoteByID(noteID, userID)
+		if err != nil {
+			log.Printf("Error getting note: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve note")
+		}
+		return preconditionFailed(c, current, current.Version)
+	}
+	if errors.Is(err, db.ErrNotebookNotFound) || errors.Is(err, db.ErrInvalidTag) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 	if err != nil {
 		log.Printf("Error updating note: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update note")
 	}
-	return c.JSON(http.StatusOK, note)
+	return respondWithETag(c, http.StatusOK, versionETag(note.Version), note)
 }
 
 func (h *NoteHandler) HandleDeleteNote(c echo.Context) error {
@@ -88,10 +144,167 @@ func (h *NoteHandler) HandleDeleteNote(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid note ID")
 	}
 
-	err = h.store.DeleteNote(noteID, userID)
+	err = h.store.DeleteNote(noteID, userID, nil)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "Note not found or not authorized")
 	}
 
 	return c.NoContent(http.StatusNoContent)
-}
\ No newline at end of file
+}
+func (h *NoteHandler) HandleGetNoteRevisions(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	noteID, err := strconv.Atoi(c.Param("noteId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid note ID")
+	}
+
+	revisions, err := h.store.GetNoteRevisions(noteID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Note not found")
+	}
+	if err != nil {
+		log.Printf("Error getting note revisions: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve revisions")
+	}
+	return c.JSON(http.StatusOK, revisions)
+}
+
+func (h *NoteHandler) HandleGetNoteRevision(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	noteID, err := strconv.Atoi(c.Param("noteId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid note ID")
+	}
+	revID, err := strconv.Atoi(c.Param("revId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid revision ID")
+	}
+
+	revision, err := h.store.GetNoteRevision(noteID, revID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Revision not found")
+	}
+	if err != nil {
+		log.Printf("Error getting note revision: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve revision")
+	}
+	return c.JSON(http.StatusOK, revision)
+}
+
+// HandleDiffNoteRevisions compares revision ?from= with revision ?to=, or with
+// the note as it is now when to is omitted. ?mode=word diffs word by word
+// instead of line by line.
+func (h *NoteHandler) HandleDiffNoteRevisions(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	noteID, err := strconv.Atoi(c.Param("noteId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid note ID")
+	}
+	fromID, err := strconv.Atoi(c.QueryParam("from"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "from must be a revision ID")
+	}
+
+	result := types.NoteRevisionDiff{From: fromID, Mode: c.QueryParam("mode")}
+	diffContent := diff.Lines
+	switch result.Mode {
+	case "", "line":
+		result.Mode = "line"
+	case "word":
+		diffContent = diff.Words
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "mode must be line or word")
+	}
+
+	from, err := h.store.GetNoteRevision(noteID, fromID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Revision not found")
+	}
+	if err != nil {
+		log.Printf("Error getting note revision: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve revision")
+	}
+
+	var toTitle, toContent string
+	if to := c.QueryParam("to"); to != "" {
+		toID, err := strconv.Atoi(to)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "to must be a revision ID")
+		}
+		rev, err := h.store.GetNoteRevision(noteID, toID, userID)
+		if errors.Is(err, db.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "Revision not found")
+		}
+		if err != nil {
+			log.Printf("Error getting note revision: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve revision")
+		}
+		result.To = &rev.ID
+		toTitle, toContent = rev.Title, rev.Content
+	} else {
+		note, err := h.store.GetNoteByID(noteID, userID)
+		if err != nil {
+			log.Printf("Error getting note: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve note")
+		}
+		toTitle, toContent = note.Title, note.Content
+	}
+
+	result.Title = diff.Words(from.Title, toTitle)
+	result.Content = diffContent(from.Content, toContent)
+	return c.JSON(http.StatusOK, result)
+}
+
+// HandleRestoreNoteRevision puts a revision's title and content back on the note.
+func (h *NoteHandler) HandleRestoreNoteRevision(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	noteID, err := strconv.Atoi(c.Param("noteId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid note ID")
+	}
+	revID, err := strconv.Atoi(c.Param("revId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid revision ID")
+	}
+
+	note, err := h.store.RestoreNoteRevision(noteID, revID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Revision not found")
+	}
+	if err != nil {
+		log.Printf("Error restoring note revision: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not restore revision")
+	}
+	return respondWithETag(c, http.StatusOK, versionETag(note.Version), note)
+}
+
+// HandleGetNoteAttachment serves a file attached to a note. Images in notes
+// can't send an Authorization header, so the route also takes ?access_token=.
+func (h *NoteHandler) HandleGetNoteAttachment(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	noteID, err := strconv.Atoi(c.Param("noteId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid note ID")
+	}
+	attachmentID, err := strconv.Atoi(c.Param("attachmentId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid attachment ID")
+	}
+
+	attachment, err := h.store.GetNoteAttachment(attachmentID, noteID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Attachment not found")
+	}
+	if err != nil {
+		log.Printf("Error getting note attachment: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve attachment")
+	}
+
+	// Attachments never change, and an SVG mustn't be able to run scripts.
+	header := c.Response().Header()
+	header.Set("Cache-Control", "private, max-age=31536000, immutable")
+	header.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("inline", map[string]string{"filename": attachment.Filename}))
+	return c.Blob(http.StatusOK, attachment.ContentType, attachment.Data)
+}
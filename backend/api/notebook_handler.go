@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"tempo-backend/db"
+	"tempo-backend/types"
+
+	"github.com/labstack/echo/v4"
+)
+
+type NotebookHandler struct {
+	store *db.NotebookStore
+}
+
+func NewNotebookHandler(store *db.NotebookStore) *NotebookHandler {
+	return &NotebookHandler{store: store}
+}
+
+func (h *NotebookHandler) HandleCreateNotebook(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	var payload types.CreateNotebookPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
+	}
+	if strings.TrimSpace(payload.Name) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Name is required")
+	}
+
+	notebook, err := h.store.CreateNotebook(payload, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Parent notebook not found")
+	}
+	if err != nil {
+		log.Printf("Error creating notebook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not create notebook")
+	}
+	return c.JSON(http.StatusCreated, notebook)
+}
+
+func (h *NotebookHandler) HandleGetNotebooks(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	notebooks, err := h.store.GetNotebooksByUser(userID)
+	if err != nil {
+		log.Printf("Error getting notebooks: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve notebooks")
+	}
+	return c.JSON(http.StatusOK, notebooks)
+}
+
+func (h *NotebookHandler) HandleGetNotebook(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	notebookID, err := strconv.Atoi(c.Param("notebookId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid notebook ID")
+	}
+
+	notebook, err := h.store.GetNotebookByID(notebookID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Notebook not found")
+	}
+	if err != nil {
+		log.Printf("Error getting notebook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve notebook")
+	}
+	return c.JSON(http.StatusOK, notebook)
+}
+
+func (h *NotebookHandler) HandleUpdateNotebook(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	notebookID, err := strconv.Atoi(c.Param("notebookId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid notebook ID")
+	}
+
+	var payload types.UpdateNotebookPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
+	}
+	if payload.Name != nil && strings.TrimSpace(*payload.Name) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Name cannot be empty")
+	}
+
+	notebook, err := h.store.UpdateNotebook(notebookID, userID, payload)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Notebook not found")
+	}
+	if errors.Is(err, db.ErrNotebookNotFound) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Parent notebook not found")
+	}
+	if errors.Is(err, db.ErrNotebookCycle) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		log.Printf("Error updating notebook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update notebook")
+	}
+	return c.JSON(http.StatusOK, notebook)
+}
+
+// HandleDeleteNotebook deletes a notebook. ?mode=move (the default) moves its
+// notes to the default notebook; ?mode=cascade deletes them along with it.
+func (h *NotebookHandler) HandleDeleteNotebook(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	notebookID, err := strconv.Atoi(c.Param("notebookId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid notebook ID")
+	}
+
+	var cascade bool
+	switch c.QueryParam("mode") {
+	case "", "move":
+	case "cascade":
+		cascade = true
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "mode must be move or cascade")
+	}
+
+	err = h.store.DeleteNotebook(notebookID, userID, cascade)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Notebook not found")
+	}
+	if errors.Is(err, db.ErrDefaultNotebook) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		log.Printf("Error deleting notebook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not delete notebook")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"tempo-backend/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 200
+)
+
+type NotificationHandler struct {
+	store *db.NotificationStore
+}
+
+func NewNotificationHandler(store *db.NotificationStore) *NotificationHandler {
+	return &NotificationHandler{store: store}
+}
+
+// HandleGetNotifications returns the user's notification feed, newest first.
+// Pass unread=true for unread notifications only.
+func (h *NotificationHandler) HandleGetNotifications(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	limit := defaultNotificationLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxNotificationLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxNotificationLimit))
+		}
+		limit = n
+	}
+
+	notifications, err := h.store.GetNotifications(userID, c.QueryParam("unread") == "true", limit)
+	if err != nil {
+		log.Printf("Error getting notifications: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve notifications")
+	}
+	return c.JSON(http.StatusOK, notifications)
+}
+
+func (h *NotificationHandler) HandleMarkNotificationRead(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	notificationID, err := strconv.Atoi(c.Param("notificationId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid notification ID")
+	}
+
+	notification, err := h.store.MarkNotificationRead(notificationID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Notification not found")
+	}
+	if err != nil {
+		log.Printf("Error marking notification read: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update notification")
+	}
+	return c.JSON(http.StatusOK, notification)
+}
+
+func (h *NotificationHandler) HandleMarkAllNotificationsRead(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	if err := h.store.MarkAllNotificationsRead(userID); err != nil {
+		log.Printf("Error marking notifications read: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update notifications")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,321 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"tempo-backend/db"
+	"tempo-backend/oidc"
+	"tempo-backend/types"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// oidcLoginTTL is how long the user has to log in at the identity provider.
+	oidcLoginTTL = 10 * time.Minute
+	// loginCodeTTL is how long the web app has to exchange a login code.
+	loginCodeTTL = time.Minute
+	// oidcStateCookie holds the state of the login the browser started. The
+	// callback only finishes logins started in the same browser, so nobody can
+	// send someone else's browser to it to log them in to the wrong account.
+	oidcStateCookie = "tempo_oidc_state"
+)
+
+// OIDCHandler logs users in with OpenID Connect identity providers and links
+// provider accounts to existing users.
+//
+// A login starts by sending the browser to /api/users/oidc/{provider}/login,
+// which redirects it to the provider. The provider sends it back to the
+// callback, which redirects it to the web app's /oidc/callback page with
+// either a single-use loginCode, exchanged for a session through
+// /api/users/oidc/exchange, or an error.
+type OIDCHandler struct {
+	users     *UserHandler
+	providers map[string]*oidc.Provider
+	list      []types.OIDCProvider
+}
+
+func NewOIDCHandler(users *UserHandler, providers []*oidc.Provider) *OIDCHandler {
+	h := &OIDCHandler{users: users, providers: make(map[string]*oidc.Provider), list: make([]types.OIDCProvider, 0)}
+	for _, p := range providers {
+		h.providers[p.Name] = p
+		h.list = append(h.list, types.OIDCProvider{Name: p.Name, DisplayName: p.DisplayName})
+	}
+	return h
+}
+
+// HandleGetProviders lists the identity providers users can log in with.
+func (h *OIDCHandler) HandleGetProviders(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.list)
+}
+
+// HandleStartLogin sends the browser to the provider to log in.
+func (h *OIDCHandler) HandleStartLogin(c echo.Context) error {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Unknown identity provider")
+	}
+
+	authURL, err := h.startLogin(c, provider, c.QueryParam("redirect"), nil)
+	if err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// HandleLinkIdentity starts linking a provider account to the user. It returns
+// the provider URL for the web app to send the browser to, since the browser
+// can't carry the user's access token through a redirect. The web app must
+// make the request with credentials, so the browser keeps the state cookie.
+func (h *OIDCHandler) HandleLinkIdentity(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Unknown identity provider")
+	}
+
+	var payload types.OIDCLinkPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+
+	authURL, err := h.startLogin(c, provider, payload.Redirect, &userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, types.OIDCLinkResponse{AuthorizationURL: authURL})
+}
+
+// startLogin records a login at the provider, ties it to the browser with the
+// state cookie, and returns the URL that starts it.
+func (h *OIDCHandler) startLogin(c echo.Context, provider *oidc.Provider, redirect string, linkUserID *int) (string, error) {
+	state, err := oidc.RandomString()
+	if err != nil {
+		log.Printf("Error generating OIDC state: %v", err)
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to start login")
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		log.Printf("Error generating OIDC nonce: %v", err)
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to start login")
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		log.Printf("Error generating PKCE verifier: %v", err)
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to start login")
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request().Context(), state, nonce, challenge)
+	if err != nil {
+		log.Printf("Error contacting identity provider %s: %v", provider.Name, err)
+		return "", echo.NewHTTPError(http.StatusBadGateway, "Identity provider is unavailable")
+	}
+
+	login := types.OIDCLogin{
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		RedirectPath: safeRedirectPath(redirect),
+		LinkUserID:   linkUserID,
+	}
+	if err := h.users.store.CreateOIDCLogin(hashToken(state), login, oidcLoginTTL); err != nil {
+		log.Printf("Error creating OIDC login: %v", err)
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to start login")
+	}
+	setOIDCStateCookie(c, state, int(oidcLoginTTL.Seconds()))
+	return authURL, nil
+}
+
+// setOIDCStateCookie sets the state cookie, or clears it when maxAge is negative.
+// Lax, rather than Strict, still sends it on the provider's redirect back.
+func setOIDCStateCookie(c echo.Context, state string, maxAge int) {
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/users/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// HandleCallback finishes a login or link when the provider sends the browser
+// back, then redirects it to the web app with the outcome.
+func (h *OIDCHandler) HandleCallback(c echo.Context) error {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Unknown identity provider")
+	}
+	params := url.Values{"provider": {provider.Name}}
+
+	state := c.QueryParam("state")
+	cookie, err := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		params.Set("error", "invalid_state")
+		return redirectToApp(c, params)
+	}
+
+	login, err := h.users.store.ConsumeOIDCLogin(hashToken(state), provider.Name)
+	if errors.Is(err, db.ErrInvalidToken) {
+		params.Set("error", "invalid_state")
+		return redirectToApp(c, params)
+	}
+	if err != nil {
+		log.Printf("Error consuming OIDC login: %v", err)
+		params.Set("error", "login_failed")
+		return redirectToApp(c, params)
+	}
+	params.Set("redirect", login.RedirectPath)
+
+	if e := c.QueryParam("error"); e != "" {
+		if e == "access_denied" {
+			params.Set("error", "access_denied")
+		} else {
+			log.Printf("Identity provider %s returned error %s: %s", provider.Name, e, c.QueryParam("error_description"))
+			params.Set("error", "login_failed")
+		}
+		return redirectToApp(c, params)
+	}
+
+	claims, err := provider.Exchange(c.Request().Context(), c.QueryParam("code"), login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("Error completing login with %s: %v", provider.Name, err)
+		params.Set("error", "login_failed")
+		return redirectToApp(c, params)
+	}
+	identity := types.ExternalIdentity{
+		Provider:          provider.Name,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+	}
+
+	if login.LinkUserID != nil {
+		err := h.users.store.LinkIdentity(*login.LinkUserID, identity)
+		switch {
+		case errors.Is(err, db.ErrIdentityTaken):
+			params.Set("error", "identity_taken")
+		case err != nil:
+			log.Printf("Error linking identity: %v", err)
+			params.Set("error", "login_failed")
+		default:
+			params.Set("linked", "true")
+		}
+		return redirectToApp(c, params)
+	}
+
+	userID, err := h.users.store.LoginWithIdentity(identity)
+	switch {
+	case errors.Is(err, db.ErrIdentityEmailTaken):
+		params.Set("error", "email_taken")
+		return redirectToApp(c, params)
+	case errors.Is(err, db.ErrIdentityNoEmail):
+		params.Set("error", "email_required")
+		return redirectToApp(c, params)
+	case err != nil:
+		log.Printf("Error logging in with identity: %v", err)
+		params.Set("error", "login_failed")
+		return redirectToApp(c, params)
+	}
+
+	code, err := randomToken()
+	if err == nil {
+		err = h.users.store.CreateLoginCode(userID, hashToken(code), loginCodeTTL)
+	}
+	if err != nil {
+		log.Printf("Error creating login code: %v", err)
+		params.Set("error", "login_failed")
+		return redirectToApp(c, params)
+	}
+	params.Set("loginCode", code)
+	return redirectToApp(c, params)
+}
+
+// HandleExchange exchanges a login code from the callback for a session, or a
+// two-factor challenge if the user has two-factor authentication enabled.
+func (h *OIDCHandler) HandleExchange(c echo.Context) error {
+	var payload types.OIDCExchangePayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.LoginCode == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "loginCode is required")
+	}
+
+	userID, err := h.users.store.ConsumeLoginCode(hashToken(payload.LoginCode))
+	if errors.Is(err, db.ErrInvalidToken) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired login code")
+	}
+	if err != nil {
+		log.Printf("Error consuming login code: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to log in")
+	}
+
+	user, err := h.users.store.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to log in")
+	}
+	if user.TwoFactorEnabled {
+		return h.users.respondWithChallenge(c, user.ID, payload.DeviceName)
+	}
+	return h.users.startSession(c, user.ID, payload.DeviceName)
+}
+
+// HandleGetIdentities lists the provider accounts linked to the user.
+func (h *OIDCHandler) HandleGetIdentities(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	identities, err := h.users.store.GetIdentities(userID)
+	if err != nil {
+		log.Printf("Error getting identities: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve linked accounts")
+	}
+	return c.JSON(http.StatusOK, identities)
+}
+
+// HandleDeleteIdentity unlinks a provider account from the user.
+func (h *OIDCHandler) HandleDeleteIdentity(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	identityID, err := strconv.Atoi(c.Param("identityId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid identity ID")
+	}
+
+	err = h.users.store.DeleteIdentity(identityID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Linked account not found")
+	}
+	if errors.Is(err, db.ErrLastLoginMethod) {
+		return echo.NewHTTPError(http.StatusConflict, "Set a password before unlinking your only linked account")
+	}
+	if err != nil {
+		log.Printf("Error deleting identity: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not unlink account")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// redirectToApp sends the browser to the web app's OIDC callback page.
+func redirectToApp(c echo.Context, params url.Values) error {
+	return c.Redirect(http.StatusFound, appURL()+"/oidc/callback?"+params.Encode())
+}
+
+// safeRedirectPath keeps redirects within the web app: anything but a plain
+// absolute path becomes "/".
+func safeRedirectPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.ContainsAny(path, "\\\r\n") {
+		return "/"
+	}
+	return path
+}
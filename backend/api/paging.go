@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"tempo-backend/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Listings return a page of records at a time. ?limit= sets the page size,
+// ?sort= one of the listing's orders, and ?cursor= continues from the end of
+// the previous page. Responses with more to come carry a Link header to the
+// next page, whose cursor stays valid only for the same sort.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// pageOptions reads the paging parameters of a listing request.
+func pageOptions(c echo.Context) (db.PageOptions, error) {
+	opts := db.PageOptions{Sort: c.QueryParam("sort"), Limit: defaultPageLimit, Cursor: c.QueryParam("cursor")}
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return opts, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageLimit))
+		}
+		opts.Limit = n
+	}
+	return opts, nil
+}
+
+// pageError turns a listing's complaint about its sort or cursor into a 400.
+// It returns nil for any other error.
+func pageError(err error) error {
+	switch {
+	case errors.Is(err, db.ErrInvalidSort):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, db.ErrInvalidCursor):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor")
+	}
+	return nil
+}
+
+// setNextPageLink points the Link header at the next page, if there is one.
+func setNextPageLink(c echo.Context, cursor string) {
+	if cursor == "" {
+		return
+	}
+	next := *c.Request().URL
+	query := next.Query()
+	query.Set("cursor", cursor)
+	next.RawQuery = query.Encode()
+	c.Response().Header().Set("Link", "<"+next.RequestURI()+`>; rel="next"`)
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"tempo-backend/db"
+	"tempo-backend/types"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxReminderOffset is how far ahead of the due time a reminder may fire, in minutes.
+const maxReminderOffset = 366 * 24 * 60
+
+type ReminderHandler struct {
+	store *db.ReminderStore
+}
+
+func NewReminderHandler(store *db.ReminderStore) *ReminderHandler {
+	return &ReminderHandler{store: store}
+}
+
+func (h *ReminderHandler) HandleCreateReminder(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	itemID, err := strconv.Atoi(c.Param("itemId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid item ID")
+	}
+
+	var payload types.CreateReminderPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
+	}
+	if (payload.RemindAt == nil) == (payload.OffsetMinutes == nil) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Exactly one of remindAt and offsetMinutes is required")
+	}
+	if payload.RemindAt != nil && !payload.RemindAt.After(time.Now()) {
+		return echo.NewHTTPError(http.StatusBadRequest, "remindAt must be in the future")
+	}
+	if payload.OffsetMinutes != nil && (*payload.OffsetMinutes < 0 || *payload.OffsetMinutes > maxReminderOffset) {
+		return echo.NewHTTPError(http.StatusBadRequest, "offsetMinutes must be between 0 and "+strconv.Itoa(maxReminderOffset))
+	}
+	if payload.Timezone == "" {
+		payload.Timezone = "UTC"
+	}
+	// "Local" would mean the server's zone here and nothing at all to PostgreSQL.
+	if _, err := time.LoadLocation(payload.Timezone); err != nil || payload.Timezone == "Local" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unknown timezone")
+	}
+	if len(payload.Channels) == 0 {
+		payload.Channels = []string{types.ReminderChannelInApp}
+	}
+	var channels []string
+	for _, channel := range payload.Channels {
+		switch channel {
+		case types.ReminderChannelInApp, types.ReminderChannelEmail, types.ReminderChannelWebhook:
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "Unknown channel "+strconv.Quote(channel))
+		}
+		if !slices.Contains(channels, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	payload.Channels = channels
+
+	reminder, err := h.store.CreateReminder(itemID, userID, payload)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Item not found")
+	}
+	if err != nil {
+		log.Printf("Error creating reminder: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not create reminder")
+	}
+	return c.JSON(http.StatusCreated, reminder)
+}
+
+func (h *ReminderHandler) HandleGetReminders(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	itemID, err := strconv.Atoi(c.Param("itemId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid item ID")
+	}
+
+	reminders, err := h.store.GetRemindersByItem(itemID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Item not found")
+	}
+	if err != nil {
+		log.Printf("Error getting reminders: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve reminders")
+	}
+	return c.JSON(http.StatusOK, reminders)
+}
+
+func (h *ReminderHandler) HandleDeleteReminder(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	itemID, err := strconv.Atoi(c.Param("itemId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid item ID")
+	}
+	reminderID, err := strconv.Atoi(c.Param("reminderId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid reminder ID")
+	}
+
+	err = h.store.DeleteReminder(reminderID, itemID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Reminder not found")
+	}
+	if err != nil {
+		log.Printf("Error deleting reminder: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not delete reminder")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"tempo-backend/db"
+	"tempo-backend/types"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type SearchHandler struct {
+	store *db.SearchStore
+}
+
+func NewSearchHandler(store *db.SearchStore) *SearchHandler {
+	return &SearchHandler{store: store}
+}
+
+// HandleSearch searches notes, journal entries and tasks at once.
+//
+// Query parameters: q (required), kind (comma-separated note, journal, task),
+// from and to (inclusive YYYY-MM-DD dates), completed (true/false, tasks only),
+// limit and offset.
+func (h *SearchHandler) HandleSearch(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	opts := types.SearchOptions{Query: c.QueryParam("q"), Limit: defaultSearchLimit}
+	if strings.TrimSpace(opts.Query) == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "q is required")
+	}
+
+	if kinds := c.QueryParam("kind"); kinds != "" {
+		for _, kind := range strings.Split(kinds, ",") {
+			switch kind {
+			case types.SearchKindNote, types.SearchKindJournal, types.SearchKindTask:
+				opts.Kinds = append(opts.Kinds, kind)
+			default:
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid kind: "+kind)
+			}
+		}
+	}
+
+	if from := c.QueryParam("from"); from != "" {
+		d, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid from date, expected YYYY-MM-DD")
+		}
+		opts.From = &d
+	}
+	if to := c.QueryParam("to"); to != "" {
+		d, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid to date, expected YYYY-MM-DD")
+		}
+		end := d.AddDate(0, 0, 1) // The store's upper bound is exclusive
+		opts.To = &end
+	}
+
+	if completed := c.QueryParam("completed"); completed != "" {
+		b, err := strconv.ParseBool(completed)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid completed value")
+		}
+		opts.Completed = &b
+	}
+
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 100")
+		}
+		opts.Limit = n
+	}
+	if offset := c.QueryParam("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid offset")
+		}
+		opts.Offset = n
+	}
+
+	results, err := h.store.Search(userID, opts)
+	if errors.Is(err, db.ErrEmptySearch) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Search query is empty")
+	}
+	if err != nil {
+		log.Printf("Error searching: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not search")
+	}
+	return c.JSON(http.StatusOK, results)
+}
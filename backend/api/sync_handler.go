@@ -0,0 +1,365 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"tempo-backend/db"
+	"tempo-backend/types"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultSyncLimit = 500
+	maxSyncLimit     = 1000
+	maxSyncBatch     = 500
+	maxMutationID    = 64
+)
+
+// Mutation outcomes reported back to the client.
+const (
+	syncApplied  = "applied"
+	syncConflict = "conflict"
+	syncError    = "error"
+)
+
+// errSyncInvalid marks a mutation the client got wrong, whose message is safe
+// to report back as is.
+type errSyncInvalid string
+
+func (e errSyncInvalid) Error() string { return string(e) }
+
+// SyncHandler serves the delta sync protocol used by offline-capable clients.
+// Pushed mutations go through the same stores as the regular endpoints.
+type SyncHandler struct {
+	sync    *db.SyncStore
+	todos   *db.TodoStore
+	notes   *db.NoteStore
+	journal *db.JournalStore
+}
+
+func NewSyncHandler(sync *db.SyncStore, todos *db.TodoStore, notes *db.NoteStore, journal *db.JournalStore) *SyncHandler {
+	return &SyncHandler{sync: sync, todos: todos, notes: notes, journal: journal}
+}
+
+// HandleSyncPull returns the changes since ?since=, or everything without it.
+func (h *SyncHandler) HandleSyncPull(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	limit := defaultSyncLimit
+	if l := c.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxSyncLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSyncLimit))
+		}
+		limit = n
+	}
+
+	changes, err := h.sync.GetChanges(userID, c.QueryParam("since"), limit)
+	if errors.Is(err, db.ErrInvalidSyncCursor) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid sync cursor")
+	}
+	if err != nil {
+		log.Printf("Error getting sync changes: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve changes")
+	}
+	return c.JSON(http.StatusOK, changes)
+}
+
+// HandleSyncPush applies a batch of client mutations in order and reports the
+// outcome of each. A failed mutation doesn't stop the ones after it.
+func (h *SyncHandler) HandleSyncPush(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	var payload types.SyncPushPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
+	}
+	if len(payload.Mutations) > maxSyncBatch {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("A batch can hold at most %d mutations", maxSyncBatch))
+	}
+
+	result := types.SyncPushResult{Results: make([]types.SyncMutationResult, 0, len(payload.Mutations))}
+	for _, m := range payload.Mutations {
+		result.Results = append(result.Results, h.applyMutation(userID, m))
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *SyncHandler) applyMutation(userID int, m types.SyncMutation) types.SyncMutationResult {
+	result := types.SyncMutationResult{MutationID: m.MutationID, Kind: m.Kind}
+	if m.MutationID == "" || len(m.MutationID) > maxMutationID {
+		result.Status = syncError
+		result.Error = fmt.Sprintf("mutationId must be between 1 and %d characters", maxMutationID)
+		return result
+	}
+
+	var apply func(db.MutationStores) (int, int, error)
+	switch m.Kind {
+	case types.SyncKindList:
+		apply = func(stores db.MutationStores) (int, int, error) { return h.applyListMutation(stores, userID, m) }
+	case types.SyncKindItem:
+		apply = func(stores db.MutationStores) (int, int, error) { return h.applyItemMutation(stores, userID, m) }
+	case types.SyncKindNote:
+		apply = func(stores db.MutationStores) (int, int, error) { return h.applyNoteMutation(stores, userID, m) }
+	case types.SyncKindJournal:
+		apply = func(stores db.MutationStores) (int, int, error) { return h.applyJournalMutation(stores, userID, m) }
+	default:
+		result.Status, result.Error = syncError, "unknown kind "+strconv.Quote(m.Kind)
+		return result
+	}
+
+	// A retried mutation is reported as applied without being repeated.
+	applied, err := h.sync.ApplyMutation(userID, m.MutationID, m.Kind, apply)
+
+	var invalid errSyncInvalid
+	switch {
+	case err == nil:
+		result.Status, result.Kind, result.ID, result.Version = syncApplied, applied.Kind, applied.RecordID, applied.Version
+	case errors.Is(err, db.ErrVersionConflict):
+		result.Status, result.ID = syncConflict, m.ID
+		result.Current = h.currentRecord(userID, m.Kind, m.ID)
+	case errors.As(err, &invalid):
+		result.Status, result.Error = syncError, invalid.Error()
+	case errors.Is(err, db.ErrNotFound):
+		result.Status, result.Error = syncError, "record not found"
+	case errors.Is(err, db.ErrInvalidParent), errors.Is(err, db.ErrMaxDepthExceeded),
+		errors.Is(err, db.ErrRecurrenceNeedsDueDate), errors.Is(err, db.ErrNotebookNotFound), errors.Is(err, db.ErrInvalidTag),
+		errors.Is(err, db.ErrListNotFound):
+		result.Status, result.Error = syncError, err.Error()
+	default:
+		log.Printf("Error applying sync mutation: %v", err)
+		result.Status, result.Error = syncError, "Could not apply mutation"
+	}
+	return result
+}
+
+// currentRecord fetches the server's copy of a record to report with a conflict.
+func (h *SyncHandler) currentRecord(userID int, kind string, id int) interface{} {
+	var record interface{}
+	var err error
+	switch kind {
+	case types.SyncKindList:
+		record, err = h.todos.GetTodoListByID(id, userID)
+	case types.SyncKindItem:
+		record, err = h.todos.GetTodoItemByID(id, userID)
+	case types.SyncKindNote:
+		record, err = h.notes.GetNoteByID(id, userID)
+	case types.SyncKindJournal:
+		record, err = h.journal.GetJournalEntryByID(id, userID)
+	}
+	if err != nil {
+		log.Printf("Error getting %s %d after sync conflict: %v", kind, id, err)
+		return nil
+	}
+	return record
+}
+
+// decodeMutationData unmarshals a mutation's data into the payload for its kind.
+func decodeMutationData(m types.SyncMutation, payload interface{}) error {
+	if len(m.Data) == 0 {
+		return errSyncInvalid("data is required")
+	}
+	if err := json.Unmarshal(m.Data, payload); err != nil {
+		return errSyncInvalid("invalid data: " + err.Error())
+	}
+	return nil
+}
+
+// resolveMutationRef returns the ID of the record created by an earlier
+// mutation of the given kind.
+func (h *SyncHandler) resolveMutationRef(userID int, mutationID, kind string) (int, error) {
+	refKind, id, err := h.sync.GetAppliedMutation(userID, mutationID)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && refKind != kind) {
+		return 0, errSyncInvalid(fmt.Sprintf("no applied %s mutation %q", kind, mutationID))
+	}
+	return id, err
+}
+
+func (h *SyncHandler) applyListMutation(stores db.MutationStores, userID int, m types.SyncMutation) (int, int, error) {
+	switch m.Op {
+	case "create":
+		var payload types.CreateTodoListPayload
+		if err := decodeMutationData(m, &payload); err != nil {
+			return 0, 0, err
+		}
+		if err := normalizeTodoList(&payload.Title, &payload.Color, &payload.Icon); err != nil {
+			return 0, 0, syncInvalidFromHTTP(err)
+		}
+		list, err := stores.Todos.CreateTodoList(payload, userID)
+		if err != nil {
+			return 0, 0, err
+		}
+		return list.ID, list.Version, nil
+	case "update":
+		var payload types.UpdateTodoListPayload
+		if err := decodeMutationData(m, &payload); err != nil {
+			return 0, 0, err
+		}
+		if err := normalizeTodoListUpdate(&payload); err != nil {
+			return 0, 0, syncInvalidFromHTTP(err)
+		}
+		list, err := stores.Todos.UpdateTodoList(m.ID, userID, payload, m.BaseVersion)
+		if err != nil {
+			return 0, 0, err
+		}
+		return list.ID, list.Version, nil
+	case "delete":
+		// Records that are already gone count as deleted.
+		if err := stores.Todos.DeleteTodoList(m.ID, userID, m.BaseVersion); err != nil && !errors.Is(err, db.ErrNotFound) {
+			return 0, 0, err
+		}
+		return m.ID, 0, nil
+	}
+	return 0, 0, errSyncInvalid("unsupported op " + strconv.Quote(m.Op) + " for lists")
+}
+
+func (h *SyncHandler) applyItemMutation(stores db.MutationStores, userID int, m types.SyncMutation) (int, int, error) {
+	switch m.Op {
+	case "create":
+		var payload types.CreateTodoItemPayload
+		if err := decodeMutationData(m, &payload); err != nil {
+			return 0, 0, err
+		}
+		listID := 0
+		switch {
+		case m.ListMutationID != "":
+			id, err := h.resolveMutationRef(userID, m.ListMutationID, types.SyncKindList)
+			if err != nil {
+				return 0, 0, err
+			}
+			listID = id
+		case m.ListID != nil:
+			listID = *m.ListID
+		default:
+			return 0, 0, errSyncInvalid("listId or listMutationId is required")
+		}
+		if m.ParentMutationID != "" {
+			id, err := h.resolveMutationRef(userID, m.ParentMutationID, types.SyncKindItem)
+			if err != nil {
+				return 0, 0, err
+			}
+			payload.ParentID = &id
+		}
+		var err error
+		if payload.RecurrenceRule, err = normalizeRecurrenceRule(payload.RecurrenceRule); err != nil {
+			return 0, 0, syncInvalidFromHTTP(err)
+		}
+		if payload.DueTime, err = normalizeDueTime(payload.DueTime, false); err != nil {
+			return 0, 0, syncInvalidFromHTTP(err)
+		}
+		if err := validateItemDetails(&payload.Priority, payload.Description); err != nil {
+			return 0, 0, syncInvalidFromHTTP(err)
+		}
+		item, err := stores.Todos.CreateTodoItem(payload, listID, userID)
+		if err != nil {
+			return 0, 0, err
+		}
+		return item.ID, item.Version, nil
+	case "update":
+		var payload types.UpdateTodoItemPayload
+		if err := decodeMutationData(m, &payload); err != nil {
+			return 0, 0, err
+		}
+		if err := normalizeTodoItemUpdate(&payload); err != nil {
+			return 0, 0, syncInvalidFromHTTP(err)
+		}
+		item, err := stores.Todos.UpdateTodoItem(m.ID, userID, payload, m.BaseVersion)
+		if err != nil {
+			return 0, 0, err
+		}
+		return item.ID, item.Version, nil
+	case "delete":
+		// Records that are already gone count as deleted.
+		if err := stores.Todos.DeleteTodoItem(m.ID, userID, m.BaseVersion); err != nil && !errors.Is(err, db.ErrNotFound) {
+			return 0, 0, err
+		}
+		return m.ID, 0, nil
+	}
+	return 0, 0, errSyncInvalid("unsupported op " + strconv.Quote(m.Op) + " for items")
+}
+
+func (h *SyncHandler) applyNoteMutation(stores db.MutationStores, userID int, m types.SyncMutation) (int, int, error) {
+	switch m.Op {
+	case "create":
+		var payload types.CreateNotePayload
+		if err := decodeMutationData(m, &payload); err != nil {
+			return 0, 0, err
+		}
+		if payload.Title == "" {
+			return 0, 0, errSyncInvalid("title is required")
+		}
+		note, err := stores.Notes.CreateNote(payload, userID)
+		if err != nil {
+			return 0, 0, err
+		}
+		return note.ID, note.Version, nil
+	case "update":
+		var payload types.UpdateNotePayload
+		if err := decodeMutationData(m, &payload); err != nil {
+			return 0, 0, err
+		}
+		note, err := stores.Notes.UpdateNote(m.ID, userID, payload, m.BaseVersion)
+		if err != nil {
+			return 0, 0, err
+		}
+		return note.ID, note.Version, nil
+	case "delete":
+		// Records that are already gone count as deleted.
+		if err := stores.Notes.DeleteNote(m.ID, userID, m.BaseVersion); err != nil && !errors.Is(err, db.ErrNotFound) {
+			return 0, 0, err
+		}
+		return m.ID, 0, nil
+	}
+	return 0, 0, errSyncInvalid("unsupported op " + strconv.Quote(m.Op) + " for notes")
+}
+
+func (h *SyncHandler) applyJournalMutation(stores db.MutationStores, userID int, m types.SyncMutation) (int, int, error) {
+	switch m.Op {
+	case "create":
+		var payload types.CreateJournalEntryPayload
+		if err := decodeMutationData(m, &payload); err != nil {
+			return 0, 0, err
+		}
+		if payload.Title == "" || payload.EntryDate.IsZero() {
+			return 0, 0, errSyncInvalid("title and entryDate are required")
+		}
+		payload.EntryDate = payload.EntryDate.Truncate(24 * time.Hour)
+		entry, err := stores.Journal.CreateJournalEntry(payload, userID)
+		if err != nil {
+			return 0, 0, err
+		}
+		return entry.ID, entry.Version, nil
+	case "update":
+		var payload types.UpdateJournalEntryPayload
+		if err := decodeMutationData(m, &payload); err != nil {
+			return 0, 0, err
+		}
+		entry, err := stores.Journal.UpdateJournalEntry(m.ID, userID, payload, m.BaseVersion)
+		if err != nil {
+			return 0, 0, err
+		}
+		return entry.ID, entry.Version, nil
+	case "delete":
+		// Records that are already gone count as deleted.
+		if err := stores.Journal.DeleteJournalEntry(m.ID, userID, m.BaseVersion); err != nil && !errors.Is(err, db.ErrNotFound) {
+			return 0, 0, err
+		}
+		return m.ID, 0, nil
+	}
+	return 0, 0, errSyncInvalid("unsupported op " + strconv.Quote(m.Op) + " for journal entries")
+}
+
+// syncInvalidFromHTTP turns a validation error built for an HTTP response into
+// a per-mutation error.
+func syncInvalidFromHTTP(err error) error {
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return errSyncInvalid(fmt.Sprint(he.Message))
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"tempo-backend/db"
+	"tempo-backend/types"
+
+	"github.com/labstack/echo/v4"
+)
+
+type TagHandler struct {
+	store *db.TagStore
+}
+
+func NewTagHandler(store *db.TagStore) *TagHandler {
+	return &TagHandler{store: store}
+}
+
+func (h *TagHandler) HandleCreateTag(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	var payload types.TagPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
+	}
+
+	tag, err := h.store.CreateTag(payload.Name, userID)
+	if errors.Is(err, db.ErrInvalidTag) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, db.ErrDuplicateTag) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		log.Printf("Error creating tag: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not create tag")
+	}
+	return c.JSON(http.StatusCreated, tag)
+}
+
+func (h *TagHandler) HandleGetTags(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	tags, err := h.store.GetTagsByUser(userID)
+	if err != nil {
+		log.Printf("Error getting tags: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve tags")
+	}
+	return c.JSON(http.StatusOK, tags)
+}
+
+func (h *TagHandler) HandleUpdateTag(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	tagID, err := strconv.Atoi(c.Param("tagId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid tag ID")
+	}
+
+	var payload types.TagPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
+	}
+
+	tag, err := h.store.RenameTag(tagID, userID, payload.Name)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Tag not found")
+	}
+	if errors.Is(err, db.ErrInvalidTag) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, db.ErrDuplicateTag) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		log.Printf("Error updating tag: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update tag")
+	}
+	return c.JSON(http.StatusOK, tag)
+}
+
+func (h *TagHandler) HandleDeleteTag(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	tagID, err := strconv.Atoi(c.Param("tagId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid tag ID")
+	}
+
+	err = h.store.DeleteTag(tagID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Tag not found")
+	}
+	if err != nil {
+		log.Printf("Error deleting tag: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not delete tag")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"tempo-backend/db"
+	"tempo-backend/types"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HandleGetTasks returns a page of the items in all of the user's lists,
+// picked by a built-in ?view= and narrowed by filters. The upcoming view
+// comes grouped by day; everything else is a plain array of tasks.
+func (h *TodoHandler) HandleGetTasks(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	var filter db.TaskFilter
+	switch view := c.QueryParam("view"); view {
+	case "", types.TaskViewToday, types.TaskViewUpcoming, types.TaskViewOverdue, types.TaskViewNoDate:
+		filter.View = view
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "view must be today, upcoming, overdue or no-date")
+	}
+
+	if dueAfter := c.QueryParam("dueAfter"); dueAfter != "" {
+		d, err := time.Parse("2006-01-02", dueAfter)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid dueAfter date, expected YYYY-MM-DD")
+		}
+		filter.DueAfter = &d
+	}
+	if dueBefore := c.QueryParam("dueBefore"); dueBefore != "" {
+		d, err := time.Parse("2006-01-02", dueBefore)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid dueBefore date, expected YYYY-MM-DD")
+		}
+		filter.DueBefore = &d
+	}
+	if overdue := c.QueryParam("overdue"); overdue != "" {
+		b, err := strconv.ParseBool(overdue)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid overdue value")
+		}
+		filter.Overdue = b
+	}
+	if completed := c.QueryParam("completed"); completed != "" {
+		b, err := strconv.ParseBool(completed)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid completed value")
+		}
+		filter.Completed = &b
+	}
+	if priorities := c.QueryParam("priority"); priorities != "" {
+		for _, p := range strings.Split(priorities, ",") {
+			n, err := strconv.Atoi(p)
+			if err != nil || n < 0 || n > types.MaxPriority {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid priority: "+p)
+			}
+			filter.Priorities = append(filter.Priorities, n)
+		}
+	}
+	filter.Tag = c.QueryParam("tag")
+	if listIDs := c.QueryParam("listIds"); listIDs != "" {
+		for _, id := range strings.Split(listIDs, ",") {
+			n, err := strconv.Atoi(id)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid list ID: "+id)
+			}
+			filter.ListIDs = append(filter.ListIDs, n)
+		}
+	}
+
+	opts, err := pageOptions(c)
+	if err != nil {
+		return err
+	}
+	tasks, next, err := h.store.GetTasks(userID, filter, opts)
+	if perr := pageError(err); perr != nil {
+		return perr
+	}
+	if err != nil {
+		log.Printf("Error getting tasks: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve tasks")
+	}
+	setNextPageLink(c, next)
+
+	if filter.View == types.TaskViewUpcoming {
+		return c.JSON(http.StatusOK, groupTasksByDay(tasks))
+	}
+	return c.JSON(http.StatusOK, tasks)
+}
+
+// groupTasksByDay groups tasks by their due date, earliest day first, keeping
+// the order of the tasks within each day. Tasks without a due date are left out.
+func groupTasksByDay(tasks []types.Task) []types.TaskDay {
+	days := make([]types.TaskDay, 0)
+	index := make(map[string]int)
+	for _, task := range tasks {
+		if task.DueDate == nil {
+			continue
+		}
+		date := task.DueDate.Format("2006-01-02")
+		i, ok := index[date]
+		if !ok {
+			i = len(days)
+			index[date] = i
+			days = append(days, types.TaskDay{Date: date, Tasks: make([]types.Task, 0)})
+		}
+		days[i].Tasks = append(days[i].Tasks, task)
+	}
+	sort.SliceStable(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days
+}
@@ -1,11 +1,18 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
 	"tempo-backend/db"
+	"tempo-backend/recurrence"
 	"tempo-backend/types"
+	"time"
+	"unicode/utf8"
 
 	"github.com/labstack/echo/v4"
 )
@@ -26,8 +33,8 @@ func (h *TodoHandler) HandleCreateTodoList(c echo.Context) error {
 	if err := c.Bind(&payload); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
 	}
-	if payload.Title == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Title is required")
+	if err := normalizeTodoList(&payload.Title, &payload.Color, &payload.Icon); err != nil {
+		return err
 	}
 
 	list, err := h.store.CreateTodoList(payload, userID)
@@ -35,17 +42,38 @@ func (h *TodoHandler) HandleCreateTodoList(c echo.Context) error {
 		log.Printf("Error creating todo list: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Could not create list")
 	}
-	return c.JSON(http.StatusCreated, list)
+	return respondWithETag(c, http.StatusCreated, versionETag(list.Version), list)
 }
 
+// HandleGetTodoLists returns a page of the user's lists, by default in their
+// order. Archived lists
+// are left out unless ?archived=true (only archived lists) or ?archived=all.
 func (h *TodoHandler) HandleGetTodoLists(c echo.Context) error {
 	userID := c.Get("userID").(int)
-	lists, err := h.store.GetTodoListsByUser(userID)
+	archived := new(bool)
+	switch c.QueryParam("archived") {
+	case "", "false":
+	case "true":
+		*archived = true
+	case "all":
+		archived = nil
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "archived must be true, false or all")
+	}
+	opts, err := pageOptions(c)
+	if err != nil {
+		return err
+	}
+	lists, next, err := h.store.GetTodoListsByUser(userID, archived, opts)
+	if perr := pageError(err); perr != nil {
+		return perr
+	}
 	if err != nil {
 		log.Printf("Error getting todo lists: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve lists")
 	}
-	return c.JSON(http.StatusOK, lists)
+	setNextPageLink(c, next)
+	return c.JSON(http.StatusOK, types.Page[types.TodoList]{Items: lists, NextCursor: next})
 }
 
 func (h *TodoHandler) HandleGetTodoListAndItems(c echo.Context) error {
@@ -57,25 +85,104 @@ func (h *TodoHandler) HandleGetTodoListAndItems(c echo.Context) error {
 
 	// First, verify the user owns this list
 	list, err := h.store.GetTodoListByID(listID, userID)
-	if err != nil {
+	if errors.Is(err, db.ErrNotFound) {
 		return echo.NewHTTPError(http.StatusNotFound, "List not found")
 	}
+	if err != nil {
+		log.Printf("Error getting todo list: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve list")
+	}
 
-	// Then, get the items for that list
-	items, err := h.store.GetTodoItemsByListID(listID)
+	// Then, get a page of its items, by default in the order the user arranged them
+	opts, err := pageOptions(c)
+	if err != nil {
+		return err
+	}
+	items, next, err := h.store.GetTodoItemsByListID(listID, userID, opts)
+	if perr := pageError(err); perr != nil {
+		return perr
+	}
 	if err != nil {
+		log.Printf("Error getting todo items: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve items")
 	}
+	setNextPageLink(c, next)
+
+	// Items come back flat with parent IDs; ?nest=true returns them as a tree instead.
+	nested := c.QueryParam("nest") == "true"
+	etag := listETag(list, items, nested)
+	rollUpSubtasks(items)
+	if nested {
+		items = nestSubtasks(items)
+	}
 
 	response := struct {
 		*types.TodoList
-		Items []types.TodoItem `json:"items"`
+		Items      []types.TodoItem `json:"items"`
+		NextCursor string           `json:"nextCursor,omitempty"`
 	}{
-		TodoList: list,
-		Items:    items,
+		TodoList:   list,
+		Items:      items,
+		NextCursor: next,
 	}
 
-	return c.JSON(http.StatusOK, response)
+	return respondWithETag(c, http.StatusOK, etag, response)
+}
+
+// HandleUpdateTodoList applies a partial update to a list: its title, color,
+// icon, archived flag or position.
+func (h *TodoHandler) HandleUpdateTodoList(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	listID, err := strconv.Atoi(c.Param("listId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid list ID")
+	}
+
+	var payload types.UpdateTodoListPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
+	}
+	if err := normalizeTodoListUpdate(&payload); err != nil {
+		return err
+	}
+
+	list, err := h.store.UpdateTodoList(listID, userID, payload, ifMatchVersion(c))
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "List not found")
+	}
+	if errors.Is(err, db.ErrVersionConflict) {
+		current, err := h.store.GetTodoListByID(listID, userID)
+		if err != nil {
+			log.Printf("Error getting todo list: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve list")
+		}
+		return preconditionFailed(c, current, current.Version)
+	}
+	if err != nil {
+		log.Printf("Error updating todo list: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update list")
+	}
+	return respondWithETag(c, http.StatusOK, versionETag(list.Version), list)
+}
+
+// HandleReorderTodoLists sets the order of the user's lists. Lists left out
+// of listIds keep their order after the ones given.
+func (h *TodoHandler) HandleReorderTodoLists(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	var payload types.ReorderTodoListsPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
+	}
+
+	lists, err := h.store.ReorderTodoLists(userID, payload.ListIDs)
+	if errors.Is(err, db.ErrInvalidListOrder) {
+		return echo.NewHTTPError(http.StatusBadRequest, "listIds must name each of your lists at most once")
+	}
+	if err != nil {
+		log.Printf("Error reordering todo lists: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not reorder lists")
+	}
+	return c.JSON(http.StatusOK, lists)
 }
 
 func (h *TodoHandler) HandleDeleteTodoList(c echo.Context) error {
@@ -85,43 +192,119 @@ func (h *TodoHandler) HandleDeleteTodoList(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid list ID")
 	}
 
-	err = h.store.DeleteTodoList(listID, userID)
+	err = h.store.DeleteTodoList(listID, userID, nil)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "List not found")
+	}
 	if err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "List not found or not authorized")
+		log.Printf("Error deleting todo list: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not delete list")
 	}
 
 	return c.NoContent(http.StatusNoContent)
 }
 
 // --- Item Handlers ---
+//
+// Ownership is enforced by the store, which scopes every item query to the
+// authenticated user. Items owned by someone else come back as db.ErrNotFound
+// and are reported as 404 so IDs can't be probed.
 
 func (h *TodoHandler) HandleCreateTodoItem(c echo.Context) error {
-	// Security check: Ensure the user owns the list they're adding an item to.
 	userID := c.Get("userID").(int)
 	listID, err := strconv.Atoi(c.Param("listId"))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid list ID")
 	}
-	if _, err := h.store.GetTodoListByID(listID, userID); err != nil {
-		return echo.NewHTTPError(http.StatusForbidden, "Not authorized to add to this list")
-	}
 
 	var payload types.CreateTodoItemPayload
 	if err := c.Bind(&payload); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
 	}
+	if payload.RecurrenceRule, err = normalizeRecurrenceRule(payload.RecurrenceRule); err != nil {
+		return err
+	}
+	if payload.DueTime, err = normalizeDueTime(payload.DueTime, false); err != nil {
+		return err
+	}
+	if err := validateItemDetails(&payload.Priority, payload.Description); err != nil {
+		return err
+	}
 
-	item, err := h.store.CreateTodoItem(payload, listID)
+	item, err := h.store.CreateTodoItem(payload, listID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "List not found")
+	}
+	if errors.Is(err, db.ErrInvalidParent) || errors.Is(err, db.ErrMaxDepthExceeded) || errors.Is(err, db.ErrRecurrenceNeedsDueDate) ||
+		errors.Is(err, db.ErrInvalidTag) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 	if err != nil {
 		log.Printf("Error creating todo item: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Could not create item")
 	}
-	return c.JSON(http.StatusCreated, item)
+	return respondWithETag(c, http.StatusCreated, versionETag(item.Version), item)
+}
+
+// HandleMoveTodoItem moves an item right before or after another item in its
+// list, or to the end of the list, for drag and drop.
+func (h *TodoHandler) HandleMoveTodoItem(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	listID, err := strconv.Atoi(c.Param("listId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid list ID")
+	}
+
+	var payload types.MoveTodoItemPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
+	}
+	if payload.BeforeID != nil && payload.AfterID != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Give beforeId or afterId, not both")
+	}
+
+	item, err := h.store.MoveTodoItem(listID, userID, payload, ifMatchVersion(c))
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Item not found")
+	}
+	if errors.Is(err, db.ErrInvalidMoveTarget) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, db.ErrVersionConflict) {
+		current, err := h.store.GetTodoItemByID(payload.ItemID, userID)
+		if err != nil {
+			log.Printf("Error getting item: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve item")
+		}
+		return preconditionFailed(c, current, current.Version)
+	}
+	if err != nil {
+		log.Printf("Error moving item: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not move item")
+	}
+	return respondWithETag(c, http.StatusOK, versionETag(item.Version), item)
+}
+
+func (h *TodoHandler) HandleGetTodoItem(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	itemID, err := strconv.Atoi(c.Param("itemId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid item ID")
+	}
+
+	item, err := h.store.GetTodoItemByID(itemID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Item not found")
+	}
+	if err != nil {
+		log.Printf("Error getting item: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve item")
+	}
+	return respondWithETag(c, http.StatusOK, versionETag(item.Version), item)
 }
 
 func (h *TodoHandler) HandleUpdateTodoItem(c echo.Context) error {
-	// Security check would go here to ensure the item belongs to the authenticated user.
-	// This is often done by joining tables in the SQL query itself. Omitted for brevity.
+	userID := c.Get("userID").(int)
 	itemID, err := strconv.Atoi(c.Param("itemId"))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid item ID")
@@ -131,26 +314,298 @@ func (h *TodoHandler) HandleUpdateTodoItem(c echo.Context) error {
 	if err := c.Bind(&payload); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid payload")
 	}
+	if err := normalizeTodoItemUpdate(&payload); err != nil {
+		return err
+	}
 
-	item, err := h.store.UpdateTodoItem(itemID, payload)
+	item, err := h.store.UpdateTodoItem(itemID, userID, payload, ifMatchVersion(c))
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Item not found")
+	}
+	if errors.Is(err, db.ErrListNotFound) {
+		return echo.NewHTTPError(http.StatusBadRequest, "List not found")
+	}
+	if errors.Is(err, db.ErrVersionConflict) {
+		current, err := h.store.GetTodoItemByID(itemID, userID)
+		if err != nil {
+			log.Printf("Error getting item: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve item")
+		}
+		return preconditionFailed(c, current, current.Version)
+	}
+	if errors.Is(err, db.ErrRecurrenceNeedsDueDate) || errors.Is(err, db.ErrInvalidTag) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 	if err != nil {
 		log.Printf("Error updating item: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update item")
 	}
-	return c.JSON(http.StatusOK, item)
+	return respondWithETag(c, http.StatusOK, versionETag(item.Version), item)
 }
 
 func (h *TodoHandler) HandleDeleteTodoItem(c echo.Context) error {
-	// Security check would go here.
+	userID := c.Get("userID").(int)
 	itemID, err := strconv.Atoi(c.Param("itemId"))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid item ID")
 	}
 
-	err = h.store.DeleteTodoItem(itemID)
-	if err != nil {
+	err = h.store.DeleteTodoItem(itemID, userID, nil)
+	if errors.Is(err, db.ErrNotFound) {
 		return echo.NewHTTPError(http.StatusNotFound, "Item not found")
 	}
+	if err != nil {
+		log.Printf("Error deleting item: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not delete item")
+	}
 
 	return c.NoContent(http.StatusNoContent)
 }
+
+// --- Recurrence Handlers ---
+
+func (h *TodoHandler) HandleSkipTodoItemOccurrence(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	itemID, err := strconv.Atoi(c.Param("itemId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid item ID")
+	}
+
+	item, err := h.store.SkipTodoItemOccurrence(itemID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Item not found")
+	}
+	if errors.Is(err, db.ErrNotRecurring) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		log.Printf("Error skipping occurrence: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not skip occurrence")
+	}
+	return respondWithETag(c, http.StatusOK, versionETag(item.Version), item)
+}
+
+func (h *TodoHandler) HandleEndTodoItemRecurrence(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	itemID, err := strconv.Atoi(c.Param("itemId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid item ID")
+	}
+
+	item, err := h.store.EndTodoItemRecurrence(itemID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Item not found")
+	}
+	if errors.Is(err, db.ErrNotRecurring) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		log.Printf("Error ending recurrence: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not end recurrence")
+	}
+	return respondWithETag(c, http.StatusOK, versionETag(item.Version), item)
+}
+
+func (h *TodoHandler) HandleGetTodoItemOccurrences(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	itemID, err := strconv.Atoi(c.Param("itemId"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid item ID")
+	}
+
+	if _, err := h.store.GetTodoItemByID(itemID, userID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "Item not found")
+		}
+		log.Printf("Error getting item: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve occurrences")
+	}
+
+	occurrences, err := h.store.GetTodoItemOccurrences(itemID, userID)
+	if err != nil {
+		log.Printf("Error getting occurrences: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve occurrences")
+	}
+	return c.JSON(http.StatusOK, occurrences)
+}
+
+// normalizeRecurrenceRule validates an RRULE from a payload and returns it in canonical form.
+func normalizeRecurrenceRule(rule *string) (*string, error) {
+	if rule == nil {
+		return nil, nil
+	}
+	parsed, err := recurrence.Parse(*rule)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid recurrence rule: "+err.Error())
+	}
+	canonical := parsed.String()
+	return &canonical, nil
+}
+
+// maxItemDescriptionLength is the longest item description, in characters.
+const maxItemDescriptionLength = 10000
+
+// normalizeTodoItemUpdate validates an item update, normalizing its
+// recurrence rule and due time.
+func normalizeTodoItemUpdate(payload *types.UpdateTodoItemPayload) error {
+	var err error
+	if payload.RecurrenceRule, err = normalizeRecurrenceRule(payload.RecurrenceRule); err != nil {
+		return err
+	}
+	if payload.DueTime, err = normalizeDueTime(payload.DueTime, true); err != nil {
+		return err
+	}
+	if payload.CompletedAt != nil && payload.IsCompleted != nil && !*payload.IsCompleted {
+		return echo.NewHTTPError(http.StatusBadRequest, "completedAt can't be set on an item being reopened")
+	}
+	return validateItemDetails(payload.Priority, payload.Description)
+}
+
+// validateItemDetails checks an item's priority and description, either of
+// which may be missing.
+func validateItemDetails(priority *int, description *string) error {
+	if priority != nil && (*priority < 0 || *priority > types.MaxPriority) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("priority must be between 0 and %d", types.MaxPriority))
+	}
+	if description != nil && utf8.RuneCountInString(*description) > maxItemDescriptionLength {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("description must be at most %d characters", maxItemDescriptionLength))
+	}
+	return nil
+}
+
+// normalizeDueTime validates a due time of day from a payload and returns it
+// as "15:04". An empty string, which clears the due time, is let through when
+// clearable is set.
+func normalizeDueTime(dueTime *string, clearable bool) (*string, error) {
+	if dueTime == nil || (clearable && *dueTime == "") {
+		return dueTime, nil
+	}
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, *dueTime); err == nil {
+			normalized := t.Format("15:04")
+			return &normalized, nil
+		}
+	}
+	return nil, echo.NewHTTPError(http.StatusBadRequest, "dueTime must be a time of day like 14:30")
+}
+
+// maxListTitleLength and maxListIconLength are the longest list titles and
+// icons, in characters.
+const (
+	maxListTitleLength = 255
+	maxListIconLength  = 64
+)
+
+// listColorPattern matches the "#rrggbb" colors lists can have.
+var listColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// normalizeTodoList validates the title, color and icon of a new list. An
+// empty color or icon is the same as none.
+func normalizeTodoList(title *string, color, icon **string) error {
+	if err := normalizeListTitle(title); err != nil {
+		return err
+	}
+	if err := normalizeListAppearance(*color, *icon); err != nil {
+		return err
+	}
+	if *color != nil && **color == "" {
+		*color = nil
+	}
+	if *icon != nil && **icon == "" {
+		*icon = nil
+	}
+	return nil
+}
+
+// normalizeTodoListUpdate validates a list update. An empty color or icon,
+// which clears it, is let through.
+func normalizeTodoListUpdate(payload *types.UpdateTodoListPayload) error {
+	if payload.Title != nil {
+		if err := normalizeListTitle(payload.Title); err != nil {
+			return err
+		}
+	}
+	return normalizeListAppearance(payload.Color, payload.Icon)
+}
+
+// normalizeListTitle trims a list title in place and checks its length.
+func normalizeListTitle(title *string) error {
+	*title = strings.TrimSpace(*title)
+	if *title == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Title is required")
+	}
+	if utf8.RuneCountInString(*title) > maxListTitleLength {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Title must be at most %d characters", maxListTitleLength))
+	}
+	return nil
+}
+
+// normalizeListAppearance checks a list's color and icon, lowercasing the
+// color and trimming the icon in place. Empty values are allowed.
+func normalizeListAppearance(color, icon *string) error {
+	if color != nil && *color != "" {
+		if !listColorPattern.MatchString(*color) {
+			return echo.NewHTTPError(http.StatusBadRequest, "color must be a hex color like #3b82f6")
+		}
+		*color = strings.ToLower(*color)
+	}
+	if icon != nil {
+		*icon = strings.TrimSpace(*icon)
+		if utf8.RuneCountInString(*icon) > maxListIconLength {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("icon must be at most %d characters", maxListIconLength))
+		}
+	}
+	return nil
+}
+
+// rollUpSubtasks fills in the subtask counts and progress of every item from its direct children.
+func rollUpSubtasks(items []types.TodoItem) {
+	index := make(map[int]int, len(items))
+	for i := range items {
+		index[items[i].ID] = i
+	}
+	for _, item := range items {
+		if item.ParentID == nil {
+			continue
+		}
+		if p, ok := index[*item.ParentID]; ok {
+			items[p].SubtaskCount++
+			if item.IsCompleted {
+				items[p].CompletedSubtaskCount++
+			}
+		}
+	}
+	for i := range items {
+		if items[i].SubtaskCount > 0 {
+			progress := float64(items[i].CompletedSubtaskCount) / float64(items[i].SubtaskCount)
+			items[i].Progress = &progress
+		}
+	}
+}
+
+// nestSubtasks turns a flat item list into a tree of top-level items with their
+// subtasks attached, preserving the original order at every level.
+func nestSubtasks(items []types.TodoItem) []types.TodoItem {
+	children := make(map[int][]types.TodoItem)
+	for _, item := range items {
+		if item.ParentID != nil {
+			children[*item.ParentID] = append(children[*item.ParentID], item)
+		}
+	}
+
+	var attach func(item types.TodoItem) types.TodoItem
+	attach = func(item types.TodoItem) types.TodoItem {
+		for _, child := range children[item.ID] {
+			item.Subtasks = append(item.Subtasks, attach(child))
+		}
+		return item
+	}
+
+	roots := make([]types.TodoItem, 0)
+	for _, item := range items {
+		if item.ParentID == nil {
+			roots = append(roots, attach(item))
+		}
+	}
+	return roots
+}
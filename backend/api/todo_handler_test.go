@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tempo-backend/db"
+	"tempo-backend/types"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+)
+
+// testPool connects to the database in TEST_DATABASE_URL and migrates a schema
+// of its own, dropped again when the test ends. Tests that need a database are
+// skipped without one.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+	defer admin.Close(ctx)
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		conn, err := pgx.Connect(context.Background(), url)
+		if err != nil {
+			return
+		}
+		defer conn.Close(context.Background())
+		conn.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+	})
+
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatalf("parsing TEST_DATABASE_URL: %v", err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("connecting to the test database: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	migrate(t, pool)
+	return pool
+}
+
+// migrate runs the Flyway migrations in db/migrations in version order.
+func migrate(t *testing.T, pool *pgxpool.Pool) {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join("..", "..", "db", "migrations", "V*__*.sql"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("finding migrations: %v", err)
+	}
+	version := func(path string) int {
+		v, _ := strconv.Atoi(strings.TrimPrefix(strings.SplitN(filepath.Base(path), "__", 2)[0], "V"))
+		return v
+	}
+	sort.Slice(files, func(i, j int) bool { return version(files[i]) < version(files[j]) })
+
+	ctx := context.Background()
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquiring a connection: %v", err)
+	}
+	defer conn.Release()
+	for _, file := range files {
+		sql, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("reading %s: %v", file, err)
+		}
+		// The simple protocol runs a whole file of statements at once.
+		if _, err := conn.Conn().PgConn().Exec(ctx, string(sql)).ReadAll(); err != nil {
+			t.Fatalf("running %s: %v", filepath.Base(file), err)
+		}
+	}
+}
+
+func createTestUser(t *testing.T, pool *pgxpool.Pool, name string) int {
+	t.Helper()
+	var id int
+	err := pool.QueryRow(context.Background(), `INSERT INTO users (username, email, password_hash)
+												VALUES ($1, $1 || '@example.com', 'x') RETURNING id`, name).Scan(&id)
+	if err != nil {
+		t.Fatalf("creating user %s: %v", name, err)
+	}
+	return id
+}
+
+// serve calls a handler as the given user, with the given path parameters,
+// and returns the status code it responded or failed with.
+func serve(handler echo.HandlerFunc, userID int, method, body string, params map[string]int) int {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set("userID", userID)
+	for name, value := range params {
+		c.SetParamNames(append(c.ParamNames(), name)...)
+		c.SetParamValues(append(c.ParamValues(), strconv.Itoa(value))...)
+	}
+
+	err := handler(c)
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code
+	}
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return rec.Code
+}
+
+// TestTodoRoutesHideOtherUsersRecords checks that every list and item route
+// answers 404 when one user asks for another user's list, item or reminder,
+// and that the owner's records are left as they were.
+func TestTodoRoutesHideOtherUsersRecords(t *testing.T) {
+	pool := testPool(t)
+	todoStore := db.NewTodoStore(pool)
+	reminderStore := db.NewReminderStore(pool)
+	todos := NewTodoHandler(todoStore)
+	reminders := NewReminderHandler(reminderStore)
+
+	owner := createTestUser(t, pool, "owner")
+	intruder := createTestUser(t, pool, "intruder")
+
+	list, err := todoStore.CreateTodoList(types.CreateTodoListPayload{Title: "Owner's list"}, owner)
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+	tomorrow := time.Now().AddDate(0, 0, 1).Truncate(24 * time.Hour)
+	rule := "FREQ=DAILY"
+	item, err := todoStore.CreateTodoItem(types.CreateTodoItemPayload{Task: "Owner's task", DueDate: &tomorrow,
+		RecurrenceRule: &rule}, list.ID, owner)
+	if err != nil {
+		t.Fatalf("creating item: %v", err)
+	}
+	remindAt := time.Now().Add(time.Hour)
+	reminder, err := reminderStore.CreateReminder(item.ID, owner, types.CreateReminderPayload{RemindAt: &remindAt,
+		Timezone: "UTC", Channels: []string{types.ReminderChannelInApp}})
+	if err != nil {
+		t.Fatalf("creating reminder: %v", err)
+	}
+	ownList, err := todoStore.CreateTodoList(types.CreateTodoListPayload{Title: "Intruder's list"}, intruder)
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+
+	later := time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339)
+	routes := []struct {
+		name    string
+		handler echo.HandlerFunc
+		method  string
+		body    string
+		params  map[string]int
+	}{
+		{"GET /lists/:listId", todos.HandleGetTodoListAndItems, http.MethodGet, "", map[string]int{"listId": list.ID}},
+		{"PUT /lists/:listId", todos.HandleUpdateTodoList, http.MethodPut, `{"title":"Taken"}`, map[string]int{"listId": list.ID}},
+		{"DELETE /lists/:listId", todos.HandleDeleteTodoList, http.MethodDelete, "", map[string]int{"listId": list.ID}},
+		{"POST /lists/:listId/items", todos.HandleCreateTodoItem, http.MethodPost, `{"task":"Planted"}`,
+			map[string]int{"listId": list.ID}},
+		{"POST /lists/:listId/items/reorder", todos.HandleMoveTodoItem, http.MethodPost,
+			fmt.Sprintf(`{"itemId":%d}`, item.ID), map[string]int{"listId": list.ID}},
+		{"POST /lists/:listId/items/reorder into own list", todos.HandleMoveTodoItem, http.MethodPost,
+			fmt.Sprintf(`{"itemId":%d}`, item.ID), map[string]int{"listId": ownList.ID}},
+		{"GET /items/:itemId", todos.HandleGetTodoItem, http.MethodGet, "", map[string]int{"itemId": item.ID}},
+		{"PUT /items/:itemId", todos.HandleUpdateTodoItem, http.MethodPut, `{"task":"Taken","isCompleted":true}`,
+			map[string]int{"itemId": item.ID}},
+		{"DELETE /items/:itemId", todos.HandleDeleteTodoItem, http.MethodDelete, "", map[string]int{"itemId": item.ID}},
+		{"POST /items/:itemId/skip", todos.HandleSkipTodoItemOccurrence, http.MethodPost, "", map[string]int{"itemId": item.ID}},
+		{"DELETE /items/:itemId/recurrence", todos.HandleEndTodoItemRecurrence, http.MethodDelete, "",
+			map[string]int{"itemId": item.ID}},
+		{"GET /items/:itemId/occurrences", todos.HandleGetTodoItemOccurrences, http.MethodGet, "",
+			map[string]int{"itemId": item.ID}},
+		{"GET /items/:itemId/reminders", reminders.HandleGetReminders, http.MethodGet, "", map[string]int{"itemId": item.ID}},
+		{"POST /items/:itemId/reminders", reminders.HandleCreateReminder, http.MethodPost,
+			fmt.Sprintf(`{"remindAt":%q}`, later), map[string]int{"itemId": item.ID}},
+		{"DELETE /items/:itemId/reminders/:reminderId", reminders.HandleDeleteReminder, http.MethodDelete, "",
+			map[string]int{"itemId": item.ID, "reminderId": reminder.ID}},
+	}
+	for _, route := range routes {
+		t.Run(route.name, func(t *testing.T) {
+			if code := serve(route.handler, intruder, route.method, route.body, route.params); code != http.StatusNotFound {
+				t.Errorf("got status %d, want %d", code, http.StatusNotFound)
+			}
+		})
+	}
+
+	gotList, err := todoStore.GetTodoListByID(list.ID, owner)
+	if err != nil || gotList.Title != list.Title || gotList.Version != list.Version {
+		t.Errorf("owner's list changed: %+v, %v", gotList, err)
+	}
+	items, _, err := todoStore.GetTodoItemsByListID(list.ID, owner, db.PageOptions{})
+	if err != nil || len(items) != 1 {
+		t.Fatalf("owner's list has items %+v, %v; want just the original", items, err)
+	}
+	if got := items[0]; got.ID != item.ID || got.Task != item.Task || got.IsCompleted || got.Version != item.Version ||
+		got.RecurrenceRule == nil {
+		t.Errorf("owner's item changed: %+v", got)
+	}
+	gotReminders, err := reminderStore.GetRemindersByItem(item.ID, owner)
+	if err != nil || len(gotReminders) != 1 {
+		t.Errorf("owner's reminders are %+v, %v; want just the original", gotReminders, err)
+	}
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"tempo-backend/db"
+	"tempo-backend/totp"
+	"tempo-backend/types"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// loginChallengeTTL is how long a user has to enter their code after their password.
+	loginChallengeTTL = 5 * time.Minute
+	// recoveryCodeCount is how many recovery codes a user is given at a time.
+	recoveryCodeCount = 10
+	// totpIssuer names the account in authenticator apps.
+	totpIssuer = "Tempo"
+)
+
+// respondWithChallenge answers a correct password from a user with two-factor
+// authentication with a challenge token instead of a session.
+func (h *UserHandler) respondWithChallenge(c echo.Context, userID int, deviceName *string) error {
+	token, err := randomToken()
+	if err != nil {
+		log.Printf("Error generating login challenge: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create token")
+	}
+	if err := h.store.CreateLoginChallenge(userID, deviceName, hashToken(token), loginChallengeTTL); err != nil {
+		log.Printf("Error creating login challenge: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create token")
+	}
+	return c.JSON(http.StatusOK, types.TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int(loginChallengeTTL.Seconds()),
+	})
+}
+
+// secondFactor reads a code as an authenticator code if it is all digits and
+// as a recovery code otherwise.
+func secondFactor(code string) db.SecondFactor {
+	code = strings.TrimSpace(code)
+	if code != "" && strings.Trim(code, "0123456789") == "" {
+		return db.SecondFactor{Code: code}
+	}
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return db.SecondFactor{RecoveryHash: hashToken(normalized)}
+}
+
+// generateRecoveryCodes returns a fresh set of recovery codes, formatted for
+// the user as xxxxx-xxxxx, and the hashes to store for them.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	buf := make([]byte, 10) // Base32 of 10 bytes is 16 characters; 10 of them carry 50 random bits
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(buf))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashToken(raw)
+	}
+	return codes, hashes, nil
+}
+
+// HandleLoginTwoFactor completes a two-factor login with the challenge token
+// from the password step and an authenticator or recovery code.
+func (h *UserHandler) HandleLoginTwoFactor(c echo.Context) error {
+	var payload types.TwoFactorLoginPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.ChallengeToken == "" || payload.Code == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "challengeToken and code are required")
+	}
+
+	userID, deviceName, err := h.store.CompleteLoginChallenge(hashToken(payload.ChallengeToken), secondFactor(payload.Code))
+	if errors.Is(err, db.ErrInvalidToken) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Login challenge expired, log in again")
+	}
+	if errors.Is(err, db.ErrInvalidTwoFactorCode) || errors.Is(err, db.ErrTwoFactorNotEnabled) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid code")
+	}
+	if err != nil {
+		log.Printf("Error completing login challenge: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to log in")
+	}
+
+	return h.startSession(c, userID, deviceName)
+}
+
+// HandleGetTwoFactor reports whether the user has two-factor authentication enabled.
+func (h *UserHandler) HandleGetTwoFactor(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	status, err := h.store.GetTwoFactorStatus(userID)
+	if err != nil {
+		log.Printf("Error getting two-factor status: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve two-factor status")
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// HandleSetupTwoFactor starts enrolling an authenticator app. Calling it again
+// before verifying replaces the secret.
+func (h *UserHandler) HandleSetupTwoFactor(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not set up two-factor authentication")
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not set up two-factor authentication")
+	}
+
+	err = h.store.StartTwoFactorSetup(userID, secret)
+	if errors.Is(err, db.ErrTwoFactorEnabled) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		log.Printf("Error starting two-factor setup: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not set up two-factor authentication")
+	}
+	return c.JSON(http.StatusOK, types.TwoFactorSetupResponse{
+		Secret:     secret,
+		OtpauthURI: totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// HandleVerifyTwoFactor enables two-factor authentication once the user enters
+// a code from the authenticator they enrolled, and returns their recovery codes.
+func (h *UserHandler) HandleVerifyTwoFactor(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	var payload types.TwoFactorCodePayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not enable two-factor authentication")
+	}
+
+	err = h.store.EnableTwoFactor(userID, strings.TrimSpace(payload.Code), hashes)
+	if errors.Is(err, db.ErrTwoFactorEnabled) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if errors.Is(err, db.ErrTwoFactorNotEnabled) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Set up two-factor authentication first")
+	}
+	if errors.Is(err, db.ErrInvalidTwoFactorCode) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid code")
+	}
+	if err != nil {
+		log.Printf("Error enabling two-factor authentication: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not enable two-factor authentication")
+	}
+	return c.JSON(http.StatusOK, types.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// HandleDisableTwoFactor turns two-factor authentication off, confirmed with a current code.
+func (h *UserHandler) HandleDisableTwoFactor(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	var payload types.TwoFactorCodePayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+
+	err := h.store.DisableTwoFactor(userID, secondFactor(payload.Code))
+	if err := twoFactorCodeError(err); err != nil {
+		return err
+	}
+	if err != nil {
+		log.Printf("Error disabling two-factor authentication: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not disable two-factor authentication")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleRegenerateRecoveryCodes replaces the user's recovery codes, confirmed with a current code.
+func (h *UserHandler) HandleRegenerateRecoveryCodes(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	var payload types.TwoFactorCodePayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not regenerate recovery codes")
+	}
+
+	err = h.store.RegenerateRecoveryCodes(userID, secondFactor(payload.Code), hashes)
+	if err := twoFactorCodeError(err); err != nil {
+		return err
+	}
+	if err != nil {
+		log.Printf("Error regenerating recovery codes: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not regenerate recovery codes")
+	}
+	return c.JSON(http.StatusOK, types.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// twoFactorCodeError maps the errors of confirming a change with a code to responses.
+func twoFactorCodeError(err error) error {
+	switch {
+	case errors.Is(err, db.ErrTwoFactorNotEnabled):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, db.ErrInvalidTwoFactorCode):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid code")
+	}
+	return nil
+}
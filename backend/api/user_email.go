@@ -0,0 +1,185 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"tempo-backend/db"
+	"tempo-backend/mail"
+	"tempo-backend/types"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+)
+
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+	// maxEmailTokensPerHour caps how many verification or reset emails an
+	// account is sent an hour, however many addresses ask.
+	maxEmailTokensPerHour = 3
+	minPasswordLength     = 8
+)
+
+// AuthRateLimiter limits each client IP to a handful of requests a minute on
+// the routes that send email or check emailed tokens and two-factor codes,
+// against both mail flooding and guessing. It counts per backend instance.
+func AuthRateLimiter() echo.MiddlewareFunc {
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(10.0 / 60), // 10 a minute, after the burst
+			Burst:     5,
+			ExpiresIn: 10 * time.Minute,
+		}),
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			return c.RealIP(), nil
+		},
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			return echo.NewHTTPError(http.StatusTooManyRequests, "Too many requests, try again later")
+		},
+	})
+}
+
+// appURL is the web app's base URL, which emailed links point into.
+func appURL() string {
+	if u := os.Getenv("APP_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return "http://localhost:3000"
+}
+
+// sendTokenEmail issues the user a single-use token for the address to and
+// emails it a link to the web app page that uses it.
+func (h *UserHandler) sendTokenEmail(user *types.User, to, purpose, template, page string, ttl time.Duration) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	if err := h.store.CreateUserToken(user.ID, purpose, to, hashToken(token), ttl, maxEmailTokensPerHour, time.Hour); err != nil {
+		return err
+	}
+
+	msg, err := mail.Render(template, to, struct {
+		Username, Email, Link, ExpiresIn string
+	}{
+		Username:  user.Username,
+		Email:     to,
+		Link:      appURL() + page + "?token=" + url.QueryEscape(token),
+		ExpiresIn: describeDuration(ttl),
+	})
+	if err != nil {
+		return err
+	}
+	return h.mailer.Send(msg)
+}
+
+// describeDuration renders a whole number of hours for an email, e.g. "48 hours".
+func describeDuration(d time.Duration) string {
+	hours := int(d.Hours())
+	if hours == 1 {
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d hours", hours)
+}
+
+// HandleVerifyEmail confirms the user's email address with the token from a verification email.
+func (h *UserHandler) HandleVerifyEmail(c echo.Context) error {
+	var payload types.VerifyEmailPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.Token == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "token is required")
+	}
+
+	err := h.store.VerifyEmail(hashToken(payload.Token))
+	if errors.Is(err, db.ErrInvalidToken) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid or expired token")
+	}
+	if err != nil {
+		log.Printf("Error verifying email: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not verify email")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleResendVerification sends the user another verification email.
+func (h *UserHandler) HandleResendVerification(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	user, err := h.store.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not send verification email")
+	}
+	if user.EmailVerifiedAt != nil {
+		return echo.NewHTTPError(http.StatusConflict, "Email is already verified")
+	}
+
+	err = h.sendTokenEmail(user, user.Email, db.TokenPurposeVerifyEmail, "verify_email", "/verify-email", verifyEmailTTL)
+	if errors.Is(err, db.ErrTokenRateLimited) {
+		return echo.NewHTTPError(http.StatusTooManyRequests, "Too many verification emails, try again later")
+	}
+	if err != nil {
+		log.Printf("Error sending verification email: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not send verification email")
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// HandleForgotPassword emails a password reset link to the address, if it
+// belongs to an account. The response is the same either way, and the email
+// goes out in the background so timing doesn't tell either.
+func (h *UserHandler) HandleForgotPassword(c echo.Context) error {
+	var payload types.ForgotPasswordPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.Email == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "email is required")
+	}
+
+	go func() {
+		user, err := h.store.GetUserByEmail(payload.Email)
+		if err != nil {
+			return
+		}
+		err = h.sendTokenEmail(user, user.Email, db.TokenPurposeResetPassword, "reset_password", "/reset-password", resetPasswordTTL)
+		if err != nil && !errors.Is(err, db.ErrTokenRateLimited) {
+			log.Printf("Error sending password reset email: %v", err)
+		}
+	}()
+	return c.NoContent(http.StatusAccepted)
+}
+
+// HandleResetPassword sets a new password with the token from a reset email
+// and logs the user out everywhere.
+func (h *UserHandler) HandleResetPassword(c echo.Context) error {
+	var payload types.ResetPasswordPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.Token == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "token is required")
+	}
+	if len(payload.Password) < minPasswordLength {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+	}
+
+	err := h.store.ResetPassword(hashToken(payload.Token), payload.Password)
+	if errors.Is(err, db.ErrInvalidToken) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid or expired token")
+	}
+	if err != nil {
+		log.Printf("Error resetting password: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not reset password")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
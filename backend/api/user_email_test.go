@@ -0,0 +1,116 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"tempo-backend/db"
+	"tempo-backend/mail"
+	"tempo-backend/mail/mailtest"
+)
+
+var emailedToken = regexp.MustCompile(`\?token=(\S+)`)
+
+// receiveToken waits for the next email to the address and returns the
+// token from the link in it.
+func receiveToken(t *testing.T, server *mailtest.Server, to, subject string) string {
+	t.Helper()
+	msg, ok := server.Receive(5 * time.Second)
+	if !ok {
+		t.Fatalf("no %q email arrived", subject)
+	}
+	header, err := msg.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("To") != to || header.Get("Subject") != subject {
+		t.Fatalf("got an email to %q about %q, want one to %q about %q",
+			header.Get("To"), header.Get("Subject"), to, subject)
+	}
+	text, err := msg.Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := emailedToken.FindStringSubmatch(text)
+	if match == nil {
+		t.Fatalf("no link with a token in %q", text)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// TestEmailFlows registers a user, verifies the address with the emailed
+// link and resets the password with another, all through SMTP.
+func TestEmailFlows(t *testing.T) {
+	pool := testPool(t)
+	server := mailtest.NewServer(t)
+	t.Setenv("SMTP_HOST", server.Host)
+	t.Setenv("SMTP_PORT", server.Port)
+	t.Setenv("SMTP_USERNAME", "")
+	t.Setenv("JWT_SECRET", "test-secret")
+	userStore := db.NewUserStore(pool)
+	users := NewUserHandler(userStore, db.NewSessionStore(pool), mail.NewSMTPMailerFromEnv())
+
+	const email = "ada@example.com"
+	body := fmt.Sprintf(`{"username":"ada","email":%q,"password":"first-password"}`, email)
+	if code := serve(users.HandleRegisterUser, 0, http.MethodPost, body, nil); code != http.StatusCreated {
+		t.Fatalf("registering: got status %d", code)
+	}
+	token := receiveToken(t, server, email, "Confirm your email address")
+
+	if code := serve(users.HandleVerifyEmail, 0, http.MethodPost, `{"token":"wrong"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("verifying with a wrong token: got status %d, want %d", code, http.StatusBadRequest)
+	}
+	verify := fmt.Sprintf(`{"token":%q}`, token)
+	if code := serve(users.HandleVerifyEmail, 0, http.MethodPost, verify, nil); code != http.StatusNoContent {
+		t.Fatalf("verifying: got status %d", code)
+	}
+	user, err := userStore.GetUserByEmail(email)
+	if err != nil || user.EmailVerifiedAt == nil {
+		t.Fatalf("after verifying the user is %+v, %v", user, err)
+	}
+	if code := serve(users.HandleVerifyEmail, 0, http.MethodPost, verify, nil); code != http.StatusBadRequest {
+		t.Errorf("verifying twice: got status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	forgot := fmt.Sprintf(`{"email":%q}`, email)
+	if code := serve(users.HandleForgotPassword, 0, http.MethodPost, forgot, nil); code != http.StatusAccepted {
+		t.Fatalf("asking for a reset: got status %d", code)
+	}
+	token = receiveToken(t, server, email, "Reset your password")
+
+	reset := fmt.Sprintf(`{"token":%q,"password":"second-password"}`, token)
+	if code := serve(users.HandleResetPassword, 0, http.MethodPost, reset, nil); code != http.StatusNoContent {
+		t.Fatalf("resetting: got status %d", code)
+	}
+	if code := serve(users.HandleResetPassword, 0, http.MethodPost, reset, nil); code != http.StatusBadRequest {
+		t.Errorf("resetting twice: got status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	login := func(password string) int {
+		body := fmt.Sprintf(`{"email":%q,"password":%q}`, email, password)
+		return serve(users.HandleLoginUser, 0, http.MethodPost, body, nil)
+	}
+	if code := login("first-password"); code != http.StatusUnauthorized {
+		t.Errorf("logging in with the old password: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := login("second-password"); code != http.StatusOK {
+		t.Errorf("logging in with the new password: got status %d, want %d", code, http.StatusOK)
+	}
+
+	// Nothing is sent for an address without an account.
+	forgot = `{"email":"nobody@example.com"}`
+	if code := serve(users.HandleForgotPassword, 0, http.MethodPost, forgot, nil); code != http.StatusAccepted {
+		t.Errorf("asking for a reset for an unknown address: got status %d", code)
+	}
+	if msg, ok := server.Receive(time.Second); ok {
+		t.Errorf("an email was sent for an unknown address: %s", msg.Data)
+	}
+}
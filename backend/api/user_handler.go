@@ -1,25 +1,30 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"time"
 
 	"tempo-backend/db"
+	"tempo-backend/mail"
+	"tempo-backend/reminders"
 	"tempo-backend/types"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type UserHandler struct {
-	store *db.UserStore
+	store    *db.UserStore
+	sessions *db.SessionStore
+	mailer   mail.Mailer
 }
 
-func NewUserHandler(store *db.UserStore) *UserHandler {
-	return &UserHandler{store: store}
+func NewUserHandler(store *db.UserStore, sessions *db.SessionStore, mailer mail.Mailer) *UserHandler {
+	return &UserHandler{store: store, sessions: sessions, mailer: mailer}
 }
 
 // HandleRegisterUser handles the user registration request.
@@ -42,16 +47,20 @@ func (h *UserHandler) HandleRegisterUser(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Could not create user")
 	}
 
+	// The account works straight away; the verification email goes out in the background.
+	go func() {
+		err := h.sendTokenEmail(user, user.Email, db.TokenPurposeVerifyEmail, "verify_email", "/verify-email", verifyEmailTTL)
+		if err != nil {
+			log.Printf("Error sending verification email: %v", err)
+		}
+	}()
+
 	return c.JSON(http.StatusCreated, user)
 }
 
 // HandleLoginUser handles the user login request.
 func (h *UserHandler) HandleLoginUser(c echo.Context) error {
-	var payload struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
-
+	var payload types.LoginUserPayload
 	if err := c.Bind(&payload); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
 	}
@@ -68,24 +77,242 @@ func (h *UserHandler) HandleLoginUser(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid credentials")
 	}
 
-	// --- Generate JWT Token ---
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	// With two-factor authentication the password only earns a challenge to answer with a code.
+	if user.TwoFactorEnabled {
+		return h.respondWithChallenge(c, user.ID, payload.DeviceName)
+	}
+
+	return h.startSession(c, user.ID, payload.DeviceName)
+}
+
+// startSession starts a session for a user who has logged in and responds with its tokens.
+func (h *UserHandler) startSession(c echo.Context, userID int, deviceName *string) error {
+	refreshToken, refreshHash, err := generateRefreshToken()
+	if err != nil {
+		log.Printf("Error generating refresh token: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create token")
+	}
+
+	session, err := h.sessions.CreateSession(userID, deviceName, c.RealIP(), c.Request().UserAgent(),
+		refreshHash, time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create session")
+	}
+
+	return h.respondWithTokens(c, userID, session.ID, refreshToken)
+}
+
+// HandleRefreshToken exchanges a refresh token for a new access token and refresh token.
+func (h *UserHandler) HandleRefreshToken(c echo.Context) error {
+	var payload types.RefreshTokenPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.RefreshToken == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "refreshToken is required")
+	}
+
+	refreshToken, refreshHash, err := generateRefreshToken()
+	if err != nil {
+		log.Printf("Error generating refresh token: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create token")
+	}
+
+	session, err := h.sessions.RotateRefreshToken(hashToken(payload.RefreshToken), refreshHash,
+		c.RealIP(), c.Request().UserAgent(), time.Now().Add(refreshTokenTTL))
+	if errors.Is(err, db.ErrRefreshTokenReused) {
+		log.Printf("Refresh token reuse detected from %s; session revoked", c.RealIP())
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid refresh token")
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Invalid refresh token")
+	}
+	if err != nil {
+		log.Printf("Error rotating refresh token: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to refresh session")
+	}
+
+	return h.respondWithTokens(c, session.UserID, session.ID, refreshToken)
+}
+
+// HandleLogout revokes the session the request was made with.
+func (h *UserHandler) HandleLogout(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	sessionID := c.Get("sessionID").(int)
+
+	if err := h.sessions.RevokeSession(sessionID, userID); err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Printf("Error revoking session: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not log out")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleGetSessions lists the user's active sessions.
+func (h *UserHandler) HandleGetSessions(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	sessionID := c.Get("sessionID").(int)
+
+	sessions, err := h.sessions.GetActiveSessionsByUser(userID)
+	if err != nil {
+		log.Printf("Error getting sessions: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve sessions")
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == sessionID
+	}
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// HandleRevokeSession revokes one of the user's sessions, e.g. a lost device.
+func (h *UserHandler) HandleRevokeSession(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid session ID")
+	}
+
+	err = h.sessions.RevokeSession(sessionID, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Session not found")
+	}
+	if err != nil {
+		log.Printf("Error revoking session: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not revoke session")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleGetSearchLanguage returns the text search configuration used for the user's notes.
+func (h *UserHandler) HandleGetSearchLanguage(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	language, err := h.store.GetSearchLanguage(userID)
+	if err != nil {
+		log.Printf("Error getting search language: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve search language")
 	}
+	return c.JSON(http.StatusOK, types.SearchLanguagePayload{Language: language})
+}
 
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["userID"] = user.ID
-	claims["exp"] = time.Now().Add(time.Hour * 72).Unix() // Token expires in 3 days
+// HandleSetSearchLanguage changes the text search configuration used for the user's notes.
+func (h *UserHandler) HandleSetSearchLanguage(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	var payload types.SearchLanguagePayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.Language == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Language is required")
+	}
+
+	err := h.store.SetSearchLanguage(userID, payload.Language)
+	if errors.Is(err, db.ErrUnknownSearchLanguage) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unknown search language")
+	}
+	if err != nil {
+		log.Printf("Error setting search language: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update search language")
+	}
+	return c.JSON(http.StatusOK, payload)
+}
+
+// maxRevisionLimit caps how many revisions per note a user can ask to keep.
+const maxRevisionLimit = 1000
+
+// HandleGetRevisionRetention returns how much note history is kept for the user.
+func (h *UserHandler) HandleGetRevisionRetention(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	retention, err := h.store.GetRevisionRetention(userID)
+	if err != nil {
+		log.Printf("Error getting revision retention: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve revision retention")
+	}
+	return c.JSON(http.StatusOK, retention)
+}
+
+// HandleSetRevisionRetention changes how much note history is kept for the user.
+func (h *UserHandler) HandleSetRevisionRetention(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	var payload types.RevisionRetentionPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.MaxRevisions < 1 || payload.MaxRevisions > maxRevisionLimit {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("maxRevisions must be between 1 and %d", maxRevisionLimit))
+	}
+	if payload.MaxAgeDays != nil && *payload.MaxAgeDays < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, "maxAgeDays must be at least 1")
+	}
+
+	if err := h.store.SetRevisionRetention(userID, payload); err != nil {
+		log.Printf("Error setting revision retention: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update revision retention")
+	}
+	return c.JSON(http.StatusOK, payload)
+}
+
+// HandleGetReminderWebhook returns the URL the user's webhook reminders are posted to.
+func (h *UserHandler) HandleGetReminderWebhook(c echo.Context) error {
+	userID := c.Get("userID").(int)
+
+	webhook, err := h.store.GetReminderWebhook(userID)
+	if err != nil {
+		log.Printf("Error getting reminder webhook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not retrieve reminder webhook")
+	}
+	return c.JSON(http.StatusOK, webhook)
+}
+
+// HandleSetReminderWebhook sets the URL the user's webhook reminders are posted
+// to and issues a new signing secret, which is only ever returned here.
+func (h *UserHandler) HandleSetReminderWebhook(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	var payload types.ReminderWebhookPayload
+	if err := c.Bind(&payload); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request payload")
+	}
+	if err := reminders.CheckWebhookURL(c.Request().Context(), payload.URL); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		log.Printf("Error generating webhook secret: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update reminder webhook")
+	}
+	if err := h.store.SetReminderWebhook(userID, &payload.URL, &secret); err != nil {
+		log.Printf("Error setting reminder webhook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not update reminder webhook")
+	}
+	return c.JSON(http.StatusOK, types.ReminderWebhook{URL: &payload.URL, Secret: secret})
+}
+
+// HandleDeleteReminderWebhook removes the user's webhook. Webhook reminders fail until another is set.
+func (h *UserHandler) HandleDeleteReminderWebhook(c echo.Context) error {
+	userID := c.Get("userID").(int)
+	if err := h.store.SetReminderWebhook(userID, nil, nil); err != nil {
+		log.Printf("Error removing reminder webhook: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Could not remove reminder webhook")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
 
-	t, err := token.SignedString([]byte(jwtSecret))
+// respondWithTokens signs an access token for the session and writes it out with the refresh token.
+func (h *UserHandler) respondWithTokens(c echo.Context, userID, sessionID int, refreshToken string) error {
+	accessToken, err := generateAccessToken(userID, sessionID)
 	if err != nil {
 		log.Printf("Error signing token: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create token")
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"token": t,
+	return c.JSON(http.StatusOK, types.TokenResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
 	})
 }
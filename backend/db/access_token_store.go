@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"tempo-backend/types"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AccessTokenStore keeps personal access tokens. Only their hashes are stored.
+type AccessTokenStore struct {
+	db *pgxpool.Pool
+}
+
+func NewAccessTokenStore(db *pgxpool.Pool) *AccessTokenStore {
+	return &AccessTokenStore{db: db}
+}
+
+const accessTokenColumns = `id, user_id, name, token_prefix, scopes, expires_at, last_used_at, created_at`
+
+func scanAccessToken(row rowScanner, token *types.AccessToken) error {
+	return row.Scan(&token.ID, &token.UserID, &token.Name, &token.Prefix, &token.Scopes,
+		&token.ExpiresAt, &token.LastUsedAt, &token.CreatedAt)
+}
+
+// CreateAccessToken stores a new personal access token for the user by the hash of the token.
+func (s *AccessTokenStore) CreateAccessToken(userID int, name, prefix, tokenHash string, scopes []string, expiresAt *time.Time) (*types.AccessToken, error) {
+	query := `INSERT INTO personal_access_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at)
+			   VALUES ($1, $2, $3, $4, $5, $6) RETURNING ` + accessTokenColumns
+	var token types.AccessToken
+	if err := scanAccessToken(s.db.QueryRow(context.Background(), query, userID, name, prefix, tokenHash, scopes, expiresAt), &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// GetAccessTokens lists the user's personal access tokens, newest first,
+// including expired ones.
+func (s *AccessTokenStore) GetAccessTokens(userID int) ([]types.AccessToken, error) {
+	rows, err := s.db.Query(context.Background(),
+		`SELECT `+accessTokenColumns+` FROM personal_access_tokens WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]types.AccessToken, 0)
+	for rows.Next() {
+		var token types.AccessToken
+		if err := scanAccessToken(rows, &token); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteAccessToken revokes one of the user's personal access tokens.
+func (s *AccessTokenStore) DeleteAccessToken(tokenID, userID int) error {
+	cmd, err := s.db.Exec(context.Background(),
+		`DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2`, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// IsAccessTokenActive reports whether the user's token still exists and hasn't expired.
+func (s *AccessTokenStore) IsAccessTokenActive(tokenID, userID int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM personal_access_tokens WHERE id = $1 AND user_id = $2
+			   AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP))`
+	var active bool
+	err := s.db.QueryRow(context.Background(), query, tokenID, userID).Scan(&active)
+	return active, err
+}
+
+// AuthenticateAccessToken looks up an unexpired token by its hash and records
+// that it was used. It returns ErrNotFound if there is no such token.
+func (s *AccessTokenStore) AuthenticateAccessToken(tokenHash string) (*types.AccessToken, error) {
+	// last_used_at is only written once a minute, so a busy script doesn't
+	// turn every request into a write.
+	query := `WITH token AS (
+				  SELECT ` + accessTokenColumns + ` FROM personal_access_tokens
+				  WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+			   ), touched AS (
+				  UPDATE personal_access_tokens p SET last_used_at = CURRENT_TIMESTAMP
+				  FROM token WHERE p.id = token.id
+					AND (token.last_used_at IS NULL OR token.last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')
+			   )
+			   SELECT ` + accessTokenColumns + ` FROM token`
+	var token types.AccessToken
+	if err := scanAccessToken(s.db.QueryRow(context.Background(), query, tokenHash), &token); err != nil {
+		return nil, notFound(err)
+	}
+	return &token, nil
+}
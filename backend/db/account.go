@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"tempo-backend/types"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUsernameTaken is returned when changing to a username another user has.
+var ErrUsernameTaken = errors.New("username is taken")
+
+// ErrEmailTaken is returned when changing to an email address another user has.
+var ErrEmailTaken = errors.New("email is taken")
+
+// ErrWrongPassword is returned when the password confirming an account change is wrong.
+var ErrWrongPassword = errors.New("wrong password")
+
+// ErrDeletionNotScheduled is returned when cancelling the deletion of an account that isn't being deleted.
+var ErrDeletionNotScheduled = errors.New("account deletion is not scheduled")
+
+// GetProfile retrieves the user's profile.
+func (s *UserStore) GetProfile(userID int) (*types.Profile, error) {
+	return getProfile(context.Background(), s.db, userID)
+}
+
+func getProfile(ctx context.Context, q querier, userID int) (*types.Profile, error) {
+	query := `SELECT u.id, u.username, u.email, u.email_verified_at,
+					 (SELECT t.email FROM user_tokens t
+					  WHERE t.user_id = u.id AND t.purpose = 'change_email' AND t.used_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP
+					  ORDER BY t.created_at DESC LIMIT 1),
+					 u.display_name, u.timezone, u.locale, u.password_hash IS NOT NULL, u.totp_enabled_at IS NOT NULL,
+					 u.deletion_scheduled_at, u.created_at
+			   FROM users u WHERE u.id = $1`
+	var p types.Profile
+	err := q.QueryRow(ctx, query, userID).Scan(
+		&p.ID, &p.Username, &p.Email, &p.EmailVerifiedAt, &p.PendingEmail,
+		&p.DisplayName, &p.Timezone, &p.Locale, &p.HasPassword, &p.TwoFactorEnabled,
+		&p.DeletionScheduledAt, &p.CreatedAt,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &p, nil
+}
+
+// UpdateProfile changes the user's username, display name, timezone and
+// locale, leaving fields that are nil alone. Email changes go through
+// ConfirmEmailChange instead.
+func (s *UserStore) UpdateProfile(userID int, payload types.UpdateProfilePayload) error {
+	cmd, err := s.db.Exec(context.Background(),
+		`UPDATE users SET username = COALESCE($2, username),
+						  display_name = CASE WHEN $3::text IS NULL THEN display_name ELSE NULLIF($3, '') END,
+						  timezone = COALESCE($4, timezone),
+						  locale = COALESCE($5, locale)
+		 WHERE id = $1`,
+		userID, payload.Username, payload.DisplayName, payload.Timezone, payload.Locale)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrUsernameTaken
+	}
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// EmailInUse reports whether an account other than the user's has the address.
+// Like the UNIQUE constraint on users.email and GetUserByEmail, it matches exactly.
+func (s *UserStore) EmailInUse(email string, userID int) (bool, error) {
+	var inUse bool
+	err := s.db.QueryRow(context.Background(),
+		`SELECT EXISTS (SELECT 1 FROM users WHERE email = $1 AND id <> $2)`, email, userID).Scan(&inUse)
+	return inUse, err
+}
+
+// ConfirmEmailChange uses a change-of-email token, making the address it was
+// sent to the user's verified email.
+func (s *UserStore) ConfirmEmailChange(tokenHash string) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	userID, email, err := consumeUserToken(ctx, tx, tokenHash, TokenPurposeChangeEmail)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE users SET email = $2, email_verified_at = CURRENT_TIMESTAMP WHERE id = $1`, userID, email)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+
+	// Links sent to the old address, or to other addresses the user tried, stop working.
+	_, err = tx.Exec(ctx, `UPDATE user_tokens SET used_at = CURRENT_TIMESTAMP
+						   WHERE user_id = $1 AND used_at IS NULL AND purpose IN ('change_email', 'verify_email', 'reset_password')`,
+		userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// checkPassword locks the user and checks their password. Users without a
+// password pass without one.
+func checkPassword(ctx context.Context, tx pgx.Tx, userID int, password string) error {
+	var hash *string
+	err := tx.QueryRow(ctx, `SELECT password_hash FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&hash)
+	if err != nil {
+		return notFound(err)
+	}
+	if hash != nil && bcrypt.CompareHashAndPassword([]byte(*hash), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// ChangePassword sets a new password for the user after checking their
+// current one, and logs out every session but the one making the change.
+func (s *UserStore) ChangePassword(userID, sessionID int, currentPassword, newPassword string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkPassword(ctx, tx, userID, currentPassword); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET password_hash = $2 WHERE id = $1`, userID, string(hashedPassword)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`,
+		userID, sessionID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE user_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND purpose = 'reset_password' AND used_at IS NULL`,
+		userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ScheduleDeletion marks the user's account for deletion after grace, once
+// their password checks out. Every other session is logged out and every
+// personal access token revoked straight away; the account itself stays
+// until it is purged, and logging back in before then can cancel it.
+func (s *UserStore) ScheduleDeletion(userID, sessionID int, password string, grace time.Duration) (time.Time, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkPassword(ctx, tx, userID, password); err != nil {
+		return time.Time{}, err
+	}
+	var scheduledAt time.Time
+	err = tx.QueryRow(ctx, `UPDATE users SET deletion_scheduled_at = COALESCE(deletion_scheduled_at, CURRENT_TIMESTAMP + make_interval(secs => $2))
+							WHERE id = $1 RETURNING deletion_scheduled_at`, userID, grace.Seconds()).Scan(&scheduledAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`,
+		userID, sessionID); err != nil {
+		return time.Time{}, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM personal_access_tokens WHERE user_id = $1`, userID); err != nil {
+		return time.Time{}, err
+	}
+	return scheduledAt, tx.Commit(ctx)
+}
+
+// CancelDeletion keeps an account that was scheduled for deletion.
+func (s *UserStore) CancelDeletion(userID int) error {
+	cmd, err := s.db.Exec(context.Background(),
+		`UPDATE users SET deletion_scheduled_at = NULL WHERE id = $1 AND deletion_scheduled_at IS NOT NULL`, userID)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrDeletionNotScheduled
+	}
+	return nil
+}
+
+// PurgeDeletedUsers permanently deletes up to limit accounts whose deletion
+// is due, along with everything they own, and returns how many it deleted.
+func (s *UserStore) PurgeDeletedUsers(limit int) (int, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `DELETE FROM users WHERE id IN (
+									SELECT id FROM users WHERE deletion_scheduled_at <= CURRENT_TIMESTAMP
+									ORDER BY deletion_scheduled_at LIMIT $1 FOR UPDATE SKIP LOCKED
+								) RETURNING id`, limit)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// Tombstones have no foreign key to cascade through.
+	if _, err := tx.Exec(ctx, `DELETE FROM sync_tombstones WHERE user_id = ANY($1)`, ids); err != nil {
+		return 0, err
+	}
+	return len(ids), tx.Commit(ctx)
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+
+	"tempo-backend/types"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// CalendarStore keeps users' calendar feeds and reads what they show. Only
+// the hashes of feed tokens are stored.
+type CalendarStore struct {
+	db *pgxpool.Pool
+}
+
+func NewCalendarStore(db *pgxpool.Pool) *CalendarStore {
+	return &CalendarStore{db: db}
+}
+
+const calendarFeedColumns = `token_prefix, last_used_at, created_at`
+
+func scanCalendarFeed(row rowScanner, feed *types.CalendarFeed) error {
+	return row.Scan(&feed.Prefix, &feed.LastUsedAt, &feed.CreatedAt)
+}
+
+// CreateCalendarFeed gives the user a feed with a new token, replacing any
+// feed they had, whose URL stops working.
+func (s *CalendarStore) CreateCalendarFeed(userID int, prefix, tokenHash string) (*types.CalendarFeed, error) {
+	query := `INSERT INTO calendar_feeds (user_id, token_prefix, token_hash) VALUES ($1, $2, $3)
+			   ON CONFLICT (user_id) DO UPDATE
+			   SET token_prefix = EXCLUDED.token_prefix, token_hash = EXCLUDED.token_hash,
+				   last_used_at = NULL, created_at = CURRENT_TIMESTAMP
+			   RETURNING ` + calendarFeedColumns
+	var feed types.CalendarFeed
+	if err := scanCalendarFeed(s.db.QueryRow(context.Background(), query, userID, prefix, tokenHash), &feed); err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// GetCalendarFeed returns the user's feed, or ErrNotFound if they have none.
+func (s *CalendarStore) GetCalendarFeed(userID int) (*types.CalendarFeed, error) {
+	var feed types.CalendarFeed
+	err := scanCalendarFeed(s.db.QueryRow(context.Background(),
+		`SELECT `+calendarFeedColumns+` FROM calendar_feeds WHERE user_id = $1`, userID), &feed)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &feed, nil
+}
+
+// DeleteCalendarFeed turns off the user's feed.
+func (s *CalendarStore) DeleteCalendarFeed(userID int) error {
+	cmd, err := s.db.Exec(context.Background(), `DELETE FROM calendar_feeds WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// AuthenticateCalendarFeed returns the user whose feed has the token with
+// this hash and records that it was fetched. Feeds of accounts being deleted
+// don't work. It returns ErrNotFound if there is no such feed.
+func (s *CalendarStore) AuthenticateCalendarFeed(tokenHash string) (int, error) {
+	// Calendar apps poll, so last_used_at is only written once a minute.
+	query := `WITH feed AS (
+				  SELECT f.user_id, f.last_used_at FROM calendar_feeds f JOIN users u ON u.id = f.user_id
+				  WHERE f.token_hash = $1 AND u.deletion_scheduled_at IS NULL
+			   ), touched AS (
+				  UPDATE calendar_feeds f SET last_used_at = CURRENT_TIMESTAMP
+				  FROM feed WHERE f.user_id = feed.user_id
+					AND (feed.last_used_at IS NULL OR feed.last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')
+			   )
+			   SELECT user_id FROM feed`
+	var userID int
+	if err := s.db.QueryRow(context.Background(), query, tokenHash).Scan(&userID); err != nil {
+		return 0, notFound(err)
+	}
+	return userID, nil
+}
+
+// GetCalendar returns the user's items with due dates, soonest first, and
+// their journal entries too if includeJournal is set.
+func (s *CalendarStore) GetCalendar(userID int, includeJournal bool) (*types.Calendar, error) {
+	ctx := context.Background()
+	var cal types.Calendar
+	if err := s.db.QueryRow(ctx, `SELECT timezone FROM users WHERE id = $1`, userID).Scan(&cal.Timezone); err != nil {
+		return nil, notFound(err)
+	}
+
+	query := `SELECT ` + todoItemColumns + `, tl.title
+			   FROM todo_items ti JOIN todo_lists tl ON tl.id = ti.list_id
+			   WHERE tl.user_id = $1 AND ti.due_date IS NOT NULL
+			   ORDER BY ti.due_date, ti.due_time NULLS FIRST, ti.id`
+	rows, err := s.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cal.Items = make([]types.CalendarItem, 0)
+	for rows.Next() {
+		var item types.CalendarItem
+		if err := scanTodoItem(titleScanner{rows, &item.ListTitle}, &item.TodoItem); err != nil {
+			return nil, err
+		}
+		cal.Items = append(cal.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if includeJournal {
+		rows, err := s.db.Query(ctx, `SELECT `+journalEntryColumns+` FROM journal_entries j
+									  WHERE j.user_id = $1 ORDER BY j.entry_date, j.id`, userID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var entry types.JournalEntry
+			if err := scanJournalEntry(rows, &entry); err != nil {
+				return nil, err
+			}
+			cal.JournalEntries = append(cal.JournalEntries, entry)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return &cal, nil
+}
+
+// titleScanner scans a row of item columns followed by its list's title.
+type titleScanner struct {
+	row   rowScanner
+	title *string
+}
+
+func (s titleScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.title)...)
+}
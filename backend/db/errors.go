@@ -0,0 +1,61 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrNotFound is returned when a record does not exist or is not owned by the requesting user.
+// The two cases are deliberately indistinguishable so callers can't probe for other users' IDs.
+var ErrNotFound = errors.New("record not found")
+
+// notFound maps pgx.ErrNoRows onto ErrNotFound and passes every other error through unchanged.
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ErrInvalidParent is returned when a subtask's parent is not an item in the same list.
+var ErrInvalidParent = errors.New("parent item must belong to the same list")
+
+// ErrMaxDepthExceeded is returned when adding a subtask would nest items deeper than MaxSubtaskDepth.
+var ErrMaxDepthExceeded = errors.New("maximum subtask depth exceeded")
+
+// ErrNotRecurring is returned by recurrence actions on an item without a recurrence rule.
+var ErrNotRecurring = errors.New("item is not recurring")
+
+// ErrRecurrenceNeedsDueDate is returned when a recurrence rule is set on an item without a due date.
+var ErrRecurrenceNeedsDueDate = errors.New("recurring items need a due date")
+
+// ErrNotebookNotFound is returned when a note payload names a notebook the user doesn't own.
+var ErrNotebookNotFound = errors.New("notebook not found")
+
+// ErrDefaultNotebook is returned when trying to delete the user's default notebook.
+var ErrDefaultNotebook = errors.New("the default notebook cannot be deleted")
+
+// ErrNotebookCycle is returned when moving a notebook inside itself or one of its descendants.
+var ErrNotebookCycle = errors.New("a notebook cannot be moved inside itself")
+
+// ErrInvalidTag is returned for empty or over-long tag names.
+var ErrInvalidTag = errors.New("tag names must be between 1 and 64 characters")
+
+// ErrVersionConflict is returned when an update names a version of the record that is no longer current.
+var ErrVersionConflict = errors.New("record has been modified since it was read")
+
+// ErrInvalidListOrder is returned when a list order repeats a list or names one the user doesn't own.
+var ErrInvalidListOrder = errors.New("list order must name each of the user's lists at most once")
+
+// ErrListNotFound is returned when an item is moved to a list the user doesn't own.
+var ErrListNotFound = errors.New("list not found")
+
+// ErrInvalidMoveTarget is returned when an item is moved next to itself or to an item in another list.
+var ErrInvalidMoveTarget = errors.New("items can only be moved next to another item in the same list")
+
+// ErrInvalidSort is returned when a listing is asked for an order it doesn't have.
+var ErrInvalidSort = errors.New("invalid sort")
+
+// ErrInvalidCursor is returned for a page cursor this server didn't issue, or one made for another order.
+var ErrInvalidCursor = errors.New("invalid cursor")
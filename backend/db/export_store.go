@@ -0,0 +1,415 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"tempo-backend/types"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrExportLost is returned when writing to an export another instance has
+// taken over, because this one's lease ran out.
+var ErrExportLost = errors.New("export was claimed by another worker")
+
+// ExportStore keeps data exports and the archives built for them.
+type ExportStore struct {
+	db *pgxpool.Pool
+}
+
+func NewExportStore(db *pgxpool.Pool) *ExportStore {
+	return &ExportStore{db: db}
+}
+
+const exportColumns = `id, user_id, status, attempts, size_bytes, error, created_at, completed_at, expires_at`
+
+func scanExport(row rowScanner, export *types.DataExport) error {
+	return row.Scan(&export.ID, &export.UserID, &export.Status, &export.Attempts, &export.SizeBytes, &export.Error,
+		&export.CreatedAt, &export.CompletedAt, &export.ExpiresAt)
+}
+
+// CreateExport queues an export of the user's data. If one is already queued
+// or being built, that one is returned instead.
+func (s *ExportStore) CreateExport(userID int) (*types.DataExport, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the user keeps two requests at once from both queueing one.
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return nil, err
+	}
+	var export types.DataExport
+	err = scanExport(tx.QueryRow(ctx, `SELECT `+exportColumns+` FROM data_exports
+										WHERE user_id = $1 AND status IN ('pending', 'running')`, userID), &export)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = scanExport(tx.QueryRow(ctx, `INSERT INTO data_exports (user_id) VALUES ($1) RETURNING `+exportColumns, userID), &export)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &export, tx.Commit(ctx)
+}
+
+// GetExport retrieves one of the user's exports.
+func (s *ExportStore) GetExport(exportID, userID int) (*types.DataExport, error) {
+	var export types.DataExport
+	err := scanExport(s.db.QueryRow(context.Background(),
+		`SELECT `+exportColumns+` FROM data_exports WHERE id = $1 AND user_id = $2`, exportID, userID), &export)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &export, nil
+}
+
+// ClaimExport claims the oldest queued export for lease, or an export whose
+// previous builder's lease ran out, and returns nil if there is none. Each
+// claim counts as an attempt and discards whatever a previous attempt wrote.
+func (s *ExportStore) ClaimExport(lease time.Duration) (*types.DataExport, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `WITH next AS (
+				  SELECT id FROM data_exports
+				  WHERE status = 'pending' OR (status = 'running' AND lease_until < CURRENT_TIMESTAMP)
+				  ORDER BY created_at LIMIT 1
+				  FOR UPDATE SKIP LOCKED
+			   )
+			   UPDATE data_exports e
+			   SET status = 'running', attempts = e.attempts + 1, started_at = CURRENT_TIMESTAMP,
+				   lease_until = CURRENT_TIMESTAMP + make_interval(secs => $1)
+			   FROM next WHERE e.id = next.id
+			   RETURNING ` + exportColumns
+	var export types.DataExport
+	err = scanExport(tx.QueryRow(ctx, query, lease.Seconds()), &export)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM data_export_chunks WHERE export_id = $1`, export.ID); err != nil {
+		return nil, err
+	}
+	return &export, tx.Commit(ctx)
+}
+
+// AppendExportChunk stores the next piece of an export's archive and renews
+// the lease on it. attempt is the claim's attempt number, so a builder that
+// lost its lease can't write into the archive its successor is building.
+func (s *ExportStore) AppendExportChunk(exportID, attempt, seq int, data []byte, lease time.Duration) error {
+	query := `WITH owned AS (
+				  UPDATE data_exports SET lease_until = CURRENT_TIMESTAMP + make_interval(secs => $5)
+				  WHERE id = $1 AND status = 'running' AND attempts = $2
+				  RETURNING id
+			   )
+			   INSERT INTO data_export_chunks (export_id, seq, data) SELECT id, $3, $4 FROM owned`
+	cmd, err := s.db.Exec(context.Background(), query, exportID, attempt, seq, data, lease.Seconds())
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrExportLost
+	}
+	return nil
+}
+
+// CompleteExport marks an export's archive as fully written, to be kept for
+// retention. The user's older archives are deleted, as they only ever need
+// their latest one.
+func (s *ExportStore) CompleteExport(exportID, attempt int, size int64, retention time.Duration) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	err = tx.QueryRow(ctx, `UPDATE data_exports
+							SET status = 'ready', size_bytes = $3, lease_until = NULL, completed_at = CURRENT_TIMESTAMP,
+								expires_at = CURRENT_TIMESTAMP + make_interval(secs => $4)
+							WHERE id = $1 AND status = 'running' AND attempts = $2
+							RETURNING user_id`,
+		exportID, attempt, size, retention.Seconds()).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrExportLost
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM data_exports WHERE user_id = $1 AND id <> $2 AND status IN ('ready', 'failed')`,
+		userID, exportID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// FailExport gives up on an export, discarding what was written of its
+// archive. The failure is kept for retention so the user can see it.
+func (s *ExportStore) FailExport(exportID, attempt int, reason string, retention time.Duration) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	cmd, err := tx.Exec(ctx, `UPDATE data_exports
+							  SET status = 'failed', error = $3, lease_until = NULL, completed_at = CURRENT_TIMESTAMP,
+								  expires_at = CURRENT_TIMESTAMP + make_interval(secs => $4)
+							  WHERE id = $1 AND status = 'running' AND attempts = $2`,
+		exportID, attempt, reason, retention.Seconds())
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrExportLost
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM data_export_chunks WHERE export_id = $1`, exportID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// DeleteExpiredExports deletes exports, and their archives, whose retention is
+// over and returns how many it deleted.
+func (s *ExportStore) DeleteExpiredExports() (int, error) {
+	cmd, err := s.db.Exec(context.Background(), `DELETE FROM data_exports WHERE expires_at <= CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, err
+	}
+	return int(cmd.RowsAffected()), nil
+}
+
+// StreamExport writes a ready export's archive to w a chunk at a time, so it
+// is never held in memory whole.
+func (s *ExportStore) StreamExport(exportID int, w io.Writer) error {
+	rows, err := s.db.Query(context.Background(),
+		`SELECT data FROM data_export_chunks WHERE export_id = $1 ORDER BY seq`, exportID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var data []byte
+	for rows.Next() {
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ExportSnapshot reads everything a user owns as it was at a single moment,
+// so an export is consistent even while the user keeps working.
+type ExportSnapshot struct {
+	tx     pgx.Tx
+	userID int
+}
+
+// BeginSnapshot starts reading the user's data. The snapshot must be closed.
+func (s *ExportStore) BeginSnapshot(userID int) (*ExportSnapshot, error) {
+	tx, err := s.db.BeginTx(context.Background(), pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	return &ExportSnapshot{tx: tx, userID: userID}, nil
+}
+
+// Close ends the snapshot.
+func (snap *ExportSnapshot) Close() error {
+	return snap.tx.Rollback(context.Background())
+}
+
+// Profile reads the user's profile.
+func (snap *ExportSnapshot) Profile() (*types.Profile, error) {
+	return getProfile(context.Background(), snap.tx, snap.userID)
+}
+
+// exportDataset is one kind of record in an export.
+type exportDataset struct {
+	query string // Selects the user's records, with the user's ID as $1
+	scan  func(rows pgx.Rows) (any, error)
+}
+
+// ExportDatasets names the kinds of record an export contains, in the order
+// they are written. Each name can be passed to ExportSnapshot.Each.
+var ExportDatasets = []string{
+	"lists", "items", "item_occurrences", "reminders", "notebooks", "tags", "notes", "note_revisions",
+	"journal", "notifications", "sessions", "identities", "access_tokens",
+}
+
+var exportDatasets = map[string]exportDataset{
+	"lists": {
+		`SELECT ` + todoListColumns + ` FROM todo_lists tl WHERE tl.user_id = $1 ORDER BY tl.id`,
+		func(rows pgx.Rows) (any, error) {
+			var list types.TodoList
+			err := scanTodoList(rows, &list)
+			return list, err
+		},
+	},
+	"items": {
+		`SELECT ` + todoItemColumns + ` FROM todo_items ti JOIN todo_lists tl ON tl.id = ti.list_id
+		 WHERE tl.user_id = $1 ORDER BY ti.id`,
+		func(rows pgx.Rows) (any, error) {
+			var item types.TodoItem
+			err := scanTodoItem(rows, &item)
+			return item, err
+		},
+	},
+	"item_occurrences": {
+		`SELECT o.id, o.item_id, o.occurrence_index, o.due_date, o.status, o.recorded_at
+		 FROM todo_item_occurrences o
+		 JOIN todo_items ti ON ti.id = o.item_id JOIN todo_lists tl ON tl.id = ti.list_id
+		 WHERE tl.user_id = $1 ORDER BY o.id`,
+		func(rows pgx.Rows) (any, error) {
+			var o types.TodoItemOccurrence
+			err := rows.Scan(&o.ID, &o.ItemID, &o.OccurrenceIndex, &o.DueDate, &o.Status, &o.RecordedAt)
+			return o, err
+		},
+	},
+	"reminders": {
+		`SELECT ` + reminderColumns + ` FROM reminders r
+		 JOIN todo_items ti ON ti.id = r.item_id JOIN todo_lists tl ON tl.id = ti.list_id
+		 WHERE tl.user_id = $1 ORDER BY r.id`,
+		func(rows pgx.Rows) (any, error) {
+			reminder := types.Reminder{Deliveries: make([]types.ReminderDelivery, 0)}
+			err := scanReminder(rows, &reminder)
+			return reminder, err
+		},
+	},
+	"notebooks": {
+		`SELECT ` + notebookColumns + ` FROM notebooks nb WHERE nb.user_id = $1 ORDER BY nb.id`,
+		func(rows pgx.Rows) (any, error) {
+			var nb types.Notebook
+			err := scanNotebook(rows, &nb)
+			return nb, err
+		},
+	},
+	"tags": {
+		`SELECT ` + tagColumns + ` FROM tags t WHERE t.user_id = $1 ORDER BY t.id`,
+		func(rows pgx.Rows) (any, error) {
+			var tag types.Tag
+			err := scanTag(rows, &tag)
+			return tag, err
+		},
+	},
+	"notes": {
+		`SELECT ` + noteColumns + ` FROM notes n WHERE n.user_id = $1 ORDER BY n.id`,
+		func(rows pgx.Rows) (any, error) {
+			var note types.Note
+			err := scanNote(rows, &note)
+			return note, err
+		},
+	},
+	"note_revisions": {
+		`SELECT ` + noteRevisionColumns + `, nr.content FROM note_revisions nr JOIN notes n ON n.id = nr.note_id
+		 WHERE n.user_id = $1 ORDER BY nr.id`,
+		func(rows pgx.Rows) (any, error) {
+			var rev types.NoteRevision
+			err := rows.Scan(&rev.ID, &rev.NoteID, &rev.Title, &rev.Length, &rev.RestoredFrom, &rev.CreatedAt, &rev.Content)
+			return rev, err
+		},
+	},
+	"journal": {
+		`SELECT ` + journalEntryColumns + ` FROM journal_entries j WHERE j.user_id = $1 ORDER BY j.entry_date, j.id`,
+		func(rows pgx.Rows) (any, error) {
+			var entry types.JournalEntry
+			err := scanJournalEntry(rows, &entry)
+			return entry, err
+		},
+	},
+	"notifications": {
+		`SELECT ` + notificationColumns + ` FROM notifications WHERE user_id = $1 ORDER BY id`,
+		func(rows pgx.Rows) (any, error) {
+			var n types.Notification
+			err := scanNotification(rows, &n)
+			return n, err
+		},
+	},
+	"sessions": {
+		`SELECT ` + sessionColumns + ` FROM sessions WHERE user_id = $1 ORDER BY id`,
+		func(rows pgx.Rows) (any, error) {
+			var session types.Session
+			err := scanSession(rows, &session)
+			return session, err
+		},
+	},
+	"identities": {
+		`SELECT id, provider, email, last_login_at, created_at FROM user_identities WHERE user_id = $1 ORDER BY id`,
+		func(rows pgx.Rows) (any, error) {
+			var identity types.UserIdentity
+			err := rows.Scan(&identity.ID, &identity.Provider, &identity.Email, &identity.LastLoginAt, &identity.CreatedAt)
+			return identity, err
+		},
+	},
+	"access_tokens": {
+		`SELECT ` + accessTokenColumns + ` FROM personal_access_tokens WHERE user_id = $1 ORDER BY id`,
+		func(rows pgx.Rows) (any, error) {
+			var token types.AccessToken
+			err := scanAccessToken(rows, &token)
+			return token, err
+		},
+	},
+}
+
+// Each calls fn with every record of the named dataset, one at a time as
+// they are read, stopping at the first error.
+func (snap *ExportSnapshot) Each(dataset string, fn func(record any) error) error {
+	source, ok := exportDatasets[dataset]
+	if !ok {
+		return fmt.Errorf("unknown export dataset %q", dataset)
+	}
+	rows, err := snap.tx.Query(context.Background(), source.query, snap.userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := source.scan(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// EachNote calls fn with every one of the user's notes.
+func (snap *ExportSnapshot) EachNote(fn func(note types.Note) error) error {
+	return snap.Each("notes", func(record any) error { return fn(record.(types.Note)) })
+}
+
+// EachJournalEntry calls fn with every one of the user's journal entries, oldest first.
+func (snap *ExportSnapshot) EachJournalEntry(fn func(entry types.JournalEntry) error) error {
+	return snap.Each("journal", func(record any) error { return fn(record.(types.JournalEntry)) })
+}
+
+// Notebooks reads all of the user's notebooks.
+func (snap *ExportSnapshot) Notebooks() ([]types.Notebook, error) {
+	notebooks := make([]types.Notebook, 0)
+	err := snap.Each("notebooks", func(record any) error {
+		notebooks = append(notebooks, record.(types.Notebook))
+		return nil
+	})
+	return notebooks, err
+}
@@ -0,0 +1,260 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"tempo-backend/types"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrIdentityTaken is returned when linking an identity provider account that already logs in as another user.
+var ErrIdentityTaken = errors.New("identity is linked to another account")
+
+// ErrIdentityEmailTaken is returned when a provider login would create an
+// account for an email that already has one, which it can't safely be linked to.
+var ErrIdentityEmailTaken = errors.New("an account with this email already exists")
+
+// ErrIdentityNoEmail is returned when a provider login would create an account but the provider shared no email.
+var ErrIdentityNoEmail = errors.New("identity provider did not share an email address")
+
+// ErrLastLoginMethod is returned when unlinking the only identity of a user without a password.
+var ErrLastLoginMethod = errors.New("cannot remove the only way to log in")
+
+// maxUsernameAttempts is how many variations of a username are tried for a new account.
+const maxUsernameAttempts = 20
+
+var usernameDisallowed = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// CreateOIDCLogin stores a login starting at an identity provider under the
+// hash of its state parameter.
+func (s *UserStore) CreateOIDCLogin(stateHash string, login types.OIDCLogin, ttl time.Duration) error {
+	ctx := context.Background()
+	// Abandoned logins are cleared out as new ones start.
+	if _, err := s.db.Exec(ctx, `DELETE FROM oidc_logins WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(ctx, `INSERT INTO oidc_logins (state_hash, provider, nonce, code_verifier, redirect_path, link_user_id, expires_at)
+							  VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP + make_interval(secs => $7))`,
+		stateHash, login.Provider, login.Nonce, login.CodeVerifier, login.RedirectPath, login.LinkUserID, ttl.Seconds())
+	return err
+}
+
+// ConsumeOIDCLogin uses up the login with the given state for the provider
+// and returns it, or ErrInvalidToken if there is no such login or it expired.
+func (s *UserStore) ConsumeOIDCLogin(stateHash, provider string) (*types.OIDCLogin, error) {
+	login := types.OIDCLogin{Provider: provider}
+	err := s.db.QueryRow(context.Background(),
+		`DELETE FROM oidc_logins WHERE state_hash = $1 AND provider = $2 AND expires_at > CURRENT_TIMESTAMP
+		 RETURNING nonce, code_verifier, redirect_path, link_user_id`, stateHash, provider).Scan(
+		&login.Nonce, &login.CodeVerifier, &login.RedirectPath, &login.LinkUserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+// LoginWithIdentity returns the user a provider account logs in as. An
+// account that isn't linked yet is linked to the user with the same email if
+// both the provider and Tempo have verified it; otherwise a new user without
+// a password is created for it.
+func (s *UserStore) LoginWithIdentity(identity types.ExternalIdentity) (int, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	err = tx.QueryRow(ctx, `UPDATE user_identities SET last_login_at = CURRENT_TIMESTAMP, email = COALESCE(NULLIF($3, ''), email)
+							WHERE provider = $1 AND subject = $2 RETURNING user_id`,
+		identity.Provider, identity.Subject, identity.Email).Scan(&userID)
+	if err == nil {
+		return userID, tx.Commit(ctx)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+
+	if identity.Email == "" {
+		return 0, ErrIdentityNoEmail
+	}
+
+	var emailVerified bool
+	err = tx.QueryRow(ctx, `SELECT id, email_verified_at IS NOT NULL FROM users WHERE lower(email) = lower($1)
+							ORDER BY id LIMIT 1 FOR UPDATE`, identity.Email).Scan(&userID, &emailVerified)
+	switch {
+	case err == nil:
+		// Linking on an unverified address on either side would let whoever
+		// controls one account take over the other.
+		if !identity.EmailVerified || !emailVerified {
+			return 0, ErrIdentityEmailTaken
+		}
+	case errors.Is(err, pgx.ErrNoRows):
+		if userID, err = createIdentityUser(ctx, tx, identity); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, err
+	}
+
+	if err := insertIdentity(ctx, tx, userID, identity, true); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit(ctx)
+}
+
+// createIdentityUser creates a user without a password for a provider
+// account, picking a free username based on what the provider calls them.
+func createIdentityUser(ctx context.Context, tx pgx.Tx, identity types.ExternalIdentity) (int, error) {
+	base := identity.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	base = usernameDisallowed.ReplaceAllString(base, "")
+	if len(base) > 32 {
+		base = base[:32]
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	for i := 0; i < maxUsernameAttempts; i++ {
+		username := base
+		if i > 0 {
+			username = fmt.Sprintf("%s%d", base, i+1)
+		}
+		var userID int
+		err := tx.QueryRow(ctx, `INSERT INTO users (username, email, email_verified_at)
+								 VALUES ($1, $2, CASE WHEN $3 THEN CURRENT_TIMESTAMP END)
+								 ON CONFLICT (username) DO NOTHING RETURNING id`,
+			username, identity.Email, identity.EmailVerified).Scan(&userID)
+		if err == nil {
+			return userID, nil
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return 0, ErrIdentityEmailTaken
+		}
+		return 0, err
+	}
+	return 0, fmt.Errorf("no free username like %q", base)
+}
+
+// insertIdentity links a provider account to a user.
+func insertIdentity(ctx context.Context, q querier, userID int, identity types.ExternalIdentity, loggedIn bool) error {
+	cmd, err := q.Exec(ctx, `INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+							 VALUES ($1, $2, $3, NULLIF($4, ''), CASE WHEN $5 THEN CURRENT_TIMESTAMP END)
+							 ON CONFLICT (provider, subject) DO UPDATE SET email = COALESCE(EXCLUDED.email, user_identities.email)
+							 WHERE user_identities.user_id = EXCLUDED.user_id`,
+		userID, identity.Provider, identity.Subject, identity.Email, loggedIn)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrIdentityTaken
+	}
+	return nil
+}
+
+// LinkIdentity links a provider account to the user, unless it already logs
+// in as someone else. Linking one the user already has just refreshes its email.
+func (s *UserStore) LinkIdentity(userID int, identity types.ExternalIdentity) error {
+	return insertIdentity(context.Background(), s.db, userID, identity, false)
+}
+
+// GetIdentities lists the provider accounts linked to the user.
+func (s *UserStore) GetIdentities(userID int) ([]types.UserIdentity, error) {
+	rows, err := s.db.Query(context.Background(),
+		`SELECT id, provider, email, last_login_at, created_at FROM user_identities
+		 WHERE user_id = $1 ORDER BY provider, created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]types.UserIdentity, 0)
+	for rows.Next() {
+		var identity types.UserIdentity
+		if err := rows.Scan(&identity.ID, &identity.Provider, &identity.Email, &identity.LastLoginAt, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// DeleteIdentity unlinks a provider account from the user. A user without a
+// password must keep at least one.
+func (s *UserStore) DeleteIdentity(identityID, userID int) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var hasPassword bool
+	err = tx.QueryRow(ctx, `SELECT password_hash IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&hasPassword)
+	if err != nil {
+		return notFound(err)
+	}
+
+	cmd, err := tx.Exec(ctx, `DELETE FROM user_identities WHERE id = $1 AND user_id = $2`, identityID, userID)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	if !hasPassword {
+		var remaining int
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM user_identities WHERE user_id = $1`, userID).Scan(&remaining); err != nil {
+			return err
+		}
+		if remaining == 0 {
+			return ErrLastLoginMethod
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// CreateLoginCode stores the hash of a code the web app can exchange for a
+// session as the user.
+func (s *UserStore) CreateLoginCode(userID int, codeHash string, ttl time.Duration) error {
+	ctx := context.Background()
+	if _, err := s.db.Exec(ctx, `DELETE FROM login_codes WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(ctx, `INSERT INTO login_codes (code_hash, user_id, expires_at)
+							  VALUES ($1, $2, CURRENT_TIMESTAMP + make_interval(secs => $3))`,
+		codeHash, userID, ttl.Seconds())
+	return err
+}
+
+// ConsumeLoginCode uses up a login code and returns the user it logs in as,
+// or ErrInvalidToken if it doesn't exist or expired.
+func (s *UserStore) ConsumeLoginCode(codeHash string) (int, error) {
+	var userID int
+	err := s.db.QueryRow(context.Background(),
+		`DELETE FROM login_codes WHERE code_hash = $1 AND expires_at > CURRENT_TIMESTAMP RETURNING user_id`,
+		codeHash).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrInvalidToken
+	}
+	return userID, err
+}
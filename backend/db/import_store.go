@@ -0,0 +1,268 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ImportStore writes notes and to-do items imported from other apps.
+type ImportStore struct {
+	db *pgxpool.Pool
+}
+
+func NewImportStore(db *pgxpool.Pool) *ImportStore {
+	return &ImportStore{db: db}
+}
+
+// ImportedNote is a note read from an import, ready to be created.
+type ImportedNote struct {
+	NotebookID  int
+	Title       string
+	Content     string
+	Tags        []string
+	CreatedAt   *time.Time // Now when nil
+	UpdatedAt   *time.Time // CreatedAt when nil
+	SourcePath  string     // The file the note came from
+	ContentHash string     // SHA-256 of the file, hex-encoded
+}
+
+// NoteImport imports notes for a user in a single transaction, so an import
+// that fails part way leaves nothing behind.
+type NoteImport struct {
+	tx        pgx.Tx
+	userID    int
+	parentID  *int           // The notebook folders are created in; nil for the top level
+	rootID    int            // The notebook files outside any folder go in
+	notebooks map[string]int // Notebooks by folder path
+
+	// NotebooksCreated counts the notebooks created for folders.
+	NotebooksCreated int
+}
+
+// BeginNoteImport starts importing notes into one of the user's notebooks, or
+// at the top level and their default notebook when notebookID is nil.
+func (s *ImportStore) BeginNoteImport(userID int, notebookID *int) (*NoteImport, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rootID, err := resolveNotebook(ctx, tx, notebookID, userID)
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	return &NoteImport{tx: tx, userID: userID, parentID: notebookID, rootID: rootID, notebooks: make(map[string]int)}, nil
+}
+
+// Commit saves everything imported.
+func (imp *NoteImport) Commit() error {
+	return imp.tx.Commit(context.Background())
+}
+
+// Rollback abandons the import. It does nothing after Commit.
+func (imp *NoteImport) Rollback() error {
+	return imp.tx.Rollback(context.Background())
+}
+
+// ImportedNoteID returns the note an earlier import created from a file with
+// the given hash, if that note still exists.
+func (imp *NoteImport) ImportedNoteID(contentHash string) (int, bool, error) {
+	var noteID int
+	err := imp.tx.QueryRow(context.Background(),
+		`SELECT note_id FROM note_imports WHERE user_id = $1 AND content_hash = $2`, imp.userID, contentHash).Scan(&noteID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return noteID, true, nil
+}
+
+// Notebook returns the notebook for a folder, given as its path from the top
+// of the import, creating it and any missing parents. Existing notebooks with
+// the same name in the same place are reused.
+func (imp *NoteImport) Notebook(folder []string) (int, error) {
+	ctx := context.Background()
+	id := imp.rootID
+	parentID := imp.parentID
+	for i, name := range folder {
+		key := strings.Join(folder[:i+1], "/")
+		notebookID, ok := imp.notebooks[key]
+		if !ok {
+			err := imp.tx.QueryRow(ctx, `SELECT id FROM notebooks
+										 WHERE user_id = $1 AND parent_id IS NOT DISTINCT FROM $2 AND name = $3
+										 ORDER BY id LIMIT 1`, imp.userID, parentID, name).Scan(&notebookID)
+			if errors.Is(err, pgx.ErrNoRows) {
+				err = imp.tx.QueryRow(ctx, `INSERT INTO notebooks (user_id, parent_id, name) VALUES ($1, $2, $3) RETURNING id`,
+					imp.userID, parentID, name).Scan(&notebookID)
+				imp.NotebooksCreated++
+			}
+			if err != nil {
+				return 0, err
+			}
+			imp.notebooks[key] = notebookID
+		}
+		id = notebookID
+		parentID = &notebookID
+	}
+	return id, nil
+}
+
+// CreateNote creates an imported note and records the file it came from. The
+// note has no revision until FinishNote is called.
+func (imp *NoteImport) CreateNote(note ImportedNote) (int, error) {
+	ctx := context.Background()
+	createdAt := time.Now()
+	if note.CreatedAt != nil {
+		createdAt = *note.CreatedAt
+	}
+	updatedAt := createdAt
+	if note.UpdatedAt != nil {
+		updatedAt = *note.UpdatedAt
+	}
+
+	var noteID int
+	query := `INSERT INTO notes (user_id, notebook_id, title, content, created_at, updated_at, search_language)
+			   VALUES ($1, $2, $3, $4, $5, $6, (SELECT search_language FROM users WHERE id = $1))
+			   RETURNING id`
+	if err := imp.tx.QueryRow(ctx, query, imp.userID, note.NotebookID, note.Title, note.Content, createdAt, updatedAt).Scan(&noteID); err != nil {
+		return 0, err
+	}
+	if err := setNoteTags(ctx, imp.tx, noteID, imp.userID, note.Tags); err != nil {
+		return 0, err
+	}
+	_, err := imp.tx.Exec(ctx, `INSERT INTO note_imports (user_id, content_hash, note_id, source_path) VALUES ($1, $2, $3, $4)`,
+		imp.userID, note.ContentHash, noteID, note.SourcePath)
+	return noteID, err
+}
+
+// AddAttachment attaches a file to an imported note.
+func (imp *NoteImport) AddAttachment(noteID int, filename, contentType string, data []byte) (int, error) {
+	var id int
+	err := imp.tx.QueryRow(context.Background(),
+		`INSERT INTO note_attachments (user_id, note_id, filename, content_type, size_bytes, data)
+		 VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		imp.userID, noteID, filename, contentType, len(data), data).Scan(&id)
+	return id, err
+}
+
+// FinishNote sets an imported note's final content, once its links point at
+// the notes and attachments they were imported as, and records its first revision.
+func (imp *NoteImport) FinishNote(noteID int, content string) error {
+	ctx := context.Background()
+	if _, err := imp.tx.Exec(ctx, `UPDATE notes SET content = $3 WHERE id = $1 AND user_id = $2 AND content IS DISTINCT FROM $3`,
+		noteID, imp.userID, content); err != nil {
+		return err
+	}
+	return recordNoteRevision(ctx, imp.tx, noteID, imp.userID, nil)
+}
+
+// ImportedItem is a to-do item read from an import, ready to be created.
+type ImportedItem struct {
+	Task            string
+	IsCompleted     bool
+	DueDate         *time.Time
+	DueTime         *string // "15:04"
+	RecurrenceRule  *string
+	RecurrenceStart *time.Time // Due date of the first occurrence
+	RecurrenceIndex int        // 1-based number of the occurrence DueDate is
+	UID             string     // The calendar entry the item came from
+}
+
+// ItemImport imports to-do items for a user in a single transaction.
+type ItemImport struct {
+	tx        pgx.Tx
+	userID    int
+	listTitle string
+
+	// ListID is the list items go in. When the import was started without
+	// one, it is nil until the first item creates a list.
+	ListID *int
+	// Timezone is the user's IANA zone, which due dates and times are read in.
+	Timezone string
+}
+
+// BeginItemImport starts importing items into one of the user's lists, or
+// into a new list called listTitle when listID is nil. It returns ErrNotFound
+// if the user has no such list.
+func (s *ImportStore) BeginItemImport(userID int, listID *int, listTitle string) (*ItemImport, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	imp := &ItemImport{tx: tx, userID: userID, listTitle: listTitle, ListID: listID}
+	err = tx.QueryRow(ctx, `SELECT timezone FROM users WHERE id = $1`, userID).Scan(&imp.Timezone)
+	if err == nil && listID != nil {
+		err = tx.QueryRow(ctx, `SELECT id FROM todo_lists WHERE id = $1 AND user_id = $2`, *listID, userID).Scan(new(int))
+	}
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, notFound(err)
+	}
+	return imp, nil
+}
+
+// Commit saves everything imported.
+func (imp *ItemImport) Commit() error {
+	return imp.tx.Commit(context.Background())
+}
+
+// Rollback abandons the import. It does nothing after Commit.
+func (imp *ItemImport) Rollback() error {
+	return imp.tx.Rollback(context.Background())
+}
+
+// ImportedItemID returns the item an earlier import created from the
+// calendar entry with the given UID, if that item still exists.
+func (imp *ItemImport) ImportedItemID(uid string) (int, bool, error) {
+	var itemID int
+	err := imp.tx.QueryRow(context.Background(),
+		`SELECT item_id FROM calendar_imports WHERE user_id = $1 AND uid = $2`, imp.userID, uid).Scan(&itemID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return itemID, true, nil
+}
+
+// CreateItem creates an imported item and records the entry it came from.
+func (imp *ItemImport) CreateItem(item ImportedItem) (int, error) {
+	ctx := context.Background()
+	if imp.ListID == nil {
+		var listID int
+		if err := imp.tx.QueryRow(ctx, `INSERT INTO todo_lists (title, user_id, position)
+										VALUES ($1, $2, (SELECT COALESCE(MIN(position), 0) - 1 FROM todo_lists WHERE user_id = $2))
+										RETURNING id`,
+			imp.listTitle, imp.userID).Scan(&listID); err != nil {
+			return 0, err
+		}
+		imp.ListID = &listID
+	}
+
+	itemRank, err := nextItemRank(ctx, imp.tx, *imp.ListID)
+	if err != nil {
+		return 0, err
+	}
+	var itemID int
+	query := `INSERT INTO todo_items (list_id, task, is_completed, due_date, due_time, recurrence_rule, recurrence_start, recurrence_index, search_language,
+				  rank)
+			   VALUES ($1, $2, $3, $4, $5::text::time, $6, $7, $8, (SELECT search_language FROM users WHERE id = $9), $10)
+			   RETURNING id`
+	if err := imp.tx.QueryRow(ctx, query, *imp.ListID, item.Task, item.IsCompleted, item.DueDate, item.DueTime,
+		item.RecurrenceRule, item.RecurrenceStart, max(item.RecurrenceIndex, 1), imp.userID, itemRank).Scan(&itemID); err != nil {
+		return 0, err
+	}
+	_, err = imp.tx.Exec(ctx, `INSERT INTO calendar_imports (user_id, uid, item_id) VALUES ($1, $2, $3)`,
+		imp.userID, item.UID, itemID)
+	return itemID, err
+}
@@ -2,62 +2,90 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"tempo-backend/types"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type JournalStore struct {
-	db *pgxpool.Pool
+	db dbtx
 }
 
 func NewJournalStore(db *pgxpool.Pool) *JournalStore {
 	return &JournalStore{db: db}
 }
 
+const journalEntryColumns = `j.id, j.user_id, j.title, j.content, j.mood, j.entry_date, j.version, j.created_at`
+
+func scanJournalEntry(row rowScanner, entry *types.JournalEntry) error {
+	return row.Scan(
+		&entry.ID, &entry.UserID, &entry.Title, &entry.Content, &entry.Mood, &entry.EntryDate, &entry.Version, &entry.CreatedAt,
+	)
+}
+
 func (s *JournalStore) CreateJournalEntry(payload types.CreateJournalEntryPayload, userID int) (*types.JournalEntry, error) {
-	query := `INSERT INTO journal_entries (user_id, title, content, mood, entry_date) VALUES ($1, $2, $3, $4, $5)
-			   RETURNING id, user_id, title, content, mood, entry_date, created_at`
+	query := `INSERT INTO journal_entries AS j (user_id, title, content, mood, entry_date, search_language)
+			   VALUES ($1, $2, $3, $4, $5, (SELECT search_language FROM users WHERE id = $1))
+			   RETURNING ` + journalEntryColumns
 	var entry types.JournalEntry
-	err := s.db.QueryRow(context.Background(), query, userID, payload.Title, payload.Content, payload.Mood, payload.EntryDate).Scan(
-		&entry.ID, &entry.UserID, &entry.Title, &entry.Content, &entry.Mood, &entry.EntryDate, &entry.CreatedAt,
-	)
+	row := s.db.QueryRow(context.Background(), query, userID, payload.Title, payload.Content, payload.Mood, payload.EntryDate)
+	err := scanJournalEntry(row, &entry)
 	return &entry, err
 }
 
-func (s *JournalStore) GetJournalEntriesByUser(userID int) ([]types.JournalEntry, error) {
-	query := `SELECT id, user_id, title, content, mood, entry_date, created_at
-			   FROM journal_entries WHERE user_id = $1 ORDER BY entry_date DESC`
-	rows, err := s.db.Query(context.Background(), query, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// JournalFilter narrows GetJournalEntriesByUser. Zero values mean "don't filter".
+type JournalFilter struct {
+	From *time.Time // First entry date, inclusive
+	To   *time.Time // Last entry date, inclusive
+	Mood string
+}
 
-	entries := make([]types.JournalEntry, 0)
-	for rows.Next() {
-		var entry types.JournalEntry
-		if err := rows.Scan(&entry.ID, &entry.UserID, &entry.Title, &entry.Content, &entry.Mood, &entry.EntryDate, &entry.CreatedAt); err != nil {
-			return nil, err
-		}
-		entries = append(entries, entry)
+// journalListing sorts entries by their date or when they were written,
+// newest first.
+var journalListing = listing{
+	orders: map[string]sortOrder{
+		"date":    {{"j.entry_date", "date", true}, {"j.id", "int", true}},
+		"created": {{"COALESCE(j.created_at, '-infinity')", "timestamptz", true}, {"j.id", "int", true}},
+	},
+	defaultOrder: "date",
+}
+
+// GetJournalEntriesByUser returns a page of the user's entries, with the cursor of the next page.
+func (s *JournalStore) GetJournalEntriesByUser(userID int, filter JournalFilter, opts PageOptions) ([]types.JournalEntry, string, error) {
+	where := []string{"j.user_id = $1"}
+	args := []interface{}{userID}
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		where = append(where, fmt.Sprintf("j.entry_date >= $%d", len(args)))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		where = append(where, fmt.Sprintf("j.entry_date <= $%d", len(args)))
+	}
+	if filter.Mood != "" {
+		args = append(args, filter.Mood)
+		where = append(where, fmt.Sprintf("j.mood = $%d", len(args)))
 	}
-	return entries, nil
+	return queryPage(context.Background(), s.db, journalListing, opts, journalEntryColumns, "journal_entries j", where, args, scanJournalEntry)
 }
 
 func (s *JournalStore) GetJournalEntryByID(entryID, userID int) (*types.JournalEntry, error) {
-	query := `SELECT id, user_id, title, content, mood, entry_date, created_at
-			   FROM journal_entries WHERE id = $1 AND user_id = $2`
+	query := `SELECT ` + journalEntryColumns + ` FROM journal_entries j WHERE j.id = $1 AND j.user_id = $2`
 	var entry types.JournalEntry
-	err := s.db.QueryRow(context.Background(), query, entryID, userID).Scan(
-		&entry.ID, &entry.UserID, &entry.Title, &entry.Content, &entry.Mood, &entry.EntryDate, &entry.CreatedAt,
-	)
-	return &entry, err
+	if err := scanJournalEntry(s.db.QueryRow(context.Background(), query, entryID, userID), &entry); err != nil {
+		return nil, notFound(err)
+	}
+	return &entry, nil
 }
 
-func (s *JournalStore) UpdateJournalEntry(entryID, userID int, payload types.UpdateJournalEntryPayload) (*types.JournalEntry, error) {
+// UpdateJournalEntry applies the payload to one of the user's entries. If expectedVersion is
+// set and the entry has moved on since, nothing is changed and ErrVersionConflict is returned.
+func (s *JournalStore) UpdateJournalEntry(entryID, userID int, payload types.UpdateJournalEntryPayload, expectedVersion *int) (*types.JournalEntry, error) {
 	var setParts []string
 	var args []interface{}
 	argID := 1
@@ -83,29 +111,51 @@ func (s *JournalStore) UpdateJournalEntry(entryID, userID int, payload types.Upd
 		argID++
 	}
 	if len(setParts) == 0 {
-		return s.GetJournalEntryByID(entryID, userID)
+		entry, err := s.GetJournalEntryByID(entryID, userID)
+		if err == nil && expectedVersion != nil && entry.Version != *expectedVersion {
+			return nil, ErrVersionConflict
+		}
+		return entry, err
 	}
+	setParts = append(setParts, "version = version + 1")
 
-	args = append(args, entryID, userID)
-	query := fmt.Sprintf(`UPDATE journal_entries SET %s WHERE id = $%d AND user_id = $%d
-						   RETURNING id, user_id, title, content, mood, entry_date, created_at`,
-		strings.Join(setParts, ", "), argID, argID+1)
+	args = append(args, entryID, userID, expectedVersion)
+	query := fmt.Sprintf(`UPDATE journal_entries j SET %s WHERE j.id = $%d AND j.user_id = $%d AND ($%d::int IS NULL OR j.version = $%d)
+						   RETURNING ` + journalEntryColumns,
+		strings.Join(setParts, ", "), argID, argID+1, argID+2, argID+2)
 
 	var entry types.JournalEntry
-	err := s.db.QueryRow(context.Background(), query, args...).Scan(
-		&entry.ID, &entry.UserID, &entry.Title, &entry.Content, &entry.Mood, &entry.EntryDate, &entry.CreatedAt,
-	)
-	return &entry, err
+	err := scanJournalEntry(s.db.QueryRow(context.Background(), query, args...), &entry)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Tell "not yours" apart from "stale" only for the owner.
+		if _, err := s.GetJournalEntryByID(entryID, userID); err != nil {
+			return nil, err
+		}
+		return nil, ErrVersionConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
 }
 
-func (s *JournalStore) DeleteJournalEntry(entryID, userID int) error {
-	query := `DELETE FROM journal_entries WHERE id = $1 AND user_id = $2`
-	cmd, err := s.db.Exec(context.Background(), query, entryID, userID)
+// DeleteJournalEntry deletes one of the user's entries. If expectedVersion is
+// set and the entry has moved on since, nothing is deleted and
+// ErrVersionConflict is returned.
+func (s *JournalStore) DeleteJournalEntry(entryID, userID int, expectedVersion *int) error {
+	query := `DELETE FROM journal_entries WHERE id = $1 AND user_id = $2 AND ($3::int IS NULL OR version = $3)`
+	cmd, err := s.db.Exec(context.Background(), query, entryID, userID, expectedVersion)
 	if err != nil {
 		return err
 	}
 	if cmd.RowsAffected() == 0 {
-		return fmt.Errorf("entry not found or user not authorized")
+		if expectedVersion == nil {
+			return ErrNotFound
+		}
+		if _, err := s.GetJournalEntryByID(entryID, userID); err != nil {
+			return err
+		}
+		return ErrVersionConflict
 	}
 	return nil
 }
\ No newline at end of file
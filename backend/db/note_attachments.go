@@ -0,0 +1,20 @@
+package db
+
+import (
+	"context"
+
+	"tempo-backend/types"
+)
+
+// GetNoteAttachment retrieves a file attached to one of the user's notes.
+func (s *NoteStore) GetNoteAttachment(attachmentID, noteID, userID int) (*types.NoteAttachment, error) {
+	var a types.NoteAttachment
+	err := s.db.QueryRow(context.Background(),
+		`SELECT id, note_id, filename, content_type, size_bytes, created_at, data FROM note_attachments
+		 WHERE id = $1 AND note_id = $2 AND user_id = $3`, attachmentID, noteID, userID).Scan(
+		&a.ID, &a.NoteID, &a.Filename, &a.ContentType, &a.SizeBytes, &a.CreatedAt, &a.Data)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &a, nil
+}
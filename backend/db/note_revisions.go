@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"tempo-backend/types"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// noteRevisionCoalesceWindow is how long after a revision is created further
+// saves are folded into it instead of starting a new one, so autosaving while
+// typing doesn't flood the history.
+const noteRevisionCoalesceWindow = 2 * time.Minute
+
+const noteRevisionColumns = `nr.id, nr.note_id, nr.title, char_length(nr.content), nr.restored_from, nr.created_at`
+
+func scanNoteRevisionSummary(row rowScanner, rev *types.NoteRevisionSummary) error {
+	return row.Scan(&rev.ID, &rev.NoteID, &rev.Title, &rev.Length, &rev.RestoredFrom, &rev.CreatedAt)
+}
+
+// recordNoteRevision saves the note's current title and content as its newest
+// revision, then prunes the note's history to the user's retention settings.
+//
+// Nothing is recorded if the newest revision already matches the note. Saves
+// within noteRevisionCoalesceWindow of the newest revision's creation replace
+// it, unless either side is a restore.
+func recordNoteRevision(ctx context.Context, q querier, noteID, userID int, restoredFrom *int) error {
+	var title, content string
+	err := q.QueryRow(ctx, `SELECT title, COALESCE(content, '') FROM notes WHERE id = $1 AND user_id = $2`,
+		noteID, userID).Scan(&title, &content)
+	if err != nil {
+		return notFound(err)
+	}
+
+	var latestID int
+	var latestTitle, latestContent string
+	var recent, latestRestore bool
+	err = q.QueryRow(ctx, `SELECT id, title, content, created_at > CURRENT_TIMESTAMP - make_interval(secs => $2),
+								  restored_from IS NOT NULL
+						   FROM note_revisions WHERE note_id = $1 ORDER BY id DESC LIMIT 1`,
+		noteID, noteRevisionCoalesceWindow.Seconds()).Scan(&latestID, &latestTitle, &latestContent, &recent, &latestRestore)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	switch {
+	case latestID != 0 && latestTitle == title && latestContent == content:
+		return nil
+	case latestID != 0 && recent && !latestRestore && restoredFrom == nil:
+		_, err = q.Exec(ctx, `UPDATE note_revisions SET title = $2, content = $3 WHERE id = $1`, latestID, title, content)
+	default:
+		_, err = q.Exec(ctx, `INSERT INTO note_revisions (note_id, title, content, restored_from) VALUES ($1, $2, $3, $4)`,
+			noteID, title, content, restoredFrom)
+	}
+	if err != nil {
+		return err
+	}
+	return pruneNoteRevisions(ctx, q, userID, noteID)
+}
+
+// pruneNoteRevisions deletes revisions beyond the user's retention limits, for
+// one note or, with noteID zero, for all of the user's notes.
+func pruneNoteRevisions(ctx context.Context, q querier, userID, noteID int) error {
+	query := `DELETE FROM note_revisions WHERE id IN (
+				  SELECT r.id FROM users u, (
+					  SELECT nr.id, nr.created_at,
+							 row_number() OVER (PARTITION BY nr.note_id ORDER BY nr.id DESC) AS rn
+					  FROM note_revisions nr JOIN notes n ON n.id = nr.note_id
+					  WHERE n.user_id = $1 AND ($2 = 0 OR n.id = $2)
+				  ) r
+				  WHERE u.id = $1 AND r.rn > 1
+					AND (r.rn > u.note_revision_limit
+						 OR r.created_at < CURRENT_TIMESTAMP - make_interval(days => u.note_revision_max_age_days))
+			  )`
+	_, err := q.Exec(ctx, query, userID, noteID)
+	return err
+}
+
+// GetNoteRevisions lists a note's revisions, newest first, without their content.
+func (s *NoteStore) GetNoteRevisions(noteID, userID int) ([]types.NoteRevisionSummary, error) {
+	ctx := context.Background()
+	if _, err := getNote(ctx, s.db, noteID, userID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + noteRevisionColumns + ` FROM note_revisions nr WHERE nr.note_id = $1 ORDER BY nr.id DESC`
+	rows, err := s.db.Query(ctx, query, noteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]types.NoteRevisionSummary, 0)
+	for rows.Next() {
+		var rev types.NoteRevisionSummary
+		if err := scanNoteRevisionSummary(rows, &rev); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// GetNoteRevision retrieves a single revision of one of the user's notes.
+func (s *NoteStore) GetNoteRevision(noteID, revisionID, userID int) (*types.NoteRevision, error) {
+	return getNoteRevision(context.Background(), s.db, noteID, revisionID, userID)
+}
+
+func getNoteRevision(ctx context.Context, q querier, noteID, revisionID, userID int) (*types.NoteRevision, error) {
+	query := `SELECT ` + noteRevisionColumns + `, nr.content
+			   FROM note_revisions nr JOIN notes n ON n.id = nr.note_id
+			   WHERE nr.id = $1 AND nr.note_id = $2 AND n.user_id = $3`
+	var rev types.NoteRevision
+	err := q.QueryRow(ctx, query, revisionID, noteID, userID).Scan(
+		&rev.ID, &rev.NoteID, &rev.Title, &rev.Length, &rev.RestoredFrom, &rev.CreatedAt, &rev.Content,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &rev, nil
+}
+
+// RestoreNoteRevision sets a note's title and content back to those of one of
+// its revisions. The restore is itself recorded as a new revision, so it can
+// be undone.
+func (s *NoteStore) RestoreNoteRevision(noteID, revisionID, userID int) (*types.Note, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rev, err := getNoteRevision(ctx, tx, noteID, revisionID, userID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `UPDATE notes SET title = $3, content = $4, updated_at = $5, version = version + 1
+							 WHERE id = $1 AND user_id = $2`,
+		noteID, userID, rev.Title, rev.Content, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := recordNoteRevision(ctx, tx, noteID, userID, &rev.ID); err != nil {
+		return nil, err
+	}
+
+	note, err := getNote(ctx, tx, noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return note, tx.Commit(ctx)
+}
@@ -2,63 +2,166 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"tempo-backend/types"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type NoteStore struct {
-	db *pgxpool.Pool
+	db dbtx
 }
 
 func NewNoteStore(db *pgxpool.Pool) *NoteStore {
 	return &NoteStore{db: db}
 }
 
-func (s *NoteStore) CreateNote(payload types.CreateNotePayload, userID int) (*types.Note, error) {
-	query := `INSERT INTO notes (user_id, title, content) VALUES ($1, $2, $3)
-			   RETURNING id, user_id, title, content, created_at, updated_at`
-	var note types.Note
-	err := s.db.QueryRow(context.Background(), query, userID, payload.Title, payload.Content).Scan(
-		&note.ID, &note.UserID, &note.Title, &note.Content, &note.CreatedAt, &note.UpdatedAt,
+// noteColumns selects a note aliased as n, including its tag names in alphabetical order.
+const noteColumns = `n.id, n.user_id, n.notebook_id, n.title, COALESCE(n.content, ''), n.created_at, n.updated_at,
+			   ARRAY(SELECT t.name FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+			         WHERE nt.note_id = n.id ORDER BY lower(t.name)), n.version`
+
+func scanNote(row rowScanner, note *types.Note) error {
+	return row.Scan(
+		&note.ID, &note.UserID, &note.NotebookID, &note.Title, &note.Content, &note.CreatedAt, &note.UpdatedAt, &note.Tags,
+		&note.Version,
 	)
-	return &note, err
 }
 
-func (s *NoteStore) GetNotesByUser(userID int) ([]types.Note, error) {
-	query := `SELECT id, user_id, title, content, created_at, updated_at
-			   FROM notes WHERE user_id = $1 ORDER BY updated_at DESC`
-	rows, err := s.db.Query(context.Background(), query, userID)
+// NoteFilter narrows GetNotesByUser. Zero values mean "don't filter".
+type NoteFilter struct {
+	NotebookID int
+	Tag        string
+}
+
+func (s *NoteStore) CreateNote(payload types.CreateNotePayload, userID int) (*types.Note, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	notebookID, err := resolveNotebook(ctx, tx, payload.NotebookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var noteID int
+	query := `INSERT INTO notes (user_id, notebook_id, title, content, search_language)
+			   VALUES ($1, $2, $3, $4, (SELECT search_language FROM users WHERE id = $1))
+			   RETURNING id`
+	if err := tx.QueryRow(ctx, query, userID, notebookID, payload.Title, payload.Content).Scan(&noteID); err != nil {
+		return nil, err
+	}
+
+	if err := setNoteTags(ctx, tx, noteID, userID, payload.Tags); err != nil {
+		return nil, err
+	}
+	if err := recordNoteRevision(ctx, tx, noteID, userID, nil); err != nil {
+		return nil, err
+	}
+
+	note, err := getNote(ctx, tx, noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return note, tx.Commit(ctx)
+}
+
+// noteListing sorts notes by when they were last updated or created, newest
+// first, or by title.
+var noteListing = listing{
+	orders: map[string]sortOrder{
+		"updated": {{"COALESCE(n.updated_at, '-infinity')", "timestamptz", true}, {"n.id", "int", true}},
+		"created": {{"COALESCE(n.created_at, '-infinity')", "timestamptz", true}, {"n.id", "int", true}},
+		"title":   {{"lower(n.title)", "text", false}, {"n.id", "int", false}},
+	},
+	defaultOrder: "updated",
+}
+
+// GetNotesByUser returns a page of the user's notes, with the cursor of the next page.
+func (s *NoteStore) GetNotesByUser(userID int, filter NoteFilter, opts PageOptions) ([]types.Note, string, error) {
+	where := []string{"n.user_id = $1"}
+	args := []interface{}{userID}
+	if filter.NotebookID != 0 {
+		args = append(args, filter.NotebookID)
+		where = append(where, fmt.Sprintf("n.notebook_id = $%d", len(args)))
+	}
+	if filter.Tag != "" {
+		args = append(args, filter.Tag)
+		where = append(where, fmt.Sprintf(`EXISTS (SELECT 1 FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+			   WHERE nt.note_id = n.id AND lower(t.name) = lower($%d))`, len(args)))
+	}
+
+	return queryPage(context.Background(), s.db, noteListing, opts, noteColumns, "notes n", where, args, scanNote)
+}
+
+// SearchNotes runs a full-text search over the user's notes in their search language,
+// best matches first. See tsQueryExpr for the query syntax.
+func (s *NoteStore) SearchNotes(userID int, search string) ([]types.NoteSearchResult, error) {
+	args := []interface{}{userID}
+	tsQuery, err := tsQueryExpr(search, "u.search_language", &args)
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, headlineOptions(0), headlineOptions(2))
+	query := fmt.Sprintf(`WITH q AS (SELECT %s AS query, u.search_language AS lang FROM users u WHERE u.id = $1)
+			   SELECT `+noteColumns+`,
+			          ts_rank_cd(n.search_vector, q.query) AS rank,
+			          ts_headline(q.lang, n.title, q.query, $%d),
+			          ts_headline(q.lang, COALESCE(n.content, ''), q.query, $%d)
+			   FROM notes n, q
+			   WHERE n.user_id = $1 AND n.search_vector @@ q.query
+			   ORDER BY rank DESC, n.updated_at DESC`, tsQuery, len(args)-1, len(args))
+	rows, err := s.db.Query(context.Background(), query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	notes := make([]types.Note, 0)
+	results := make([]types.NoteSearchResult, 0)
 	for rows.Next() {
-		var note types.Note
-		if err := rows.Scan(&note.ID, &note.UserID, &note.Title, &note.Content, &note.CreatedAt, &note.UpdatedAt); err != nil {
+		var r types.NoteSearchResult
+		if err := rows.Scan(&r.ID, &r.UserID, &r.NotebookID, &r.Title, &r.Content, &r.CreatedAt, &r.UpdatedAt, &r.Tags,
+			&r.Version, &r.Rank, &r.TitleHighlight, &r.Snippet); err != nil {
 			return nil, err
 		}
-		notes = append(notes, note)
+		r.TitleHighlight = renderHighlight(r.TitleHighlight)
+		r.Snippet = renderHighlight(r.Snippet)
+		results = append(results, r)
 	}
-	return notes, nil
+	return results, rows.Err()
 }
 
 func (s *NoteStore) GetNoteByID(noteID, userID int) (*types.Note, error) {
-	query := `SELECT id, user_id, title, content, created_at, updated_at
-			   FROM notes WHERE id = $1 AND user_id = $2`
+	return getNote(context.Background(), s.db, noteID, userID)
+}
+
+func getNote(ctx context.Context, q querier, noteID, userID int) (*types.Note, error) {
+	query := `SELECT ` + noteColumns + ` FROM notes n WHERE n.id = $1 AND n.user_id = $2`
 	var note types.Note
-	err := s.db.QueryRow(context.Background(), query, noteID, userID).Scan(
-		&note.ID, &note.UserID, &note.Title, &note.Content, &note.CreatedAt, &note.UpdatedAt,
-	)
-	return &note, err
+	if err := scanNote(q.QueryRow(ctx, query, noteID, userID), &note); err != nil {
+		return nil, notFound(err)
+	}
+	return &note, nil
 }
 
-func (s *NoteStore) UpdateNote(noteID, userID int, payload types.UpdateNotePayload) (*types.Note, error) {
+// UpdateNote applies the payload to one of the user's notes. If expectedVersion is
+// set and the note has moved on since, nothing is changed and ErrVersionConflict is returned.
+func (s *NoteStore) UpdateNote(noteID, userID int, payload types.UpdateNotePayload, expectedVersion *int) (*types.Note, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
 	var setParts []string
 	var args []interface{}
 	argID := 1
@@ -73,34 +176,117 @@ func (s *NoteStore) UpdateNote(noteID, userID int, payload types.UpdateNotePaylo
 		args = append(args, *payload.Content)
 		argID++
 	}
-	if len(setParts) == 0 {
-		return s.GetNoteByID(noteID, userID) // No update, just return the note
+	if payload.NotebookID != nil {
+		notebookID, err := resolveNotebook(ctx, tx, payload.NotebookID, userID)
+		if err != nil {
+			return nil, err
+		}
+		setParts = append(setParts, fmt.Sprintf("notebook_id = $%d", argID))
+		args = append(args, notebookID)
+		argID++
+	}
+	if len(setParts) == 0 && payload.Tags == nil {
+		// No update, just return the note
+		note, err := getNote(ctx, tx, noteID, userID)
+		if err == nil && expectedVersion != nil && note.Version != *expectedVersion {
+			return nil, ErrVersionConflict
+		}
+		return note, err
 	}
 
-	setParts = append(setParts, fmt.Sprintf("updated_at = $%d", argID))
+	setParts = append(setParts, fmt.Sprintf("updated_at = $%d", argID), "version = version + 1")
 	args = append(args, time.Now())
 	argID++
 
-	args = append(args, noteID, userID)
-	query := fmt.Sprintf(`UPDATE notes SET %s WHERE id = $%d AND user_id = $%d
-						   RETURNING id, user_id, title, content, created_at, updated_at`,
-		strings.Join(setParts, ", "), argID, argID+1)
+	args = append(args, noteID, userID, expectedVersion)
+	query := fmt.Sprintf(`UPDATE notes SET %s WHERE id = $%d AND user_id = $%d AND ($%d::int IS NULL OR version = $%d)`,
+		strings.Join(setParts, ", "), argID, argID+1, argID+2, argID+2)
+	cmd, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.RowsAffected() == 0 {
+		if _, err := getNote(ctx, tx, noteID, userID); err != nil {
+			return nil, err
+		}
+		return nil, ErrVersionConflict
+	}
 
-	var note types.Note
-	err := s.db.QueryRow(context.Background(), query, args...).Scan(
-		&note.ID, &note.UserID, &note.Title, &note.Content, &note.CreatedAt, &note.UpdatedAt,
-	)
-	return &note, err
+	if payload.Tags != nil {
+		if err := setNoteTags(ctx, tx, noteID, userID, *payload.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if payload.Title != nil || payload.Content != nil {
+		if err := recordNoteRevision(ctx, tx, noteID, userID, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	note, err := getNote(ctx, tx, noteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return note, tx.Commit(ctx)
 }
 
-func (s *NoteStore) DeleteNote(noteID, userID int) error {
-	query := `DELETE FROM notes WHERE id = $1 AND user_id = $2`
-	cmd, err := s.db.Exec(context.Background(), query, noteID, userID)
+// DeleteNote deletes one of the user's notes. If expectedVersion is set and the
+// note has moved on since, nothing is deleted and ErrVersionConflict is returned.
+func (s *NoteStore) DeleteNote(noteID, userID int, expectedVersion *int) error {
+	query := `DELETE FROM notes WHERE id = $1 AND user_id = $2 AND ($3::int IS NULL OR version = $3)`
+	cmd, err := s.db.Exec(context.Background(), query, noteID, userID, expectedVersion)
 	if err != nil {
 		return err
 	}
 	if cmd.RowsAffected() == 0 {
-		return fmt.Errorf("note not found or user not authorized")
+		if expectedVersion == nil {
+			return ErrNotFound
+		}
+		if _, err := s.GetNoteByID(noteID, userID); err != nil {
+			return err
+		}
+		return ErrVersionConflict
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// resolveNotebook returns the notebook a note should be filed in: the requested
+// one, which the user must own, or the user's default notebook if none was requested.
+func resolveNotebook(ctx context.Context, tx pgx.Tx, notebookID *int, userID int) (int, error) {
+	if notebookID == nil {
+		return ensureDefaultNotebook(ctx, tx, userID)
+	}
+	var id int
+	err := tx.QueryRow(ctx, `SELECT id FROM notebooks WHERE id = $1 AND user_id = $2`, *notebookID, userID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotebookNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// setNoteTags replaces a note's tags with the given names, creating any tags the
+// user doesn't have yet. Names are matched case-insensitively.
+func setNoteTags(ctx context.Context, tx pgx.Tx, noteID, userID int, names []string) error {
+	names, err := normalizeTagNames(names)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM note_tags WHERE note_id = $1`, noteID); err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	if err := createTags(ctx, tx, userID, names); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO note_tags (note_id, tag_id)
+						   SELECT $1, id FROM tags WHERE user_id = $2 AND lower(name) IN (SELECT lower(unnest($3::text[])))`,
+		noteID, userID, names)
+	return err
+}
@@ -0,0 +1,241 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"tempo-backend/types"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// defaultNotebookName is the name given to a user's default notebook when it is first created.
+const defaultNotebookName = "Notebook"
+
+type NotebookStore struct {
+	db *pgxpool.Pool
+}
+
+func NewNotebookStore(db *pgxpool.Pool) *NotebookStore {
+	return &NotebookStore{db: db}
+}
+
+const notebookColumns = `nb.id, nb.user_id, nb.parent_id, nb.name, nb.is_default,
+			   (SELECT COUNT(*) FROM notes n WHERE n.notebook_id = nb.id), nb.created_at, nb.updated_at`
+
+func scanNotebook(row rowScanner, nb *types.Notebook) error {
+	return row.Scan(&nb.ID, &nb.UserID, &nb.ParentID, &nb.Name, &nb.IsDefault, &nb.NoteCount, &nb.CreatedAt, &nb.UpdatedAt)
+}
+
+// ensureDefaultNotebook returns the ID of the user's default notebook, creating it on first use.
+func ensureDefaultNotebook(ctx context.Context, q querier, userID int) (int, error) {
+	if _, err := q.Exec(ctx, `INSERT INTO notebooks (user_id, name, is_default) VALUES ($1, $2, TRUE)
+							  ON CONFLICT (user_id) WHERE is_default DO NOTHING`, userID, defaultNotebookName); err != nil {
+		return 0, err
+	}
+	var id int
+	err := q.QueryRow(ctx, `SELECT id FROM notebooks WHERE user_id = $1 AND is_default`, userID).Scan(&id)
+	return id, err
+}
+
+// CreateNotebook creates a notebook, optionally nested inside another of the user's notebooks.
+func (s *NotebookStore) CreateNotebook(payload types.CreateNotebookPayload, userID int) (*types.Notebook, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if payload.ParentID != nil {
+		if _, err := getNotebook(ctx, tx, *payload.ParentID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	var id int
+	err = tx.QueryRow(ctx, `INSERT INTO notebooks (user_id, parent_id, name) VALUES ($1, $2, $3) RETURNING id`,
+		userID, payload.ParentID, payload.Name).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	nb, err := getNotebook(ctx, tx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	return nb, tx.Commit(ctx)
+}
+
+// GetNotebooksByUser retrieves all of the user's notebooks, flat; use ParentID to rebuild the tree.
+// The default notebook is created if the user doesn't have one yet.
+func (s *NotebookStore) GetNotebooksByUser(userID int) ([]types.Notebook, error) {
+	ctx := context.Background()
+	if _, err := ensureDefaultNotebook(ctx, s.db, userID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + notebookColumns + ` FROM notebooks nb WHERE nb.user_id = $1
+			   ORDER BY nb.is_default DESC, lower(nb.name)`
+	rows, err := s.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notebooks := make([]types.Notebook, 0)
+	for rows.Next() {
+		var nb types.Notebook
+		if err := scanNotebook(rows, &nb); err != nil {
+			return nil, err
+		}
+		notebooks = append(notebooks, nb)
+	}
+	return notebooks, rows.Err()
+}
+
+// GetNotebookByID retrieves a single notebook, ensuring it belongs to the user.
+func (s *NotebookStore) GetNotebookByID(notebookID, userID int) (*types.Notebook, error) {
+	return getNotebook(context.Background(), s.db, notebookID, userID)
+}
+
+func getNotebook(ctx context.Context, q querier, notebookID, userID int) (*types.Notebook, error) {
+	query := `SELECT ` + notebookColumns + ` FROM notebooks nb WHERE nb.id = $1 AND nb.user_id = $2`
+	var nb types.Notebook
+	if err := scanNotebook(q.QueryRow(ctx, query, notebookID, userID), &nb); err != nil {
+		return nil, notFound(err)
+	}
+	return &nb, nil
+}
+
+// UpdateNotebook renames a notebook and/or moves it under a new parent.
+func (s *NotebookStore) UpdateNotebook(notebookID, userID int, payload types.UpdateNotebookPayload) (*types.Notebook, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var setParts []string
+	var args []interface{}
+	argID := 1
+
+	if payload.Name != nil {
+		setParts = append(setParts, fmt.Sprintf("name = $%d", argID))
+		args = append(args, *payload.Name)
+		argID++
+	}
+	if payload.MoveToTopLevel {
+		setParts = append(setParts, "parent_id = NULL")
+	} else if payload.ParentID != nil {
+		if err := checkNotebookParent(ctx, tx, notebookID, *payload.ParentID, userID); err != nil {
+			return nil, err
+		}
+		setParts = append(setParts, fmt.Sprintf("parent_id = $%d", argID))
+		args = append(args, *payload.ParentID)
+		argID++
+	}
+	if len(setParts) == 0 {
+		return getNotebook(ctx, tx, notebookID, userID) // No update, just return the notebook
+	}
+	setParts = append(setParts, "updated_at = CURRENT_TIMESTAMP")
+
+	args = append(args, notebookID, userID)
+	query := fmt.Sprintf(`UPDATE notebooks SET %s WHERE id = $%d AND user_id = $%d`,
+		strings.Join(setParts, ", "), argID, argID+1)
+	cmd, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.RowsAffected() == 0 {
+		return nil, ErrNotFound
+	}
+
+	nb, err := getNotebook(ctx, tx, notebookID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return nb, tx.Commit(ctx)
+}
+
+// checkNotebookParent verifies that parentID is one of the user's notebooks and
+// is neither notebookID itself nor nested anywhere inside it.
+func checkNotebookParent(ctx context.Context, q querier, notebookID, parentID, userID int) error {
+	query := `WITH RECURSIVE ancestors AS (
+				  SELECT id, parent_id FROM notebooks WHERE id = $1 AND user_id = $2
+				  UNION ALL
+				  SELECT nb.id, nb.parent_id FROM notebooks nb JOIN ancestors a ON nb.id = a.parent_id
+			   )
+			   SELECT EXISTS (SELECT 1 FROM ancestors), EXISTS (SELECT 1 FROM ancestors WHERE id = $3)`
+	var parentExists, cycle bool
+	if err := q.QueryRow(ctx, query, parentID, userID, notebookID).Scan(&parentExists, &cycle); err != nil {
+		return err
+	}
+	if !parentExists {
+		return ErrNotebookNotFound
+	}
+	if cycle {
+		return ErrNotebookCycle
+	}
+	return nil
+}
+
+// DeleteNotebook deletes one of the user's notebooks other than the default one.
+//
+// With cascade false, the notebook's notes move to the default notebook and its
+// sub-notebooks move up to its parent. With cascade true, the notebook is
+// deleted along with every sub-notebook and every note inside any of them.
+func (s *NotebookStore) DeleteNotebook(notebookID, userID int, cascade bool) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var parentID *int
+	var isDefault bool
+	err = tx.QueryRow(ctx, `SELECT parent_id, is_default FROM notebooks WHERE id = $1 AND user_id = $2 FOR UPDATE`,
+		notebookID, userID).Scan(&parentID, &isDefault)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if isDefault {
+		return ErrDefaultNotebook
+	}
+
+	if cascade {
+		query := `WITH RECURSIVE subtree AS (
+					  SELECT id FROM notebooks WHERE id = $1
+					  UNION ALL
+					  SELECT nb.id FROM notebooks nb JOIN subtree st ON nb.parent_id = st.id
+				  )
+				  DELETE FROM notes WHERE notebook_id IN (SELECT id FROM subtree)`
+		if _, err := tx.Exec(ctx, query, notebookID); err != nil {
+			return err
+		}
+	} else {
+		defaultID, err := ensureDefaultNotebook(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE notes SET notebook_id = $2, version = version + 1 WHERE notebook_id = $1`, notebookID, defaultID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE notebooks SET parent_id = $2 WHERE parent_id = $1`, notebookID, parentID); err != nil {
+			return err
+		}
+	}
+
+	// Any remaining sub-notebooks (cascade only) go with it via the parent_id foreign key.
+	if _, err := tx.Exec(ctx, `DELETE FROM notebooks WHERE id = $1`, notebookID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
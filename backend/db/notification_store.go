@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"tempo-backend/types"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type NotificationStore struct {
+	db *pgxpool.Pool
+}
+
+func NewNotificationStore(db *pgxpool.Pool) *NotificationStore {
+	return &NotificationStore{db: db}
+}
+
+const notificationColumns = `id, kind, title, body, item_id, read_at, created_at`
+
+func scanNotification(row rowScanner, n *types.Notification) error {
+	return row.Scan(&n.ID, &n.Kind, &n.Title, &n.Body, &n.ItemID, &n.ReadAt, &n.CreatedAt)
+}
+
+// AddReminderNotification puts a reminder into the user's feed. Adding the
+// same delivery twice, as a retry may, adds it once.
+func (s *NotificationStore) AddReminderNotification(reminder types.DueReminder, title, body string) error {
+	_, err := s.db.Exec(context.Background(),
+		`INSERT INTO notifications (user_id, kind, title, body, item_id, delivery_id) VALUES ($1, 'reminder', $2, $3, $4, $5)
+		 ON CONFLICT (delivery_id) DO NOTHING`,
+		reminder.UserID, title, body, reminder.ItemID, reminder.DeliveryID)
+	return err
+}
+
+// GetNotifications retrieves up to limit of the user's notifications, newest
+// first, optionally only the unread ones.
+func (s *NotificationStore) GetNotifications(userID int, unreadOnly bool, limit int) ([]types.Notification, error) {
+	query := `SELECT ` + notificationColumns + ` FROM notifications
+			   WHERE user_id = $1 AND (NOT $2 OR read_at IS NULL) ORDER BY created_at DESC, id DESC LIMIT $3`
+	rows, err := s.db.Query(context.Background(), query, userID, unreadOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := make([]types.Notification, 0)
+	for rows.Next() {
+		var n types.Notification
+		if err := scanNotification(rows, &n); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkNotificationRead marks one of the user's notifications as read.
+func (s *NotificationStore) MarkNotificationRead(notificationID, userID int) (*types.Notification, error) {
+	query := `UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP)
+			   WHERE id = $1 AND user_id = $2 RETURNING ` + notificationColumns
+	var n types.Notification
+	if err := scanNotification(s.db.QueryRow(context.Background(), query, notificationID, userID), &n); err != nil {
+		return nil, notFound(err)
+	}
+	return &n, nil
+}
+
+// MarkAllNotificationsRead marks every unread notification of the user's as read.
+func (s *NotificationStore) MarkAllNotificationsRead(userID int) error {
+	_, err := s.db.Exec(context.Background(),
+		`UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND read_at IS NULL`, userID)
+	return err
+}
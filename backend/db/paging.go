@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// pageCursorPrefix versions the page cursor format, like syncCursorPrefix.
+const pageCursorPrefix = "p1:"
+
+// PageOptions chooses one page of a listing, such as a user's notes.
+type PageOptions struct {
+	// Sort names one of the listing's orders, or its reverse with a leading
+	// "-". Empty means the listing's default order.
+	Sort string
+	// Limit is the most records on the page; 0 means no limit.
+	Limit int
+	// Cursor continues from the end of the previous page; empty starts at the top.
+	Cursor string
+}
+
+// sortKey is one column a listing is sorted by.
+type sortKey struct {
+	expr string // A SQL expression that is never NULL
+	typ  string // Its SQL type, to read cursor values back as
+	desc bool
+}
+
+// sortOrder is the keys of one order of a listing, most significant first.
+// The last key is unique, so every record has a place of its own and a
+// cursor picks up exactly where the last page ended.
+type sortOrder []sortKey
+
+// listing is a kind of record that can be read a page at a time.
+type listing struct {
+	orders       map[string]sortOrder
+	defaultOrder string
+}
+
+// order resolves PageOptions.Sort, returning the name cursors are tied to.
+func (l listing) order(name string) (string, sortOrder, error) {
+	if name == "" {
+		name = l.defaultOrder
+	}
+	order, ok := l.orders[strings.TrimPrefix(name, "-")]
+	if !ok {
+		names := make([]string, 0, len(l.orders))
+		for n := range l.orders {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return "", nil, fmt.Errorf("%w: use one of %s, with a leading - to reverse it", ErrInvalidSort, strings.Join(names, ", "))
+	}
+	if strings.HasPrefix(name, "-") {
+		order = order.reversed()
+	}
+	return name, order, nil
+}
+
+func (o sortOrder) reversed() sortOrder {
+	r := make(sortOrder, len(o))
+	for i, key := range o {
+		r[i] = sortKey{expr: key.expr, typ: key.typ, desc: !key.desc}
+	}
+	return r
+}
+
+func (o sortOrder) orderBy() string {
+	parts := make([]string, len(o))
+	for i, key := range o {
+		parts[i] = key.expr
+		if key.desc {
+			parts[i] += " DESC"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// keyColumns selects the keys as text, for the cursor of the last record.
+func (o sortOrder) keyColumns() string {
+	parts := make([]string, len(o))
+	for i, key := range o {
+		parts[i] = "(" + key.expr + ")::text"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// after returns a condition matching the records that come after one whose
+// keys have the given values, adding the values to args.
+func (o sortOrder) after(values []string, args *[]interface{}) string {
+	params := make([]string, len(o))
+	for i, key := range o {
+		*args = append(*args, values[i])
+		params[i] = fmt.Sprintf("$%d::%s", len(*args), key.typ)
+	}
+	ors := make([]string, len(o))
+	for i, key := range o {
+		ands := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, o[j].expr+" = "+params[j])
+		}
+		op := ">"
+		if key.desc {
+			op = "<"
+		}
+		ands = append(ands, key.expr+" "+op+" "+params[i])
+		ors[i] = "(" + strings.Join(ands, " AND ") + ")"
+	}
+	return "(" + strings.Join(ors, " OR ") + ")"
+}
+
+// pageCursor is what a cursor holds: the order it was made for and the keys
+// of the last record of its page.
+type pageCursor struct {
+	Sort string   `json:"s"`
+	Keys []string `json:"k"`
+}
+
+func encodePageCursor(sort string, keys []string) string {
+	raw, _ := json.Marshal(pageCursor{Sort: sort, Keys: keys})
+	return base64.RawURLEncoding.EncodeToString(append([]byte(pageCursorPrefix), raw...))
+}
+
+// decodePageCursor unwraps a cursor from encodePageCursor, checking that it
+// was made for the same order.
+func decodePageCursor(cursor, sort string, keys int) ([]string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), pageCursorPrefix) {
+		return nil, ErrInvalidCursor
+	}
+	var c pageCursor
+	if err := json.Unmarshal(raw[len(pageCursorPrefix):], &c); err != nil || c.Sort != sort || len(c.Keys) != keys {
+		return nil, ErrInvalidCursor
+	}
+	return c.Keys, nil
+}
+
+// keyScanner scans a row whose record columns are followed by its sort keys.
+type keyScanner struct {
+	row  rowScanner
+	keys []string
+}
+
+func (s keyScanner) Scan(dest ...any) error {
+	for i := range s.keys {
+		dest = append(dest, &s.keys[i])
+	}
+	return s.row.Scan(dest...)
+}
+
+// queryPage reads one page of a listing: the records selected by columns from
+// from that match every condition in where. It returns the cursor of the next
+// page, or "" on the last one.
+func queryPage[T any](ctx context.Context, q querier, l listing, opts PageOptions, columns, from string, where []string,
+	args []interface{}, scan func(rowScanner, *T) error) ([]T, string, error) {
+	name, order, err := l.order(opts.Sort)
+	if err != nil {
+		return nil, "", err
+	}
+	if opts.Cursor != "" {
+		values, err := decodePageCursor(opts.Cursor, name, len(order))
+		if err != nil {
+			return nil, "", err
+		}
+		where = append(where, order.after(values, &args))
+	}
+
+	query := `SELECT ` + columns + `, ` + order.keyColumns() + ` FROM ` + from +
+		` WHERE ` + strings.Join(where, " AND ") + ` ORDER BY ` + order.orderBy()
+	if opts.Limit > 0 {
+		// One more than asked for tells whether there is another page.
+		args = append(args, opts.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	rows, err := q.Query(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	records := make([]T, 0)
+	var last []string
+	for rows.Next() {
+		if opts.Limit > 0 && len(records) == opts.Limit {
+			return records, encodePageCursor(name, last), nil
+		}
+		var record T
+		keys := make([]string, len(order))
+		if err := scan(keyScanner{rows, keys}, &record); err != nil {
+			return nil, "", err
+		}
+		records = append(records, record)
+		last = keys
+	}
+	return records, "", rows.Err()
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"context"
+	"tempo-backend/types"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Reminders hang off items, so like items they are scoped to the user through
+// todo_lists. The scheduler side of the store (FireDueReminders onwards) works
+// across all users and is safe to run from any number of backend instances at
+// once: every claim skips rows another instance has locked.
+
+type ReminderStore struct {
+	db *pgxpool.Pool
+}
+
+func NewReminderStore(db *pgxpool.Pool) *ReminderStore {
+	return &ReminderStore{db: db}
+}
+
+// reminderFireAt is when a reminder fires, for use with reminders r joined to
+// their item ti. Offset reminders fire offset_minutes before the item is due,
+// reading its due date and time in the reminder's timezone; items without a
+// due time count as due at 09:00.
+const reminderFireAt = `CASE WHEN r.offset_minutes IS NULL THEN r.remind_at
+			   WHEN ti.due_date IS NULL THEN NULL
+			   ELSE (ti.due_date + COALESCE(ti.due_time, TIME '09:00')) AT TIME ZONE r.timezone
+					- make_interval(mins => r.offset_minutes) END`
+
+const reminderColumns = `r.id, r.item_id, r.remind_at, r.offset_minutes, r.timezone, r.channels, r.fire_at, r.status, r.created_at`
+
+func scanReminder(row rowScanner, reminder *types.Reminder) error {
+	return row.Scan(&reminder.ID, &reminder.ItemID, &reminder.RemindAt, &reminder.OffsetMinutes, &reminder.Timezone,
+		&reminder.Channels, &reminder.FireAt, &reminder.Status, &reminder.CreatedAt)
+}
+
+// rescheduleReminders recomputes when the item's offset reminders fire after
+// its due date or time has changed. A reminder whose time moves is pending
+// again, even if it already fired for the old time.
+func rescheduleReminders(ctx context.Context, q querier, itemID int) error {
+	query := `UPDATE reminders r SET fire_at = ` + reminderFireAt + `, status = 'pending'
+			   FROM todo_items ti
+			   WHERE r.item_id = $1 AND ti.id = r.item_id AND r.offset_minutes IS NOT NULL
+				 AND r.fire_at IS DISTINCT FROM (` + reminderFireAt + `)`
+	_, err := q.Exec(ctx, query, itemID)
+	return err
+}
+
+// CreateReminder adds a reminder to an item owned by the user.
+func (s *ReminderStore) CreateReminder(itemID, userID int, payload types.CreateReminderPayload) (*types.Reminder, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var reminderID int
+	err = tx.QueryRow(ctx, `INSERT INTO reminders (item_id, remind_at, offset_minutes, timezone, channels, fire_at)
+							 SELECT ti.id, $3, $4, $5, $6, $3
+							 FROM todo_items ti JOIN todo_lists tl ON tl.id = ti.list_id
+							 WHERE ti.id = $1 AND tl.user_id = $2
+							 RETURNING id`,
+		itemID, userID, payload.RemindAt, payload.OffsetMinutes, payload.Timezone, payload.Channels).Scan(&reminderID)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if err := rescheduleReminders(ctx, tx, itemID); err != nil {
+		return nil, err
+	}
+
+	var reminder types.Reminder
+	if err := scanReminder(tx.QueryRow(ctx, `SELECT `+reminderColumns+` FROM reminders r WHERE r.id = $1`, reminderID), &reminder); err != nil {
+		return nil, err
+	}
+	reminder.Deliveries = make([]types.ReminderDelivery, 0)
+	return &reminder, tx.Commit(ctx)
+}
+
+// GetRemindersByItem retrieves an item's reminders, soonest first, each with
+// its latest delivery on every channel.
+func (s *ReminderStore) GetRemindersByItem(itemID, userID int) ([]types.Reminder, error) {
+	ctx := context.Background()
+	if _, err := getTodoItem(ctx, s.db, itemID, userID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + reminderColumns + `
+			   FROM reminders r JOIN todo_items ti ON ti.id = r.item_id JOIN todo_lists tl ON tl.id = ti.list_id
+			   WHERE r.item_id = $1 AND tl.user_id = $2 ORDER BY r.fire_at NULLS LAST, r.id`
+	rows, err := s.db.Query(ctx, query, itemID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := make([]types.Reminder, 0)
+	index := make(map[int]int)
+	for rows.Next() {
+		var reminder types.Reminder
+		if err := scanReminder(rows, &reminder); err != nil {
+			return nil, err
+		}
+		reminder.Deliveries = make([]types.ReminderDelivery, 0)
+		index[reminder.ID] = len(reminders)
+		reminders = append(reminders, reminder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := s.db.Query(ctx, `SELECT DISTINCT ON (d.reminder_id, d.channel)
+										   d.reminder_id, d.channel, d.fire_at, d.status, d.attempts, d.last_error, d.delivered_at
+										FROM reminder_deliveries d JOIN reminders r ON r.id = d.reminder_id
+										WHERE r.item_id = $1
+										ORDER BY d.reminder_id, d.channel, d.fire_at DESC`, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer deliveries.Close()
+	for deliveries.Next() {
+		var reminderID int
+		var d types.ReminderDelivery
+		if err := deliveries.Scan(&reminderID, &d.Channel, &d.FireAt, &d.Status, &d.Attempts, &d.LastError, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		if i, ok := index[reminderID]; ok {
+			reminders[i].Deliveries = append(reminders[i].Deliveries, d)
+		}
+	}
+	return reminders, deliveries.Err()
+}
+
+// DeleteReminder deletes one of an item's reminders, ensuring the item belongs to the user.
+func (s *ReminderStore) DeleteReminder(reminderID, itemID, userID int) error {
+	query := `DELETE FROM reminders r USING todo_items ti, todo_lists tl
+			   WHERE r.id = $1 AND r.item_id = $2 AND ti.id = r.item_id AND tl.id = ti.list_id AND tl.user_id = $3`
+	cmd, err := s.db.Exec(context.Background(), query, reminderID, itemID, userID)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// FireDueReminders fires up to limit reminders whose time has come, queueing a
+// delivery on each of their channels, and returns how many it took. Reminders
+// for completed items, or more than maxLateness overdue (say, after downtime),
+// are skipped instead of delivered late.
+func (s *ReminderStore) FireDueReminders(limit int, maxLateness time.Duration) (int, error) {
+	query := `WITH due AS (
+				  SELECT r.id, (ti.is_completed OR r.fire_at < CURRENT_TIMESTAMP - make_interval(secs => $2)) AS stale
+				  FROM reminders r JOIN todo_items ti ON ti.id = r.item_id
+				  WHERE r.status = 'pending' AND r.fire_at <= CURRENT_TIMESTAMP
+				  ORDER BY r.fire_at LIMIT $1
+				  FOR UPDATE OF r SKIP LOCKED
+			   ), fired AS (
+				  UPDATE reminders r SET status = CASE WHEN due.stale THEN 'skipped' ELSE 'fired' END
+				  FROM due WHERE r.id = due.id
+				  RETURNING r.id, r.status, r.fire_at, r.channels
+			   ), queued AS (
+				  INSERT INTO reminder_deliveries (reminder_id, channel, fire_at)
+				  SELECT id, unnest(channels), fire_at FROM fired WHERE status = 'fired'
+				  ON CONFLICT (reminder_id, channel, fire_at) DO NOTHING
+			   )
+			   SELECT COUNT(*) FROM fired`
+	var count int
+	err := s.db.QueryRow(context.Background(), query, limit, maxLateness.Seconds()).Scan(&count)
+	return count, err
+}
+
+// ClaimDeliveries claims up to limit pending deliveries that are due, for lease.
+// Each claim counts as an attempt; a delivery that isn't marked delivered or
+// failed before the lease runs out is claimed again.
+func (s *ReminderStore) ClaimDeliveries(limit int, lease time.Duration) ([]types.DueReminder, error) {
+	query := `WITH due AS (
+				  SELECT id FROM reminder_deliveries
+				  WHERE status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+				  ORDER BY next_attempt_at LIMIT $1
+				  FOR UPDATE SKIP LOCKED
+			   ), claimed AS (
+				  UPDATE reminder_deliveries d
+				  SET attempts = d.attempts + 1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+				  FROM due WHERE d.id = due.id
+				  RETURNING d.id, d.reminder_id, d.channel, d.attempts, d.fire_at
+			   )
+			   SELECT c.id, c.reminder_id, c.channel, c.attempts, c.fire_at,
+					  ti.id, ti.list_id, ti.task, ti.due_date, to_char(ti.due_time, 'HH24:MI'),
+					  u.id, u.username, u.email, u.reminder_webhook_url, u.reminder_webhook_secret
+			   FROM claimed c
+			   JOIN reminders r ON r.id = c.reminder_id
+			   JOIN todo_items ti ON ti.id = r.item_id
+			   JOIN todo_lists tl ON tl.id = ti.list_id
+			   JOIN users u ON u.id = tl.user_id`
+	rows, err := s.db.Query(context.Background(), query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []types.DueReminder
+	for rows.Next() {
+		var d types.DueReminder
+		if err := rows.Scan(&d.DeliveryID, &d.ReminderID, &d.Channel, &d.Attempt, &d.FireAt,
+			&d.ItemID, &d.ListID, &d.Task, &d.DueDate, &d.DueTime,
+			&d.UserID, &d.Username, &d.Email, &d.WebhookURL, &d.WebhookSecret); err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+// MarkDelivered records that a delivery succeeded.
+func (s *ReminderStore) MarkDelivered(deliveryID int) error {
+	_, err := s.db.Exec(context.Background(),
+		`UPDATE reminder_deliveries SET status = 'delivered', delivered_at = CURRENT_TIMESTAMP, last_error = NULL
+		 WHERE id = $1`, deliveryID)
+	return err
+}
+
+// MarkAttemptFailed records a failed delivery attempt. The delivery is retried
+// at retryAt, or given up on if retryAt is nil.
+func (s *ReminderStore) MarkAttemptFailed(deliveryID int, reason string, retryAt *time.Time) error {
+	_, err := s.db.Exec(context.Background(),
+		`UPDATE reminder_deliveries
+		 SET status = CASE WHEN $3::timestamptz IS NULL THEN 'failed' ELSE 'pending' END,
+			 last_error = $2, next_attempt_at = COALESCE($3::timestamptz, next_attempt_at)
+		 WHERE id = $1`, deliveryID, reason, retryAt)
+	return err
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// ErrEmptySearch is returned when a search string contains nothing to search for.
+var ErrEmptySearch = errors.New("search query is empty")
+
+// ErrUnknownSearchLanguage is returned when a text search configuration does not exist in the database.
+var ErrUnknownSearchLanguage = errors.New("unknown search language")
+
+// Highlight markers passed to ts_headline. They are control characters so they
+// can't collide with user text, and are turned into <mark> tags only after the
+// rest of the snippet has been HTML-escaped.
+const (
+	highlightStart = "\x01"
+	highlightStop  = "\x02"
+)
+
+// headlineOptions returns ts_headline options for a snippet of content, or for
+// a whole short field such as a title when fragments is zero.
+func headlineOptions(fragments int) string {
+	opts := fmt.Sprintf("StartSel=%s, StopSel=%s", highlightStart, highlightStop)
+	if fragments == 0 {
+		return opts + ", HighlightAll=true"
+	}
+	return opts + fmt.Sprintf(`, MaxFragments=%d, MaxWords=30, MinWords=10, FragmentDelimiter=" … "`, fragments)
+}
+
+// renderHighlight escapes a ts_headline result and wraps matches in <mark> tags.
+func renderHighlight(headline string) string {
+	escaped := html.EscapeString(headline)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	return strings.ReplaceAll(escaped, highlightStop, "</mark>")
+}
+
+// tsQueryExpr turns a user search string into a SQL tsquery expression in the
+// text search configuration given by langExpr, appending its parameters to args.
+//
+// The string uses web search syntax ("quoted phrases", -excluded, or), handled
+// by websearch_to_tsquery, plus prefix terms written as word* which that
+// function doesn't support.
+func tsQueryExpr(search, langExpr string, args *[]interface{}) (string, error) {
+	var plain []string
+	var exprs []string
+	for _, token := range splitSearch(search) {
+		if strings.HasSuffix(token, "*") && !strings.HasPrefix(token, `"`) && !strings.HasPrefix(token, "-") {
+			word := strings.Map(func(r rune) rune {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, token)
+			if word != "" {
+				*args = append(*args, word)
+				exprs = append(exprs, fmt.Sprintf("to_tsquery(%s, $%d || ':*')", langExpr, len(*args)))
+			}
+			continue
+		}
+		plain = append(plain, token)
+	}
+
+	if len(plain) > 0 {
+		*args = append(*args, strings.Join(plain, " "))
+		exprs = append([]string{fmt.Sprintf("websearch_to_tsquery(%s, $%d)", langExpr, len(*args))}, exprs...)
+	}
+	if len(exprs) == 0 {
+		return "", ErrEmptySearch
+	}
+	return "(" + strings.Join(exprs, " && ") + ")", nil
+}
+
+// splitSearch splits a search string on whitespace, keeping quoted phrases together.
+func splitSearch(search string) []string {
+	var tokens []string
+	var current strings.Builder
+	inQuotes := false
+	for _, r := range search {
+		switch {
+		case r == '"':
+			current.WriteRune(r)
+			inQuotes = !inQuotes
+		case unicode.IsSpace(r) && !inQuotes:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"tempo-backend/types"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SearchStore struct {
+	db *pgxpool.Pool
+}
+
+// NewSearchStore creates a new SearchStore.
+func NewSearchStore(db *pgxpool.Pool) *SearchStore {
+	return &SearchStore{db: db}
+}
+
+// Search runs one full-text query across the user's notes, journal entries and
+// to-do items and returns a single ranked page of results.
+func (s *SearchStore) Search(userID int, opts types.SearchOptions) (*types.SearchResults, error) {
+	args := []interface{}{userID}
+	tsQuery, err := tsQueryExpr(opts.Query, "u.search_language", &args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Postgres rejects parameters a statement never references, so the headline
+	// options are only bound once a branch that uses them is included.
+	var titleOpts, snippetOpts int
+	headline := func(opt *int, fragments int) int {
+		if *opt == 0 {
+			args = append(args, headlineOptions(fragments))
+			*opt = len(args)
+		}
+		return *opt
+	}
+
+	wanted := func(kind string) bool {
+		if len(opts.Kinds) == 0 {
+			return true
+		}
+		for _, k := range opts.Kinds {
+			if k == kind {
+				return true
+			}
+		}
+		return false
+	}
+
+	var branches []string
+	if wanted(types.SearchKindNote) {
+		branches = append(branches, fmt.Sprintf(`
+			SELECT 'note' AS kind, n.id, n.title,
+			       ts_headline(q.lang, COALESCE(n.content, ''), q.query, $%d) AS snippet,
+			       ts_rank_cd(n.search_vector, q.query) AS rank, n.updated_at AS hit_date,
+			       NULL::int AS list_id, NULL::boolean AS is_completed
+			FROM q, notes n
+			WHERE n.user_id = $1 AND n.search_vector @@ q.query`, headline(&snippetOpts, 2)))
+	}
+	if wanted(types.SearchKindJournal) {
+		branches = append(branches, fmt.Sprintf(`
+			SELECT 'journal', j.id, j.title,
+			       ts_headline(q.lang, COALESCE(j.content, ''), q.query, $%d),
+			       ts_rank_cd(j.search_vector, q.query), j.entry_date::timestamptz,
+			       NULL::int, NULL::boolean
+			FROM q, journal_entries j
+			WHERE j.user_id = $1 AND j.search_vector @@ q.query`, headline(&snippetOpts, 2)))
+	}
+	if wanted(types.SearchKindTask) {
+		taskOpts := headline(&titleOpts, 0)
+		taskFilter := ""
+		if opts.Completed != nil {
+			args = append(args, *opts.Completed)
+			taskFilter = fmt.Sprintf(" AND ti.is_completed = $%d", len(args))
+		}
+		branches = append(branches, fmt.Sprintf(`
+			SELECT 'task', ti.id, ti.task,
+			       ts_headline(q.lang, ti.task, q.query, $%d),
+			       ts_rank_cd(ti.search_vector, q.query), COALESCE(ti.due_date::timestamptz, ti.created_at),
+			       ti.list_id, ti.is_completed
+			FROM q, todo_items ti JOIN todo_lists tl ON tl.id = ti.list_id
+			WHERE tl.user_id = $1 AND ti.search_vector @@ q.query%s`, taskOpts, taskFilter))
+	}
+	if len(branches) == 0 {
+		return &types.SearchResults{Results: []types.SearchResult{}, Limit: opts.Limit, Offset: opts.Offset}, nil
+	}
+
+	var where []string
+	if opts.From != nil {
+		args = append(args, *opts.From)
+		where = append(where, fmt.Sprintf("hit_date >= $%d", len(args)))
+	}
+	if opts.To != nil {
+		args = append(args, *opts.To)
+		where = append(where, fmt.Sprintf("hit_date < $%d", len(args)))
+	}
+	whereClause := ""
+	if len(where) > 0 {
+		whereClause = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	args = append(args, opts.Limit, opts.Offset)
+	query := fmt.Sprintf(`WITH q AS (SELECT %s AS query, u.search_language AS lang FROM users u WHERE u.id = $1),
+			   hits AS (%s)
+			   SELECT kind, id, title, snippet, rank, hit_date, list_id, is_completed, COUNT(*) OVER ()
+			   FROM hits %s
+			   ORDER BY rank DESC, hit_date DESC, kind, id
+			   LIMIT $%d OFFSET $%d`,
+		tsQuery, strings.Join(branches, "\n\t\t\tUNION ALL"), whereClause, len(args)-1, len(args))
+
+	rows, err := s.db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := &types.SearchResults{Results: make([]types.SearchResult, 0), Limit: opts.Limit, Offset: opts.Offset}
+	for rows.Next() {
+		var r types.SearchResult
+		if err := rows.Scan(&r.Kind, &r.ID, &r.Title, &r.Snippet, &r.Rank, &r.Date, &r.ListID, &r.IsCompleted,
+			&results.Total); err != nil {
+			return nil, err
+		}
+		r.Snippet = renderHighlight(r.Snippet)
+		r.Link = searchResultLink(r)
+		results.Results = append(results.Results, r)
+	}
+	return results, rows.Err()
+}
+
+// searchResultLink returns the web app path that opens a search result.
+func searchResultLink(r types.SearchResult) string {
+	switch r.Kind {
+	case types.SearchKindNote:
+		return fmt.Sprintf("/notes/%d", r.ID)
+	case types.SearchKindJournal:
+		return fmt.Sprintf("/journal/%d", r.ID)
+	case types.SearchKindTask:
+		if r.ListID != nil {
+			return fmt.Sprintf("/todo/%d", *r.ListID)
+		}
+	}
+	return ""
+}
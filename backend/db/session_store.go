@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"tempo-backend/types"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that was already rotated is presented again.
+// The session it belonged to has been revoked by the time this is returned.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+type SessionStore struct {
+	db *pgxpool.Pool
+}
+
+// NewSessionStore creates a new SessionStore.
+func NewSessionStore(db *pgxpool.Pool) *SessionStore {
+	return &SessionStore{db: db}
+}
+
+const sessionColumns = `id, user_id, device_name, COALESCE(ip_address, ''), COALESCE(user_agent, ''),
+			   created_at, last_used_at, expires_at, revoked_at`
+
+func scanSession(row rowScanner, session *types.Session) error {
+	return row.Scan(
+		&session.ID, &session.UserID, &session.DeviceName, &session.IPAddress, &session.UserAgent,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt,
+	)
+}
+
+// CreateSession starts a new session and stores the hash of its first refresh token.
+func (s *SessionStore) CreateSession(userID int, deviceName *string, ip, userAgent, refreshTokenHash string, expiresAt time.Time) (*types.Session, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO sessions (user_id, device_name, ip_address, user_agent, expires_at)
+			   VALUES ($1, $2, $3, $4, $5) RETURNING ` + sessionColumns
+	var session types.Session
+	if err := scanSession(tx.QueryRow(ctx, query, userID, deviceName, ip, userAgent, expiresAt), &session); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `INSERT INTO refresh_tokens (token_hash, session_id) VALUES ($1, $2)`,
+		refreshTokenHash, session.ID); err != nil {
+		return nil, err
+	}
+
+	return &session, tx.Commit(ctx)
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one, extending the session.
+// It returns ErrNotFound if the token is unknown or its session is revoked or expired,
+// and ErrRefreshTokenReused (after revoking the session) if the token was already used.
+func (s *SessionStore) RotateRefreshToken(oldHash, newHash, ip, userAgent string, expiresAt time.Time) (*types.Session, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var sessionID int
+	var usedAt *time.Time
+	err = tx.QueryRow(ctx, `SELECT session_id, used_at FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`,
+		oldHash).Scan(&sessionID, &usedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if usedAt != nil {
+		// Someone is replaying a rotated token, so either the client or an attacker
+		// holds a stale copy. Revoke the whole family rather than guess which.
+		if _, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+								   WHERE id = $1 AND revoked_at IS NULL`, sessionID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	query := `UPDATE sessions SET last_used_at = CURRENT_TIMESTAMP, ip_address = $2, user_agent = $3, expires_at = $4
+			   WHERE id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+			   RETURNING ` + sessionColumns
+	var session types.Session
+	if err := scanSession(tx.QueryRow(ctx, query, sessionID, ip, userAgent, expiresAt), &session); err != nil {
+		return nil, notFound(err)
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = $1`,
+		oldHash); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO refresh_tokens (token_hash, session_id) VALUES ($1, $2)`,
+		newHash, sessionID); err != nil {
+		return nil, err
+	}
+
+	return &session, tx.Commit(ctx)
+}
+
+// IsSessionActive reports whether a session exists for the user and is neither revoked nor expired.
+func (s *SessionStore) IsSessionActive(sessionID, userID int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM sessions WHERE id = $1 AND user_id = $2
+			   AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP)`
+	var active bool
+	err := s.db.QueryRow(context.Background(), query, sessionID, userID).Scan(&active)
+	return active, err
+}
+
+// GetActiveSessionsByUser retrieves every live session for a user, most recently used first.
+func (s *SessionStore) GetActiveSessionsByUser(userID int) ([]types.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions
+			   WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+			   ORDER BY last_used_at DESC`
+	rows, err := s.db.Query(context.Background(), query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]types.Session, 0)
+	for rows.Next() {
+		var session types.Session
+		if err := scanSession(rows, &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes a session, ensuring it belongs to the user.
+func (s *SessionStore) RevokeSession(sessionID, userID int) error {
+	query := `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+			   WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	cmd, err := s.db.Exec(context.Background(), query, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,367 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"tempo-backend/types"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrInvalidSyncCursor is returned for a sync cursor this server didn't issue.
+var ErrInvalidSyncCursor = errors.New("invalid sync cursor")
+
+// syncCursorPrefix versions the cursor format so it can change without
+// misreading cursors held by existing clients.
+const syncCursorPrefix = "v1:"
+
+type SyncStore struct {
+	db *pgxpool.Pool
+}
+
+func NewSyncStore(db *pgxpool.Pool) *SyncStore {
+	return &SyncStore{db: db}
+}
+
+// encodeSyncCursor wraps a change sequence number in an opaque cursor.
+func encodeSyncCursor(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(syncCursorPrefix + strconv.FormatInt(seq, 10)))
+}
+
+// decodeSyncCursor unwraps a cursor from encodeSyncCursor. The empty cursor
+// starts from the beginning.
+func decodeSyncCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), syncCursorPrefix) {
+		return 0, ErrInvalidSyncCursor
+	}
+	seq, err := strconv.ParseInt(strings.TrimPrefix(string(raw), syncCursorPrefix), 10, 64)
+	if err != nil || seq < 0 {
+		return 0, ErrInvalidSyncCursor
+	}
+	return seq, nil
+}
+
+// seqScanner scans a row whose record columns are followed by its change_seq.
+type seqScanner struct {
+	row rowScanner
+	seq *int64
+}
+
+func (s seqScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.seq)...)
+}
+
+// syncChange is one changed record waiting to be added to a page of changes.
+type syncChange struct {
+	seq   int64
+	add   func(*types.SyncChanges)
+	event types.ChangeEvent
+}
+
+// Change event operations. A record still at its first version was created
+// since the cursor; any other was updated.
+const (
+	changeCreated = "created"
+	changeUpdated = "updated"
+	changeDeleted = "deleted"
+)
+
+func recordChange(kind string, id, version int, record interface{}) types.ChangeEvent {
+	op := changeUpdated
+	if version == 1 {
+		op = changeCreated
+	}
+	return types.ChangeEvent{Kind: kind, Op: op, ID: id, Record: record}
+}
+
+// GetChanges returns up to limit of the user's changes made after cursor, oldest
+// first, with the cursor to pass next time.
+func (s *SyncStore) GetChanges(userID int, cursor string, limit int) (*types.SyncChanges, error) {
+	since, err := decodeSyncCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	changes, hasMore, err := s.changesSince(userID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &types.SyncChanges{
+		HasMore:        hasMore,
+		Lists:          make([]types.TodoList, 0),
+		Items:          make([]types.TodoItem, 0),
+		Notes:          make([]types.Note, 0),
+		JournalEntries: make([]types.JournalEntry, 0),
+		Deleted:        make([]types.SyncDeletion, 0),
+	}
+	for _, change := range changes {
+		change.add(result)
+		since = change.seq
+	}
+	result.Cursor = encodeSyncCursor(since)
+	return result, nil
+}
+
+// GetChangeEvents is GetChanges as a sequence of events, each carrying the
+// cursor that resumes after it.
+func (s *SyncStore) GetChangeEvents(userID int, cursor string, limit int) ([]types.ChangeEvent, bool, error) {
+	since, err := decodeSyncCursor(cursor)
+	if err != nil {
+		return nil, false, err
+	}
+	changes, hasMore, err := s.changesSince(userID, since, limit)
+	if err != nil {
+		return nil, false, err
+	}
+
+	events := make([]types.ChangeEvent, 0, len(changes))
+	for _, change := range changes {
+		change.event.Cursor = encodeSyncCursor(change.seq)
+		events = append(events, change.event)
+	}
+	return events, hasMore, nil
+}
+
+// GetLatestCursor returns a cursor positioned after the user's latest
+// committed change, for clients that only want changes from now on.
+func (s *SyncStore) GetLatestCursor(userID int) (string, error) {
+	query := `SELECT GREATEST(
+				  (SELECT MAX(change_seq) FROM todo_lists WHERE user_id = $1),
+				  (SELECT MAX(ti.change_seq) FROM todo_items ti JOIN todo_lists tl ON tl.id = ti.list_id WHERE tl.user_id = $1),
+				  (SELECT MAX(change_seq) FROM notes WHERE user_id = $1),
+				  (SELECT MAX(change_seq) FROM journal_entries WHERE user_id = $1),
+				  (SELECT MAX(change_seq) FROM sync_tombstones WHERE user_id = $1),
+				  0)`
+	var seq int64
+	if err := s.db.QueryRow(context.Background(), query, userID).Scan(&seq); err != nil {
+		return "", err
+	}
+	return encodeSyncCursor(seq), nil
+}
+
+// changesSince reads up to limit of the user's changes after since, oldest
+// first, and reports whether there are more.
+//
+// All sources are read from one snapshot, and the change sequence is drawn in
+// commit order per user (see sync_next_seq), so nothing committed at or before
+// the last change returned can turn up later.
+func (s *SyncStore) changesSince(userID int, since int64, limit int) ([]syncChange, bool, error) {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	sources := []struct {
+		query string
+		scan  func(rows pgx.Rows) (syncChange, error)
+	}{
+		{
+			`SELECT ` + todoListColumns + `, tl.change_seq FROM todo_lists tl
+			 WHERE tl.user_id = $1 AND tl.change_seq > $2 ORDER BY tl.change_seq LIMIT $3`,
+			func(rows pgx.Rows) (syncChange, error) {
+				var change syncChange
+				var list types.TodoList
+				if err := scanTodoList(seqScanner{rows, &change.seq}, &list); err != nil {
+					return change, err
+				}
+				change.add = func(c *types.SyncChanges) { c.Lists = append(c.Lists, list) }
+				change.event = recordChange(types.SyncKindList, list.ID, list.Version, list)
+				return change, nil
+			},
+		},
+		{
+			`SELECT ` + todoItemColumns + `, ti.change_seq FROM todo_items ti JOIN todo_lists tl ON tl.id = ti.list_id
+			 WHERE tl.user_id = $1 AND ti.change_seq > $2 ORDER BY ti.change_seq LIMIT $3`,
+			func(rows pgx.Rows) (syncChange, error) {
+				var change syncChange
+				var item types.TodoItem
+				if err := scanTodoItem(seqScanner{rows, &change.seq}, &item); err != nil {
+					return change, err
+				}
+				change.add = func(c *types.SyncChanges) { c.Items = append(c.Items, item) }
+				change.event = recordChange(types.SyncKindItem, item.ID, item.Version, item)
+				return change, nil
+			},
+		},
+		{
+			`SELECT ` + noteColumns + `, n.change_seq FROM notes n
+			 WHERE n.user_id = $1 AND n.change_seq > $2 ORDER BY n.change_seq LIMIT $3`,
+			func(rows pgx.Rows) (syncChange, error) {
+				var change syncChange
+				var note types.Note
+				if err := scanNote(seqScanner{rows, &change.seq}, &note); err != nil {
+					return change, err
+				}
+				change.add = func(c *types.SyncChanges) { c.Notes = append(c.Notes, note) }
+				change.event = recordChange(types.SyncKindNote, note.ID, note.Version, note)
+				return change, nil
+			},
+		},
+		{
+			`SELECT ` + journalEntryColumns + `, j.change_seq FROM journal_entries j
+			 WHERE j.user_id = $1 AND j.change_seq > $2 ORDER BY j.change_seq LIMIT $3`,
+			func(rows pgx.Rows) (syncChange, error) {
+				var change syncChange
+				var entry types.JournalEntry
+				if err := scanJournalEntry(seqScanner{rows, &change.seq}, &entry); err != nil {
+					return change, err
+				}
+				change.add = func(c *types.SyncChanges) { c.JournalEntries = append(c.JournalEntries, entry) }
+				change.event = recordChange(types.SyncKindJournal, entry.ID, entry.Version, entry)
+				return change, nil
+			},
+		},
+		{
+			`SELECT kind, record_id, change_seq FROM sync_tombstones
+			 WHERE user_id = $1 AND change_seq > $2 ORDER BY change_seq LIMIT $3`,
+			func(rows pgx.Rows) (syncChange, error) {
+				var change syncChange
+				var deletion types.SyncDeletion
+				if err := rows.Scan(&deletion.Kind, &deletion.ID, &change.seq); err != nil {
+					return change, err
+				}
+				change.add = func(c *types.SyncChanges) { c.Deleted = append(c.Deleted, deletion) }
+				change.event = types.ChangeEvent{Kind: deletion.Kind, Op: changeDeleted, ID: deletion.ID}
+				return change, nil
+			},
+		},
+	}
+
+	// Take up to limit+1 from every source, then keep the limit oldest overall.
+	var changes []syncChange
+	for _, source := range sources {
+		rows, err := tx.Query(ctx, source.query, userID, since, limit+1)
+		if err != nil {
+			return nil, false, err
+		}
+		for rows.Next() {
+			change, err := source.scan(rows)
+			if err != nil {
+				rows.Close()
+				return nil, false, err
+			}
+			changes = append(changes, change)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, false, err
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].seq < changes[j].seq })
+
+	if len(changes) > limit {
+		return changes[:limit], true, nil
+	}
+	return changes, false, nil
+}
+
+// changeChannel is the channel the sync triggers NOTIFY with the ID of the
+// user whose records changed.
+const changeChannel = "tempo_changes"
+
+// ListenForChanges holds a connection listening for change notifications,
+// calling ready once listening and notify with the user ID of each one, until
+// ctx is cancelled or the connection fails.
+func (s *SyncStore) ListenForChanges(ctx context.Context, ready func(), notify func(userID int)) error {
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+changeChannel); err != nil {
+		return err
+	}
+	// The connection goes back to the pool afterwards, so it mustn't keep listening.
+	defer conn.Exec(context.Background(), "UNLISTEN "+changeChannel)
+
+	ready()
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if userID, err := strconv.Atoi(n.Payload); err == nil {
+			notify(userID)
+		}
+	}
+}
+
+// GetAppliedMutation returns the kind and ID of the record a client mutation
+// was applied to, or ErrNotFound if it hasn't been applied.
+func (s *SyncStore) GetAppliedMutation(userID int, mutationID string) (string, int, error) {
+	var kind string
+	var recordID int
+	err := s.db.QueryRow(context.Background(),
+		`SELECT kind, record_id FROM sync_mutations WHERE user_id = $1 AND mutation_id = $2`, userID, mutationID,
+	).Scan(&kind, &recordID)
+	if err != nil {
+		return "", 0, notFound(err)
+	}
+	return kind, recordID, nil
+}
+
+// MutationStores are the stores a client mutation is applied through, all
+// working inside the transaction that records it.
+type MutationStores struct {
+	Todos   *TodoStore
+	Notes   *NoteStore
+	Journal *JournalStore
+}
+
+// AppliedMutation is the outcome of ApplyMutation.
+type AppliedMutation struct {
+	Kind     string
+	RecordID int
+	Version  int  // The record's version after the change; 0 for deletes and replays
+	Replayed bool // The mutation was applied by an earlier push and wasn't run again
+}
+
+// ApplyMutation applies a client mutation at most once. The mutation ID is
+// claimed in the transaction apply makes its change in, so the change and the
+// record of it commit together or not at all, and a retry pushed at the same
+// time waits for the first attempt to finish and then finds it applied. apply
+// returns the ID and version of the record it changed; if it fails, nothing
+// is recorded and the mutation can be retried.
+func (s *SyncStore) ApplyMutation(userID int, mutationID, kind string,
+	apply func(MutationStores) (int, int, error)) (*AppliedMutation, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	cmd, err := tx.Exec(ctx, `INSERT INTO sync_mutations (user_id, mutation_id, kind, record_id) VALUES ($1, $2, $3, 0)
+							  ON CONFLICT (user_id, mutation_id) DO NOTHING`, userID, mutationID, kind)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.RowsAffected() == 0 {
+		applied := AppliedMutation{Replayed: true}
+		err := tx.QueryRow(ctx, `SELECT kind, record_id FROM sync_mutations WHERE user_id = $1 AND mutation_id = $2`,
+			userID, mutationID).Scan(&applied.Kind, &applied.RecordID)
+		return &applied, err
+	}
+
+	recordID, version, err := apply(MutationStores{Todos: &TodoStore{db: tx}, Notes: &NoteStore{db: tx}, Journal: &JournalStore{db: tx}})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE sync_mutations SET record_id = $3 WHERE user_id = $1 AND mutation_id = $2`,
+		userID, mutationID, recordID); err != nil {
+		return nil, err
+	}
+	return &AppliedMutation{Kind: kind, RecordID: recordID, Version: version}, tx.Commit(ctx)
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"tempo-backend/types"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrDuplicateTag is returned when creating or renaming a tag to a name the user already has.
+var ErrDuplicateTag = errors.New("a tag with that name already exists")
+
+const maxTagLength = 64
+
+type TagStore struct {
+	db *pgxpool.Pool
+}
+
+func NewTagStore(db *pgxpool.Pool) *TagStore {
+	return &TagStore{db: db}
+}
+
+const tagColumns = `t.id, t.user_id, t.name, (SELECT COUNT(*) FROM note_tags nt WHERE nt.tag_id = t.id),
+			   (SELECT COUNT(*) FROM todo_item_tags it WHERE it.tag_id = t.id), t.created_at`
+
+func scanTag(row rowScanner, tag *types.Tag) error {
+	return row.Scan(&tag.ID, &tag.UserID, &tag.Name, &tag.NoteCount, &tag.ItemCount, &tag.CreatedAt)
+}
+
+// normalizeTagNames trims tag names and drops case-insensitive duplicates, keeping the first spelling.
+func normalizeTagNames(names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || utf8.RuneCountInString(name) > maxTagLength {
+			return nil, ErrInvalidTag
+		}
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, name)
+	}
+	return normalized, nil
+}
+
+// CreateTag creates a tag for the user.
+func (s *TagStore) CreateTag(name string, userID int) (*types.Tag, error) {
+	names, err := normalizeTagNames([]string{name})
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO tags AS t (user_id, name) VALUES ($1, $2) RETURNING ` + tagColumns
+	var tag types.Tag
+	if err := scanTag(s.db.QueryRow(context.Background(), query, userID, names[0]), &tag); err != nil {
+		return nil, duplicateTag(err)
+	}
+	return &tag, nil
+}
+
+// GetTagsByUser retrieves all of the user's tags in alphabetical order.
+func (s *TagStore) GetTagsByUser(userID int) ([]types.Tag, error) {
+	query := `SELECT ` + tagColumns + ` FROM tags t WHERE t.user_id = $1 ORDER BY lower(t.name)`
+	rows, err := s.db.Query(context.Background(), query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]types.Tag, 0)
+	for rows.Next() {
+		var tag types.Tag
+		if err := scanTag(rows, &tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// RenameTag renames one of the user's tags.
+func (s *TagStore) RenameTag(tagID, userID int, name string) (*types.Tag, error) {
+	names, err := normalizeTagNames([]string{name})
+	if err != nil {
+		return nil, err
+	}
+
+	// Tagged notes and items are bumped to a new version since their tag list reads differently.
+	query := `WITH bumped AS (
+				  UPDATE notes SET version = version + 1
+				  WHERE id IN (SELECT nt.note_id FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+							   WHERE t.id = $1 AND t.user_id = $2)
+			  ), bumped_items AS (
+				  UPDATE todo_items SET version = version + 1
+				  WHERE id IN (SELECT it.item_id FROM todo_item_tags it JOIN tags t ON t.id = it.tag_id
+							   WHERE t.id = $1 AND t.user_id = $2)
+			  )
+			  UPDATE tags t SET name = $3 WHERE t.id = $1 AND t.user_id = $2 RETURNING ` + tagColumns
+	var tag types.Tag
+	if err := scanTag(s.db.QueryRow(context.Background(), query, tagID, userID, names[0]), &tag); err != nil {
+		return nil, duplicateTag(notFound(err))
+	}
+	return &tag, nil
+}
+
+// DeleteTag deletes one of the user's tags, removing it from every note and item.
+func (s *TagStore) DeleteTag(tagID, userID int) error {
+	query := `WITH bumped AS (
+				  UPDATE notes SET version = version + 1
+				  WHERE id IN (SELECT nt.note_id FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+							   WHERE t.id = $1 AND t.user_id = $2)
+			  ), bumped_items AS (
+				  UPDATE todo_items SET version = version + 1
+				  WHERE id IN (SELECT it.item_id FROM todo_item_tags it JOIN tags t ON t.id = it.tag_id
+							   WHERE t.id = $1 AND t.user_id = $2)
+			  )
+			  DELETE FROM tags WHERE id = $1 AND user_id = $2`
+	cmd, err := s.db.Exec(context.Background(), query, tagID, userID)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// duplicateTag maps a unique violation on the tag name index onto ErrDuplicateTag.
+func duplicateTag(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrDuplicateTag
+	}
+	return err
+}
+
+// createTags creates any of the named tags the user doesn't have yet.
+func createTags(ctx context.Context, tx pgx.Tx, userID int, names []string) error {
+	_, err := tx.Exec(ctx, `INSERT INTO tags (user_id, name) SELECT $1, unnest($2::text[])
+							ON CONFLICT (user_id, lower(name)) DO NOTHING`, userID, names)
+	return err
+}
@@ -2,15 +2,19 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"tempo-backend/rank"
+	"tempo-backend/recurrence"
 	"tempo-backend/types"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type TodoStore struct {
-	db *pgxpool.Pool
+	db dbtx
 }
 
 func NewTodoStore(db *pgxpool.Pool) *TodoStore {
@@ -19,98 +23,441 @@ func NewTodoStore(db *pgxpool.Pool) *TodoStore {
 
 // --- ToDo List Methods ---
 
-// CreateTodoList creates a new to-do list for a specific user.
+const todoListColumns = `tl.id, tl.user_id, tl.title, tl.color, tl.icon, tl.is_archived, tl.position, tl.version, tl.created_at`
+
+func scanTodoList(row rowScanner, list *types.TodoList) error {
+	return row.Scan(&list.ID, &list.UserID, &list.Title, &list.Color, &list.Icon, &list.IsArchived, &list.Position, &list.Version, &list.CreatedAt)
+}
+
+// CreateTodoList creates a new to-do list for a specific user, above their other lists.
 func (s *TodoStore) CreateTodoList(payload types.CreateTodoListPayload, userID int) (*types.TodoList, error) {
-	query := `INSERT INTO todo_lists (title, user_id) VALUES ($1, $2)
-			   RETURNING id, user_id, title, created_at`
+	query := `INSERT INTO todo_lists AS tl (title, user_id, color, icon, position)
+			   VALUES ($1, $2, $3, $4, (SELECT COALESCE(MIN(position), 0) - 1 FROM todo_lists WHERE user_id = $2))
+			   RETURNING ` + todoListColumns
 	var list types.TodoList
-	err := s.db.QueryRow(context.Background(), query, payload.Title, userID).Scan(
-		&list.ID, &list.UserID, &list.Title, &list.CreatedAt,
-	)
+	err := scanTodoList(s.db.QueryRow(context.Background(), query, payload.Title, userID, payload.Color, payload.Icon), &list)
 	return &list, err
 }
 
-// GetTodoListsByUser retrieves all to-do lists for a given user.
-func (s *TodoStore) GetTodoListsByUser(userID int) ([]types.TodoList, error) {
-	query := `SELECT id, user_id, title, created_at FROM todo_lists WHERE user_id = $1 ORDER BY created_at DESC`
-	rows, err := s.db.Query(context.Background(), query, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// todoListListing sorts lists in the user's order, newest first, or by title.
+var todoListListing = listing{
+	orders: map[string]sortOrder{
+		"manual": {
+			{"tl.position", "int", false},
+			{"COALESCE(tl.created_at, '-infinity')", "timestamptz", true},
+			{"tl.id", "int", true},
+		},
+		"created": {{"COALESCE(tl.created_at, '-infinity')", "timestamptz", true}, {"tl.id", "int", true}},
+		"title":   {{"lower(tl.title)", "text", false}, {"tl.id", "int", false}},
+	},
+	defaultOrder: "manual",
+}
 
-	lists := make([]types.TodoList, 0)
-	for rows.Next() {
-		var list types.TodoList
-		if err := rows.Scan(&list.ID, &list.UserID, &list.Title, &list.CreatedAt); err != nil {
-			return nil, err
-		}
-		lists = append(lists, list)
+// GetTodoListsByUser returns a page of the user's to-do lists, with the cursor
+// of the next page. If archived is set, only lists that are (or aren't)
+// archived are returned.
+func (s *TodoStore) GetTodoListsByUser(userID int, archived *bool, opts PageOptions) ([]types.TodoList, string, error) {
+	where := []string{"tl.user_id = $1"}
+	args := []interface{}{userID}
+	if archived != nil {
+		args = append(args, *archived)
+		where = append(where, fmt.Sprintf("tl.is_archived = $%d", len(args)))
 	}
-	return lists, nil
+	return queryPage(context.Background(), s.db, todoListListing, opts, todoListColumns, "todo_lists tl", where, args, scanTodoList)
 }
 
 // GetTodoListByID retrieves a single to-do list, ensuring it belongs to the correct user.
 func (s *TodoStore) GetTodoListByID(listID, userID int) (*types.TodoList, error) {
-	query := `SELECT id, user_id, title, created_at FROM todo_lists WHERE id = $1 AND user_id = $2`
+	query := `SELECT ` + todoListColumns + ` FROM todo_lists tl WHERE tl.id = $1 AND tl.user_id = $2`
 	var list types.TodoList
-	err := s.db.QueryRow(context.Background(), query, listID, userID).Scan(
-		&list.ID, &list.UserID, &list.Title, &list.CreatedAt,
-	)
-	return &list, err
+	if err := scanTodoList(s.db.QueryRow(context.Background(), query, listID, userID), &list); err != nil {
+		return nil, notFound(err)
+	}
+	return &list, nil
 }
 
-// DeleteTodoList deletes a list, ensuring it belongs to the correct user.
-func (s *TodoStore) DeleteTodoList(listID, userID int) error {
-	query := `DELETE FROM todo_lists WHERE id = $1 AND user_id = $2`
-	cmd, err := s.db.Exec(context.Background(), query, listID, userID)
+// DeleteTodoList deletes a list, ensuring it belongs to the correct user. If
+// expectedVersion is set and the list has moved on since, nothing is deleted
+// and ErrVersionConflict is returned.
+func (s *TodoStore) DeleteTodoList(listID, userID int, expectedVersion *int) error {
+	query := `DELETE FROM todo_lists WHERE id = $1 AND user_id = $2 AND ($3::int IS NULL OR version = $3)`
+	cmd, err := s.db.Exec(context.Background(), query, listID, userID, expectedVersion)
 	if err != nil {
 		return err
 	}
 	if cmd.RowsAffected() == 0 {
-		return fmt.Errorf("list not found or user not authorized")
+		if expectedVersion == nil {
+			return ErrNotFound
+		}
+		if _, err := s.GetTodoListByID(listID, userID); err != nil {
+			return err
+		}
+		return ErrVersionConflict
 	}
 	return nil
 }
 
+// UpdateTodoList applies a partial update to a list the user owns. An empty
+// color or icon clears it. If expectedVersion is set and the list has moved
+// past it, ErrVersionConflict is returned and nothing is changed.
+func (s *TodoStore) UpdateTodoList(listID, userID int, payload types.UpdateTodoListPayload, expectedVersion *int) (*types.TodoList, error) {
+	var setParts []string
+	var args []interface{}
+	argID := 1
+
+	if payload.Title != nil {
+		setParts = append(setParts, fmt.Sprintf("title = $%d", argID))
+		args = append(args, *payload.Title)
+		argID++
+	}
+	if payload.Color != nil {
+		setParts = append(setParts, fmt.Sprintf("color = NULLIF($%d, '')", argID))
+		args = append(args, *payload.Color)
+		argID++
+	}
+	if payload.Icon != nil {
+		setParts = append(setParts, fmt.Sprintf("icon = NULLIF($%d, '')", argID))
+		args = append(args, *payload.Icon)
+		argID++
+	}
+	if payload.IsArchived != nil {
+		setParts = append(setParts, fmt.Sprintf("is_archived = $%d", argID))
+		args = append(args, *payload.IsArchived)
+		argID++
+	}
+	if payload.Position != nil {
+		setParts = append(setParts, fmt.Sprintf("position = $%d", argID))
+		args = append(args, *payload.Position)
+		argID++
+	}
+	if len(setParts) == 0 {
+		list, err := s.GetTodoListByID(listID, userID)
+		if err == nil && expectedVersion != nil && list.Version != *expectedVersion {
+			return nil, ErrVersionConflict
+		}
+		return list, err
+	}
+	setParts = append(setParts, "version = version + 1")
+
+	args = append(args, listID, userID, expectedVersion)
+	query := fmt.Sprintf(`UPDATE todo_lists tl SET %s WHERE tl.id = $%d AND tl.user_id = $%d AND ($%d::int IS NULL OR tl.version = $%d)
+						   RETURNING `+todoListColumns,
+		strings.Join(setParts, ", "), argID, argID+1, argID+2, argID+2)
+
+	var list types.TodoList
+	err := scanTodoList(s.db.QueryRow(context.Background(), query, args...), &list)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := s.GetTodoListByID(listID, userID); err != nil {
+			return nil, err
+		}
+		return nil, ErrVersionConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ReorderTodoLists rewrites the positions of all the user's lists in one
+// transaction: the lists named come first, in that order, followed by the
+// rest in their current order. Only lists whose position changes get a new
+// version. It returns all the user's lists in their new order.
+func (s *TodoStore) ReorderTodoLists(userID int, listIDs []int) ([]types.TodoList, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT id FROM todo_lists WHERE user_id = $1
+								ORDER BY position, created_at DESC, id DESC FOR UPDATE`, userID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[int]bool, len(current))
+	for _, id := range current {
+		owned[id] = true
+	}
+	placed := make(map[int]bool, len(listIDs))
+	order := make([]int, 0, len(current))
+	for _, id := range listIDs {
+		if !owned[id] || placed[id] {
+			return nil, ErrInvalidListOrder
+		}
+		placed[id] = true
+		order = append(order, id)
+	}
+	for _, id := range current {
+		if !placed[id] {
+			order = append(order, id)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE todo_lists tl SET position = o.n - 1, version = version + 1
+						   FROM unnest($1::int[]) WITH ORDINALITY AS o(id, n)
+						   WHERE tl.id = o.id AND tl.user_id = $2 AND tl.position <> o.n - 1`, order, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	lists, _, err := s.GetTodoListsByUser(userID, nil, PageOptions{})
+	return lists, err
+}
+
 // --- ToDo Item Methods ---
+//
+// Items don't carry a user_id of their own, so every item query joins through
+// todo_lists to scope it to the list owner. An item that exists but belongs to
+// someone else is reported as ErrNotFound, exactly like one that doesn't exist.
 
-// CreateTodoItem adds a new task to a specific to-do list.
-func (s *TodoStore) CreateTodoItem(payload types.CreateTodoItemPayload, listID int) (*types.TodoItem, error) {
-	query := `INSERT INTO todo_items (list_id, task, due_date) VALUES ($1, $2, $3)
-			   RETURNING id, list_id, task, is_completed, due_date, priority, created_at`
-	var item types.TodoItem
-	err := s.db.QueryRow(context.Background(), query, listID, payload.Task, payload.DueDate).Scan(
-		&item.ID, &item.ListID, &item.Task, &item.IsCompleted, &item.DueDate, &item.Priority, &item.CreatedAt,
+// MaxSubtaskDepth is how many levels deep items may nest, counting top-level items as depth 1.
+const MaxSubtaskDepth = 3
+
+// todoItemColumns selects an item aliased as ti, including its tag names in alphabetical order.
+const todoItemColumns = `ti.id, ti.list_id, ti.parent_id, ti.task, ti.description, ti.is_completed, ti.completed_at, ti.due_date,
+			   to_char(ti.due_time, 'HH24:MI'), ti.priority, ti.rank,
+			   ARRAY(SELECT t.name FROM todo_item_tags it JOIN tags t ON t.id = it.tag_id
+			         WHERE it.item_id = ti.id ORDER BY lower(t.name)),
+			   ti.created_at, ti.recurrence_rule, ti.recurrence_start, ti.recurrence_index, ti.version`
+
+func scanTodoItem(row rowScanner, item *types.TodoItem) error {
+	return row.Scan(
+		&item.ID, &item.ListID, &item.ParentID, &item.Task, &item.Description, &item.IsCompleted, &item.CompletedAt, &item.DueDate,
+		&item.DueTime, &item.Priority, &item.Rank, &item.Tags, &item.CreatedAt, &item.RecurrenceRule, &item.RecurrenceStart,
+		&item.RecurrenceIndex, &item.Version,
 	)
-	return &item, err
 }
 
-// GetTodoItemsByListID retrieves all items for a given to-do list.
-func (s *TodoStore) GetTodoItemsByListID(listID int) ([]types.TodoItem, error) {
-	query := `SELECT id, list_id, task, is_completed, due_date, priority, created_at FROM todo_items
-			   WHERE list_id = $1 ORDER BY created_at ASC`
-	rows, err := s.db.Query(context.Background(), query, listID)
+// todoItemListing sorts a list's items. Ties fall back to the manual order.
+var todoItemListing = listing{
+	orders: map[string]sortOrder{
+		types.ItemSortManual: {{"ti.rank", "text", false}, {"ti.id", "int", false}},
+		// Dated items first, soonest first; on each day, items without a time come first.
+		types.ItemSortDue: {
+			{"COALESCE(ti.due_date, 'infinity')", "date", false},
+			{"(ti.due_time IS NOT NULL)", "boolean", false},
+			{"COALESCE(ti.due_time, '00:00')", "time", false},
+			{"ti.rank", "text", false},
+			{"ti.id", "int", false},
+		},
+		types.ItemSortPriority: {
+			{"COALESCE(ti.priority, 0)", "int", true},
+			{"COALESCE(ti.due_date, 'infinity')", "date", false},
+			{"ti.rank", "text", false},
+			{"ti.id", "int", false},
+		},
+		types.ItemSortCreated: {{"COALESCE(ti.created_at, '-infinity')", "timestamptz", false}, {"ti.id", "int", false}},
+	},
+	defaultOrder: types.ItemSortManual,
+}
+
+// nextItemRank returns a rank after every item in the list, for an item added at its end.
+// If that rank would be too long, the list's ranks are spread out again first.
+func nextItemRank(ctx context.Context, tx pgx.Tx, listID int) (string, error) {
+	next := func() (string, error) {
+		var last string
+		if err := tx.QueryRow(ctx, `SELECT COALESCE(MAX(rank), '') FROM todo_items WHERE list_id = $1`, listID).Scan(&last); err != nil {
+			return "", err
+		}
+		return rank.Between(last, "")
+	}
+	itemRank, err := next()
+	if err != nil || len(itemRank) <= rank.MaxLength {
+		return itemRank, err
+	}
+	if err := spreadItemRanks(ctx, tx, listID); err != nil {
+		return "", err
+	}
+	return next()
+}
+
+// CreateTodoItem adds a new task to the end of a specific to-do list owned by the user.
+// If the payload names a parent, the item is created as a subtask of it.
+func (s *TodoStore) CreateTodoItem(payload types.CreateTodoItemPayload, listID, userID int) (*types.TodoItem, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if payload.ParentID != nil {
+		if err := checkSubtaskParent(ctx, tx, *payload.ParentID, listID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if payload.RecurrenceRule != nil && payload.DueDate == nil {
+		return nil, ErrRecurrenceNeedsDueDate
+	}
+
+	itemRank, err := nextItemRank(ctx, tx, listID)
 	if err != nil {
 		return nil, err
 	}
+
+	query := `INSERT INTO todo_items AS ti (list_id, parent_id, task, due_date, due_time, recurrence_rule, recurrence_start, search_language,
+				   description, priority, rank)
+			   SELECT tl.id, $3::int, $4::text, $5::date, $7::text::time, $6::text, CASE WHEN $6::text IS NOT NULL THEN $5::date END,
+					  u.search_language, NULLIF($8::text, ''), $9::int, $10::text
+			   FROM todo_lists tl JOIN users u ON u.id = tl.user_id WHERE tl.id = $1 AND tl.user_id = $2
+			   RETURNING ` + todoItemColumns
+	var item types.TodoItem
+	err = scanTodoItem(tx.QueryRow(ctx, query, listID, userID, payload.ParentID, payload.Task, payload.DueDate,
+		payload.RecurrenceRule, payload.DueTime, payload.Description, payload.Priority, itemRank), &item)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if len(payload.Tags) > 0 {
+		if err := setItemTags(ctx, tx, item.ID, userID, payload.Tags); err != nil {
+			return nil, err
+		}
+		tagged, err := getTodoItem(ctx, tx, item.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		item = *tagged
+	}
+	return &item, tx.Commit(ctx)
+}
+
+// setItemTags replaces an item's tags with the given names, creating any tags
+// the user doesn't have yet. Names are matched case-insensitively.
+func setItemTags(ctx context.Context, tx pgx.Tx, itemID, userID int, names []string) error {
+	names, err := normalizeTagNames(names)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM todo_item_tags WHERE item_id = $1`, itemID); err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	if err := createTags(ctx, tx, userID, names); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO todo_item_tags (item_id, tag_id)
+						   SELECT $1, id FROM tags WHERE user_id = $2 AND lower(name) IN (SELECT lower(unnest($3::text[])))`,
+		itemID, userID, names)
+	return err
+}
+
+// checkSubtaskParent verifies that parentID is an item in listID owned by the user
+// and that a child of it would not exceed MaxSubtaskDepth.
+func checkSubtaskParent(ctx context.Context, q querier, parentID, listID, userID int) error {
+	query := `WITH RECURSIVE ancestors AS (
+				  SELECT ti.id, ti.parent_id, ti.list_id, 1 AS depth
+				  FROM todo_items ti JOIN todo_lists tl ON tl.id = ti.list_id
+				  WHERE ti.id = $1 AND tl.user_id = $2
+				  UNION ALL
+				  SELECT p.id, p.parent_id, p.list_id, a.depth + 1
+				  FROM todo_items p JOIN ancestors a ON p.id = a.parent_id
+			   )
+			   SELECT (SELECT list_id FROM ancestors WHERE id = $1), MAX(depth) FROM ancestors`
+	var parentListID *int
+	var parentDepth *int
+	if err := q.QueryRow(ctx, query, parentID, userID).Scan(&parentListID, &parentDepth); err != nil {
+		return err
+	}
+	if parentListID == nil || *parentListID != listID {
+		return ErrInvalidParent
+	}
+	if *parentDepth+1 > MaxSubtaskDepth {
+		return ErrMaxDepthExceeded
+	}
+	return nil
+}
+
+// GetTodoItemsByListID returns a page of the items of a to-do list owned by
+// the user, with the cursor of the next page. Pages are counted in top-level
+// items, and each comes with all of its subtasks, flat alongside it in the
+// same order; use ParentID to rebuild the tree.
+func (s *TodoStore) GetTodoItemsByListID(listID, userID int, opts PageOptions) ([]types.TodoItem, string, error) {
+	ctx := context.Background()
+	roots, next, err := queryPage(ctx, s.db, todoItemListing, opts, "ti.id",
+		"todo_items ti JOIN todo_lists tl ON tl.id = ti.list_id",
+		[]string{"ti.list_id = $1", "tl.user_id = $2", "ti.parent_id IS NULL"}, []interface{}{listID, userID},
+		func(row rowScanner, id *int) error { return row.Scan(id) })
+	if err != nil {
+		return nil, "", err
+	}
+	if len(roots) == 0 {
+		return make([]types.TodoItem, 0), next, nil
+	}
+
+	_, order, _ := todoItemListing.order(opts.Sort)
+	query := `WITH RECURSIVE page AS (
+				  SELECT id FROM todo_items WHERE id = ANY($1)
+				  UNION ALL
+				  SELECT c.id FROM todo_items c JOIN page p ON c.parent_id = p.id
+			  )
+			  SELECT ` + todoItemColumns + ` FROM todo_items ti WHERE ti.id IN (SELECT id FROM page)
+			  ORDER BY ` + order.orderBy()
+	rows, err := s.db.Query(ctx, query, roots)
+	if err != nil {
+		return nil, "", err
+	}
 	defer rows.Close()
 
 	items := make([]types.TodoItem, 0)
 	for rows.Next() {
 		var item types.TodoItem
-		if err := rows.Scan(
-			&item.ID, &item.ListID, &item.Task, &item.IsCompleted, &item.DueDate, &item.Priority, &item.CreatedAt,
-		); err != nil {
-			return nil, err
+		if err := scanTodoItem(rows, &item); err != nil {
+			return nil, "", err
 		}
 		items = append(items, item)
 	}
-	return items, nil
+	return items, next, rows.Err()
+}
+
+// GetTodoItemByID retrieves a single item, ensuring its list belongs to the user.
+func (s *TodoStore) GetTodoItemByID(itemID, userID int) (*types.TodoItem, error) {
+	return getTodoItem(context.Background(), s.db, itemID, userID)
+}
+
+func getTodoItem(ctx context.Context, q querier, itemID, userID int) (*types.TodoItem, error) {
+	query := `SELECT ` + todoItemColumns + `
+			   FROM todo_items ti JOIN todo_lists tl ON tl.id = ti.list_id
+			   WHERE ti.id = $1 AND tl.user_id = $2`
+	var item types.TodoItem
+	if err := scanTodoItem(q.QueryRow(ctx, query, itemID, userID), &item); err != nil {
+		return nil, notFound(err)
+	}
+	return &item, nil
 }
 
-// UpdateTodoItem updates a specific todo item.
-func (s *TodoStore) UpdateTodoItem(itemID int, payload types.UpdateTodoItemPayload) (*types.TodoItem, error) {
+// lockTodoItem is getTodoItem with a row lock, for read-modify-write inside a transaction.
+func lockTodoItem(ctx context.Context, tx pgx.Tx, itemID, userID int) (*types.TodoItem, error) {
+	query := `SELECT ` + todoItemColumns + `
+			   FROM todo_items ti JOIN todo_lists tl ON tl.id = ti.list_id
+			   WHERE ti.id = $1 AND tl.user_id = $2 FOR UPDATE OF ti`
+	var item types.TodoItem
+	if err := scanTodoItem(tx.QueryRow(ctx, query, itemID, userID), &item); err != nil {
+		return nil, notFound(err)
+	}
+	return &item, nil
+}
+
+// UpdateTodoItem updates a specific todo item, ensuring its list belongs to the user.
+// When the item is completed with CompleteSubtasks set, all of its descendants are completed too.
+// Completing a recurring item records the occurrence and rolls the item forward to the next one.
+// Moving the item to another list takes its subtasks along; ErrListNotFound is returned if the
+// user doesn't own that list.
+// If expectedVersion is set and the item has moved on since, nothing is changed and
+// ErrVersionConflict is returned.
+func (s *TodoStore) UpdateTodoItem(itemID, userID int, payload types.UpdateTodoItemPayload, expectedVersion *int) (*types.TodoItem, error) {
 	// Dynamically build the SET part of the query
 	var setParts []string
 	var args []interface{}
@@ -126,31 +473,456 @@ func (s *TodoStore) UpdateTodoItem(itemID int, payload types.UpdateTodoItemPaylo
 		args = append(args, *payload.IsCompleted)
 		argID++
 	}
-	if len(setParts) == 0 {
-		return nil, fmt.Errorf("no update fields provided")
+	if payload.CompletedAt != nil {
+		if payload.IsCompleted == nil {
+			setParts = append(setParts, "is_completed = TRUE")
+		}
+		setParts = append(setParts, fmt.Sprintf("completed_at = $%d", argID))
+		args = append(args, *payload.CompletedAt)
+		argID++
+	}
+	if payload.Description != nil {
+		setParts = append(setParts, fmt.Sprintf("description = NULLIF($%d::text, '')", argID))
+		args = append(args, *payload.Description)
+		argID++
+	}
+	if payload.Priority != nil {
+		setParts = append(setParts, fmt.Sprintf("priority = $%d", argID))
+		args = append(args, *payload.Priority)
+		argID++
+	}
+	// The series of a recurring item starts from its due date, the new one if it changes.
+	seriesStart := "ti.due_date"
+	clearsDueDate := payload.DueDate.Set && payload.DueDate.Value == nil
+	if payload.DueDate.Set {
+		setParts = append(setParts, fmt.Sprintf("due_date = $%d::date", argID))
+		args = append(args, payload.DueDate.Value)
+		seriesStart = fmt.Sprintf("$%d::date", argID)
+		argID++
+		if clearsDueDate {
+			setParts = append(setParts, "due_time = NULL")
+		}
+	}
+	if payload.DueTime != nil && !clearsDueDate {
+		setParts = append(setParts, fmt.Sprintf("due_time = NULLIF($%d::text, '')::time", argID))
+		args = append(args, *payload.DueTime)
+		argID++
+	}
+	if payload.RecurrenceRule != nil {
+		// A new rule starts a new series from the due date.
+		setParts = append(setParts, fmt.Sprintf("recurrence_rule = $%d", argID),
+			"recurrence_start = "+seriesStart, "recurrence_index = 1")
+		args = append(args, *payload.RecurrenceRule)
+		argID++
+	} else if payload.DueDate.Set {
+		// So does moving a recurring item to another date.
+		moved := "ti.recurrence_rule IS NOT NULL AND ti.due_date IS DISTINCT FROM " + seriesStart
+		setParts = append(setParts,
+			"recurrence_start = CASE WHEN "+moved+" THEN "+seriesStart+" ELSE ti.recurrence_start END",
+			"recurrence_index = CASE WHEN "+moved+" THEN 1 ELSE ti.recurrence_index END")
+	}
+	if payload.ListID != nil {
+		// Subtasks can't have a parent in another list, so one moved on its own comes loose.
+		setParts = append(setParts, fmt.Sprintf("list_id = $%d", argID),
+			fmt.Sprintf("parent_id = CASE WHEN ti.list_id = $%d THEN ti.parent_id END", argID))
+		args = append(args, *payload.ListID)
+		argID++
+	}
+	if len(setParts) == 0 && payload.Tags == nil {
+		// No update, just return the item
+		item, err := s.GetTodoItemByID(itemID, userID)
+		if err == nil && expectedVersion != nil && item.Version != *expectedVersion {
+			return nil, ErrVersionConflict
+		}
+		return item, err
 	}
+	setParts = append(setParts, "version = ti.version + 1")
 
-	args = append(args, itemID)
-	query := fmt.Sprintf(`UPDATE todo_items SET %s WHERE id = $%d
-						   RETURNING id, list_id, task, is_completed, due_date, priority, created_at`,
-		strings.Join(setParts, ", "), argID)
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	before, err := lockTodoItem(ctx, tx, itemID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion != nil && before.Version != *expectedVersion {
+		return nil, ErrVersionConflict
+	}
+	moving := payload.ListID != nil && *payload.ListID != before.ListID
+	if moving {
+		var listID int
+		err := tx.QueryRow(ctx, `SELECT id FROM todo_lists WHERE id = $1 AND user_id = $2 FOR SHARE`,
+			*payload.ListID, userID).Scan(&listID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrListNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	args = append(args, itemID, userID)
+	query := fmt.Sprintf(`UPDATE todo_items ti SET %s FROM todo_lists tl
+						   WHERE ti.id = $%d AND tl.id = ti.list_id AND tl.user_id = $%d
+						   RETURNING `+todoItemColumns,
+		strings.Join(setParts, ", "), argID, argID+1)
 
 	var item types.TodoItem
-	err := s.db.QueryRow(context.Background(), query, args...).Scan(
-		&item.ID, &item.ListID, &item.Task, &item.IsCompleted, &item.DueDate, &item.Priority, &item.CreatedAt,
-	)
-	return &item, err
+	if err := scanTodoItem(tx.QueryRow(ctx, query, args...), &item); err != nil {
+		return nil, notFound(err)
+	}
+
+	if payload.Tags != nil {
+		if err := setItemTags(ctx, tx, item.ID, userID, *payload.Tags); err != nil {
+			return nil, err
+		}
+		tagged, err := getTodoItem(ctx, tx, item.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		item = *tagged
+	}
+
+	if moving {
+		subtasks := `WITH RECURSIVE descendants AS (
+						 SELECT id FROM todo_items WHERE parent_id = $1
+						 UNION ALL
+						 SELECT c.id FROM todo_items c JOIN descendants d ON c.parent_id = d.id
+					 )
+					 UPDATE todo_items SET list_id = $2, version = version + 1
+					 WHERE id IN (SELECT id FROM descendants)`
+		if _, err := tx.Exec(ctx, subtasks, item.ID, item.ListID); err != nil {
+			return nil, err
+		}
+		if err := rankSubtreeLast(ctx, tx, item.ID, item.ListID); err != nil {
+			return nil, err
+		}
+		moved, err := getTodoItem(ctx, tx, item.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		item = *moved
+	}
+
+	if item.IsCompleted && payload.CompleteSubtasks {
+		descendants := `WITH RECURSIVE descendants AS (
+							SELECT id FROM todo_items WHERE parent_id = $1
+							UNION ALL
+							SELECT c.id FROM todo_items c JOIN descendants d ON c.parent_id = d.id
+						)
+						UPDATE todo_items SET is_completed = TRUE, version = version + 1
+						WHERE id IN (SELECT id FROM descendants) AND is_completed = FALSE`
+		if _, err := tx.Exec(ctx, descendants, item.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if item.RecurrenceRule != nil && item.DueDate == nil {
+		return nil, ErrRecurrenceNeedsDueDate
+	}
+	if payload.DueDate.Set || payload.DueTime != nil {
+		if err := rescheduleReminders(ctx, tx, item.ID); err != nil {
+			return nil, err
+		}
+	}
+	if item.RecurrenceRule != nil && item.IsCompleted && !before.IsCompleted {
+		next, err := advanceRecurrence(ctx, tx, &item, "completed")
+		if err != nil {
+			return nil, err
+		}
+		item = *next
+	}
+
+	return &item, tx.Commit(ctx)
+}
+
+// rankSubtreeLast gives an item that has just moved to another list, and its
+// subtasks, ranks after every other item there, keeping their own order.
+func rankSubtreeLast(ctx context.Context, tx pgx.Tx, itemID, listID int) error {
+	query := `WITH RECURSIVE subtree AS (
+				  SELECT id FROM todo_items WHERE id = $1
+				  UNION ALL
+				  SELECT c.id FROM todo_items c JOIN subtree s ON c.parent_id = s.id
+			  )
+			  SELECT ti.id FROM todo_items ti JOIN subtree s ON s.id = ti.id ORDER BY ti.rank, ti.id`
+	rows, err := tx.Query(ctx, query, itemID)
+	if err != nil {
+		return err
+	}
+	moved, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+
+	var last string
+	err = tx.QueryRow(ctx, `SELECT COALESCE(MAX(rank), '') FROM todo_items WHERE list_id = $1 AND NOT (id = ANY($2))`,
+		listID, moved).Scan(&last)
+	if err != nil {
+		return err
+	}
+	ranks := make([]string, len(moved))
+	for i := range moved {
+		if last, err = rank.Between(last, ""); err != nil {
+			return err
+		}
+		ranks[i] = last
+	}
+	_, err = tx.Exec(ctx, `UPDATE todo_items ti SET rank = r.rank FROM unnest($1::int[], $2::text[]) AS r(id, rank)
+						   WHERE ti.id = r.id`, moved, ranks)
+	if err != nil || len(last) <= rank.MaxLength {
+		return err
+	}
+	return spreadItemRanks(ctx, tx, listID)
+}
+
+// MoveTodoItem moves an item within its list, right before or after another item
+// there, or to the end. Only the moved item gets a new rank, unless ranks in the
+// list have grown too long and all of them are spread out again.
+// It returns ErrNotFound if the item isn't in the user's list, and
+// ErrInvalidMoveTarget if the item to move next to isn't another item there.
+func (s *TodoStore) MoveTodoItem(listID, userID int, payload types.MoveTodoItemPayload, expectedVersion *int) (*types.TodoItem, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Moves within a list are taken one at a time, so two can't pick the same rank.
+	var locked int
+	err = tx.QueryRow(ctx, `SELECT id FROM todo_lists WHERE id = $1 AND user_id = $2 FOR NO KEY UPDATE`, listID, userID).Scan(&locked)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	item, err := lockTodoItem(ctx, tx, payload.ItemID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if item.ListID != listID {
+		return nil, ErrNotFound
+	}
+	if expectedVersion != nil && item.Version != *expectedVersion {
+		return nil, ErrVersionConflict
+	}
+
+	// Find the ranks of the items the moved one goes between.
+	var lower, upper string
+	neighbour := func(query string, args ...interface{}) (string, error) {
+		var r string
+		err := tx.QueryRow(ctx, query, args...).Scan(&r)
+		return r, err
+	}
+	anchorRank := func(id int) (string, error) {
+		if id == item.ID {
+			return "", ErrInvalidMoveTarget
+		}
+		r, err := neighbour(`SELECT rank FROM todo_items WHERE id = $1 AND list_id = $2`, id, listID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrInvalidMoveTarget
+		}
+		return r, err
+	}
+	switch {
+	case payload.AfterID != nil:
+		if lower, err = anchorRank(*payload.AfterID); err != nil {
+			return nil, err
+		}
+		upper, err = neighbour(`SELECT COALESCE(MIN(rank), '') FROM todo_items WHERE list_id = $1 AND rank > $2 AND id <> $3`,
+			listID, lower, item.ID)
+	case payload.BeforeID != nil:
+		if upper, err = anchorRank(*payload.BeforeID); err != nil {
+			return nil, err
+		}
+		lower, err = neighbour(`SELECT COALESCE(MAX(rank), '') FROM todo_items WHERE list_id = $1 AND rank < $2 AND id <> $3`,
+			listID, upper, item.ID)
+	default:
+		lower, err = neighbour(`SELECT COALESCE(MAX(rank), '') FROM todo_items WHERE list_id = $1 AND id <> $2`, listID, item.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	newRank, err := rank.Between(lower, upper)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE todo_items SET rank = $2, version = version + 1 WHERE id = $1`, item.ID, newRank); err != nil {
+		return nil, err
+	}
+	if len(newRank) > rank.MaxLength {
+		if err := spreadItemRanks(ctx, tx, listID); err != nil {
+			return nil, err
+		}
+	}
+	if item, err = getTodoItem(ctx, tx, item.ID, userID); err != nil {
+		return nil, err
+	}
+	return item, tx.Commit(ctx)
+}
+
+// spreadItemRanks gives every item in a list a new, short rank, keeping their order.
+func spreadItemRanks(ctx context.Context, tx pgx.Tx, listID int) error {
+	rows, err := tx.Query(ctx, `SELECT id FROM todo_items WHERE list_id = $1 ORDER BY rank, id`, listID)
+	if err != nil {
+		return err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE todo_items ti SET rank = r.rank, version = ti.version + 1
+						   FROM unnest($1::int[], $2::text[]) AS r(id, rank)
+						   WHERE ti.id = r.id AND ti.rank <> r.rank`, ids, rank.Spread(len(ids)))
+	return err
+}
+
+// SkipTodoItemOccurrence skips the current occurrence of a recurring item without completing it.
+// Skipping the last occurrence of a finite series completes the item.
+func (s *TodoStore) SkipTodoItemOccurrence(itemID, userID int) (*types.TodoItem, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	item, err := lockTodoItem(ctx, tx, itemID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if item.RecurrenceRule == nil || item.DueDate == nil {
+		return nil, ErrNotRecurring
+	}
+
+	item, err = advanceRecurrence(ctx, tx, item, "skipped")
+	if err != nil {
+		return nil, err
+	}
+	return item, tx.Commit(ctx)
 }
 
-// DeleteTodoItem deletes a specific todo item.
-func (s *TodoStore) DeleteTodoItem(itemID int) error {
-	query := `DELETE FROM todo_items WHERE id = $1`
-	cmd, err := s.db.Exec(context.Background(), query, itemID)
+// EndTodoItemRecurrence removes the recurrence rule, leaving the current occurrence as a one-off item.
+func (s *TodoStore) EndTodoItemRecurrence(itemID, userID int) (*types.TodoItem, error) {
+	query := `UPDATE todo_items ti SET recurrence_rule = NULL, recurrence_start = NULL, recurrence_index = 1,
+			       version = ti.version + 1
+			   FROM todo_lists tl
+			   WHERE ti.id = $1 AND tl.id = ti.list_id AND tl.user_id = $2 AND ti.recurrence_rule IS NOT NULL
+			   RETURNING ` + todoItemColumns
+	var item types.TodoItem
+	if err := scanTodoItem(s.db.QueryRow(context.Background(), query, itemID, userID), &item); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Tell "not yours" apart from "not recurring" only for the owner.
+			if _, err := s.GetTodoItemByID(itemID, userID); err != nil {
+				return nil, err
+			}
+			return nil, ErrNotRecurring
+		}
+		return nil, err
+	}
+	return &item, nil
+}
+
+// GetTodoItemOccurrences retrieves the completion history of a recurring item, newest first.
+func (s *TodoStore) GetTodoItemOccurrences(itemID, userID int) ([]types.TodoItemOccurrence, error) {
+	query := `SELECT o.id, o.item_id, o.occurrence_index, o.due_date, o.status, o.recorded_at
+			   FROM todo_item_occurrences o
+			   JOIN todo_items ti ON ti.id = o.item_id JOIN todo_lists tl ON tl.id = ti.list_id
+			   WHERE o.item_id = $1 AND tl.user_id = $2 ORDER BY o.occurrence_index DESC, o.id DESC`
+	rows, err := s.db.Query(context.Background(), query, itemID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	occurrences := make([]types.TodoItemOccurrence, 0)
+	for rows.Next() {
+		var o types.TodoItemOccurrence
+		if err := rows.Scan(&o.ID, &o.ItemID, &o.OccurrenceIndex, &o.DueDate, &o.Status, &o.RecordedAt); err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, o)
+	}
+	return occurrences, rows.Err()
+}
+
+// advanceRecurrence records the item's current occurrence with the given status and moves
+// the item on to the next one, reopening it and its subtasks. If the series is exhausted
+// the item is left completed.
+func advanceRecurrence(ctx context.Context, tx pgx.Tx, item *types.TodoItem, status string) (*types.TodoItem, error) {
+	rule, err := recurrence.Parse(*item.RecurrenceRule)
+	if err != nil {
+		return nil, fmt.Errorf("stored recurrence rule for item %d: %w", item.ID, err)
+	}
+
+	if _, err := tx.Exec(ctx, `INSERT INTO todo_item_occurrences (item_id, occurrence_index, due_date, status)
+							   VALUES ($1, $2, $3, $4)`, item.ID, item.RecurrenceIndex, item.DueDate, status); err != nil {
+		return nil, err
+	}
+
+	start := *item.DueDate
+	if item.RecurrenceStart != nil {
+		start = *item.RecurrenceStart
+	}
+	next, ok := rule.Next(start, *item.DueDate, item.RecurrenceIndex)
+
+	var query string
+	var args []interface{}
+	if ok {
+		query = `UPDATE todo_items ti SET due_date = $2, is_completed = FALSE, recurrence_index = recurrence_index + 1,
+				  version = version + 1
+				  WHERE ti.id = $1 RETURNING ` + todoItemColumns
+		args = []interface{}{item.ID, next}
+	} else {
+		query = `UPDATE todo_items ti SET is_completed = TRUE, version = version + 1 WHERE ti.id = $1 RETURNING ` + todoItemColumns
+		args = []interface{}{item.ID}
+	}
+	var updated types.TodoItem
+	if err := scanTodoItem(tx.QueryRow(ctx, query, args...), &updated); err != nil {
+		return nil, err
+	}
+
+	if ok {
+		reopen := `WITH RECURSIVE descendants AS (
+					   SELECT id FROM todo_items WHERE parent_id = $1
+					   UNION ALL
+					   SELECT c.id FROM todo_items c JOIN descendants d ON c.parent_id = d.id
+				   )
+				   UPDATE todo_items SET is_completed = FALSE, version = version + 1
+				   WHERE id IN (SELECT id FROM descendants) AND is_completed = TRUE`
+		if _, err := tx.Exec(ctx, reopen, item.ID); err != nil {
+			return nil, err
+		}
+		// Offset reminders fire again for the next occurrence.
+		if err := rescheduleReminders(ctx, tx, item.ID); err != nil {
+			return nil, err
+		}
+	}
+	return &updated, nil
+}
+
+// DeleteTodoItem deletes a specific todo item, ensuring its list belongs to the user.
+// Subtasks are removed with it by the parent_id foreign key. If expectedVersion
+// is set and the item has moved on since, nothing is deleted and
+// ErrVersionConflict is returned.
+func (s *TodoStore) DeleteTodoItem(itemID, userID int, expectedVersion *int) error {
+	query := `DELETE FROM todo_items ti USING todo_lists tl
+			   WHERE ti.id = $1 AND tl.id = ti.list_id AND tl.user_id = $2 AND ($3::int IS NULL OR ti.version = $3)`
+	cmd, err := s.db.Exec(context.Background(), query, itemID, userID, expectedVersion)
 	if err != nil {
 		return err
 	}
 	if cmd.RowsAffected() == 0 {
-		return fmt.Errorf("item not found")
+		if expectedVersion == nil {
+			return ErrNotFound
+		}
+		if _, err := s.GetTodoItemByID(itemID, userID); err != nil {
+			return err
+		}
+		return ErrVersionConflict
 	}
 	return nil
 }
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"tempo-backend/types"
+	"time"
+)
+
+// TaskFilter narrows GetTasks. Zero values mean "don't filter".
+type TaskFilter struct {
+	// View is one of the types.TaskView names. Views leave out completed
+	// items unless Completed says otherwise.
+	View       string
+	DueAfter   *time.Time // Earliest due date, inclusive
+	DueBefore  *time.Time // Latest due date, inclusive
+	Overdue    bool
+	Completed  *bool
+	Priorities []int
+	Tag        string
+	ListIDs    []int
+}
+
+// The user's current date and time of day, for the items' due dates and
+// times, which have no timezone of their own. The query joins users as u.
+const (
+	userToday = "(now() AT TIME ZONE u.timezone)::date"
+	userNow   = "(now() AT TIME ZONE u.timezone)::time"
+)
+
+// overdueCondition matches open items past their due date, or past their due
+// time today.
+const overdueCondition = "NOT ti.is_completed AND (ti.due_date < " + userToday +
+	" OR (ti.due_date = " + userToday + " AND ti.due_time < " + userNow + "))"
+
+// taskListing sorts tasks across lists, by default soonest due first with the
+// most important first on each day and time.
+var taskListing = listing{
+	orders: map[string]sortOrder{
+		types.ItemSortDue: {
+			{"COALESCE(ti.due_date, 'infinity')", "date", false},
+			{"(ti.due_time IS NOT NULL)", "boolean", false},
+			{"COALESCE(ti.due_time, '00:00')", "time", false},
+			{"COALESCE(ti.priority, 0)", "int", true},
+			{"ti.id", "int", false},
+		},
+		types.ItemSortPriority: {
+			{"COALESCE(ti.priority, 0)", "int", true},
+			{"COALESCE(ti.due_date, 'infinity')", "date", false},
+			{"ti.id", "int", false},
+		},
+		types.ItemSortCreated: {{"COALESCE(ti.created_at, '-infinity')", "timestamptz", false}, {"ti.id", "int", false}},
+	},
+	defaultOrder: types.ItemSortDue,
+}
+
+// GetTasks returns a page of the items in all of the user's lists that match
+// the filter, with the cursor of the next page. Subtasks are matched on their
+// own, like any other item.
+func (s *TodoStore) GetTasks(userID int, filter TaskFilter, opts PageOptions) ([]types.Task, string, error) {
+	where := []string{"tl.user_id = $1"}
+	args := []interface{}{userID}
+
+	switch filter.View {
+	case types.TaskViewToday:
+		where = append(where, "ti.due_date = "+userToday)
+	case types.TaskViewUpcoming:
+		where = append(where, "ti.due_date BETWEEN "+userToday+" AND "+userToday+" + 6")
+	case types.TaskViewOverdue:
+		filter.Overdue = true
+	case types.TaskViewNoDate:
+		where = append(where, "ti.due_date IS NULL")
+	}
+	if filter.View != "" && filter.Completed == nil {
+		open := false
+		filter.Completed = &open
+	}
+
+	if filter.DueAfter != nil {
+		args = append(args, *filter.DueAfter)
+		where = append(where, fmt.Sprintf("ti.due_date >= $%d", len(args)))
+	}
+	if filter.DueBefore != nil {
+		args = append(args, *filter.DueBefore)
+		where = append(where, fmt.Sprintf("ti.due_date <= $%d", len(args)))
+	}
+	if filter.Overdue {
+		where = append(where, overdueCondition)
+	}
+	if filter.Completed != nil {
+		args = append(args, *filter.Completed)
+		where = append(where, fmt.Sprintf("ti.is_completed = $%d", len(args)))
+	}
+	if len(filter.Priorities) > 0 {
+		args = append(args, filter.Priorities)
+		where = append(where, fmt.Sprintf("COALESCE(ti.priority, 0) = ANY($%d)", len(args)))
+	}
+	if filter.Tag != "" {
+		args = append(args, filter.Tag)
+		where = append(where, fmt.Sprintf(`EXISTS (SELECT 1 FROM todo_item_tags it JOIN tags t ON t.id = it.tag_id
+			   WHERE it.item_id = ti.id AND lower(t.name) = lower($%d))`, len(args)))
+	}
+	if len(filter.ListIDs) > 0 {
+		args = append(args, filter.ListIDs)
+		where = append(where, fmt.Sprintf("ti.list_id = ANY($%d)", len(args)))
+	}
+
+	return queryPage(context.Background(), s.db, taskListing, opts, todoItemColumns+", tl.title",
+		"todo_items ti JOIN todo_lists tl ON tl.id = ti.list_id JOIN users u ON u.id = tl.user_id", where, args,
+		func(row rowScanner, task *types.Task) error {
+			return scanTodoItem(titleScanner{row, &task.ListTitle}, &task.TodoItem)
+		})
+}
@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"tempo-backend/totp"
+	"tempo-backend/types"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrTwoFactorEnabled is returned when setting up two-factor authentication for a user who already has it.
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+
+// ErrTwoFactorNotEnabled is returned by two-factor actions for a user who hasn't set it up.
+var ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+
+// ErrInvalidTwoFactorCode is returned for a wrong, reused or expired authenticator or recovery code.
+var ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+
+// maxLoginChallengeAttempts is how many codes may be tried against one login challenge.
+const maxLoginChallengeAttempts = 5
+
+// SecondFactor is what the user offered as their second factor: an
+// authenticator code, or the hash of a recovery code.
+type SecondFactor struct {
+	Code         string
+	RecoveryHash string
+}
+
+// GetTwoFactorStatus reports whether the user has two-factor authentication
+// enabled and how many unused recovery codes they have left.
+func (s *UserStore) GetTwoFactorStatus(userID int) (*types.TwoFactorStatus, error) {
+	var status types.TwoFactorStatus
+	err := s.db.QueryRow(context.Background(),
+		`SELECT u.totp_enabled_at IS NOT NULL,
+				(SELECT COUNT(*) FROM recovery_codes rc WHERE rc.user_id = u.id AND rc.used_at IS NULL)
+		 FROM users u WHERE u.id = $1`, userID).Scan(&status.Enabled, &status.RecoveryCodesRemaining)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &status, nil
+}
+
+// StartTwoFactorSetup stores a new secret for the user to enroll in their
+// authenticator. It takes effect once confirmed with EnableTwoFactor.
+func (s *UserStore) StartTwoFactorSetup(userID int, secret string) error {
+	cmd, err := s.db.Exec(context.Background(),
+		`UPDATE users SET totp_secret = $2 WHERE id = $1 AND totp_enabled_at IS NULL`, userID, secret)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+// EnableTwoFactor turns on two-factor authentication once the user proves
+// their authenticator produces codes for the secret from StartTwoFactorSetup,
+// and gives them the recovery codes with the given hashes.
+func (s *UserStore) EnableTwoFactor(userID int, code string, recoveryHashes []string) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var secret *string
+	var enabled bool
+	var lastCounter int64
+	err = tx.QueryRow(ctx, `SELECT totp_secret, totp_enabled_at IS NOT NULL, totp_last_counter FROM users WHERE id = $1 FOR UPDATE`,
+		userID).Scan(&secret, &enabled, &lastCounter)
+	if err != nil {
+		return notFound(err)
+	}
+	if enabled {
+		return ErrTwoFactorEnabled
+	}
+	if secret == nil {
+		return ErrTwoFactorNotEnabled
+	}
+	counter, ok := totp.Validate(*secret, code, time.Now(), lastCounter)
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE users SET totp_enabled_at = CURRENT_TIMESTAMP, totp_last_counter = $2 WHERE id = $1`,
+		userID, counter); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// DisableTwoFactor turns off two-factor authentication, given a valid second factor.
+func (s *UserStore) DisableTwoFactor(userID int, factor SecondFactor) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkSecondFactor(ctx, tx, userID, factor); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL WHERE id = $1`, userID); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes with the ones
+// with the given hashes, given a valid second factor.
+func (s *UserStore) RegenerateRecoveryCodes(userID int, factor SecondFactor, recoveryHashes []string) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkSecondFactor(ctx, tx, userID, factor); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, q querier, userID int, hashes []string) error {
+	if _, err := q.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	_, err := q.Exec(ctx, `INSERT INTO recovery_codes (user_id, code_hash) SELECT $1, unnest($2::text[])`, userID, hashes)
+	return err
+}
+
+// checkSecondFactor accepts an authenticator code newer than the last one
+// accepted, or an unused recovery code, using it up. It locks the user's row
+// until the transaction ends, so concurrent attempts can't both use a code.
+func checkSecondFactor(ctx context.Context, tx pgx.Tx, userID int, factor SecondFactor) error {
+	var secret *string
+	var enabled bool
+	var lastCounter int64
+	err := tx.QueryRow(ctx, `SELECT totp_secret, totp_enabled_at IS NOT NULL, totp_last_counter FROM users WHERE id = $1 FOR UPDATE`,
+		userID).Scan(&secret, &enabled, &lastCounter)
+	if err != nil {
+		return notFound(err)
+	}
+	if !enabled || secret == nil {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if factor.RecoveryHash != "" {
+		cmd, err := tx.Exec(ctx, `UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+								  WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, factor.RecoveryHash)
+		if err != nil {
+			return err
+		}
+		if cmd.RowsAffected() == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	counter, ok := totp.Validate(*secret, factor.Code, time.Now(), lastCounter)
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+	_, err = tx.Exec(ctx, `UPDATE users SET totp_last_counter = $2 WHERE id = $1`, userID, counter)
+	return err
+}
+
+// CreateLoginChallenge stores the hash of a challenge token for a user who has
+// passed the password step of a two-factor login.
+func (s *UserStore) CreateLoginChallenge(userID int, deviceName *string, tokenHash string, ttl time.Duration) error {
+	ctx := context.Background()
+	// Expired challenges are cleared out as new ones are made.
+	if _, err := s.db.Exec(ctx, `DELETE FROM login_challenges WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(ctx, `INSERT INTO login_challenges (token_hash, user_id, device_name, expires_at)
+							  VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(secs => $4))`,
+		tokenHash, userID, deviceName, ttl.Seconds())
+	return err
+}
+
+// CompleteLoginChallenge checks the second factor for a login challenge and,
+// if it is good, uses up the challenge and returns who is logging in and from
+// what device. A challenge allows a few wrong codes before it is discarded.
+func (s *UserStore) CompleteLoginChallenge(tokenHash string, factor SecondFactor) (int, *string, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID, attempts int
+	var deviceName *string
+	err = tx.QueryRow(ctx, `SELECT user_id, device_name, attempts FROM login_challenges
+							WHERE token_hash = $1 AND expires_at > CURRENT_TIMESTAMP FOR UPDATE`,
+		tokenHash).Scan(&userID, &deviceName, &attempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil, ErrInvalidToken
+		}
+		return 0, nil, err
+	}
+
+	err = checkSecondFactor(ctx, tx, userID, factor)
+	if errors.Is(err, ErrInvalidTwoFactorCode) {
+		// Undo anything the check did, but keep count of the failure.
+		if err := tx.Rollback(ctx); err != nil {
+			return 0, nil, err
+		}
+		query := `UPDATE login_challenges SET attempts = attempts + 1 WHERE token_hash = $1`
+		if attempts+1 >= maxLoginChallengeAttempts {
+			query = `DELETE FROM login_challenges WHERE token_hash = $1`
+		}
+		if _, err := s.db.Exec(ctx, query, tokenHash); err != nil {
+			return 0, nil, err
+		}
+		return 0, nil, ErrInvalidTwoFactorCode
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM login_challenges WHERE token_hash = $1`, tokenHash); err != nil {
+		return 0, nil, err
+	}
+	return userID, deviceName, tx.Commit(ctx)
+}
@@ -31,13 +31,14 @@ func (s *UserStore) CreateUser(user types.RegisterUserPayload) (*types.User, err
 
 	// Insert the new user into the database
 	query := `INSERT INTO users (username, email, password_hash) VALUES ($1, $2, $3)
-			   RETURNING id, username, email, created_at`
+			   RETURNING id, username, email, email_verified_at, created_at`
 
 	var newUser types.User
 	err = s.db.QueryRow(context.Background(), query, user.Username, user.Email, string(hashedPassword)).Scan(
 		&newUser.ID,
 		&newUser.Username,
 		&newUser.Email,
+		&newUser.EmailVerifiedAt,
 		&newUser.CreatedAt,
 	)
 	if err != nil {
@@ -49,13 +50,16 @@ func (s *UserStore) CreateUser(user types.RegisterUserPayload) (*types.User, err
 
 // GetUserByEmail retrieves a user by their email address.
 func (s *UserStore) GetUserByEmail(email string) (*types.User, error) {
-	query := `SELECT id, username, email, password_hash, created_at FROM users WHERE email = $1`
+	query := `SELECT id, username, email, COALESCE(password_hash, ''), email_verified_at, totp_enabled_at IS NOT NULL, created_at
+			   FROM users WHERE email = $1`
 	var user types.User
 	err := s.db.QueryRow(context.Background(), query, email).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.PasswordHash,
+		&user.EmailVerifiedAt,
+		&user.TwoFactorEnabled,
 		&user.CreatedAt,
 	)
 	if err != nil {
@@ -64,3 +68,129 @@ func (s *UserStore) GetUserByEmail(email string) (*types.User, error) {
 	}
 	return &user, nil
 }
+
+// GetUserByID retrieves a user by ID.
+func (s *UserStore) GetUserByID(userID int) (*types.User, error) {
+	query := `SELECT id, username, email, COALESCE(password_hash, ''), email_verified_at, totp_enabled_at IS NOT NULL, created_at
+			   FROM users WHERE id = $1`
+	var user types.User
+	err := s.db.QueryRow(context.Background(), query, userID).Scan(
+		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.EmailVerifiedAt, &user.TwoFactorEnabled, &user.CreatedAt,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+// GetSearchLanguage returns the text search configuration used for the user's notes.
+func (s *UserStore) GetSearchLanguage(userID int) (string, error) {
+	var language string
+	err := s.db.QueryRow(context.Background(), `SELECT search_language::text FROM users WHERE id = $1`, userID).Scan(&language)
+	if err != nil {
+		return "", notFound(err)
+	}
+	return language, nil
+}
+
+// SetSearchLanguage changes the user's text search configuration and re-indexes
+// all of their notes, journal entries and to-do items in it.
+func (s *UserStore) SetSearchLanguage(userID int, language string) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = $1)`, language).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownSearchLanguage
+	}
+
+	cmd, err := tx.Exec(ctx, `UPDATE users SET search_language = $2::regconfig WHERE id = $1`, userID, language)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	// Rewriting the language regenerates each row's search_vector.
+	reindex := []string{
+		`UPDATE notes SET search_language = $2::regconfig
+		 WHERE user_id = $1 AND search_language <> $2::regconfig`,
+		`UPDATE journal_entries SET search_language = $2::regconfig
+		 WHERE user_id = $1 AND search_language <> $2::regconfig`,
+		`UPDATE todo_items ti SET search_language = $2::regconfig FROM todo_lists tl
+		 WHERE tl.id = ti.list_id AND tl.user_id = $1 AND ti.search_language <> $2::regconfig`,
+	}
+	for _, query := range reindex {
+		if _, err := tx.Exec(ctx, query, userID, language); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// GetRevisionRetention returns how much note history is kept for the user.
+func (s *UserStore) GetRevisionRetention(userID int) (*types.RevisionRetentionPayload, error) {
+	var retention types.RevisionRetentionPayload
+	err := s.db.QueryRow(context.Background(),
+		`SELECT note_revision_limit, note_revision_max_age_days FROM users WHERE id = $1`, userID,
+	).Scan(&retention.MaxRevisions, &retention.MaxAgeDays)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &retention, nil
+}
+
+// SetRevisionRetention changes how much note history is kept for the user and
+// prunes existing revisions to match.
+func (s *UserStore) SetRevisionRetention(userID int, retention types.RevisionRetentionPayload) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	cmd, err := tx.Exec(ctx, `UPDATE users SET note_revision_limit = $2, note_revision_max_age_days = $3 WHERE id = $1`,
+		userID, retention.MaxRevisions, retention.MaxAgeDays)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if err := pruneNoteRevisions(ctx, tx, userID, 0); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// GetReminderWebhook returns the URL webhook reminders are posted to, if any.
+func (s *UserStore) GetReminderWebhook(userID int) (*types.ReminderWebhook, error) {
+	var webhook types.ReminderWebhook
+	err := s.db.QueryRow(context.Background(), `SELECT reminder_webhook_url FROM users WHERE id = $1`, userID).Scan(&webhook.URL)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &webhook, nil
+}
+
+// SetReminderWebhook sets the URL webhook reminders are posted to and the
+// secret they are signed with. A nil URL removes the webhook.
+func (s *UserStore) SetReminderWebhook(userID int, url *string, secret *string) error {
+	cmd, err := s.db.Exec(context.Background(),
+		`UPDATE users SET reminder_webhook_url = $2, reminder_webhook_secret = $3 WHERE id = $1`, userID, url, secret)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
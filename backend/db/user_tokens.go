@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Purposes of the single-use tokens emailed to users.
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+	TokenPurposeChangeEmail   = "change_email"
+)
+
+// ErrInvalidToken is returned for an emailed token that doesn't exist, has
+// expired, or has already been used.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// ErrTokenRateLimited is returned when too many tokens of a kind have been
+// issued to a user recently.
+var ErrTokenRateLimited = errors.New("too many requests")
+
+// CreateUserToken stores the hash of a new token for the user, good for ttl
+// and tied to the email address it is sent to. It refuses with
+// ErrTokenRateLimited once maxPerWindow tokens for the same purpose have been
+// issued within window.
+func (s *UserStore) CreateUserToken(userID int, purpose, email, tokenHash string, ttl time.Duration, maxPerWindow int, window time.Duration) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the user serializes concurrent requests, so the count below holds.
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return err
+	}
+	var recent int
+	err = tx.QueryRow(ctx, `SELECT COUNT(*) FROM user_tokens
+							 WHERE user_id = $1 AND purpose = $2 AND created_at > CURRENT_TIMESTAMP - make_interval(secs => $3)`,
+		userID, purpose, window.Seconds()).Scan(&recent)
+	if err != nil {
+		return err
+	}
+	if recent >= maxPerWindow {
+		return ErrTokenRateLimited
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO user_tokens (token_hash, user_id, purpose, email, expires_at)
+						   VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + make_interval(secs => $5))`,
+		tokenHash, userID, purpose, email, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// consumeUserToken marks a token used and returns the user and email address
+// it was issued for.
+func consumeUserToken(ctx context.Context, q querier, tokenHash, purpose string) (int, string, error) {
+	var userID int
+	var email string
+	err := q.QueryRow(ctx, `UPDATE user_tokens SET used_at = CURRENT_TIMESTAMP
+							WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+							RETURNING user_id, email`, tokenHash, purpose).Scan(&userID, &email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, "", ErrInvalidToken
+		}
+		return 0, "", err
+	}
+	return userID, email, nil
+}
+
+// VerifyEmail uses a verification token, marking the address it was sent to
+// as verified if it is still the user's.
+func (s *UserStore) VerifyEmail(tokenHash string) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	userID, email, err := consumeUserToken(ctx, tx, tokenHash, TokenPurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+	cmd, err := tx.Exec(ctx, `UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+							  WHERE id = $1 AND email = $2`, userID, email)
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		// The user has changed their address since; the token is spent either way.
+		return ErrInvalidToken
+	}
+	return tx.Commit(ctx)
+}
+
+// ResetPassword uses a password reset token to set a new password. Every other
+// outstanding reset token is spent and every session revoked, so whoever knew
+// the old password is logged out. Following the link also proves the user
+// reads the address, so it counts as verified.
+func (s *UserStore) ResetPassword(tokenHash, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	userID, email, err := consumeUserToken(ctx, tx, tokenHash, TokenPurposeResetPassword)
+	if err != nil {
+		return err
+	}
+	cmd, err := tx.Exec(ctx, `UPDATE users SET password_hash = $3,
+								  email_verified_at = CASE WHEN email = $2 THEN COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+														   ELSE email_verified_at END
+							  WHERE id = $1`, userID, email, string(hashedPassword))
+	if err != nil {
+		return err
+	}
+	if cmd.RowsAffected() == 0 {
+		return ErrInvalidToken
+	}
+
+	cleanup := []string{
+		`UPDATE user_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND purpose = 'reset_password' AND used_at IS NULL`,
+		`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL`,
+	}
+	for _, query := range cleanup {
+		if _, err := tx.Exec(ctx, query, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,193 @@
+// Package diff computes line and word diffs between two texts using Myers'
+// O(ND) algorithm.
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+)
+
+// maxEdits bounds the work spent looking for a minimal diff. Texts that differ
+// by more edits than this are reported as one deletion followed by one insertion
+// of everything between their common prefix and suffix.
+const maxEdits = 1000
+
+// Change is a run of consecutive tokens with the same operation. Joining the
+// Text of every Equal and Delete change gives the old text back; Equal and
+// Insert gives the new one.
+type Change struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// Lines diffs a and b line by line. Each line keeps its trailing newline.
+func Lines(a, b string) []Change {
+	return diffTokens(splitLines(a), splitLines(b))
+}
+
+// Words diffs a and b word by word. Runs of whitespace are tokens of their
+// own, so changes in spacing show up too.
+func Words(a, b string) []Change {
+	return diffTokens(splitWords(a), splitWords(b))
+}
+
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func splitWords(s string) []string {
+	var tokens []string
+	start, prevSpace := 0, false
+	for i, r := range s {
+		space := unicode.IsSpace(r)
+		if i > start && space != prevSpace {
+			tokens = append(tokens, s[start:i])
+			start = i
+		}
+		prevSpace = space
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+// diffTokens returns an edit script turning a into b, with consecutive tokens
+// of the same operation merged.
+func diffTokens(a, b []string) []Change {
+	var changes []Change
+	emit := func(op Op, text string) {
+		if last := len(changes) - 1; last >= 0 && changes[last].Op == op {
+			changes[last].Text += text
+			return
+		}
+		changes = append(changes, Change{Op: op, Text: text})
+	}
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		emit(Equal, a[prefix])
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for _, c := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		emit(c.Op, c.Text)
+	}
+	for _, token := range a[len(a)-suffix:] {
+		emit(Equal, token)
+	}
+	if changes == nil {
+		changes = []Change{}
+	}
+	return changes
+}
+
+// myers returns the shortest edit script between a and b, one change per
+// token, or a wholesale replacement if that needs more than maxEdits edits.
+func myers(a, b []string) []Change {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit > maxEdits {
+		limit = maxEdits
+	}
+
+	// trace[d] holds the furthest x reached on each diagonal k in -d..d after
+	// d edits, indexed by k+d.
+	var trace [][]int
+	found := false
+	for d := 0; d <= limit && !found; d++ {
+		v := make([]int, 2*d+1)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			switch {
+			case d == 0:
+				x = 0
+			case k == -d || (k != d && trace[d-1][k-1+d-1] < trace[d-1][k+1+d-1]):
+				x = trace[d-1][k+1+d-1] // Down: insertion
+			default:
+				x = trace[d-1][k-1+d-1] + 1 // Right: deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+d] = x
+			if x >= n && y >= m {
+				found = true
+			}
+		}
+		trace = append(trace, v)
+	}
+	if !found {
+		changes := make([]Change, 0, n+m)
+		for _, token := range a {
+			changes = append(changes, Change{Op: Delete, Text: token})
+		}
+		for _, token := range b {
+			changes = append(changes, Change{Op: Insert, Text: token})
+		}
+		return changes
+	}
+
+	// Walk back from the end, collecting the script in reverse.
+	var reversed []Change
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		k := x - y
+		prev := trace[d-1]
+		var prevK int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+
+		// The edit moves from (prevX, prevY) to (midX, midY); a run of equal
+		// tokens then leads on to (x, y).
+		midX, midY := prevX, prevY+1
+		if prevK == k-1 {
+			midX, midY = prevX+1, prevY
+		}
+		for x > midX && y > midY {
+			x--
+			y--
+			reversed = append(reversed, Change{Op: Equal, Text: a[x]})
+		}
+		if prevK == k+1 {
+			reversed = append(reversed, Change{Op: Insert, Text: b[prevY]})
+		} else {
+			reversed = append(reversed, Change{Op: Delete, Text: a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		reversed = append(reversed, Change{Op: Equal, Text: a[x]})
+	}
+
+	changes := make([]Change, len(reversed))
+	for i, c := range reversed {
+		changes[len(reversed)-1-i] = c
+	}
+	return changes
+}
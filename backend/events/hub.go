@@ -0,0 +1,103 @@
+// Package events fans database change notifications out to the event streams
+// open on this backend instance.
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Reconnect backoff for the notification listener.
+const (
+	minListenBackoff = time.Second
+	maxListenBackoff = 30 * time.Second
+)
+
+// Hub tracks the open streams for each user and wakes them when that user's
+// records change. A wake-up carries no data and each stream has room for one
+// pending wake-up, so a burst of changes wakes a slow stream once and
+// notifying never blocks on a stream.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[int]map[chan struct{}]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[int]map[chan struct{}]struct{})}
+}
+
+// Subscribe registers a stream for the user's changes. The returned function
+// unregisters it.
+func (h *Hub) Subscribe(userID int) (<-chan struct{}, func()) {
+	wake := make(chan struct{}, 1)
+
+	h.mu.Lock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan struct{}]struct{})
+	}
+	h.subscribers[userID][wake] = struct{}{}
+	h.mu.Unlock()
+
+	return wake, func() {
+		h.mu.Lock()
+		delete(h.subscribers[userID], wake)
+		if len(h.subscribers[userID]) == 0 {
+			delete(h.subscribers, userID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// Notify wakes every stream open for the user.
+func (h *Hub) Notify(userID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for wake := range h.subscribers[userID] {
+		wakeUp(wake)
+	}
+}
+
+// NotifyAll wakes every open stream, for when notifications may have been missed.
+func (h *Hub) NotifyAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, streams := range h.subscribers {
+		for wake := range streams {
+			wakeUp(wake)
+		}
+	}
+}
+
+func wakeUp(wake chan struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default: // Already has a wake-up pending
+	}
+}
+
+// Run feeds the hub from listen, such as db.SyncStore.ListenForChanges, until
+// ctx is cancelled, reconnecting with backoff whenever it fails. Every stream
+// is woken once listening resumes, in case it missed something in between.
+func (h *Hub) Run(ctx context.Context, listen func(ctx context.Context, ready func(), notify func(userID int)) error) {
+	backoff := minListenBackoff
+	for {
+		started := time.Now()
+		err := listen(ctx, h.NotifyAll, h.Notify)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > maxListenBackoff {
+			backoff = minListenBackoff
+		}
+		log.Printf("Change listener stopped, retrying in %v: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxListenBackoff)
+	}
+}
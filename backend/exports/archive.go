@@ -0,0 +1,246 @@
+package exports
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"tempo-backend/db"
+	"tempo-backend/types"
+	"time"
+	"unicode"
+)
+
+// maxSlugLength bounds the part of a file name taken from a title.
+const maxSlugLength = 60
+
+// writeArchive writes everything the snapshot's user owns to w as a ZIP:
+// profile.json and a JSON array per dataset, shaped as the API returns them,
+// plus each note and journal entry as a Markdown file under notes/ and
+// journal/. Records are written as they are read.
+func writeArchive(w io.Writer, snap *db.ExportSnapshot) error {
+	zw := zip.NewWriter(w)
+	exportedAt := time.Now()
+
+	profile, err := snap.Profile()
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "profile.json", exportedAt, profile); err != nil {
+		return err
+	}
+	for _, dataset := range db.ExportDatasets {
+		if err := writeJSONArray(zw, dataset+".json", exportedAt, func(fn func(record any) error) error {
+			return snap.Each(dataset, fn)
+		}); err != nil {
+			return fmt.Errorf("writing %s: %w", dataset, err)
+		}
+	}
+
+	notebooks, err := snap.Notebooks()
+	if err != nil {
+		return err
+	}
+	folders := notebookFolders(notebooks)
+	names := make(map[int]string, len(notebooks))
+	for _, nb := range notebooks {
+		names[nb.ID] = nb.Name
+	}
+	err = snap.EachNote(func(note types.Note) error {
+		name := path.Join("notes", folders[note.NotebookID], fmt.Sprintf("%d-%s.md", note.ID, slug(note.Title)))
+		f, err := create(zw, name, note.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		return writeNoteMarkdown(f, note, names[note.NotebookID])
+	})
+	if err != nil {
+		return fmt.Errorf("writing notes: %w", err)
+	}
+	err = snap.EachJournalEntry(func(entry types.JournalEntry) error {
+		name := path.Join("journal", fmt.Sprintf("%s-%d-%s.md", entry.EntryDate.Format(time.DateOnly), entry.ID, slug(entry.Title)))
+		f, err := create(zw, name, entry.CreatedAt)
+		if err != nil {
+			return err
+		}
+		return writeJournalMarkdown(f, entry)
+	})
+	if err != nil {
+		return fmt.Errorf("writing journal entries: %w", err)
+	}
+	return zw.Close()
+}
+
+func create(zw *zip.Writer, name string, modified time.Time) (io.Writer, error) {
+	return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+}
+
+func writeJSON(zw *zip.Writer, name string, modified time.Time, v any) error {
+	f, err := create(zw, name, modified)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeJSONArray writes the records each passes to its callback as a JSON
+// array, encoding them one at a time instead of collecting them first.
+func writeJSONArray(zw *zip.Writer, name string, modified time.Time, each func(fn func(record any) error) error) error {
+	f, err := create(zw, name, modified)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, "["); err != nil {
+		return err
+	}
+	sep := "\n  "
+	err = each(func(record any) error {
+		data, err := json.MarshalIndent(record, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, sep); err != nil {
+			return err
+		}
+		sep = ",\n  "
+		_, err = f.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	end := "\n]\n"
+	if sep == "\n  " {
+		end = "]\n"
+	}
+	_, err = io.WriteString(f, end)
+	return err
+}
+
+// writeNoteMarkdown renders a note as Markdown with YAML front matter.
+func writeNoteMarkdown(w io.Writer, note types.Note, notebook string) error {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "title: %s\n", yamlString(note.Title))
+	fmt.Fprintf(&b, "notebook: %s\n", yamlString(notebook))
+	tags := make([]string, len(note.Tags))
+	for i, tag := range note.Tags {
+		tags[i] = yamlString(tag)
+	}
+	fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(tags, ", "))
+	fmt.Fprintf(&b, "created: %s\n", note.CreatedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "updated: %s\n", note.UpdatedAt.UTC().Format(time.RFC3339))
+	b.WriteString("---\n\n")
+	fmt.Fprintf(&b, "# %s\n\n", heading(note.Title))
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+	return writeBody(w, note.Content)
+}
+
+// writeJournalMarkdown renders a journal entry as Markdown with YAML front matter.
+func writeJournalMarkdown(w io.Writer, entry types.JournalEntry) error {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "title: %s\n", yamlString(entry.Title))
+	fmt.Fprintf(&b, "date: %s\n", entry.EntryDate.Format(time.DateOnly))
+	if entry.Mood != nil {
+		fmt.Fprintf(&b, "mood: %s\n", yamlString(*entry.Mood))
+	}
+	fmt.Fprintf(&b, "created: %s\n", entry.CreatedAt.UTC().Format(time.RFC3339))
+	b.WriteString("---\n\n")
+	fmt.Fprintf(&b, "# %s\n\n", heading(entry.Title))
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+	return writeBody(w, entry.Content)
+}
+
+func writeBody(w io.Writer, content string) error {
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	_, err := io.WriteString(w, content)
+	return err
+}
+
+// heading keeps a title on one line.
+func heading(title string) string {
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// yamlString quotes s for YAML. JSON strings are valid YAML scalars.
+func yamlString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// notebookFolders maps each notebook to the folder its notes go in, nested
+// like the notebooks are.
+func notebookFolders(notebooks []types.Notebook) map[int]string {
+	byID := make(map[int]types.Notebook, len(notebooks))
+	for _, nb := range notebooks {
+		byID[nb.ID] = nb
+	}
+	folders := make(map[int]string, len(notebooks))
+	for _, nb := range notebooks {
+		var parts []string
+		// The depth check guards against a cycle, which the API never creates.
+		for cur, ok := nb, true; ok && len(parts) <= len(notebooks); cur, ok = parentOf(byID, cur) {
+			parts = append([]string{folderName(cur)}, parts...)
+		}
+		folders[nb.ID] = path.Join(parts...)
+	}
+	return folders
+}
+
+func parentOf(byID map[int]types.Notebook, nb types.Notebook) (types.Notebook, bool) {
+	if nb.ParentID == nil {
+		return types.Notebook{}, false
+	}
+	parent, ok := byID[*nb.ParentID]
+	return parent, ok
+}
+
+// folderName makes a notebook name safe to use as a folder name.
+func folderName(nb types.Notebook) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || unicode.IsControl(r) {
+			return '-'
+		}
+		return r
+	}, nb.Name)
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return fmt.Sprintf("notebook-%d", nb.ID)
+	}
+	return name
+}
+
+// slug turns a title into the lowercase, dash-separated part of a file name.
+func slug(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		} else {
+			dash = true
+		}
+		if b.Len() >= maxSlugLength {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "untitled"
+	}
+	return b.String()
+}
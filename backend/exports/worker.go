@@ -0,0 +1,167 @@
+// Package exports builds the ZIP archives users download to take their data
+// with them, in the background.
+package exports
+
+import (
+	"context"
+	"errors"
+	"log"
+	"tempo-backend/db"
+	"tempo-backend/types"
+	"time"
+)
+
+const (
+	// pollInterval is how often the worker looks for queued exports.
+	pollInterval = 5 * time.Second
+	// exportLease is how long a claimed export is left alone before another
+	// instance may assume this one died and start it over. Writing each chunk
+	// renews it.
+	exportLease = 5 * time.Minute
+	// maxAttempts is how many times an export is tried before giving up.
+	maxAttempts = 3
+	// exportRetention is how long a finished archive is kept for download.
+	exportRetention = 7 * 24 * time.Hour
+	// chunkSize is how much of an archive is buffered before it is stored.
+	chunkSize = 1 << 20
+)
+
+// Worker builds queued exports. Any number of backend instances can run one
+// against the same database: the store hands each export to one at a time.
+type Worker struct {
+	store *db.ExportStore
+	wake  chan struct{}
+}
+
+func NewWorker(store *db.ExportStore) *Worker {
+	return &Worker{store: store, wake: make(chan struct{}, 1)}
+}
+
+// Wake has the worker look for queued exports now rather than at its next poll.
+func (w *Worker) Wake() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run builds queued exports until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		w.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// poll deletes expired archives, then builds queued exports one at a time
+// until there are none left.
+func (w *Worker) poll(ctx context.Context) {
+	if _, err := w.store.DeleteExpiredExports(); err != nil {
+		log.Printf("Error deleting expired exports: %v", err)
+	}
+	for ctx.Err() == nil {
+		export, err := w.store.ClaimExport(exportLease)
+		if err != nil {
+			log.Printf("Error claiming export: %v", err)
+			return
+		}
+		if export == nil {
+			return
+		}
+		w.build(export)
+	}
+}
+
+// build writes an export's archive. An attempt that fails is left to be
+// claimed again once its lease runs out, until maxAttempts is reached.
+func (w *Worker) build(export *types.DataExport) {
+	if export.Attempts > maxAttempts {
+		w.fail(export, "Export failed too many times")
+		return
+	}
+
+	out := &chunkWriter{store: w.store, export: export, buf: make([]byte, 0, chunkSize)}
+	err := w.writeArchive(export, out)
+	if err == nil {
+		err = out.Close()
+	}
+	if err == nil {
+		err = w.store.CompleteExport(export.ID, export.Attempts, out.size, exportRetention)
+	}
+	if errors.Is(err, db.ErrExportLost) {
+		log.Printf("Export %d was taken over by another worker", export.ID)
+		return
+	}
+	if err != nil {
+		log.Printf("Error building export %d (attempt %d): %v", export.ID, export.Attempts, err)
+		if export.Attempts >= maxAttempts {
+			w.fail(export, "Export could not be built")
+		}
+	}
+}
+
+func (w *Worker) fail(export *types.DataExport, reason string) {
+	if err := w.store.FailExport(export.ID, export.Attempts, reason, exportRetention); err != nil && !errors.Is(err, db.ErrExportLost) {
+		log.Printf("Error failing export %d: %v", export.ID, err)
+	}
+}
+
+func (w *Worker) writeArchive(export *types.DataExport, out *chunkWriter) error {
+	snap, err := w.store.BeginSnapshot(export.UserID)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+	return writeArchive(out, snap)
+}
+
+// chunkWriter stores an archive as it is written, a chunk at a time, so no
+// more than one chunk of it is ever held in memory.
+type chunkWriter struct {
+	store  *db.ExportStore
+	export *types.DataExport
+	buf    []byte
+	seq    int
+	size   int64
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), chunkSize-len(cw.buf))
+		cw.buf = append(cw.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(cw.buf) == chunkSize {
+			if err := cw.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close stores whatever is left in the buffer.
+func (cw *chunkWriter) Close() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	return cw.flush()
+}
+
+func (cw *chunkWriter) flush() error {
+	if err := cw.store.AppendExportChunk(cw.export.ID, cw.export.Attempts, cw.seq, cw.buf, exportLease); err != nil {
+		return err
+	}
+	cw.seq++
+	cw.size += int64(len(cw.buf))
+	cw.buf = cw.buf[:0]
+	return nil
+}
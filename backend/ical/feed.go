@@ -0,0 +1,223 @@
+package ical
+
+import (
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"tempo-backend/recurrence"
+	"tempo-backend/types"
+)
+
+const (
+	// prodID identifies Tempo as the product that wrote a calendar.
+	prodID = "-//Tempo//Tempo//EN"
+	// uidDomain makes the UIDs of Tempo's entries globally unique.
+	uidDomain = "@tempo"
+	// maxDescriptionLength is how much of a journal entry or item description
+	// a calendar shows, in characters.
+	maxDescriptionLength = 2000
+	// timezoneYears is how many years after the last due date the VTIMEZONE
+	// covers, so recurring items keep the right offsets.
+	timezoneYears = 10
+)
+
+// WriteFeed writes a user's calendar. Items are written as events, which
+// every calendar app shows, or with asTasks as VTODOs for apps with task
+// lists. Items due at a time are in the user's timezone; the rest, and
+// journal entries, are all-day.
+func WriteFeed(out io.Writer, cal *types.Calendar, asTasks bool, now time.Time) error {
+	loc, err := time.LoadLocation(cal.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	w := NewWriter(out)
+	w.Begin("VCALENDAR")
+	w.Prop("VERSION", "2.0")
+	w.Prop("PRODID", prodID)
+	w.Prop("CALSCALE", "GREGORIAN")
+	w.Prop("METHOD", "PUBLISH")
+	w.Text("X-WR-CALNAME", "Tempo")
+	w.Text("X-WR-TIMEZONE", loc.String())
+	w.Prop("REFRESH-INTERVAL", "PT1H", "VALUE=DURATION")
+	w.Prop("X-PUBLISHED-TTL", "PT1H")
+
+	if from, to, ok := timedRange(cal.Items); ok && loc != time.UTC {
+		w.Timezone(loc, wallTime(from, loc).AddDate(0, 0, -1), wallTime(to, loc).AddDate(timezoneYears, 0, 0))
+	}
+
+	stamp := FormatUTC(now)
+	for _, item := range cal.Items {
+		writeItem(w, item, loc, asTasks, stamp)
+	}
+	for _, entry := range cal.JournalEntries {
+		w.Begin("VEVENT")
+		w.Prop("UID", "journal-"+strconv.Itoa(entry.ID)+uidDomain)
+		w.Prop("DTSTAMP", stamp)
+		w.Prop("SEQUENCE", strconv.Itoa(entry.Version-1))
+		w.Prop("DTSTART", FormatDate(entry.EntryDate), "VALUE=DATE")
+		w.Prop("DTEND", FormatDate(entry.EntryDate.AddDate(0, 0, 1)), "VALUE=DATE")
+		title := entry.Title
+		if title == "" {
+			title = "Journal"
+		}
+		w.Text("SUMMARY", title)
+		if entry.Content != "" {
+			w.Text("DESCRIPTION", truncate(entry.Content, maxDescriptionLength))
+		}
+		w.Text("CATEGORIES", "Journal")
+		w.Prop("TRANSP", "TRANSPARENT")
+		w.End("VEVENT")
+	}
+	w.End("VCALENDAR")
+	return w.Flush()
+}
+
+// writeItem writes an item as a VEVENT or VTODO. stamp is the DTSTAMP.
+func writeItem(w *Writer, item types.CalendarItem, loc *time.Location, asTasks bool, stamp string) {
+	component := "VEVENT"
+	if asTasks {
+		component = "VTODO"
+	}
+	w.Begin(component)
+	w.Prop("UID", itemUID(item.ID))
+	w.Prop("DTSTAMP", stamp)
+	w.Prop("SEQUENCE", strconv.Itoa(item.Version-1))
+
+	due := *item.DueDate
+	timed := item.DueTime != nil
+	var at time.Time
+	if timed {
+		at = dueAt(due, *item.DueTime, loc)
+		value, params := dateTimeValue(at, loc)
+		if asTasks {
+			// A task's DUE must come after its DTSTART, so it starts the
+			// day it is due, or the day before if it is due at midnight.
+			start := wallTime(due, loc)
+			if !start.Before(at) {
+				start = start.AddDate(0, 0, -1)
+			}
+			startValue, startParams := dateTimeValue(start, loc)
+			w.Prop("DTSTART", startValue, startParams...)
+			w.Prop("DUE", value, params...)
+		} else {
+			w.Prop("DTSTART", value, params...)
+		}
+	} else {
+		w.Prop("DTSTART", FormatDate(due), "VALUE=DATE")
+		if asTasks {
+			w.Prop("DUE", FormatDate(due.AddDate(0, 0, 1)), "VALUE=DATE")
+		} else {
+			w.Prop("DTEND", FormatDate(due.AddDate(0, 0, 1)), "VALUE=DATE")
+		}
+	}
+	if rrule, ok := feedRule(item, timed, loc); ok {
+		w.Prop("RRULE", rrule)
+	}
+
+	w.Text("SUMMARY", item.Task)
+	if item.Description != nil {
+		w.Text("DESCRIPTION", truncate(*item.Description, maxDescriptionLength))
+	}
+	w.Text("CATEGORIES", item.ListTitle)
+	if item.ParentID != nil {
+		w.Prop("RELATED-TO", itemUID(*item.ParentID))
+	}
+	if asTasks {
+		if item.IsCompleted {
+			w.Prop("STATUS", "COMPLETED")
+			if item.CompletedAt != nil {
+				w.Prop("COMPLETED", FormatUTC(*item.CompletedAt))
+			}
+		} else {
+			w.Prop("STATUS", "NEEDS-ACTION")
+		}
+	} else {
+		w.Prop("TRANSP", "TRANSPARENT")
+	}
+	w.End(component)
+}
+
+// feedRule returns the RRULE for an open recurring item. The series is
+// written from the item's current occurrence, so COUNT only counts the
+// occurrences left. With a time, UNTIL must be a UTC time too: the end of
+// its day where the user is.
+func feedRule(item types.CalendarItem, timed bool, loc *time.Location) (string, bool) {
+	if item.RecurrenceRule == nil || item.IsCompleted {
+		return "", false
+	}
+	rule, err := recurrence.Parse(*item.RecurrenceRule)
+	if err != nil {
+		return "", false
+	}
+	if rule.Count > 0 {
+		rule.Count -= item.RecurrenceIndex - 1
+		if rule.Count < 1 {
+			return "", false
+		}
+	}
+	until := rule.Until
+	if timed && until != nil {
+		rule.Until = nil
+	}
+	s := rule.String()
+	if timed && until != nil {
+		end := time.Date(until.Year(), until.Month(), until.Day(), 23, 59, 59, 0, loc)
+		s += ";UNTIL=" + FormatUTC(end)
+	}
+	return s, true
+}
+
+// dateTimeValue formats a due time, in UTC when the user's timezone is UTC
+// and otherwise with its TZID.
+func dateTimeValue(at time.Time, loc *time.Location) (string, []string) {
+	if loc == time.UTC {
+		return FormatUTC(at), nil
+	}
+	return FormatDateTime(at), []string{"TZID=" + loc.String()}
+}
+
+// timedRange returns the earliest and latest due dates of items due at a
+// time, which the VTIMEZONE must cover. ok is false if there are none.
+func timedRange(items []types.CalendarItem) (from, to time.Time, ok bool) {
+	for _, item := range items {
+		if item.DueTime == nil {
+			continue
+		}
+		if !ok || item.DueDate.Before(from) {
+			from = *item.DueDate
+		}
+		if !ok || item.DueDate.After(to) {
+			to = *item.DueDate
+		}
+		ok = true
+	}
+	return from, to, ok
+}
+
+// dueAt returns the time an item is due, from its date and "15:04" time.
+func dueAt(date time.Time, clock string, loc *time.Location) time.Time {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return wallTime(date, loc)
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+}
+
+// wallTime returns midnight at the start of date in loc.
+func wallTime(date time.Time, loc *time.Location) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+}
+
+func itemUID(id int) string {
+	return "item-" + strconv.Itoa(id) + uidDomain
+}
+
+// truncate shortens s to at most n characters, marking where it was cut.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
@@ -0,0 +1,219 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxNesting is how deeply components may nest; real calendars use three levels.
+const maxNesting = 8
+
+// maxContentLine is the longest content line read, after unfolding.
+const maxContentLine = 1 << 20
+
+// ErrNoCalendar is returned when the data holds no VCALENDAR.
+var ErrNoCalendar = errors.New("no VCALENDAR found")
+
+// Component is a calendar component, such as VCALENDAR, VEVENT or VTIMEZONE,
+// with its properties and the components inside it.
+type Component struct {
+	Name       string
+	Props      []Property
+	Components []*Component
+}
+
+// Property is a content line. Value is as written, so TEXT values are still
+// escaped; see Property.Text.
+type Property struct {
+	Name   string
+	Params map[string]string // Parameter names are upper case
+	Value  string
+}
+
+// Get returns the first property with the given name, or nil.
+func (c *Component) Get(name string) *Property {
+	for i := range c.Props {
+		if c.Props[i].Name == name {
+			return &c.Props[i]
+		}
+	}
+	return nil
+}
+
+// All returns every property with the given name.
+func (c *Component) All(name string) []Property {
+	var props []Property
+	for _, p := range c.Props {
+		if p.Name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// Text returns the value of a TEXT property, unescaped.
+func (p *Property) Text() string {
+	return Unescape(p.Value)
+}
+
+// Unescape reverses Escape.
+func Unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// Parse reads the first VCALENDAR in r. Property and component names are
+// upper-cased; anything outside the VCALENDAR is ignored.
+func Parse(r io.Reader) (*Component, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxContentLine)
+
+	var stack []*Component
+	var lineNo int
+	var pending []byte
+	handle := func(line string) (*Component, error) {
+		if line == "" {
+			return nil, nil
+		}
+		prop, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		switch prop.Name {
+		case "BEGIN":
+			name := strings.ToUpper(prop.Value)
+			if len(stack) == 0 && name != "VCALENDAR" {
+				return nil, nil
+			}
+			if len(stack) >= maxNesting {
+				return nil, fmt.Errorf("line %d: components nested too deeply", lineNo)
+			}
+			c := &Component{Name: name}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, c)
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 {
+				return nil, nil
+			}
+			c := stack[len(stack)-1]
+			if name := strings.ToUpper(prop.Value); name != c.Name {
+				return nil, fmt.Errorf("line %d: END:%s inside %s", lineNo, name, c.Name)
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return c, nil
+			}
+		default:
+			if len(stack) > 0 {
+				c := stack[len(stack)-1]
+				c.Props = append(c.Props, prop)
+			}
+		}
+		return nil, nil
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if lineNo == 0 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		// A line starting with a space or tab continues the one before it.
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if len(pending)+len(line) > maxContentLine {
+				return nil, fmt.Errorf("line %d: content line too long", lineNo)
+			}
+			pending = append(pending, line[1:]...)
+			continue
+		}
+		cal, err := handle(string(pending))
+		if err != nil || cal != nil {
+			return cal, err
+		}
+		lineNo++
+		pending = append(pending[:0], line...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	cal, err := handle(string(pending))
+	if err != nil || cal != nil {
+		return cal, err
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("%s is not closed", stack[len(stack)-1].Name)
+	}
+	return nil, ErrNoCalendar
+}
+
+// parseLine splits a content line into its name, parameters and value.
+func parseLine(line string) (Property, error) {
+	prop := Property{Params: make(map[string]string)}
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return prop, errors.New("malformed content line")
+	}
+	prop.Name = strings.ToUpper(line[:i])
+	rest := line[i:]
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return prop, errors.New("malformed parameter")
+		}
+		key := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+
+		var value string
+		for {
+			if strings.HasPrefix(rest, `"`) {
+				end := strings.IndexByte(rest[1:], '"')
+				if end < 0 {
+					return prop, errors.New("unterminated quoted parameter")
+				}
+				value += rest[1 : end+1]
+				rest = rest[end+2:]
+			} else {
+				end := strings.IndexAny(rest, ";:,")
+				if end < 0 {
+					return prop, errors.New("missing property value")
+				}
+				value += rest[:end]
+				rest = rest[end:]
+			}
+			// Multiple values are kept comma-separated.
+			if !strings.HasPrefix(rest, ",") {
+				break
+			}
+			value += ","
+			rest = rest[1:]
+		}
+		prop.Params[key] = value
+	}
+	if !strings.HasPrefix(rest, ":") {
+		return prop, errors.New("missing property value")
+	}
+	prop.Value = rest[1:]
+	return prop, nil
+}
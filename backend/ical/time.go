@@ -0,0 +1,290 @@
+package ical
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"tempo-backend/recurrence"
+)
+
+// maxOnsets bounds how many recurring onsets of a VTIMEZONE observance are
+// stepped through to find the one in effect.
+const maxOnsets = 1000
+
+// onsetLookback is how many years before a time the onsets of a VTIMEZONE
+// observance are looked for.
+const onsetLookback = 5
+
+// ErrUnknownTimezone is returned for a TZID that is neither an IANA zone nor
+// defined by a VTIMEZONE in the calendar.
+var ErrUnknownTimezone = errors.New("unknown timezone")
+
+// DateTime is a DATE or DATE-TIME value.
+type DateTime struct {
+	Time     time.Time
+	AllDay   bool // A DATE value; Time is midnight in the floating location
+	Floating bool // A local time without a timezone, read in the floating location
+
+	tzid string // The TZID the time was given in, if any
+}
+
+// Timezones resolves the TZIDs used in a calendar, preferring IANA zones of
+// the same name and falling back to the calendar's own VTIMEZONE definitions.
+type Timezones struct {
+	defined   map[string]*vtimezone
+	locations map[string]*time.Location
+}
+
+// vtimezone is a VTIMEZONE, as the observances that make it up.
+type vtimezone struct {
+	observances []observance
+}
+
+// observance is a STANDARD or DAYLIGHT period of a VTIMEZONE. Its onsets are
+// DTSTART and those of its RRULE and RDATEs, all in local time before the change.
+type observance struct {
+	start      time.Time // Wall clock time, in UTC
+	offsetFrom int
+	offsetTo   int
+	rule       *recurrence.Rule
+	rdates     []time.Time
+}
+
+// NewTimezones reads the VTIMEZONE components of a calendar. Ones that can't
+// be read are left out, so their TZIDs only resolve if IANA knows them.
+func NewTimezones(cal *Component) *Timezones {
+	tz := &Timezones{defined: make(map[string]*vtimezone), locations: make(map[string]*time.Location)}
+	for _, c := range cal.Components {
+		if c.Name != "VTIMEZONE" || c.Get("TZID") == nil {
+			continue
+		}
+		var v vtimezone
+		for _, sub := range c.Components {
+			if sub.Name != "STANDARD" && sub.Name != "DAYLIGHT" {
+				continue
+			}
+			if o, err := parseObservance(sub); err == nil {
+				v.observances = append(v.observances, o)
+			}
+		}
+		if len(v.observances) > 0 {
+			tz.defined[c.Get("TZID").Value] = &v
+		}
+	}
+	return tz
+}
+
+func parseObservance(c *Component) (observance, error) {
+	var o observance
+	start, from, to := c.Get("DTSTART"), c.Get("TZOFFSETFROM"), c.Get("TZOFFSETTO")
+	if start == nil || from == nil || to == nil {
+		return o, errors.New("incomplete observance")
+	}
+	var err error
+	if o.start, err = parseWallClock(start.Value); err != nil {
+		return o, err
+	}
+	if o.offsetFrom, err = parseOffset(from.Value); err != nil {
+		return o, err
+	}
+	if o.offsetTo, err = parseOffset(to.Value); err != nil {
+		return o, err
+	}
+	if rrule := c.Get("RRULE"); rrule != nil {
+		if o.rule, err = recurrence.Parse(rrule.Value); err != nil {
+			return o, err
+		}
+	}
+	for _, rdate := range c.All("RDATE") {
+		for _, v := range strings.Split(rdate.Value, ",") {
+			if t, err := parseWallClock(v); err == nil {
+				o.rdates = append(o.rdates, t)
+			}
+		}
+	}
+	return o, nil
+}
+
+// ParseTime reads a DATE or DATE-TIME property such as DTSTART or DUE. Dates
+// and floating times are read in floating, usually the user's own timezone.
+func (tz *Timezones) ParseTime(p *Property, floating *time.Location) (DateTime, error) {
+	value := strings.TrimSpace(p.Value)
+	if p.Params["VALUE"] == "DATE" || len(value) == len(dateLayout) {
+		d, err := time.ParseInLocation(dateLayout, value, floating)
+		if err != nil {
+			return DateTime{}, fmt.Errorf("invalid date %q", value)
+		}
+		return DateTime{Time: d, AllDay: true}, nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(utcLayout, value)
+		if err != nil {
+			return DateTime{}, fmt.Errorf("invalid date-time %q", value)
+		}
+		return DateTime{Time: t}, nil
+	}
+	wall, err := parseWallClock(value)
+	if err != nil {
+		return DateTime{}, err
+	}
+	tzid := p.Params["TZID"]
+	if tzid == "" {
+		return DateTime{Time: inLocation(wall, floating), Floating: true}, nil
+	}
+	loc, err := tz.location(tzid, wall)
+	if err != nil {
+		return DateTime{}, err
+	}
+	return DateTime{Time: inLocation(wall, loc), tzid: tzid}, nil
+}
+
+// On returns the time with dt's wall clock on another date, in the same
+// timezone, for stepping through the occurrences of a recurring entry. The
+// offset is the one in effect on that date.
+func (tz *Timezones) On(dt DateTime, date time.Time) time.Time {
+	wall := time.Date(date.Year(), date.Month(), date.Day(), dt.Time.Hour(), dt.Time.Minute(), dt.Time.Second(), 0, time.UTC)
+	loc := dt.Time.Location()
+	if dt.tzid != "" {
+		if l, err := tz.location(dt.tzid, wall); err == nil {
+			loc = l
+		}
+	}
+	return inLocation(wall, loc)
+}
+
+// location returns the location a wall clock time with the given TZID is in.
+// For zones only the calendar defines, that is the offset in effect then.
+func (tz *Timezones) location(tzid string, wall time.Time) (*time.Location, error) {
+	if loc, ok := tz.locations[tzid]; ok {
+		return loc, nil
+	}
+	if loc := loadIANA(tzid); loc != nil {
+		tz.locations[tzid] = loc
+		return loc, nil
+	}
+	if v, ok := tz.defined[tzid]; ok {
+		return time.FixedZone(tzid, v.offsetAt(wall)), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownTimezone, tzid)
+}
+
+// loadIANA loads the IANA zone a TZID names. Some apps put a prefix before
+// the name, as in "/mozilla.org/20050126_1/Europe/Berlin", so each shorter
+// suffix of the path is tried too.
+func loadIANA(tzid string) *time.Location {
+	name := strings.Trim(tzid, "/")
+	for name != "" {
+		if name != "Local" {
+			if loc, err := time.LoadLocation(name); err == nil {
+				return loc
+			}
+		}
+		_, rest, found := strings.Cut(name, "/")
+		if !found {
+			break
+		}
+		name = rest
+	}
+	return nil
+}
+
+// offsetAt returns the UTC offset in effect at a wall clock time: that of the
+// observance with the latest onset at or before it.
+func (v *vtimezone) offsetAt(wall time.Time) int {
+	var latest time.Time
+	offset := v.observances[0].offsetFrom
+	found := false
+	for _, o := range v.observances {
+		if onset, ok := o.lastOnset(wall); ok && (!found || onset.After(latest)) {
+			latest, offset, found = onset, o.offsetTo, true
+		}
+	}
+	if !found {
+		// Before every onset, use the offset the earliest one changes from.
+		earliest := v.observances[0]
+		for _, o := range v.observances[1:] {
+			if o.start.Before(earliest.start) {
+				earliest = o
+			}
+		}
+		offset = earliest.offsetFrom
+	}
+	return offset
+}
+
+// lastOnset returns the latest onset of the observance at or before wall.
+func (o observance) lastOnset(wall time.Time) (time.Time, bool) {
+	if o.start.After(wall) {
+		return time.Time{}, false
+	}
+	latest := o.start
+	if o.rule != nil {
+		clock := o.start.Sub(dateOf(o.start))
+		current := o.start
+		// Without a COUNT, onsets long before wall needn't be stepped
+		// through; zones change at most yearly, so a few years back will do.
+		if skipTo := time.Date(wall.Year()-onsetLookback, time.January, 1, 0, 0, 0, 0, time.UTC); o.rule.Count == 0 && skipTo.After(current) {
+			current = skipTo
+		}
+		for i := 1; i < maxOnsets; i++ {
+			next, ok := o.rule.Next(o.start, current, i)
+			if !ok || next.Add(clock).After(wall) {
+				break
+			}
+			current = next
+			latest = next.Add(clock)
+		}
+	}
+	for _, rdate := range o.rdates {
+		if !rdate.After(wall) && rdate.After(latest) {
+			latest = rdate
+		}
+	}
+	return latest, true
+}
+
+// parseWallClock reads a local DATE-TIME, or a DATE as midnight, as a time in
+// UTC with the same wall clock.
+func parseWallClock(value string) (time.Time, error) {
+	value = strings.TrimSuffix(value, "Z")
+	for _, layout := range []string{dateTimeLayout, dateLayout} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date-time %q", value)
+}
+
+// parseOffset reads a UTC-OFFSET value such as +0100 or -053000 as seconds.
+func parseOffset(value string) (int, error) {
+	if len(value) != 5 && len(value) != 7 || (value[0] != '+' && value[0] != '-') {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+	seconds := 0
+	for i, unit := range []int{3600, 60, 1} {
+		if 1+2*i >= len(value) {
+			break
+		}
+		n, err := strconv.Atoi(value[1+2*i : 3+2*i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid UTC offset %q", value)
+		}
+		seconds += n * unit
+	}
+	if value[0] == '-' {
+		seconds = -seconds
+	}
+	return seconds, nil
+}
+
+// inLocation returns the time in loc with the same wall clock as wall.
+func inLocation(wall time.Time, loc *time.Location) time.Time {
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+}
+
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
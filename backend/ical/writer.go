@@ -0,0 +1,174 @@
+// Package ical reads and writes iCalendar (RFC 5545) data.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLineLength is the longest a content line may be, in octets, before it is
+// folded onto a continuation line.
+const maxLineLength = 75
+
+// maxTransitions bounds how many offset changes a VTIMEZONE lists.
+const maxTransitions = 500
+
+// Date and time layouts for DATE, local DATE-TIME and UTC DATE-TIME values.
+const (
+	dateLayout     = "20060102"
+	dateTimeLayout = "20060102T150405"
+	utcLayout      = "20060102T150405Z"
+)
+
+// Writer writes content lines, folding long ones and ending each with CRLF.
+// The first error is kept and returned by Flush.
+type Writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Begin starts a component, such as VCALENDAR or VEVENT.
+func (w *Writer) Begin(component string) {
+	w.line("BEGIN:" + component)
+}
+
+// End ends a component started with Begin.
+func (w *Writer) End(component string) {
+	w.line("END:" + component)
+}
+
+// Prop writes a property whose value is already in iCalendar form. Each
+// param is NAME=value; values with special characters are quoted.
+func (w *Writer) Prop(name, value string, params ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	for _, param := range params {
+		key, val, _ := strings.Cut(param, "=")
+		b.WriteString(";" + key + "=")
+		if strings.ContainsAny(val, `:;,`) {
+			val = `"` + strings.ReplaceAll(val, `"`, "") + `"`
+		}
+		b.WriteString(val)
+	}
+	b.WriteString(":" + value)
+	w.line(b.String())
+}
+
+// Text writes a property whose value is text, escaping it.
+func (w *Writer) Text(name, text string, params ...string) {
+	w.Prop(name, Escape(text), params...)
+}
+
+// Flush writes anything buffered and returns the first error seen.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// line writes a content line, folding it every maxLineLength octets without
+// splitting a UTF-8 sequence.
+func (w *Writer) line(s string) {
+	if w.err != nil {
+		return
+	}
+	limit := maxLineLength
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		if _, w.err = w.w.WriteString(s[:cut] + "\r\n "); w.err != nil {
+			return
+		}
+		s = s[cut:]
+		limit = maxLineLength - 1 // Continuation lines start with a space
+	}
+	_, w.err = w.w.WriteString(s + "\r\n")
+}
+
+// Escape escapes text for a TEXT value.
+func Escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+// FormatDate formats the date of t as a DATE value.
+func FormatDate(t time.Time) string {
+	return t.Format(dateLayout)
+}
+
+// FormatDateTime formats the wall clock time of t as a local DATE-TIME
+// value, to be written with the TZID of its location.
+func FormatDateTime(t time.Time) string {
+	return t.Format(dateTimeLayout)
+}
+
+// FormatUTC formats t as a UTC DATE-TIME value.
+func FormatUTC(t time.Time) string {
+	return t.UTC().Format(utcLayout)
+}
+
+// Timezone writes a VTIMEZONE for loc, with one observance for each period
+// between its offset changes from from to to. Listing the changes, rather
+// than describing them with rules, works for any zone Go knows.
+func (w *Writer) Timezone(loc *time.Location, from, to time.Time) {
+	w.Begin("VTIMEZONE")
+	w.Prop("TZID", loc.String())
+	t := from.In(loc)
+	start, end := t.ZoneBounds()
+	prevOffset := 0
+	if !start.IsZero() {
+		_, prevOffset = start.Add(-time.Second).Zone()
+	}
+	for i := 0; i < maxTransitions; i++ {
+		name, offset := t.Zone()
+		onset := "19700101T000000"
+		if start.IsZero() {
+			prevOffset = offset
+		} else {
+			// An observance starts at the local time before the change.
+			onset = FormatDateTime(start.In(time.FixedZone("", prevOffset)))
+		}
+
+		kind := "STANDARD"
+		if t.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		w.Begin(kind)
+		w.Prop("DTSTART", onset)
+		w.Prop("TZOFFSETFROM", formatOffset(prevOffset))
+		w.Prop("TZOFFSETTO", formatOffset(offset))
+		w.Text("TZNAME", name)
+		w.End(kind)
+
+		if end.IsZero() || end.After(to) {
+			break
+		}
+		prevOffset = offset
+		t = end.In(loc)
+		start, end = t.ZoneBounds()
+	}
+	w.End("VTIMEZONE")
+}
+
+// formatOffset formats seconds east of UTC as a UTC-OFFSET value.
+func formatOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign, seconds = '-', -seconds
+	}
+	s := fmt.Sprintf("%c%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		s += fmt.Sprintf("%02d", seconds%60)
+	}
+	return s
+}
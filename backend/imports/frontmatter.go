@@ -0,0 +1,181 @@
+package imports
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// frontMatter is what an import understands of a Markdown file's YAML front
+// matter. Other keys are ignored.
+type frontMatter struct {
+	Title   string
+	Tags    []string
+	Aliases []string
+	Created *time.Time
+	Updated *time.Time
+}
+
+// frontMatterDateLayouts are the date formats accepted in front matter.
+var frontMatterDateLayouts = []string{
+	time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04", time.DateOnly,
+}
+
+// parseFrontMatter splits the front matter off the start of a Markdown file
+// and reads it, returning the rest of the file and anything that couldn't be
+// understood. Only the flat subset of YAML notes use is supported: scalars,
+// [inline] lists and "- item" lists.
+func parseFrontMatter(text string) (frontMatter, string, []string) {
+	var fm frontMatter
+	lines, body, ok := splitFrontMatter(text)
+	if !ok {
+		return fm, text, nil
+	}
+
+	values := make(map[string][]string)
+	var key string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if item, isItem := strings.CutPrefix(trimmed, "- "); isItem || trimmed == "-" {
+			if key != "" && isItem {
+				values[key] = append(values[key], unquote(item))
+			}
+			continue
+		}
+		if line != strings.TrimLeft(line, " \t") {
+			continue // Nested mappings aren't supported
+		}
+		k, v, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+			values[key] = nil
+		case strings.HasPrefix(v, "[") && strings.HasSuffix(v, "]"):
+			values[key] = splitInlineList(v[1 : len(v)-1])
+		default:
+			values[key] = []string{unquote(v)}
+		}
+	}
+
+	var warnings []string
+	if v := values["title"]; len(v) > 0 {
+		fm.Title = v[0]
+	}
+	for _, k := range []string{"tags", "tag"} {
+		for _, v := range values[k] {
+			// A single value may hold several tags: "tags: work, ideas" or "tags: work ideas".
+			for _, tag := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' }) {
+				if tag = strings.TrimPrefix(tag, "#"); tag != "" {
+					fm.Tags = append(fm.Tags, tag)
+				}
+			}
+		}
+	}
+	for _, k := range []string{"aliases", "alias"} {
+		fm.Aliases = append(fm.Aliases, values[k]...)
+	}
+	for _, d := range []struct {
+		keys []string
+		dest **time.Time
+	}{
+		{[]string{"created", "created_at", "date"}, &fm.Created},
+		{[]string{"updated", "updated_at", "modified"}, &fm.Updated},
+	} {
+		for _, k := range d.keys {
+			v := values[k]
+			if len(v) == 0 || *d.dest != nil {
+				continue
+			}
+			if t, ok := parseDate(v[0]); ok {
+				*d.dest = &t
+			} else {
+				warnings = append(warnings, "Could not read the date in "+k+": "+v[0])
+			}
+		}
+	}
+	return fm, body, warnings
+}
+
+// splitFrontMatter returns the lines between a leading "---" and the next
+// "---" or "...", and the text after them. ok is false when there is no
+// complete front matter block.
+func splitFrontMatter(text string) (lines []string, body string, ok bool) {
+	text = strings.TrimPrefix(text, "\ufeff")
+	rest, found := strings.CutPrefix(text, "---\n")
+	if !found {
+		if rest, found = strings.CutPrefix(text, "---\r\n"); !found {
+			return nil, text, false
+		}
+	}
+	for len(rest) > 0 {
+		line, next, _ := strings.Cut(rest, "\n")
+		if end := strings.TrimRight(line, "\r"); end == "---" || end == "..." {
+			return lines, strings.TrimLeft(next, "\r\n"), true
+		}
+		lines = append(lines, strings.TrimRight(line, "\r"))
+		rest = next
+	}
+	return nil, text, false
+}
+
+// splitInlineList splits the inside of a YAML [inline, list] on commas
+// outside quotes.
+func splitInlineList(s string) []string {
+	var items []string
+	var quote rune
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ',':
+			items = append(items, s[start:i])
+			start = i + 1
+		}
+	}
+	items = append(items, s[start:])
+
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		if item = unquote(strings.TrimSpace(item)); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// unquote reads a YAML scalar, removing quotes and trailing comments.
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if v, err := strconv.Unquote(s); err == nil {
+			return v
+		}
+		return s[1 : len(s)-1]
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s
+}
+
+func parseDate(s string) (time.Time, bool) {
+	for _, layout := range frontMatterDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,356 @@
+package imports
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"tempo-backend/db"
+	"tempo-backend/ical"
+	"tempo-backend/recurrence"
+	"tempo-backend/types"
+)
+
+const (
+	// maxCalendarEntries is how many events and tasks an imported calendar may hold.
+	maxCalendarEntries = 5000
+	// maxListTitleLength is the longest list title, in characters.
+	maxListTitleLength = 255
+	// maxOccurrenceSteps bounds how far a recurring event is stepped forward to today.
+	maxOccurrenceSteps = 50000
+)
+
+// ErrInvalidCalendar is returned for data that isn't an iCalendar file.
+var ErrInvalidCalendar = errors.New("not a valid iCalendar file")
+
+// ErrTooManyEntries is returned for a calendar with more than maxCalendarEntries entries.
+var ErrTooManyEntries = fmt.Errorf("calendar has more than %d events and tasks", maxCalendarEntries)
+
+// ImportCalendar imports the events and tasks of an iCalendar (.ics) file as
+// to-do items, in listID or, when it is nil, a new list named after the
+// calendar or the file it came from.
+//
+// Dates and times are converted to the user's timezone; a DATE without a time
+// stays on its day. Tasks keep their due date and status. Events are due when
+// they start: past events are imported as completed, and a recurring event
+// as its next occurrence. Recurrence rules the app supports are kept; others
+// import just that one occurrence. Entries imported before, by UID, are skipped.
+func ImportCalendar(store *db.ImportStore, userID int, listID *int, r io.Reader, filename string) (*types.CalendarImportReport, error) {
+	cal, err := ical.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCalendar, err)
+	}
+	var entries []*ical.Component
+	for _, c := range cal.Components {
+		if c.Name == "VEVENT" || c.Name == "VTODO" {
+			entries = append(entries, c)
+		}
+	}
+	if len(entries) > maxCalendarEntries {
+		return nil, ErrTooManyEntries
+	}
+
+	imp, err := store.BeginItemImport(userID, listID, calendarTitle(cal, filename))
+	if err != nil {
+		return nil, err
+	}
+	defer imp.Rollback()
+
+	loc, err := time.LoadLocation(imp.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	ci := &calendarImport{tz: ical.NewTimezones(cal), loc: loc, now: time.Now().In(loc)}
+
+	report := &types.CalendarImportReport{
+		Created: make([]types.ImportedEntry, 0),
+		Skipped: make([]types.ImportedEntry, 0),
+		Errors:  make([]types.ImportedEntry, 0),
+	}
+	seen := make(map[string]bool)
+	for _, c := range entries {
+		entry := types.ImportedEntry{UID: entryUID(c)}
+		if p := c.Get("SUMMARY"); p != nil {
+			entry.Task = taskText(p.Text())
+		}
+		switch {
+		case c.Get("RECURRENCE-ID") != nil:
+			entry.Reason = "Changes to a single occurrence of a recurring entry aren't imported"
+			report.Skipped = append(report.Skipped, entry)
+			continue
+		case seen[entry.UID]:
+			entry.Reason = "Another entry in the calendar has the same UID"
+			report.Skipped = append(report.Skipped, entry)
+			continue
+		}
+		seen[entry.UID] = true
+
+		if itemID, ok, err := imp.ImportedItemID(entry.UID); err != nil {
+			return nil, err
+		} else if ok {
+			entry.ItemID = &itemID
+			entry.Reason = "Already imported"
+			report.Skipped = append(report.Skipped, entry)
+			continue
+		}
+		if status := c.Get("STATUS"); status != nil && strings.EqualFold(status.Value, "CANCELLED") {
+			entry.Reason = "Cancelled"
+			report.Skipped = append(report.Skipped, entry)
+			continue
+		}
+
+		item, warnings, reason := ci.item(c)
+		if reason != "" {
+			entry.Reason = reason
+			report.Errors = append(report.Errors, entry)
+			continue
+		}
+		item.UID = entry.UID
+		if item.Task == "" {
+			item.Task = "Untitled"
+		}
+		itemID, err := imp.CreateItem(item)
+		if err != nil {
+			return nil, err
+		}
+		entry.ItemID = &itemID
+		entry.Task = item.Task
+		entry.Warnings = warnings
+		report.Created = append(report.Created, entry)
+	}
+
+	if err := imp.Commit(); err != nil {
+		return nil, err
+	}
+	report.ListID = imp.ListID
+	return report, nil
+}
+
+// calendarImport is an import of a calendar in progress.
+type calendarImport struct {
+	tz  *ical.Timezones
+	loc *time.Location // The user's timezone
+	now time.Time      // In loc
+}
+
+// item reads a VEVENT or VTODO as an item, with warnings about anything of
+// it that couldn't be kept, or the reason it can't be imported.
+func (ci *calendarImport) item(c *ical.Component) (db.ImportedItem, []string, string) {
+	var item db.ImportedItem
+	var warnings []string
+	if p := c.Get("SUMMARY"); p != nil {
+		item.Task = taskText(p.Text())
+	}
+
+	isTask := c.Name == "VTODO"
+	if isTask {
+		status := c.Get("STATUS")
+		item.IsCompleted = c.Get("COMPLETED") != nil || (status != nil && strings.EqualFold(status.Value, "COMPLETED"))
+	}
+
+	due := c.Get("DTSTART")
+	if isTask && c.Get("DUE") != nil {
+		due = c.Get("DUE")
+	}
+	if due == nil {
+		if isTask {
+			if c.Get("RRULE") != nil {
+				warnings = append(warnings, "The task has no due date, so its recurrence was ignored")
+			}
+			return item, warnings, ""
+		}
+		return item, nil, "The event has no start"
+	}
+	dt, err := ci.tz.ParseTime(due, ci.loc)
+	if errors.Is(err, ical.ErrUnknownTimezone) {
+		warnings = append(warnings, fmt.Sprintf("Unknown timezone %q; the time was read in yours", due.Params["TZID"]))
+		floating := *due
+		floating.Params = map[string]string{}
+		dt, err = ci.tz.ParseTime(&floating, ci.loc)
+	}
+	if err != nil {
+		return item, nil, fmt.Sprintf("Could not read %s: %v", due.Name, err)
+	}
+
+	if rrule := c.Get("RRULE"); rrule != nil {
+		rule, err := recurrence.Parse(rrule.Value)
+		if err == nil {
+			warnings = append(warnings, ci.recurring(&item, c, dt, rule, !isTask)...)
+			return item, warnings, ""
+		}
+		warnings = append(warnings, "The recurrence rule isn't supported, so only the first occurrence was imported: "+err.Error())
+	}
+
+	ci.setDue(&item, dt, dt.Time)
+	if !isTask && item.DueDate != nil && ci.isPast(dt, dt.Time) {
+		item.IsCompleted = true
+	}
+	return item, warnings, ""
+}
+
+// recurring sets a recurring entry's rule and due date. Events move on to
+// their first occurrence from today, skipping excluded dates, and are left
+// completed at their last one if the series has ended.
+func (ci *calendarImport) recurring(item *db.ImportedItem, c *ical.Component, dt ical.DateTime, rule *recurrence.Rule, advance bool) []string {
+	var warnings []string
+	if len(c.All("RRULE")) > 1 {
+		warnings = append(warnings, "Only the first of several recurrence rules was kept")
+	}
+	if c.Get("RDATE") != nil {
+		warnings = append(warnings, "Extra dates (RDATE) of the recurrence were ignored")
+	}
+	excluded := make(map[string]bool)
+	for _, p := range c.All("EXDATE") {
+		for _, v := range strings.Split(p.Value, ",") {
+			value := p
+			value.Value = v
+			ex, err := ci.tz.ParseTime(&value, ci.loc)
+			if err != nil {
+				continue
+			}
+			if !ex.AllDay && !dt.AllDay {
+				ex.Time = ex.Time.In(dt.Time.Location())
+			}
+			excluded[sourceDate(ex.Time).Format(time.DateOnly)] = true
+		}
+	}
+
+	// Occurrences are stepped through in the entry's own timezone, where
+	// the rule's days are meant.
+	start := sourceDate(dt.Time)
+	current, index := start, 1
+	ended := false
+	for i := 0; advance && i < maxOccurrenceSteps; i++ {
+		if !ci.isPast(dt, ci.tz.On(dt, current)) && !excluded[current.Format(time.DateOnly)] {
+			break
+		}
+		next, ok := rule.Next(start, current, index)
+		if !ok {
+			ended = true
+			break
+		}
+		current, index = next, index+1
+	}
+	for d := range excluded {
+		if d > current.Format(time.DateOnly) {
+			warnings = append(warnings, "Exceptions (EXDATE) to the recurrence after the next occurrence were ignored")
+			break
+		}
+	}
+
+	at := ci.tz.On(dt, current)
+	ci.setDue(item, dt, at)
+	shift := 0
+	if !dt.AllDay {
+		shift = daysBetween(current, sourceDate(at.In(ci.loc)))
+	}
+	if shift != 0 && !shiftRule(rule, shift) {
+		// The rule's days can't be moved into the user's timezone, so the
+		// item keeps the dates and time the entry has where it was made.
+		warnings = append(warnings, fmt.Sprintf("The recurrence follows %s, so its dates and time are as they are there", dt.Time.Location()))
+		due := current
+		dueTime := at.Format("15:04")
+		item.DueDate, item.DueTime = &due, &dueTime
+		shift = 0
+	}
+
+	ruleText := rule.String()
+	recurrenceStart := start.AddDate(0, 0, shift)
+	item.RecurrenceRule = &ruleText
+	item.RecurrenceStart = &recurrenceStart
+	item.RecurrenceIndex = index
+	item.IsCompleted = item.IsCompleted || ended
+	return warnings
+}
+
+// setDue sets the item's due date, and time unless dt is a DATE, to at in the
+// user's timezone.
+func (ci *calendarImport) setDue(item *db.ImportedItem, dt ical.DateTime, at time.Time) {
+	if dt.AllDay {
+		due := sourceDate(at)
+		item.DueDate = &due
+		return
+	}
+	local := at.In(ci.loc)
+	due := sourceDate(local)
+	dueTime := local.Format("15:04")
+	item.DueDate, item.DueTime = &due, &dueTime
+}
+
+// isPast reports whether an occurrence at the given time is before today, or
+// for timed entries before now, in the user's timezone.
+func (ci *calendarImport) isPast(dt ical.DateTime, at time.Time) bool {
+	if dt.AllDay {
+		return sourceDate(at).Before(sourceDate(ci.now))
+	}
+	return at.Before(ci.now)
+}
+
+// shiftRule moves the weekdays of a rule by days, for a series whose dates
+// move when converted to another timezone. It reports false for rules whose
+// days can't simply be moved, those with month days or numbered weekdays.
+func shiftRule(rule *recurrence.Rule, days int) bool {
+	if len(rule.ByMonthDay) > 0 {
+		return false
+	}
+	for i, wd := range rule.ByDay {
+		if wd.N != 0 {
+			return false
+		}
+		rule.ByDay[i].Weekday = time.Weekday(((int(wd.Weekday)+days)%7 + 7) % 7)
+	}
+	return true
+}
+
+// sourceDate returns the date of t where it is, at midnight UTC as dates are stored.
+func sourceDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func daysBetween(a, b time.Time) int {
+	return int(b.Sub(a).Hours() / 24)
+}
+
+// entryUID returns an entry's UID or, for entries without one, a hash of
+// what it says so importing it again is still skipped.
+func entryUID(c *ical.Component) string {
+	if p := c.Get("UID"); p != nil && strings.TrimSpace(p.Value) != "" {
+		return strings.TrimSpace(p.Text())
+	}
+	h := sha256.New()
+	for _, name := range []string{"SUMMARY", "DTSTART", "DUE", "RRULE"} {
+		if p := c.Get(name); p != nil {
+			fmt.Fprintf(h, "%s:%s\n", name, p.Value)
+		}
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// taskText turns a SUMMARY into a task, on one line.
+func taskText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// calendarTitle names the list a calendar is imported into after the
+// calendar, or else the file it was uploaded as.
+func calendarTitle(cal *ical.Component, filename string) string {
+	title := ""
+	if p := cal.Get("X-WR-CALNAME"); p != nil {
+		title = taskText(p.Text())
+	}
+	if title == "" {
+		title = strings.TrimSpace(strings.TrimSuffix(filename, ".ics"))
+	}
+	if title == "" {
+		title = "Imported calendar"
+	}
+	if utf8.RuneCountInString(title) > maxListTitleLength {
+		title = string([]rune(title)[:maxListTitleLength])
+	}
+	return title
+}
@@ -0,0 +1,114 @@
+package imports
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// linkKind is the syntax a link in a Markdown note was written in.
+type linkKind int
+
+const (
+	wikiLink  linkKind = iota // [[Note]] or [[Note#Heading|label]]
+	wikiEmbed                 // ![[image.png]] or ![[Note]]
+	mdLink                    // [label](Other%20Note.md)
+	mdImage                   // ![alt](images/photo.png)
+)
+
+// link is a link found in a note, pointing at another file of the import.
+type link struct {
+	kind    linkKind
+	target  string // The file or note named, without any #heading
+	heading string
+	label   string // The alias, link text or alt text
+}
+
+// linkPattern matches wikilinks and embeds, then Markdown links and images.
+var linkPattern = regexp.MustCompile(`(!?)\[\[([^\[\]\n]+)\]\]|(!?)\[([^\[\]\n]*)\]\((<[^<>\n]+>|[^()\s]+)(\s+"[^"\n]*")?\)`)
+
+// schemePattern matches URLs with a scheme, which never point into the import.
+var schemePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:`)
+
+// rewriteLinks replaces each link in a Markdown document that points at a
+// local file with what rewrite returns for it, leaving links for which it
+// returns false alone. Code blocks and code spans are left untouched.
+func rewriteLinks(text string, rewrite func(l link) (string, bool)) string {
+	lines := strings.SplitAfter(text, "\n")
+	fence := ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+
+		// Between backticks is code; an unmatched last backtick opens nothing.
+		parts := strings.Split(line, "`")
+		for j := range parts {
+			if j%2 == 0 || j == len(parts)-1 {
+				parts[j] = rewriteLine(parts[j], rewrite)
+			}
+		}
+		lines[i] = strings.Join(parts, "`")
+	}
+	return strings.Join(lines, "")
+}
+
+func rewriteLine(text string, rewrite func(l link) (string, bool)) string {
+	return linkPattern.ReplaceAllStringFunc(text, func(match string) string {
+		l, ok := parseLink(linkPattern.FindStringSubmatch(match))
+		if !ok {
+			return match
+		}
+		if replacement, ok := rewrite(l); ok {
+			return replacement
+		}
+		return match
+	})
+}
+
+// parseLink reads a match of linkPattern. ok is false for links that don't
+// point at another file, such as web links and links within the same note.
+func parseLink(m []string) (link, bool) {
+	var l link
+	if m[2] != "" {
+		l.kind = wikiLink
+		if m[1] == "!" {
+			l.kind = wikiEmbed
+		}
+		target, label, _ := strings.Cut(m[2], "|")
+		target = strings.TrimSuffix(target, `\`) // Pipes are escaped inside tables
+		l.target, l.heading, _ = strings.Cut(target, "#")
+		l.target = strings.TrimSpace(l.target)
+		l.label = strings.TrimSpace(label)
+		return l, l.target != ""
+	}
+
+	l.kind = mdLink
+	if m[3] == "!" {
+		l.kind = mdImage
+	}
+	l.label = m[4]
+	dest := strings.TrimSuffix(strings.TrimPrefix(m[5], "<"), ">")
+	if schemePattern.MatchString(dest) || strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "#") {
+		return l, false
+	}
+	dest, l.heading, _ = strings.Cut(dest, "#")
+	if unescaped, err := url.PathUnescape(dest); err == nil {
+		dest = unescaped
+	}
+	l.target = dest
+	return l, l.target != ""
+}
+
+// escapeLabel keeps text from ending the [label] of a Markdown link early.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(s)
+}
@@ -0,0 +1,415 @@
+// Package imports reads data exported from other apps into Tempo.
+package imports
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"tempo-backend/db"
+	"tempo-backend/types"
+	"unicode/utf8"
+)
+
+const (
+	// maxVaultFiles is how many files an imported archive may hold.
+	maxVaultFiles = 10000
+	// maxNoteSize is the largest Markdown file that is imported.
+	maxNoteSize = 5 << 20
+	// maxAttachmentSize is the largest embedded image that is imported.
+	maxAttachmentSize = 10 << 20
+	// maxImportSize bounds everything read out of an archive, which can
+	// uncompress to far more than was uploaded.
+	maxImportSize = 1 << 30
+	// maxTitleLength is the longest note title, in characters.
+	maxTitleLength = 255
+	// maxTagLength is the longest tag name, in characters.
+	maxTagLength = 64
+)
+
+// ErrTooManyFiles is returned for an archive with more than maxVaultFiles files.
+var ErrTooManyFiles = fmt.Errorf("archive has more than %d files", maxVaultFiles)
+
+// ErrImportTooLarge is returned when an archive uncompresses to more than maxImportSize.
+var ErrImportTooLarge = errors.New("archive is too large once uncompressed")
+
+// imageTypes maps the file extensions that are imported as attachments to their content types.
+var imageTypes = map[string]string{
+	".png": "image/png", ".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".gif": "image/gif",
+	".webp": "image/webp", ".svg": "image/svg+xml", ".bmp": "image/bmp", ".avif": "image/avif",
+}
+
+// sizePattern matches the width, or width and height, Obsidian puts where an embed's label would be.
+var sizePattern = regexp.MustCompile(`^\d+(x\d+)?$`)
+
+// vaultNote is a Markdown file of the archive that has a note.
+type vaultNote struct {
+	path    string
+	noteID  int
+	body    string // The content before its links are rewritten
+	created bool   // False for notes an earlier import created, which are left as they are
+	report  types.ImportedFile
+}
+
+// vaultImport is an import of a Markdown archive in progress.
+type vaultImport struct {
+	imp    *db.NoteImport
+	files  map[string]*zip.File // Every file, by its path
+	byName map[string]string    // Lowercased file names to the first path with that name
+	read   int64                // Bytes read out of the archive so far
+
+	notes   []*vaultNote
+	byPath  map[string]*vaultNote // Lowercased paths without .md
+	byTitle map[string]*vaultNote // Lowercased file names without .md, and aliases
+
+	embedded    map[string]bool // Paths of files embedded in a note
+	attachments int
+}
+
+// ImportMarkdown imports a ZIP archive of Markdown files, such as an Obsidian
+// vault, as notes. Folders become notebooks inside notebookID, or at the top
+// level when it is nil. Front matter sets each note's title, tags and dates,
+// [[wikilinks]] and links to other files become links to their notes, and
+// embedded images become attachments. Files imported before, with the same
+// content, are skipped.
+func ImportMarkdown(store *db.ImportStore, userID int, notebookID *int, zr *zip.Reader) (*types.ImportReport, error) {
+	if len(zr.File) > maxVaultFiles {
+		return nil, ErrTooManyFiles
+	}
+	imp, err := store.BeginNoteImport(userID, notebookID)
+	if err != nil {
+		return nil, err
+	}
+	defer imp.Rollback()
+
+	v := &vaultImport{
+		imp:      imp,
+		files:    make(map[string]*zip.File),
+		byName:   make(map[string]string),
+		byPath:   make(map[string]*vaultNote),
+		byTitle:  make(map[string]*vaultNote),
+		embedded: make(map[string]bool),
+	}
+	var paths []string
+	for _, f := range zr.File {
+		name, ok := cleanPath(f.Name)
+		if !ok || f.FileInfo().IsDir() {
+			continue
+		}
+		if _, dup := v.files[name]; !dup {
+			paths = append(paths, name)
+		}
+		v.files[name] = f
+		if _, taken := v.byName[strings.ToLower(path.Base(name))]; !taken {
+			v.byName[strings.ToLower(path.Base(name))] = name
+		}
+	}
+	slices.Sort(paths)
+
+	report := &types.ImportReport{
+		Created: make([]types.ImportedFile, 0),
+		Skipped: make([]types.ImportedFile, 0),
+		Errors:  make([]types.ImportedFile, 0),
+	}
+
+	// Every note is created before any links are rewritten, so links can
+	// point forwards as well as back.
+	seen := make(map[string]string)
+	for _, name := range paths {
+		if !isMarkdown(name) {
+			continue
+		}
+		data, err := v.readFile(v.files[name], maxNoteSize)
+		if err != nil {
+			if errors.Is(err, ErrImportTooLarge) {
+				return nil, err
+			}
+			report.Errors = append(report.Errors, types.ImportedFile{Path: name, Reason: err.Error()})
+			continue
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		if first, ok := seen[hash]; ok {
+			report.Skipped = append(report.Skipped, types.ImportedFile{Path: name, Reason: "Same content as " + first})
+			continue
+		}
+		seen[hash] = name
+
+		noteID, ok, err := imp.ImportedNoteID(hash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			v.addNote(&vaultNote{path: name, noteID: noteID})
+			report.Skipped = append(report.Skipped, types.ImportedFile{Path: name, NoteID: &noteID, Reason: "Already imported"})
+			continue
+		}
+
+		note, reason, err := v.createNote(name, data, hash)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			report.Errors = append(report.Errors, types.ImportedFile{Path: name, Reason: reason})
+			continue
+		}
+		v.addNote(note)
+	}
+
+	for _, note := range v.notes {
+		if !note.created {
+			continue
+		}
+		content, err := v.rewriteLinks(note)
+		if err != nil {
+			return nil, err
+		}
+		if err := imp.FinishNote(note.noteID, content); err != nil {
+			return nil, err
+		}
+		report.Created = append(report.Created, note.report)
+	}
+
+	for _, name := range paths {
+		if !isMarkdown(name) && !v.embedded[name] {
+			report.Skipped = append(report.Skipped, types.ImportedFile{Path: name, Reason: "Not a Markdown file or an embedded image"})
+		}
+	}
+	report.NotebooksCreated = imp.NotebooksCreated
+	report.AttachmentsCreated = v.attachments
+	return report, imp.Commit()
+}
+
+// createNote creates the note for a Markdown file. A reason is returned
+// instead for files that can't be imported.
+func (v *vaultImport) createNote(name string, data []byte, hash string) (*vaultNote, string, error) {
+	if !utf8.Valid(data) {
+		return nil, "Not valid UTF-8 text", nil
+	}
+	fm, body, warnings := parseFrontMatter(string(data))
+
+	title := strings.TrimSpace(fm.Title)
+	if title == "" {
+		title = strings.TrimSuffix(path.Base(name), path.Ext(name))
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength])
+		warnings = append(warnings, "Title was shortened")
+	}
+	var tags []string
+	for _, tag := range fm.Tags {
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			warnings = append(warnings, "Tag is too long and was left out: "+tag)
+			continue
+		}
+		tags = append(tags, tag)
+	}
+
+	var folder []string
+	if dir := path.Dir(name); dir != "." {
+		for _, part := range strings.Split(dir, "/") {
+			if utf8.RuneCountInString(part) > maxTitleLength {
+				part = string([]rune(part)[:maxTitleLength])
+			}
+			folder = append(folder, part)
+		}
+	}
+	notebookID, err := v.imp.Notebook(folder)
+	if err != nil {
+		return nil, "", err
+	}
+	noteID, err := v.imp.CreateNote(db.ImportedNote{
+		NotebookID:  notebookID,
+		Title:       title,
+		Content:     body,
+		Tags:        tags,
+		CreatedAt:   fm.Created,
+		UpdatedAt:   fm.Updated,
+		SourcePath:  name,
+		ContentHash: hash,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	note := &vaultNote{path: name, noteID: noteID, body: body, created: true}
+	note.report = types.ImportedFile{Path: name, NoteID: &note.noteID, Title: title, Warnings: warnings}
+	for _, alias := range fm.Aliases {
+		if _, taken := v.byTitle[strings.ToLower(alias)]; !taken {
+			v.byTitle[strings.ToLower(alias)] = note
+		}
+	}
+	return note, "", nil
+}
+
+func (v *vaultImport) addNote(note *vaultNote) {
+	v.notes = append(v.notes, note)
+	key := strings.ToLower(strings.TrimSuffix(note.path, path.Ext(note.path)))
+	v.byPath[key] = note
+	if _, taken := v.byTitle[path.Base(key)]; !taken {
+		v.byTitle[path.Base(key)] = note
+	}
+}
+
+// rewriteLinks points a note's links at the notes the files they name were
+// imported as, and its embedded images at attachments, noting any it can't
+// resolve in the note's report.
+func (v *vaultImport) rewriteLinks(note *vaultNote) (string, error) {
+	dir := path.Dir(note.path)
+	attached := make(map[string]int)
+	warn := func(format string, args ...any) {
+		note.report.Warnings = append(note.report.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	var failure error
+	content := rewriteLinks(note.body, func(l link) (string, bool) {
+		if failure != nil {
+			return "", false
+		}
+		ext := strings.ToLower(path.Ext(l.target))
+		contentType, isImage := imageTypes[ext]
+		embed := l.kind == wikiEmbed || l.kind == mdImage
+
+		if embed && isImage {
+			name, ok := v.resolveFile(l.target, dir)
+			if !ok {
+				warn("Embedded image not found: %s", l.target)
+				return "", false
+			}
+			attachmentID, ok := attached[name]
+			if !ok {
+				data, err := v.readFile(v.files[name], maxAttachmentSize)
+				if errors.Is(err, ErrImportTooLarge) {
+					failure = err
+					return "", false
+				}
+				if err != nil {
+					warn("Embedded image %s: %v", name, err)
+					return "", false
+				}
+				if attachmentID, err = v.imp.AddAttachment(note.noteID, path.Base(name), contentType, data); err != nil {
+					failure = err
+					return "", false
+				}
+				attached[name] = attachmentID
+				v.embedded[name] = true
+				v.attachments++
+			}
+			alt := l.label
+			if alt == "" || sizePattern.MatchString(alt) {
+				alt = strings.TrimSuffix(path.Base(name), path.Ext(name))
+			}
+			return fmt.Sprintf("![%s](/api/notes/%d/attachments/%d)", escapeLabel(alt), note.noteID, attachmentID), true
+		}
+
+		// Anything else is a link to a note, unless it names some other kind of file.
+		if ext != "" && !isMarkdown(l.target) {
+			if l.kind == mdLink || l.kind == mdImage {
+				return "", false
+			}
+			if _, ok := v.resolveFile(l.target, dir); ok {
+				return "", false
+			}
+		}
+		target, ok := v.resolveNote(l.target, dir)
+		if !ok {
+			warn("Link to a note that isn't in the import: %s", l.target)
+			return "", false
+		}
+		label := l.label
+		if label == "" {
+			label = l.target
+			if isMarkdown(label) {
+				label = strings.TrimSuffix(label, path.Ext(label))
+			}
+			if l.heading != "" {
+				label += " > " + l.heading
+			}
+		}
+		return fmt.Sprintf("[%s](/notes/%d)", escapeLabel(label), target.noteID), true
+	})
+	return content, failure
+}
+
+// resolveNote finds the note a link names, as a path relative to the linking
+// note or to the top of the archive, by file name anywhere, or by alias.
+func (v *vaultImport) resolveNote(target, dir string) (*vaultNote, bool) {
+	key := strings.ToLower(target)
+	if isMarkdown(key) {
+		key = strings.TrimSuffix(key, path.Ext(key))
+	}
+	for _, candidate := range []string{path.Join(strings.ToLower(dir), key), path.Clean(key)} {
+		if note, ok := v.byPath[candidate]; ok {
+			return note, true
+		}
+	}
+	if note, ok := v.byTitle[path.Base(key)]; ok && !strings.Contains(key, "/") {
+		return note, true
+	}
+	note, ok := v.byTitle[strings.ToLower(target)]
+	return note, ok
+}
+
+// resolveFile finds the file an embed names, as a path relative to the
+// embedding note or to the top of the archive, or by file name anywhere.
+func (v *vaultImport) resolveFile(target, dir string) (string, bool) {
+	for _, candidate := range []string{path.Join(dir, target), path.Clean(target)} {
+		if _, ok := v.files[candidate]; ok {
+			return candidate, true
+		}
+	}
+	name, ok := v.byName[strings.ToLower(path.Base(target))]
+	return name, ok
+}
+
+// readFile reads a file of the archive, refusing ones larger than limit.
+func (v *vaultImport) readFile(f *zip.File, limit int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("larger than %d MB", limit>>20)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("could not be read: %w", err)
+	}
+	defer rc.Close()
+
+	// The sizes in the archive can't be trusted, so the reads are bounded too.
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not be read: %w", err)
+	}
+	v.read += int64(len(data))
+	if v.read > maxImportSize {
+		return nil, ErrImportTooLarge
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("larger than %d MB", limit>>20)
+	}
+	return data, nil
+}
+
+// cleanPath normalizes a path in an archive. ok is false for hidden files and
+// folders, such as .obsidian settings, and the __MACOSX folders macOS adds.
+func cleanPath(name string) (string, bool) {
+	name = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, `\`, "/")), "/")
+	if name == "" {
+		return "", false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+func isMarkdown(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".md" || ext == ".markdown"
+}
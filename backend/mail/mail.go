@@ -0,0 +1,101 @@
+// Package mail sends email: through an SMTP relay in production, or into a
+// directory or the log during development.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message is an email with a plain-text body and, optionally, an HTML
+// alternative.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends email.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// FromEnv configures a mailer from MAIL_DRIVER: "smtp" (see NewSMTPMailerFromEnv),
+// "file", which writes each message into MAIL_DIR, or "log". Without
+// MAIL_DRIVER it uses SMTP when SMTP_HOST is set and returns nil otherwise,
+// leaving email disabled.
+func FromEnv() (Mailer, error) {
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "":
+		if m := NewSMTPMailerFromEnv(); m != nil {
+			return m, nil
+		}
+		return nil, nil
+	case "smtp":
+		if m := NewSMTPMailerFromEnv(); m != nil {
+			return m, nil
+		}
+		return nil, fmt.Errorf("MAIL_DRIVER is smtp but SMTP_HOST is not set")
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("MAIL_DRIVER is file but MAIL_DIR is not set")
+		}
+		return NewFileMailer(dir)
+	case "log":
+		return LogMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
+
+// format renders msg as an RFC 5322 message from the given sender, as
+// multipart/alternative when it has an HTML body.
+func format(from string, msg Message) ([]byte, error) {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(from, "\r\n") {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		writePart(&b, msg.Text)
+		return b.Bytes(), nil
+	}
+
+	boundary := make([]byte, 12)
+	if _, err := rand.Read(boundary); err != nil {
+		return nil, err
+	}
+	sep := "tempo-" + hex.EncodeToString(boundary)
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", sep)
+	fmt.Fprintf(&b, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n", sep)
+	writePart(&b, msg.Text)
+	fmt.Fprintf(&b, "\r\n--%s\r\nContent-Type: text/html; charset=utf-8\r\n", sep)
+	writePart(&b, msg.HTML)
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", sep)
+	return b.Bytes(), nil
+}
+
+// writePart writes a quoted-printable body, which keeps lines short enough for
+// any relay whatever the content.
+func writePart(b *bytes.Buffer, body string) {
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(b)
+	w.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")))
+	w.Close()
+}
@@ -0,0 +1,184 @@
+// Package mailtest runs an SMTP server in process for tests, catching what is
+// sent to it instead of delivering it.
+package mailtest
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Message is an email as the server received it.
+type Message struct {
+	From string
+	To   []string
+	Data []byte // The message as sent after DATA, with dot-stuffing undone
+}
+
+// Header parses the message's headers.
+func (m Message) Header() (netmail.Header, error) {
+	msg, err := netmail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		return nil, err
+	}
+	return msg.Header, nil
+}
+
+// Text returns the message's plain-text body, decoded, whether it is the whole
+// message or one part of a multipart one.
+func (m Message) Text() (string, error) {
+	msg, err := netmail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		return "", err
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return decode(msg.Body, msg.Header.Get("Content-Transfer-Encoding"))
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		// NextPart undoes quoted-printable itself.
+		part, err := parts.NextPart()
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(part.Header.Get("Content-Type"), "text/plain") {
+			body, err := io.ReadAll(part)
+			return string(body), err
+		}
+	}
+}
+
+func decode(body io.Reader, encoding string) (string, error) {
+	if strings.EqualFold(encoding, "quoted-printable") {
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	return string(data), err
+}
+
+// Server is an SMTP server on a local port. It speaks just enough SMTP for
+// net/smtp's client, offering neither STARTTLS nor AUTH, and accepts every
+// message.
+type Server struct {
+	// Host and Port are where the server listens, as SMTP_HOST and SMTP_PORT
+	// would give them.
+	Host, Port string
+
+	listener net.Listener
+	messages chan Message
+}
+
+// NewServer starts a server that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("mailtest: listening: %v", err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	s := &Server{Host: host, Port: port, listener: listener, messages: make(chan Message, 16)}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+// Receive waits up to timeout for the next message, reporting false if none
+// arrived.
+func (s *Server) Receive(timeout time.Duration) (Message, bool) {
+	select {
+	case msg := <-s.messages:
+		return msg, true
+	case <-time.After(timeout):
+		return Message{}, false
+	}
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle runs one SMTP session (RFC 5321).
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 mailtest ESMTP")
+	var msg Message
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250 mailtest")
+		case "MAIL":
+			msg = Message{From: address(arg)}
+			reply("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, address(arg))
+			reply("250 OK")
+		case "DATA":
+			if len(msg.To) == 0 {
+				reply("503 No recipients")
+				continue
+			}
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data bytes.Buffer
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			msg.Data = data.Bytes()
+			s.messages <- msg
+			msg = Message{}
+			reply("250 OK")
+		case "RSET":
+			msg = Message{}
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// address takes the address out of a MAIL FROM:<...> or RCPT TO:<...>
+// argument.
+func address(arg string) string {
+	_, addr, _ := strings.Cut(arg, ":")
+	addr, _, _ = strings.Cut(strings.TrimSpace(addr), " ")
+	return strings.Trim(addr, "<>")
+}
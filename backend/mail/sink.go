@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// devSender is the From address of mail that never leaves the machine.
+const devSender = "tempo@localhost"
+
+// FileMailer writes each message into a directory as an .eml file, for
+// development: open them in a mail client, or have a test read them.
+type FileMailer struct {
+	dir string
+	seq atomic.Int64
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	data, err := format(devSender, msg)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().UTC().Format("20060102T150405.000"), m.seq.Add(1))
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0o644)
+}
+
+// LogMailer writes the plain-text part of each message to the log, for
+// development.
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
@@ -0,0 +1,67 @@
+package mail
+
+import (
+	"mime"
+	"strings"
+	"testing"
+	"time"
+
+	"tempo-backend/mail/mailtest"
+)
+
+func TestSMTPMailerSends(t *testing.T) {
+	server := mailtest.NewServer(t)
+	t.Setenv("MAIL_DRIVER", "")
+	t.Setenv("SMTP_HOST", server.Host)
+	t.Setenv("SMTP_PORT", server.Port)
+	t.Setenv("SMTP_FROM", "tempo@example.com")
+	t.Setenv("SMTP_USERNAME", "")
+
+	mailer, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mailer.(*SMTPMailer); !ok {
+		t.Fatalf("FromEnv with SMTP_HOST set returned %T", mailer)
+	}
+
+	// A line starting with a dot has to survive dot-stuffing, and a long one
+	// quoted-printable's line wrapping.
+	text := "Hi Ada,\n.hidden dot\n" + strings.Repeat("long line ", 20) + "\n"
+	err = mailer.Send(Message{To: "ada@example.com", Subject: "Grüße from Tempo", Text: text, HTML: "<p>Hi Ada</p>"})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	msg, ok := server.Receive(5 * time.Second)
+	if !ok {
+		t.Fatal("no message arrived")
+	}
+	if msg.From != "tempo@example.com" || len(msg.To) != 1 || msg.To[0] != "ada@example.com" {
+		t.Errorf("envelope is from %q to %q", msg.From, msg.To)
+	}
+	header, err := msg.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil || subject != "Grüße from Tempo" {
+		t.Errorf("subject is %q, %v", subject, err)
+	}
+	if got := header.Get("From"); got != "tempo@example.com" {
+		t.Errorf("From header is %q", got)
+	}
+	got, err := msg.Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.ReplaceAll(text, "\n", "\r\n"); got != want {
+		t.Errorf("text body is %q, want %q", got, want)
+	}
+}
+
+func TestFormatRejectsHeaderInjection(t *testing.T) {
+	if _, err := format("tempo@example.com", Message{To: "ada@example.com\r\nBcc: eve@example.com"}); err == nil {
+		t.Error("format accepted a recipient with a line break")
+	}
+}
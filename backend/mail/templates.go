@@ -0,0 +1,72 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Each email is a pair of templates in templates/: name.txt defines "subject"
+// and "text", and name.html is the HTML body. Both are given the same data.
+//
+//go:embed templates
+var templateFS embed.FS
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var templates = loadTemplates()
+
+func loadTemplates() map[string]emailTemplate {
+	entries, err := templateFS.ReadDir("templates")
+	if err != nil {
+		panic(err)
+	}
+	loaded := make(map[string]emailTemplate)
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".txt")
+		if !ok {
+			continue
+		}
+		// Parsed one email at a time, since every text template defines the same names.
+		loaded[name] = emailTemplate{
+			text: texttemplate.Must(texttemplate.ParseFS(templateFS, path.Join("templates", name+".txt"))),
+			html: htmltemplate.Must(htmltemplate.ParseFS(templateFS, path.Join("templates", name+".html"))),
+		}
+	}
+	return loaded
+}
+
+// Render fills in the named email for the recipient.
+func Render(name, to string, data any) (Message, error) {
+	msg := Message{To: to}
+	t, ok := templates[name]
+	if !ok {
+		return msg, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var b bytes.Buffer
+	if err := t.text.ExecuteTemplate(&b, "subject", data); err != nil {
+		return msg, err
+	}
+	msg.Subject = strings.TrimSpace(b.String())
+
+	b.Reset()
+	if err := t.text.ExecuteTemplate(&b, "text", data); err != nil {
+		return msg, err
+	}
+	msg.Text = strings.TrimSpace(b.String()) + "\n"
+
+	b.Reset()
+	if err := t.html.Execute(&b, data); err != nil {
+		return msg, err
+	}
+	msg.HTML = b.String()
+	return msg, nil
+}
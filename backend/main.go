@@ -5,14 +5,23 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	_ "time/tzdata" // Reminder timezones are validated against the IANA database, which the host may lack
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 
+	"tempo-backend/accounts"
 	"tempo-backend/api"
 	"tempo-backend/db"
+	"tempo-backend/events"
+	"tempo-backend/exports"
+	"tempo-backend/mail"
+	"tempo-backend/oidc"
+	"tempo-backend/reminders"
+	"tempo-backend/types"
 )
 
 func main() {
@@ -40,7 +49,37 @@ func main() {
 
 	// Initialize stores and handlers
 	userStore := db.NewUserStore(dbpool)
-	userHandler := api.NewUserHandler(userStore)
+	sessionStore := db.NewSessionStore(dbpool)
+	mailer, err := mail.FromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure mail: %v\n", err)
+	}
+	// Account emails can't be turned off, so without a mail driver they go to the log.
+	accountMailer := mailer
+	if accountMailer == nil {
+		log.Println("No mail driver configured; account emails will be logged")
+		accountMailer = mail.LogMailer{}
+	}
+	userHandler := api.NewUserHandler(userStore, sessionStore, accountMailer)
+	// Identity providers send users back to the API at its public URL.
+	apiURL := strings.TrimSuffix(os.Getenv("API_URL"), "/")
+	if apiURL == "" {
+		apiURL = "http://localhost:8080"
+	}
+	oidcProviders, err := oidc.ProvidersFromEnv(func(name string) string {
+		return apiURL + "/api/users/oidc/" + name + "/callback"
+	})
+	if err != nil {
+		log.Fatalf("Unable to configure identity providers: %v\n", err)
+	}
+	oidcHandler := api.NewOIDCHandler(userHandler, oidcProviders)
+	authMiddleware := api.JWTAuthMiddleware(sessionStore)
+	// Routes that scripts may use also take personal access tokens with the scopes for their resources.
+	accessTokenStore := db.NewAccessTokenStore(dbpool)
+	accessTokenHandler := api.NewAccessTokenHandler(accessTokenStore)
+	scopedAuth := func(resources ...string) echo.MiddlewareFunc {
+		return api.ScopedAuthMiddleware(sessionStore, accessTokenStore, resources...)
+	}
 
 	todoStore := db.NewTodoStore(dbpool)
 	todoHandler := api.NewTodoHandler(todoStore)
@@ -48,18 +87,70 @@ func main() {
 	noteStore := db.NewNoteStore(dbpool)
 	noteHandler := api.NewNoteHandler(noteStore)
 
+	importStore := db.NewImportStore(dbpool)
+	importHandler := api.NewImportHandler(importStore)
+
+	// Calendar apps subscribe to a secret feed URL on the API.
+	calendarStore := db.NewCalendarStore(dbpool)
+	calendarHandler := api.NewCalendarHandler(calendarStore, apiURL)
+
+	notebookStore := db.NewNotebookStore(dbpool)
+	notebookHandler := api.NewNotebookHandler(notebookStore)
+
+	tagStore := db.NewTagStore(dbpool)
+	tagHandler := api.NewTagHandler(tagStore)
+
 	journalStore := db.NewJournalStore(dbpool)
 	journalHandler := api.NewJournalHandler(journalStore)
 
+	searchStore := db.NewSearchStore(dbpool)
+	searchHandler := api.NewSearchHandler(searchStore)
+
+	syncStore := db.NewSyncStore(dbpool)
+	syncHandler := api.NewSyncHandler(syncStore, todoStore, noteStore, journalStore)
+
+	notificationStore := db.NewNotificationStore(dbpool)
+	notificationHandler := api.NewNotificationHandler(notificationStore)
+
+	reminderStore := db.NewReminderStore(dbpool)
+	reminderHandler := api.NewReminderHandler(reminderStore)
+
+	// Reminders are delivered in the background. Email is only offered once a mail driver is configured.
+	reminderChannels := map[string]reminders.Channel{
+		types.ReminderChannelInApp:   reminders.NewInAppChannel(notificationStore),
+		types.ReminderChannelWebhook: reminders.NewWebhookChannel(),
+	}
+	if mailer != nil {
+		reminderChannels[types.ReminderChannelEmail] = reminders.NewEmailChannel(mailer)
+	}
+	go reminders.NewScheduler(reminderStore, reminderChannels).Run(context.Background())
+	go accounts.NewPurger(userStore).Run(context.Background())
+
+	// Data exports are built in the background too.
+	exportStore := db.NewExportStore(dbpool)
+	exportWorker := exports.NewWorker(exportStore)
+	go exportWorker.Run(context.Background())
+	exportHandler := api.NewExportHandler(exportStore, exportWorker)
+
+	hub := events.NewHub()
+	go hub.Run(context.Background(), syncStore.ListenForChanges)
+	eventHandler := api.NewEventHandler(hub, syncStore, sessionStore, accessTokenStore)
+
 	// Initialize Echo
 	e := echo.New()
 	e.Use(middleware.Logger())
+	e.Use(api.RedactAccessToken) // Keep ?access_token= on the event routes out of the log
 	e.Use(middleware.Recover())
 
 	// Add CORS Middleware
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"http://localhost:3000"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization,
+			"If-Match", "If-None-Match"},
+		// Let the web app read entity tags, to send them back in If-Match, and next-page links.
+		ExposeHeaders: []string{"ETag", "Link"},
+		// Linking an identity sets a cookie, which browsers only keep from credentialed requests.
+		AllowCredentials: true,
 	}))
 
 
@@ -70,40 +161,168 @@ func main() {
 	userGroup := apiGroup.Group("/users")
 	userGroup.POST("/register", userHandler.HandleRegisterUser)
 	userGroup.POST("/login", userHandler.HandleLoginUser)
+	userGroup.POST("/refresh", userHandler.HandleRefreshToken)
+
+	// Email verification, password reset and two-factor login (public, rate limited)
+	authRateLimiter := api.AuthRateLimiter()
+	userGroup.POST("/verify-email", userHandler.HandleVerifyEmail, authRateLimiter)
+	userGroup.POST("/verify-email/resend", userHandler.HandleResendVerification, authMiddleware, authRateLimiter)
+	userGroup.POST("/password/forgot", userHandler.HandleForgotPassword, authRateLimiter)
+	userGroup.POST("/password/reset", userHandler.HandleResetPassword, authRateLimiter)
+	userGroup.POST("/email/confirm", userHandler.HandleConfirmEmailChange, authRateLimiter)
+	userGroup.POST("/login/2fa", userHandler.HandleLoginTwoFactor, authRateLimiter)
+
+	// Identity provider login (public)
+	userGroup.GET("/oidc/providers", oidcHandler.HandleGetProviders)
+	userGroup.GET("/oidc/:provider/login", oidcHandler.HandleStartLogin, authRateLimiter)
+	userGroup.GET("/oidc/:provider/callback", oidcHandler.HandleCallback)
+	userGroup.POST("/oidc/exchange", oidcHandler.HandleExchange, authRateLimiter)
+
+	// Session routes (protected)
+	userGroup.POST("/logout", userHandler.HandleLogout, authMiddleware)
+	userGroup.GET("/sessions", userHandler.HandleGetSessions, authMiddleware)
+	userGroup.DELETE("/sessions/:id", userHandler.HandleRevokeSession, authMiddleware)
+
+	// Two-factor authentication routes (protected)
+	userGroup.GET("/2fa", userHandler.HandleGetTwoFactor, authMiddleware)
+	userGroup.POST("/2fa/setup", userHandler.HandleSetupTwoFactor, authMiddleware)
+	userGroup.POST("/2fa/verify", userHandler.HandleVerifyTwoFactor, authMiddleware, authRateLimiter)
+	userGroup.DELETE("/2fa", userHandler.HandleDisableTwoFactor, authMiddleware, authRateLimiter)
+	userGroup.POST("/2fa/recovery-codes", userHandler.HandleRegenerateRecoveryCodes, authMiddleware, authRateLimiter)
+
+	// Account routes (protected)
+	userGroup.GET("/me", userHandler.HandleGetProfile, authMiddleware)
+	userGroup.PATCH("/me", userHandler.HandleUpdateProfile, authMiddleware)
+	userGroup.DELETE("/me", userHandler.HandleDeleteAccount, authMiddleware, authRateLimiter)
+	userGroup.DELETE("/me/deletion", userHandler.HandleCancelDeletion, authMiddleware)
+	userGroup.PUT("/me/password", userHandler.HandleChangePassword, authMiddleware, authRateLimiter)
+
+	// Preference routes (protected)
+	userGroup.GET("/me/search-language", userHandler.HandleGetSearchLanguage, authMiddleware)
+	userGroup.PUT("/me/search-language", userHandler.HandleSetSearchLanguage, authMiddleware)
+	userGroup.GET("/me/revision-retention", userHandler.HandleGetRevisionRetention, authMiddleware)
+	userGroup.PUT("/me/revision-retention", userHandler.HandleSetRevisionRetention, authMiddleware)
+	userGroup.GET("/me/reminder-webhook", userHandler.HandleGetReminderWebhook, authMiddleware)
+	userGroup.PUT("/me/reminder-webhook", userHandler.HandleSetReminderWebhook, authMiddleware)
+	userGroup.DELETE("/me/reminder-webhook", userHandler.HandleDeleteReminderWebhook, authMiddleware)
+	userGroup.GET("/me/tokens", accessTokenHandler.HandleGetAccessTokens, authMiddleware)
+	userGroup.POST("/me/tokens", accessTokenHandler.HandleCreateAccessToken, authMiddleware)
+	userGroup.DELETE("/me/tokens/:tokenId", accessTokenHandler.HandleDeleteAccessToken, authMiddleware)
+	userGroup.GET("/me/identities", oidcHandler.HandleGetIdentities, authMiddleware)
+	userGroup.POST("/me/identities/:provider", oidcHandler.HandleLinkIdentity, authMiddleware)
+	userGroup.DELETE("/me/identities/:identityId", oidcHandler.HandleDeleteIdentity, authMiddleware)
+	userGroup.GET("/me/calendar-feed", calendarHandler.HandleGetCalendarFeed, authMiddleware)
+	userGroup.POST("/me/calendar-feed", calendarHandler.HandleCreateCalendarFeed, authMiddleware)
+	userGroup.DELETE("/me/calendar-feed", calendarHandler.HandleDeleteCalendarFeed, authMiddleware)
 
 	// To-Do List routes (protected)
 	listGroup := apiGroup.Group("/lists")
-	listGroup.Use(api.JWTAuthMiddleware) // Apply the middleware to all routes in this group
+	listGroup.Use(scopedAuth("todos")) // Apply the middleware to all routes in this group
 	listGroup.POST("", todoHandler.HandleCreateTodoList)
 	listGroup.GET("", todoHandler.HandleGetTodoLists)
+	listGroup.POST("/reorder", todoHandler.HandleReorderTodoLists)
 	listGroup.GET("/:listId", todoHandler.HandleGetTodoListAndItems)
+	listGroup.PUT("/:listId", todoHandler.HandleUpdateTodoList)
 	listGroup.DELETE("/:listId", todoHandler.HandleDeleteTodoList)
 	listGroup.POST("/:listId/items", todoHandler.HandleCreateTodoItem)
+	listGroup.POST("/:listId/items/reorder", todoHandler.HandleMoveTodoItem)
 
 	// To-Do Item routes (protected)
 	itemGroup := apiGroup.Group("/items")
-	itemGroup.Use(api.JWTAuthMiddleware)
+	itemGroup.Use(scopedAuth("todos"))
+	itemGroup.GET("/:itemId", todoHandler.HandleGetTodoItem)
 	itemGroup.PUT("/:itemId", todoHandler.HandleUpdateTodoItem)
 	itemGroup.DELETE("/:itemId", todoHandler.HandleDeleteTodoItem)
+	itemGroup.POST("/:itemId/skip", todoHandler.HandleSkipTodoItemOccurrence)
+	itemGroup.DELETE("/:itemId/recurrence", todoHandler.HandleEndTodoItemRecurrence)
+	itemGroup.GET("/:itemId/occurrences", todoHandler.HandleGetTodoItemOccurrences)
+	itemGroup.GET("/:itemId/reminders", reminderHandler.HandleGetReminders)
+	itemGroup.POST("/:itemId/reminders", reminderHandler.HandleCreateReminder)
+	itemGroup.DELETE("/:itemId/reminders/:reminderId", reminderHandler.HandleDeleteReminder)
+
+	// Task view routes (protected): items across all of the user's lists
+	apiGroup.GET("/tasks", todoHandler.HandleGetTasks, scopedAuth("todos"))
+
+	// Notification routes (protected)
+	notificationGroup := apiGroup.Group("/notifications")
+	notificationGroup.Use(scopedAuth("todos"))
+	notificationGroup.GET("", notificationHandler.HandleGetNotifications)
+	notificationGroup.POST("/read", notificationHandler.HandleMarkAllNotificationsRead)
+	notificationGroup.POST("/:notificationId/read", notificationHandler.HandleMarkNotificationRead)
 
 	// Notes routes (protected)
 	noteGroup := apiGroup.Group("/notes")
-	noteGroup.Use(api.JWTAuthMiddleware)
+	noteGroup.Use(scopedAuth("notes"))
 	noteGroup.POST("", noteHandler.HandleCreateNote)
 	noteGroup.GET("", noteHandler.HandleGetNotes)
 	noteGroup.GET("/:noteId", noteHandler.HandleGetNote)
 	noteGroup.PUT("/:noteId", noteHandler.HandleUpdateNote)
 	noteGroup.DELETE("/:noteId", noteHandler.HandleDeleteNote)
+	noteGroup.GET("/:noteId/revisions", noteHandler.HandleGetNoteRevisions)
+	noteGroup.GET("/:noteId/revisions/diff", noteHandler.HandleDiffNoteRevisions)
+	noteGroup.GET("/:noteId/revisions/:revId", noteHandler.HandleGetNoteRevision)
+	noteGroup.POST("/:noteId/revisions/:revId/restore", noteHandler.HandleRestoreNoteRevision)
+	// Attachments are loaded by <img> tags, which can't set headers.
+	apiGroup.GET("/notes/:noteId/attachments/:attachmentId", noteHandler.HandleGetNoteAttachment, api.TokenFromQuery, scopedAuth("notes"))
+
+	// Notebook routes (protected)
+	notebookGroup := apiGroup.Group("/notebooks")
+	notebookGroup.Use(scopedAuth("notes"))
+	notebookGroup.POST("", notebookHandler.HandleCreateNotebook)
+	notebookGroup.GET("", notebookHandler.HandleGetNotebooks)
+	notebookGroup.GET("/:notebookId", notebookHandler.HandleGetNotebook)
+	notebookGroup.PUT("/:notebookId", notebookHandler.HandleUpdateNotebook)
+	notebookGroup.DELETE("/:notebookId", notebookHandler.HandleDeleteNotebook)
+
+	// Tag routes (protected)
+	tagGroup := apiGroup.Group("/tags")
+	tagGroup.Use(scopedAuth("tags"))
+	tagGroup.POST("", tagHandler.HandleCreateTag)
+	tagGroup.GET("", tagHandler.HandleGetTags)
+	tagGroup.PUT("/:tagId", tagHandler.HandleUpdateTag)
+	tagGroup.DELETE("/:tagId", tagHandler.HandleDeleteTag)
 
 	// Journal routes (protected)
 	journalGroup := apiGroup.Group("/journal")
-	journalGroup.Use(api.JWTAuthMiddleware)
+	journalGroup.Use(scopedAuth("journal"))
 	journalGroup.POST("", journalHandler.HandleCreateJournalEntry)
 	journalGroup.GET("", journalHandler.HandleGetJournalEntries)
 	journalGroup.GET("/:entryId", journalHandler.HandleGetJournalEntry)
 	journalGroup.PUT("/:entryId", journalHandler.HandleUpdateJournalEntry)
 	journalGroup.DELETE("/:entryId", journalHandler.HandleDeleteJournalEntry)
 
+	// Search routes (protected)
+	searchGroup := apiGroup.Group("/search")
+	searchGroup.Use(scopedAuth("todos", "notes", "journal"))
+	searchGroup.GET("", searchHandler.HandleSearch)
+
+	// Sync routes (protected)
+	syncGroup := apiGroup.Group("/sync")
+	syncGroup.Use(scopedAuth("todos", "notes", "journal"))
+	syncGroup.GET("", syncHandler.HandleSyncPull)
+	syncGroup.POST("", syncHandler.HandleSyncPush)
+
+	// Event stream routes (protected). Browsers can't set headers on these
+	// requests, so the access token may also come as ?access_token=.
+	eventGroup := apiGroup.Group("/events")
+	eventGroup.Use(api.TokenFromQuery, scopedAuth("todos", "notes", "journal"))
+	eventGroup.GET("", eventHandler.HandleEvents)
+	eventGroup.GET("/ws", eventHandler.HandleEventsWebSocket)
+
+	// Import routes (protected)
+	importGroup := apiGroup.Group("/import")
+	importGroup.POST("/markdown", importHandler.HandleImportMarkdown, scopedAuth("notes"))
+	importGroup.POST("/ics", importHandler.HandleImportCalendar, scopedAuth("todos"))
+
+	// Data export routes (protected). They cover the whole account, so personal access tokens can't use them.
+	exportGroup := apiGroup.Group("/export")
+	exportGroup.Use(authMiddleware)
+	exportGroup.POST("", exportHandler.HandleCreateExport)
+	exportGroup.GET("/:exportId", exportHandler.HandleGetExport)
+
+	// Calendar feed (public). The secret token in the URL stands in for a login.
+	e.GET("/ical/:token/tasks.ics", calendarHandler.HandleCalendarFeed)
+
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -0,0 +1,303 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testClientID = "tempo-test"
+
+// mockProvider is an identity provider serving discovery, JWKS and token
+// endpoints. It remembers the PKCE challenge and nonce of the last
+// authorization request and answers the code "good-code" with an ID token for
+// it, signed with the signing key.
+type mockProvider struct {
+	*httptest.Server
+	t *testing.T
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PrivateKey // Published in the JWKS
+	signingID string
+	signing   *rsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    func(jwt.MapClaims) // Changes the next ID tokens' claims
+	issuer    string              // Overrides the issuer in the discovery document
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	m := &mockProvider{t: t, keys: make(map[string]*rsa.PrivateKey)}
+	m.rotate("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := m.URL
+		if m.issuer != "" {
+			issuer = m.issuer
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		var keys []map[string]string
+		for kid, key := range m.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// rotate publishes a new key under kid and signs with it from now on.
+func (m *mockProvider) rotate(kid string) {
+	key := m.signWith(kid)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[kid] = key
+}
+
+// signWith signs ID tokens with a new key with the given ID, which isn't
+// published.
+func (m *mockProvider) signWith(kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signingID, m.signing = kid, key
+	return key
+}
+
+// authorize plays the user's browser at the authorization endpoint: it notes
+// the challenge and nonce the provider would keep with the code.
+func (m *mockProvider) authorize(authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" {
+		m.t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.challenge, m.nonce = q.Get("code_challenge"), q.Get("nonce")
+}
+
+func (m *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	if r.FormValue("grant_type") != "authorization_code" || r.FormValue("code") != "good-code" {
+		fail("invalid_grant")
+		return
+	}
+	if r.FormValue("client_id") != testClientID {
+		fail("invalid_client")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != m.challenge {
+		fail("invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            m.URL,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          m.nonce,
+		"email":          "ada@example.com",
+		"email_verified": true,
+	}
+	if m.claims != nil {
+		m.claims(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.signingID
+	signed, err := token.SignedString(m.signing)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": signed})
+}
+
+// login runs a login against the mock provider as the login handlers do,
+// exchanging the code with the given verifier and nonce; empty ones mean the
+// login's own.
+func login(t *testing.T, p *Provider, m *mockProvider, verifier, nonce string) (*Claims, error) {
+	t.Helper()
+	ctx := context.Background()
+	realVerifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	realNonce, _ := RandomString()
+	authURL, err := p.AuthCodeURL(ctx, "state", realNonce, challenge)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	m.authorize(authURL)
+	if verifier == "" {
+		verifier = realVerifier
+	}
+	if nonce == "" {
+		nonce = realNonce
+	}
+	return p.Exchange(ctx, "good-code", verifier, nonce)
+}
+
+func newTestProvider(t *testing.T, m *mockProvider) *Provider {
+	t.Helper()
+	p, err := NewProvider(Config{Name: "mock", Issuer: m.URL, ClientID: testClientID,
+		RedirectURL: "http://localhost/api/users/oidc/mock/callback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+
+	claims, err := login(t, p, m, "", "")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "ada@example.com" || !claims.EmailVerified {
+		t.Errorf("got claims %+v", claims)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		claims   func(jwt.MapClaims)
+	}{
+		{name: "wrong nonce", nonce: "someone-elses-nonce"},
+		{name: "wrong PKCE verifier", verifier: "not-the-verifier"},
+		{name: "wrong audience", claims: func(c jwt.MapClaims) { c["aud"] = "another-client" }},
+		{name: "another party among several audiences", claims: func(c jwt.MapClaims) {
+			c["aud"] = []string{testClientID, "another-client"}
+			c["azp"] = "another-client"
+		}},
+		{name: "wrong issuer", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "no subject", claims: func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.claims = tt.claims
+			p := newTestProvider(t, m)
+			if claims, err := login(t, p, m, tt.verifier, tt.nonce); err == nil {
+				t.Errorf("login succeeded with claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestExchangeAcceptsAuthorizedPartyAmongSeveralAudiences(t *testing.T) {
+	m := newMockProvider(t)
+	m.claims = func(c jwt.MapClaims) {
+		c["aud"] = []string{testClientID, "another-client"}
+		c["azp"] = testClientID
+	}
+	if _, err := login(t, newTestProvider(t, m), m, "", ""); err != nil {
+		t.Errorf("login: %v", err)
+	}
+}
+
+// TestExchangeRejectsUnknownKey checks that a token signed with a key the
+// provider never published is refused, even once the keys are fetched again.
+func TestExchangeRejectsUnknownKey(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+	m.signWith("stray")
+
+	_, err := login(t, p, m, "", "")
+	if err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Errorf("login with an unpublished key: got %v, want an unknown signing key error", err)
+	}
+}
+
+// TestExchangeFollowsKeyRotation checks that a token signed with a newly
+// published key is accepted once the key set may be fetched again, and that
+// unknown keys don't make it be fetched more than once a minute.
+func TestExchangeFollowsKeyRotation(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+	if _, err := login(t, p, m, "", ""); err != nil {
+		t.Fatalf("login with the first key: %v", err)
+	}
+
+	m.rotate("key-2")
+	if _, err := login(t, p, m, "", ""); err == nil {
+		t.Fatal("the key set was fetched again within a minute")
+	}
+
+	p.keys.mu.Lock()
+	p.keys.fetchedAt = p.keys.fetchedAt.Add(-minKeyRefresh)
+	p.keys.mu.Unlock()
+	if _, err := login(t, p, m, "", ""); err != nil {
+		t.Fatalf("login with the rotated key: %v", err)
+	}
+
+	// Tokens signed with the old key are still good while it is published.
+	m.mu.Lock()
+	m.signingID, m.signing = "key-1", m.keys["key-1"]
+	m.mu.Unlock()
+	if _, err := login(t, p, m, "", ""); err != nil {
+		t.Errorf("login with the first key after rotation: %v", err)
+	}
+}
+
+func TestDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	m := newMockProvider(t)
+	m.issuer = "https://evil.example.com"
+	p := newTestProvider(t, m)
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "challenge"); err == nil {
+		t.Error("a discovery document for another issuer was accepted")
+	}
+}
@@ -0,0 +1,151 @@
+// Package rank generates lexicographic ranks: strings that sort items in a
+// chosen order and always leave room for another rank between any two, so an
+// item can be moved by rewriting its own rank alone.
+package rank
+
+import (
+	"errors"
+	"strings"
+)
+
+// digits are the characters ranks are made of, in byte order, so ranks
+// compare correctly as plain strings (and under the C collation in Postgres).
+// A rank reads as the digits of a fraction between 0 and 1, so "V" is about
+// a half. Ranks never end in the zero digit, which keeps room before each one.
+const digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const base = len(digits)
+
+// MaxLength is how long a rank may grow before the ranks of its list should
+// be spread out again.
+const MaxLength = 32
+
+// ErrInvalid is returned for ranks that contain other characters or end in
+// the zero digit, or bounds that are out of order.
+var ErrInvalid = errors.New("invalid rank")
+
+// Between returns a rank that sorts after a and before b. Either may be empty
+// for no bound, so Between("", "") is a first rank. Ranks added at either end
+// step one digit past the bound instead of halving the gap, which keeps them
+// short when items are added at the end of a list one after another.
+func Between(a, b string) (string, error) {
+	if !valid(a) || !valid(b) || (a != "" && b != "" && a >= b) {
+		return "", ErrInvalid
+	}
+	switch {
+	case b == "":
+		return after(a), nil
+	case a == "":
+		return before(b), nil
+	}
+	return midpoint(a, b), nil
+}
+
+// Spread returns n ranks in order, evenly spaced with room for many more
+// between each of them, for giving a whole list short ranks again.
+func Spread(n int) []string {
+	width, size := 1, base
+	for size < (n+1)*base {
+		width++
+		size *= base
+	}
+	ranks := make([]string, n)
+	for i := range ranks {
+		ranks[i] = strings.TrimRight(format((i+1)*size/(n+1), width), digits[:1])
+	}
+	return ranks
+}
+
+func after(a string) string {
+	if a == "" {
+		return digits[base/2 : base/2+1]
+	}
+	if v := value(a[0]); v+1 < base {
+		return digits[v+1 : v+2]
+	}
+	return a[:1] + after(a[1:])
+}
+
+func before(b string) string {
+	switch v := value(b[0]); {
+	case v > 1:
+		return digits[v-1 : v]
+	case v == 1 && len(b) > 1:
+		return b[:1]
+	case v == 1:
+		return digits[:1] + digits[base-1:]
+	}
+	// A rank can't be the zero digit alone, so b has more digits.
+	return b[:1] + before(b[1:])
+}
+
+// midpoint returns a rank halfway between a and b, where an empty b stands
+// for the top of the range.
+func midpoint(a, b string) string {
+	if b != "" {
+		// Keep the digits a and b share, reading missing digits of a as zero.
+		n := 0
+		for n < len(b) && digitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			return b[:n] + midpoint(tail(a, n), b[n:])
+		}
+	}
+	da, db := value(digitAt(a, 0)), base
+	if b != "" {
+		db = value(b[0])
+	}
+	if db-da > 1 {
+		m := (da + db) / 2
+		return digits[m : m+1]
+	}
+	if len(b) > 1 {
+		// b's first digit on its own is after a and before the rest of b.
+		return b[:1]
+	}
+	return digits[da:da+1] + midpoint(tail(a, 1), "")
+}
+
+func valid(r string) bool {
+	if r == "" {
+		return true
+	}
+	if r[len(r)-1] == digits[0] {
+		return false
+	}
+	for i := 0; i < len(r); i++ {
+		if value(r[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func value(c byte) int {
+	return strings.IndexByte(digits, c)
+}
+
+func digitAt(r string, i int) byte {
+	if i < len(r) {
+		return r[i]
+	}
+	return digits[0]
+}
+
+func tail(r string, i int) string {
+	if i < len(r) {
+		return r[i:]
+	}
+	return ""
+}
+
+// format writes v as width digits, with leading zeros.
+func format(v, width int) string {
+	b := make([]byte, width)
+	for i := width - 1; i >= 0; i-- {
+		b[i] = digits[v%base]
+		v /= base
+	}
+	return string(b)
+}
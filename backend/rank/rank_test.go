@@ -0,0 +1,164 @@
+package rank
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", "V"},
+		{"V", "", "W"},
+		{"z", "", "zV"},
+		{"", "V", "U"},
+		{"", "1", "0z"},
+		{"", "1V", "1"},
+		{"", "01", "00z"},
+		{"1", "3", "2"},
+		{"1", "2", "1V"},
+		{"V", "W", "VV"},
+		{"V1", "W", "VV"},
+		{"V", "V1", "V0V"},
+		{"Vz", "W", "VzV"},
+		{"VV", "VVV", "VVF"},
+	}
+	for _, tt := range tests {
+		got, err := Between(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("Between(%q, %q): %v", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Between(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestBetweenRejects(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{"V", "V"},
+		{"W", "V"},
+		{"V0", ""},
+		{"", "V0"},
+		{"0", ""},
+		{"V-", ""},
+		{"", "é"},
+	}
+	for _, tt := range tests {
+		if got, err := Between(tt.a, tt.b); err != ErrInvalid {
+			t.Errorf("Between(%q, %q) = %q, %v; want ErrInvalid", tt.a, tt.b, got, err)
+		}
+	}
+}
+
+// TestBetweenKeepsOrder inserts ranks at random places in a list and checks
+// that each lands where it was put and is a valid rank.
+func TestBetweenKeepsOrder(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var ranks []string
+	for i := 0; i < 2000; i++ {
+		at := rnd.Intn(len(ranks) + 1)
+		var a, b string
+		if at > 0 {
+			a = ranks[at-1]
+		}
+		if at < len(ranks) {
+			b = ranks[at]
+		}
+		r, err := Between(a, b)
+		if err != nil {
+			t.Fatalf("Between(%q, %q): %v", a, b, err)
+		}
+		if !valid(r) || (a != "" && r <= a) || (b != "" && r >= b) {
+			t.Fatalf("Between(%q, %q) = %q, which isn't a rank between them", a, b, r)
+		}
+		ranks = append(ranks[:at], append([]string{r}, ranks[at:]...)...)
+	}
+}
+
+// TestBetweenStaysShortAtTheEnds checks that adding items one after another at
+// either end of a list grows ranks slowly, by a digit every thirty-odd items.
+func TestBetweenStaysShortAtTheEnds(t *testing.T) {
+	last, first := "", ""
+	for i := 0; i < 500; i++ {
+		var err error
+		if last, err = Between(last, ""); err != nil {
+			t.Fatal(err)
+		}
+		if first, err = Between("", first); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(last) > MaxLength || len(first) > MaxLength {
+		t.Errorf("after 500 items ranks are %q and %q, longer than %d", first, last, MaxLength)
+	}
+}
+
+// TestBetweenGrowsWhenBisecting checks that repeatedly inserting into the
+// same gap eventually needs MaxLength, which is when lists are spread out.
+func TestBetweenGrowsWhenBisecting(t *testing.T) {
+	a, b := "V", "W"
+	for i := 0; len(a) <= MaxLength; i++ {
+		if i > 1000 {
+			t.Fatalf("ranks stayed short after %d insertions: %q", i, a)
+		}
+		r, err := Between(a, b)
+		if err != nil {
+			t.Fatalf("Between(%q, %q): %v", a, b, err)
+		}
+		b = r
+		if r, err = Between(a, b); err != nil {
+			t.Fatalf("Between(%q, %q): %v", a, b, err)
+		}
+		a = r
+	}
+}
+
+func TestSpread(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 61, 62, 63, 1000, 100000} {
+		ranks := Spread(n)
+		if len(ranks) != n {
+			t.Fatalf("Spread(%d) returned %d ranks", n, len(ranks))
+		}
+		if !sort.StringsAreSorted(ranks) {
+			t.Errorf("Spread(%d) isn't in order", n)
+		}
+		for i, r := range ranks {
+			if !valid(r) || r == "" {
+				t.Fatalf("Spread(%d)[%d] = %q isn't a rank", n, i, r)
+			}
+			if i > 0 && r == ranks[i-1] {
+				t.Fatalf("Spread(%d) repeats %q", n, r)
+			}
+			if len(r) > 4 {
+				t.Errorf("Spread(%d)[%d] = %q is longer than needed", n, i, r)
+			}
+		}
+	}
+}
+
+// TestSpreadLeavesRoom checks that there is a short rank between any two
+// spread ranks and at both ends.
+func TestSpreadLeavesRoom(t *testing.T) {
+	ranks := Spread(1000)
+	for i := 0; i <= len(ranks); i++ {
+		var a, b string
+		if i > 0 {
+			a = ranks[i-1]
+		}
+		if i < len(ranks) {
+			b = ranks[i]
+		}
+		r, err := Between(a, b)
+		if err != nil {
+			t.Fatalf("Between(%q, %q): %v", a, b, err)
+		}
+		if len(r) > 3 {
+			t.Errorf("Between(%q, %q) = %q, want a short rank", a, b, r)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+// Package recurrence implements the subset of RFC 5545 recurrence rules that
+// makes sense for date-only due dates: DAILY, WEEKLY, MONTHLY and YEARLY
+// frequencies with INTERVAL, COUNT, UNTIL, WKST, BYDAY, BYMONTHDAY and BYMONTH.
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// WeekdayNum is a BYDAY entry such as MO, 2TU or -1FR. N is zero for "every".
+type WeekdayNum struct {
+	Weekday time.Weekday
+	N       int
+}
+
+// Rule is a parsed recurrence rule.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int        // Total occurrences including the first; 0 means unbounded
+	Until      *time.Time // Last date an occurrence may fall on, inclusive
+	WeekStart  time.Weekday
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+}
+
+// gregorianCycle is how many periods of each frequency make up 400 years,
+// after which the calendar repeats itself, weekdays included. The periods a
+// rule selects repeat within that many of them, so a rule that matches no
+// date in that many selected periods never matches at all.
+var gregorianCycle = map[Frequency]int{Daily: 146097, Weekly: 20871, Monthly: 4800, Yearly: 400}
+
+var weekdayCodes = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+var weekdayNames = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// Parse parses an RRULE value such as "FREQ=WEEKLY;BYDAY=MO,TH". A leading
+// "RRULE:" is accepted and ignored.
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "RRULE:"), "rrule:")
+	if s == "" {
+		return nil, fmt.Errorf("empty recurrence rule")
+	}
+
+	rule := &Rule{Interval: 1, WeekStart: time.Monday}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("malformed rule part %q", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s given more than once", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			switch Frequency(value) {
+			case Daily, Weekly, Monthly, Yearly:
+				rule.Freq = Frequency(value)
+			default:
+				err = fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			rule.Interval, err = parsePositive(value)
+		case "COUNT":
+			rule.Count, err = parsePositive(value)
+		case "UNTIL":
+			rule.Until, err = parseUntil(value)
+		case "WKST":
+			wd, ok := weekdayCodes[value]
+			if !ok {
+				err = fmt.Errorf("invalid WKST %q", value)
+			}
+			rule.WeekStart = wd
+		case "BYDAY":
+			rule.ByDay, err = parseByDay(value)
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseIntList(value, -31, 31)
+		case "BYMONTH":
+			var months []int
+			months, err = parseIntList(value, 1, 12)
+			for _, m := range months {
+				rule.ByMonth = append(rule.ByMonth, time.Month(m))
+			}
+		default:
+			err = fmt.Errorf("unsupported rule part %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := rule.validate(); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (r *Rule) validate() error {
+	if r.Freq == "" {
+		return fmt.Errorf("FREQ is required")
+	}
+	if r.Count > 0 && r.Until != nil {
+		return fmt.Errorf("COUNT and UNTIL cannot both be set")
+	}
+	if r.Freq == Weekly && len(r.ByMonthDay) > 0 {
+		return fmt.Errorf("BYMONTHDAY cannot be used with FREQ=WEEKLY")
+	}
+	for _, wd := range r.ByDay {
+		if wd.N == 0 {
+			continue
+		}
+		switch {
+		case r.Freq != Monthly && r.Freq != Yearly:
+			return fmt.Errorf("numbered BYDAY is only valid with FREQ=MONTHLY or FREQ=YEARLY")
+		case r.Freq == Monthly || len(r.ByMonth) > 0:
+			if wd.N < -5 || wd.N > 5 {
+				return fmt.Errorf("BYDAY ordinal %d out of range", wd.N)
+			}
+		}
+	}
+	return nil
+}
+
+// String returns the rule in canonical RRULE form, without the "RRULE:" prefix.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+weekdayNames[r.WeekStart])
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, wd := range r.ByDay {
+			days[i] = weekdayNames[wd.Weekday]
+			if wd.N != 0 {
+				days[i] = strconv.Itoa(wd.N) + days[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, d := range r.ByMonthDay {
+			days[i] = strconv.Itoa(d)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonth) > 0 {
+		months := make([]string, len(r.ByMonth))
+		for i, m := range r.ByMonth {
+			months[i] = strconv.Itoa(int(m))
+		}
+		parts = append(parts, "BYMONTH="+strings.Join(months, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Next returns the occurrence following current for a series that began on
+// start, where current is occurrence number index (1-based). It reports false
+// once the series is exhausted by COUNT or UNTIL. Only the dates of start and
+// current are considered.
+func (r *Rule) Next(start, current time.Time, index int) (time.Time, bool) {
+	if r.Count > 0 && index >= r.Count {
+		return time.Time{}, false
+	}
+	start, current = dateOf(start), dateOf(current)
+
+	d := current.AddDate(0, 0, 1)
+	if d.Before(start) {
+		d = start
+	}
+	// Skip straight to each period FREQ and INTERVAL select, and look for a
+	// match among its days.
+	for i := 0; i < gregorianCycle[r.Freq]; i++ {
+		period := r.periodOf(start, d)
+		if skip := (r.Interval - period%r.Interval) % r.Interval; skip > 0 {
+			period += skip
+			d = r.periodStart(start, period)
+		}
+		for end := r.periodStart(start, period+1); d.Before(end); d = d.AddDate(0, 0, 1) {
+			if r.Until != nil && d.After(*r.Until) {
+				return time.Time{}, false
+			}
+			if r.matches(start, d) {
+				return d, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// periodOf returns the number of the FREQ period d falls in, counting the one
+// the series began in as 0.
+func (r *Rule) periodOf(start, d time.Time) int {
+	switch r.Freq {
+	case Weekly:
+		return daysBetween(r.weekOf(start), r.weekOf(d)) / 7
+	case Monthly:
+		return (d.Year()-start.Year())*12 + int(d.Month()) - int(start.Month())
+	case Yearly:
+		return d.Year() - start.Year()
+	}
+	return daysBetween(start, d)
+}
+
+// periodStart returns the first day of period number n, as counted by periodOf.
+func (r *Rule) periodStart(start time.Time, n int) time.Time {
+	switch r.Freq {
+	case Weekly:
+		return r.weekOf(start).AddDate(0, 0, 7*n)
+	case Monthly:
+		return time.Date(start.Year(), start.Month()+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+	case Yearly:
+		return time.Date(start.Year()+n, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return start.AddDate(0, 0, n)
+}
+
+// matches reports whether d is an occurrence of a series that began on start.
+func (r *Rule) matches(start, d time.Time) bool {
+	// The date must fall in a period selected by FREQ and INTERVAL.
+	if r.periodOf(start, d)%r.Interval != 0 {
+		return false
+	}
+
+	// Then it must satisfy every BYxxx filter. Filters that are absent default
+	// to the matching component of the start date, as in RFC 5545.
+	if len(r.ByMonth) > 0 {
+		if !containsMonth(r.ByMonth, d.Month()) {
+			return false
+		}
+	} else if r.Freq == Yearly && len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 && d.Month() != start.Month() {
+		return false
+	}
+
+	if len(r.ByMonthDay) > 0 && !r.matchesMonthDay(d) {
+		return false
+	}
+	if len(r.ByDay) > 0 && !r.matchesByDay(d) {
+		return false
+	}
+
+	if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+		switch r.Freq {
+		case Weekly:
+			return d.Weekday() == start.Weekday()
+		case Monthly, Yearly:
+			return d.Day() == start.Day()
+		}
+	}
+	return true
+}
+
+func (r *Rule) matchesMonthDay(d time.Time) bool {
+	last := daysInMonth(d)
+	for _, md := range r.ByMonthDay {
+		if md == d.Day() || (md < 0 && last+md+1 == d.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesByDay(d time.Time) bool {
+	for _, wd := range r.ByDay {
+		if wd.Weekday != d.Weekday() {
+			continue
+		}
+		if wd.N == 0 {
+			return true
+		}
+
+		// Numbered weekdays count within the month, or within the year for a
+		// YEARLY rule without BYMONTH.
+		var first, last time.Time
+		if r.Freq == Yearly && len(r.ByMonth) == 0 {
+			first = time.Date(d.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+			last = time.Date(d.Year(), time.December, 31, 0, 0, 0, 0, time.UTC)
+		} else {
+			first = time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, time.UTC)
+			last = time.Date(d.Year(), d.Month(), daysInMonth(d), 0, 0, 0, 0, time.UTC)
+		}
+		if wd.N > 0 && daysBetween(first, d)/7+1 == wd.N {
+			return true
+		}
+		if wd.N < 0 && daysBetween(d, last)/7+1 == -wd.N {
+			return true
+		}
+	}
+	return false
+}
+
+// weekOf returns the first day of the week containing d, honouring WKST.
+func (r *Rule) weekOf(d time.Time) time.Time {
+	offset := (int(d.Weekday()) - int(r.WeekStart) + 7) % 7
+	return d.AddDate(0, 0, -offset)
+}
+
+func parsePositive(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("expected a positive integer, got %q", value)
+	}
+	return n, nil
+}
+
+func parseUntil(value string) (*time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			until := dateOf(t)
+			return &until, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid UNTIL %q", value)
+}
+
+func parseByDay(value string) ([]WeekdayNum, error) {
+	var days []WeekdayNum
+	for _, item := range strings.Split(value, ",") {
+		if len(item) < 2 {
+			return nil, fmt.Errorf("invalid BYDAY %q", item)
+		}
+		code := item[len(item)-2:]
+		wd, ok := weekdayCodes[code]
+		if !ok {
+			return nil, fmt.Errorf("invalid BYDAY %q", item)
+		}
+		var n int
+		if prefix := item[:len(item)-2]; prefix != "" {
+			var err error
+			n, err = strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -53 || n > 53 {
+				return nil, fmt.Errorf("invalid BYDAY %q", item)
+			}
+		}
+		days = append(days, WeekdayNum{Weekday: wd, N: n})
+	}
+	return days, nil
+}
+
+func parseIntList(value string, min, max int) ([]int, error) {
+	var values []int
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(item)
+		if err != nil || n == 0 || n < min || n > max {
+			return nil, fmt.Errorf("invalid value %q", item)
+		}
+		values = append(values, n)
+	}
+	sort.Ints(values)
+	return values, nil
+}
+
+func containsMonth(months []time.Month, m time.Month) bool {
+	for _, month := range months {
+		if month == m {
+			return true
+		}
+	}
+	return false
+}
+
+// dateOf drops the time of day, returning the calendar date of t at UTC midnight.
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func daysBetween(a, b time.Time) int {
+	return int(b.Sub(a).Hours() / 24)
+}
+
+func daysInMonth(d time.Time) int {
+	return time.Date(d.Year(), d.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
@@ -0,0 +1,154 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string // Canonical form
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"RRULE:freq=weekly;byday=mo,th", "FREQ=WEEKLY;BYDAY=MO,TH"},
+		{"FREQ=WEEKLY;WKST=SU;INTERVAL=2", "FREQ=WEEKLY;INTERVAL=2;WKST=SU"},
+		{"FREQ=MONTHLY;BYMONTHDAY=15,-1", "FREQ=MONTHLY;BYMONTHDAY=-1,15"},
+		{"FREQ=MONTHLY;BYDAY=-1FR", "FREQ=MONTHLY;BYDAY=-1FR"},
+		{"FREQ=YEARLY;BYMONTH=11;BYDAY=4TH", "FREQ=YEARLY;BYDAY=4TH;BYMONTH=11"},
+		{"FREQ=YEARLY;BYDAY=20MO", "FREQ=YEARLY;BYDAY=20MO"},
+		{"FREQ=DAILY;COUNT=5", "FREQ=DAILY;COUNT=5"},
+		{"FREQ=DAILY;UNTIL=20261231T235959Z", "FREQ=DAILY;UNTIL=20261231"},
+		{"FREQ=DAILY;INTERVAL=1", "FREQ=DAILY"},
+	}
+	for _, tt := range tests {
+		rule, err := Parse(tt.rule)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.rule, err)
+			continue
+		}
+		if got := rule.String(); got != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		"RRULE:",
+		"FREQ=HOURLY",
+		"INTERVAL=2",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=DAILY;COUNT=-1",
+		"FREQ=DAILY;COUNT=2;UNTIL=20260101",
+		"FREQ=DAILY;UNTIL=2026-01-01",
+		"FREQ=DAILY;BYSETPOS=1",
+		"FREQ=DAILY;BYDAY=XX",
+		"FREQ=DAILY;BYDAY=0MO",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=YEARLY;BYMONTH=1;BYDAY=6MO",
+		"FREQ=YEARLY;BYDAY=54MO",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"FREQ=YEARLY;BYMONTH=13",
+		"FREQ=WEEKLY;WKST=XX",
+		"FREQ=DAILY;COUNT",
+	} {
+		if _, err := Parse(rule); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", rule)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		start   string
+		current string
+		index   int
+		want    string // "" when the series is over
+	}{
+		{"daily", "FREQ=DAILY", "2026-01-01", "2026-01-01", 1, "2026-01-02"},
+		{"daily interval", "FREQ=DAILY;INTERVAL=3", "2026-01-01", "2026-01-01", 1, "2026-01-04"},
+		{"current before start", "FREQ=DAILY", "2026-01-10", "2026-01-01", 1, "2026-01-10"},
+
+		{"count left", "FREQ=DAILY;COUNT=3", "2026-01-01", "2026-01-02", 2, "2026-01-03"},
+		{"count reached", "FREQ=DAILY;COUNT=3", "2026-01-01", "2026-01-03", 3, ""},
+		{"count of one", "FREQ=WEEKLY;COUNT=1", "2026-01-01", "2026-01-01", 1, ""},
+
+		{"until inclusive", "FREQ=DAILY;UNTIL=20260102", "2026-01-01", "2026-01-01", 1, "2026-01-02"},
+		{"until passed", "FREQ=DAILY;UNTIL=20260102", "2026-01-01", "2026-01-02", 2, ""},
+		{"until between occurrences", "FREQ=WEEKLY;UNTIL=20260110", "2026-01-01", "2026-01-08", 2, ""},
+		{"until before a far period", "FREQ=YEARLY;INTERVAL=30;UNTIL=20400101", "2026-03-10", "2026-03-10", 1, ""},
+
+		{"weekly on start weekday", "FREQ=WEEKLY", "2026-01-01", "2026-01-01", 1, "2026-01-08"},
+		{"weekly byday same week", "FREQ=WEEKLY;BYDAY=MO,TH", "2026-01-05", "2026-01-05", 1, "2026-01-08"},
+		{"weekly byday next week", "FREQ=WEEKLY;BYDAY=MO,TH", "2026-01-05", "2026-01-08", 2, "2026-01-12"},
+		{"weekly byday interval", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH", "2026-01-05", "2026-01-08", 2, "2026-01-19"},
+		{"weeks start on monday", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SU", "2026-01-05", "2026-01-05", 1, "2026-01-11"},
+		{"weeks start on sunday", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SU;WKST=SU", "2026-01-05", "2026-01-05", 1, "2026-01-18"},
+
+		{"monthly last friday", "FREQ=MONTHLY;BYDAY=-1FR", "2026-01-30", "2026-01-30", 1, "2026-02-27"},
+		{"monthly second tuesday", "FREQ=MONTHLY;BYDAY=2TU", "2026-01-13", "2026-01-13", 1, "2026-02-10"},
+		{"monthly fifth monday", "FREQ=MONTHLY;BYDAY=5MO", "2026-03-30", "2026-03-30", 1, "2026-06-29"},
+		{"monthly last day", "FREQ=MONTHLY;BYMONTHDAY=-1", "2026-01-31", "2026-01-31", 1, "2026-02-28"},
+		{"monthly last day again", "FREQ=MONTHLY;BYMONTHDAY=-1", "2026-01-31", "2026-02-28", 2, "2026-03-31"},
+		{"monthly on the 31st", "FREQ=MONTHLY", "2026-01-31", "2026-01-31", 1, "2026-03-31"},
+		{"monthly interval across years", "FREQ=MONTHLY;INTERVAL=5", "2026-10-15", "2026-10-15", 1, "2027-03-15"},
+
+		{"yearly", "FREQ=YEARLY", "2026-03-10", "2026-03-10", 1, "2027-03-10"},
+		{"yearly large interval", "FREQ=YEARLY;INTERVAL=30", "2026-03-10", "2026-03-10", 1, "2056-03-10"},
+		{"yearly leap day", "FREQ=YEARLY", "2024-02-29", "2024-02-29", 1, "2028-02-29"},
+		{"yearly leap day skips 2100", "FREQ=YEARLY", "2096-02-29", "2096-02-29", 1, "2104-02-29"},
+		{"yearly leap day interval", "FREQ=YEARLY;INTERVAL=7", "2024-02-29", "2024-02-29", 1, "2052-02-29"},
+		{"yearly numbered weekday in month", "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH", "2026-11-26", "2026-11-26", 1, "2027-11-25"},
+		{"yearly numbered weekday in year", "FREQ=YEARLY;BYDAY=1MO", "2026-01-05", "2026-01-05", 1, "2027-01-04"},
+
+		{"never matches", "FREQ=MONTHLY;BYMONTH=2;BYMONTHDAY=30", "2026-01-01", "2026-01-01", 1, ""},
+		{"never matches daily", "FREQ=DAILY;BYMONTH=2;BYMONTHDAY=31", "2026-01-01", "2026-01-01", 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.rule, err)
+			}
+			got, ok := rule.Next(date(tt.start), date(tt.current), tt.index)
+			switch {
+			case tt.want == "" && ok:
+				t.Errorf("Next = %s, want the series to be over", got.Format("2006-01-02"))
+			case tt.want != "" && !ok:
+				t.Errorf("Next reported the series over, want %s", tt.want)
+			case tt.want != "" && !got.Equal(date(tt.want)):
+				t.Errorf("Next = %s, want %s", got.Format("2006-01-02"), tt.want)
+			}
+		})
+	}
+}
+
+// TestNextIgnoresTimeOfDay checks that only the dates of start and current count.
+func TestNextIgnoresTimeOfDay(t *testing.T) {
+	rule, err := Parse("FREQ=DAILY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 23, 30, 0, 0, time.FixedZone("", -5*3600))
+	got, ok := rule.Next(start, start, 1)
+	if !ok || !got.Equal(date("2026-01-02")) {
+		t.Errorf("Next = %v, %v; want 2026-01-02", got, ok)
+	}
+}
@@ -0,0 +1,236 @@
+package reminders
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"tempo-backend/db"
+	"tempo-backend/mail"
+	"tempo-backend/types"
+	"time"
+)
+
+// due describes when the reminder's item is due, or "" if it has no due date.
+func due(reminder types.DueReminder) string {
+	if reminder.DueDate == nil {
+		return ""
+	}
+	when := reminder.DueDate.Format("Monday, January 2, 2006")
+	if reminder.DueTime != nil {
+		when += " at " + *reminder.DueTime
+	}
+	return when
+}
+
+// InAppChannel adds reminders to the user's notification feed.
+type InAppChannel struct {
+	store *db.NotificationStore
+}
+
+func NewInAppChannel(store *db.NotificationStore) *InAppChannel {
+	return &InAppChannel{store: store}
+}
+
+func (c *InAppChannel) Deliver(ctx context.Context, reminder types.DueReminder) error {
+	body := "This is a reminder about \"" + reminder.Task + "\"."
+	if when := due(reminder); when != "" {
+		body += " It is due " + when + "."
+	}
+	return c.store.AddReminderNotification(reminder, "Reminder: "+reminder.Task, body)
+}
+
+// EmailChannel emails reminders to the user's address.
+type EmailChannel struct {
+	mailer mail.Mailer
+}
+
+func NewEmailChannel(mailer mail.Mailer) *EmailChannel {
+	return &EmailChannel{mailer: mailer}
+}
+
+func (c *EmailChannel) Deliver(ctx context.Context, reminder types.DueReminder) error {
+	msg, err := mail.Render("reminder", reminder.Email, struct{ Task, Due string }{reminder.Task, due(reminder)})
+	if err != nil {
+		return Permanent(err)
+	}
+	return c.mailer.Send(msg)
+}
+
+// WebhookChannel posts reminders as JSON to the user's webhook. Each request
+// carries an X-Tempo-Signature header, "sha256=" and the hex HMAC-SHA256 of the
+// body keyed with the webhook's secret, and an X-Tempo-Delivery header that
+// stays the same when a delivery is retried.
+//
+// Webhook URLs come from users, so requests only go to public addresses and
+// redirects aren't followed; otherwise a webhook could reach services on the
+// server's own network, such as a cloud metadata endpoint. For the same
+// reason the reason recorded for a failed delivery doesn't say how the far
+// end responded.
+type WebhookChannel struct {
+	client *http.Client
+}
+
+func NewWebhookChannel() *WebhookChannel {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: refuseNonPublic}
+	return &WebhookChannel{client: &http.Client{
+		Timeout: 10 * time.Second,
+		// No proxy, so the dialer sees the webhook's own address.
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// errWebhookFailed is recorded for every failed webhook delivery; the details are logged.
+var errWebhookFailed = errors.New("webhook delivery failed")
+
+// errNonPublicAddress refuses webhooks that point inside the server's network.
+var errNonPublicAddress = errors.New("webhook address is not public")
+
+// nonPublicNets are the special-purpose ranges net.IP has no method for:
+// "this network", carrier-grade NAT, benchmarking, the reserved block above
+// 240.0.0.0 (which takes in the broadcast address) and NAT64, which maps any
+// IPv4 address, private ones included, into IPv6.
+var nonPublicNets = []net.IPNet{
+	cidr("0.0.0.0/8"),
+	cidr("100.64.0.0/10"),
+	cidr("198.18.0.0/15"),
+	cidr("240.0.0.0/4"),
+	cidr("64:ff9b::/96"),
+}
+
+func cidr(s string) net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return *network
+}
+
+// isPublic reports whether ip may be sent webhooks: it isn't private,
+// loopback, link-local (which includes 169.254.169.254, where clouds serve
+// instance metadata), multicast, unspecified or in nonPublicNets.
+func isPublic(ip net.IP) bool {
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range nonPublicNets {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// refuseNonPublic is a net.Dialer Control function. It runs after the host
+// name is resolved, so it checks the address actually connected to and a
+// name can't be re-pointed at a private address after it was checked.
+func refuseNonPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+		return errNonPublicAddress
+	}
+	return nil
+}
+
+// CheckWebhookURL checks a URL a user wants webhooks sent to: it must be an
+// absolute http or https URL whose host resolves only to public addresses.
+// Deliveries check the address again when they connect.
+func CheckWebhookURL(ctx context.Context, rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Hostname() == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, target.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return errors.New("url host could not be resolved")
+	}
+	for _, addr := range addrs {
+		if !isPublic(addr.IP) {
+			return errors.New("url must point to a public address")
+		}
+	}
+	return nil
+}
+
+// webhookEvent is the body of a webhook reminder.
+type webhookEvent struct {
+	Event      string    `json:"event"` // "reminder"
+	ReminderID int       `json:"reminderId"`
+	FireAt     time.Time `json:"fireAt"`
+	Item       struct {
+		ID      int     `json:"id"`
+		ListID  int     `json:"listId"`
+		Task    string  `json:"task"`
+		DueDate *string `json:"dueDate"`
+		DueTime *string `json:"dueTime"`
+	} `json:"item"`
+}
+
+func (c *WebhookChannel) Deliver(ctx context.Context, reminder types.DueReminder) error {
+	if reminder.WebhookURL == nil || reminder.WebhookSecret == nil {
+		return Permanent(errors.New("no webhook configured"))
+	}
+
+	event := webhookEvent{Event: "reminder", ReminderID: reminder.ReminderID, FireAt: reminder.FireAt}
+	event.Item.ID = reminder.ItemID
+	event.Item.ListID = reminder.ListID
+	event.Item.Task = reminder.Task
+	event.Item.DueTime = reminder.DueTime
+	if reminder.DueDate != nil {
+		date := reminder.DueDate.Format(time.DateOnly)
+		event.Item.DueDate = &date
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(*reminder.WebhookSecret))
+	mac.Write(body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *reminder.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tempo-Webhook/1")
+	req.Header.Set("X-Tempo-Delivery", strconv.Itoa(reminder.DeliveryID))
+	req.Header.Set("X-Tempo-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		log.Printf("Error delivering reminder %d to webhook: %v", reminder.DeliveryID, err)
+		if errors.Is(err, errNonPublicAddress) {
+			return Permanent(errWebhookFailed)
+		}
+		return errWebhookFailed
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusRequestTimeout || res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		log.Printf("Webhook for reminder delivery %d responded %s", reminder.DeliveryID, res.Status)
+		return errWebhookFailed
+	default:
+		log.Printf("Webhook for reminder delivery %d responded %s", reminder.DeliveryID, res.Status)
+		return Permanent(errWebhookFailed)
+	}
+}
@@ -0,0 +1,149 @@
+// Package reminders runs the background scheduler that fires due reminders and
+// delivers them on their channels.
+package reminders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"tempo-backend/db"
+	"tempo-backend/types"
+	"time"
+)
+
+const (
+	// pollInterval is how often the scheduler looks for due reminders.
+	pollInterval = 15 * time.Second
+	// batchSize is how many reminders or deliveries are claimed at a time.
+	batchSize = 20
+	// deliveryLease is how long a claimed delivery is left alone before another
+	// instance may assume this one died and try again. It must outlast deliveryTimeout.
+	deliveryLease = 5 * time.Minute
+	// deliveryTimeout bounds a single delivery attempt.
+	deliveryTimeout = time.Minute
+	// maxAttempts is how many times a delivery is tried before giving up.
+	maxAttempts = 5
+	// maxLateness is how overdue a reminder can be and still be delivered.
+	maxLateness = 24 * time.Hour
+)
+
+// Channel delivers a reminder one way, such as email. Returning an error
+// wrapped with Permanent gives up on the delivery instead of retrying it.
+type Channel interface {
+	Deliver(ctx context.Context, reminder types.DueReminder) error
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a delivery error as one retrying won't fix.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Scheduler fires reminders and delivers them. Any number of backend instances
+// can run one against the same database: the store hands each reminder and
+// each delivery to a single instance at a time.
+type Scheduler struct {
+	store    *db.ReminderStore
+	channels map[string]Channel
+}
+
+// NewScheduler creates a scheduler delivering on the given channels, keyed by
+// channel name. Deliveries on a channel that isn't configured fail.
+func NewScheduler(store *db.ReminderStore, channels map[string]Channel) *Scheduler {
+	return &Scheduler{store: store, channels: channels}
+}
+
+// Run polls for due reminders until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		s.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll fires every reminder that is due, then works through the deliveries
+// that are due, a batch at a time.
+func (s *Scheduler) poll(ctx context.Context) {
+	for ctx.Err() == nil {
+		fired, err := s.store.FireDueReminders(batchSize, maxLateness)
+		if err != nil {
+			log.Printf("Error firing reminders: %v", err)
+			return
+		}
+		if fired < batchSize {
+			break
+		}
+	}
+
+	for ctx.Err() == nil {
+		due, err := s.store.ClaimDeliveries(batchSize, deliveryLease)
+		if err != nil {
+			log.Printf("Error claiming reminder deliveries: %v", err)
+			return
+		}
+		var wg sync.WaitGroup
+		for _, reminder := range due {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.deliver(ctx, reminder)
+			}()
+		}
+		wg.Wait()
+		if len(due) < batchSize {
+			return
+		}
+	}
+}
+
+// deliver attempts one delivery and records how it went.
+func (s *Scheduler) deliver(ctx context.Context, reminder types.DueReminder) {
+	err := errors.New("channel not configured")
+	if channel, ok := s.channels[reminder.Channel]; ok {
+		deliverCtx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+		err = channel.Deliver(deliverCtx, reminder)
+		cancel()
+	}
+	if err == nil {
+		err = s.store.MarkDelivered(reminder.DeliveryID)
+		if err != nil {
+			// The lease runs out and it is delivered again; channels tolerate repeats where they can.
+			log.Printf("Error recording reminder delivery %d: %v", reminder.DeliveryID, err)
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	var permanent permanentError
+	if !errors.As(err, &permanent) && reminder.Attempt < maxAttempts {
+		at := time.Now().Add(retryDelay(reminder.Attempt))
+		retryAt = &at
+	}
+	reason := fmt.Sprintf("attempt %d: %v", reminder.Attempt, err)
+	if err := s.store.MarkAttemptFailed(reminder.DeliveryID, reason, retryAt); err != nil {
+		log.Printf("Error recording failed reminder delivery %d: %v", reminder.DeliveryID, err)
+	}
+}
+
+// retryDelay backs off exponentially from a minute, up to an hour.
+func retryDelay(attempt int) time.Duration {
+	delay := time.Minute << (attempt - 1)
+	if delay <= 0 || delay > time.Hour {
+		return time.Hour
+	}
+	return delay
+}
@@ -0,0 +1,117 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key from RFC 6238 Appendix B, "12345678901234567890",
+// base32-encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeMatchesRFC6238 checks the SHA-1 test vectors from RFC 6238
+// Appendix B. The RFC gives eight digits; a six digit code is the last six.
+func TestCodeMatchesRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string // eight digits, as in the RFC
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+		if want := tt.want[len(tt.want)-Digits:]; got != want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestCodeAcceptsLowercaseSecret(t *testing.T) {
+	upper, _ := Code(rfcSecret, 1)
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil || lower != upper {
+		t.Errorf("Code with a lowercase secret = %s, %v; want %s", lower, err, upper)
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted a secret that isn't base32")
+	}
+}
+
+// TestValidateSkew checks that codes for the steps either side of now are
+// accepted, and codes further out aren't.
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Counter(now)
+	tests := []struct {
+		offset int64
+		ok     bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, step+tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matched, ok := Validate(rfcSecret, code, now, 0)
+		if ok != tt.ok {
+			t.Errorf("code for step %+d: ok = %v, want %v", tt.offset, ok, tt.ok)
+		}
+		if ok && matched != step+tt.offset {
+			t.Errorf("code for step %+d matched step %d, want %d", tt.offset, matched, step+tt.offset)
+		}
+	}
+}
+
+// TestValidateRejectsReplay checks that a code can't be used again once its
+// step is passed as after, nor can an earlier one.
+func TestValidateRejectsReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Counter(now)
+	current, _ := Code(rfcSecret, step)
+	previous, _ := Code(rfcSecret, step-1)
+
+	matched, ok := Validate(rfcSecret, current, now, 0)
+	if !ok || matched != step {
+		t.Fatalf("Validate = %d, %v; want %d, true", matched, ok, step)
+	}
+	if _, ok := Validate(rfcSecret, current, now, matched); ok {
+		t.Error("the same code was accepted twice")
+	}
+	if _, ok := Validate(rfcSecret, previous, now, matched); ok {
+		t.Error("an earlier code was accepted after a later one")
+	}
+	// Still valid a step later, but already used.
+	if _, ok := Validate(rfcSecret, current, now.Add(Period), matched); ok {
+		t.Error("the same code was accepted again in the next period")
+	}
+	next, _ := Code(rfcSecret, step+1)
+	if got, ok := Validate(rfcSecret, next, now.Add(Period), matched); !ok || got != step+1 {
+		t.Errorf("the next code gave %d, %v; want %d, true", got, ok, step+1)
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870822", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now, 0); ok {
+			t.Errorf("Validate accepted %q", code)
+		}
+	}
+	if _, ok := Validate(rfcSecret, " 287082 ", now, 0); !ok {
+		t.Error("Validate didn't trim spaces around the code")
+	}
+}
@@ -0,0 +1,64 @@
+package types
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Personal access token scopes. Each grants read or write access to one kind
+// of data; write also grants read.
+const (
+	ScopeTodosRead    = "todos:read"
+	ScopeTodosWrite   = "todos:write"
+	ScopeNotesRead    = "notes:read"
+	ScopeNotesWrite   = "notes:write"
+	ScopeJournalRead  = "journal:read"
+	ScopeJournalWrite = "journal:write"
+	ScopeTagsRead     = "tags:read"
+	ScopeTagsWrite    = "tags:write"
+)
+
+// AccessTokenScopes lists every scope a personal access token can have.
+var AccessTokenScopes = []string{
+	ScopeTodosRead, ScopeTodosWrite,
+	ScopeNotesRead, ScopeNotesWrite,
+	ScopeJournalRead, ScopeJournalWrite,
+	ScopeTagsRead, ScopeTagsWrite,
+}
+
+// HasScope reports whether scopes grant scope, counting a write scope as
+// granting read on the same resource.
+func HasScope(scopes []string, scope string) bool {
+	if slices.Contains(scopes, scope) {
+		return true
+	}
+	resource, ok := strings.CutSuffix(scope, ":read")
+	return ok && slices.Contains(scopes, resource+":write")
+}
+
+// AccessToken is a personal access token. The token itself is only returned
+// once, when it is created.
+type AccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // The start of the token, to tell tokens apart
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"` // Nil for tokens that never expire
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type CreateAccessTokenPayload struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays *int     `json:"expiresInDays"` // Omit for a token that never expires
+}
+
+// CreatedAccessToken is a newly created personal access token, with the token
+// to use as a bearer token.
+type CreatedAccessToken struct {
+	AccessToken
+	Token string `json:"token"`
+}
@@ -0,0 +1,25 @@
+package types
+
+import "time"
+
+// CalendarFeed is a user's secret calendar subscription URL. The URL is only
+// returned once, when the feed is created.
+type CalendarFeed struct {
+	Prefix     string     `json:"prefix"` // The start of the token, to tell feeds apart
+	URL        string     `json:"url,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt"` // When a calendar app last fetched the feed
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// CalendarItem is an item with a due date, as it appears in a calendar feed.
+type CalendarItem struct {
+	TodoItem
+	ListTitle string
+}
+
+// Calendar is what a user's calendar feed shows.
+type Calendar struct {
+	Timezone       string // IANA zone due times are read in
+	Items          []CalendarItem
+	JournalEntries []JournalEntry // Only when the feed asks for them
+}
@@ -0,0 +1,24 @@
+package types
+
+import "time"
+
+// Data export statuses.
+const (
+	ExportStatusPending = "pending"
+	ExportStatusRunning = "running"
+	ExportStatusReady   = "ready"
+	ExportStatusFailed  = "failed"
+)
+
+// DataExport is a ZIP archive of everything a user owns, built in the background.
+type DataExport struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"-"`
+	Status      string     `json:"status"` // "pending", "running", "ready" or "failed"
+	Attempts    int        `json:"-"`
+	SizeBytes   *int64     `json:"sizeBytes"` // Set once the archive is ready
+	Error       *string    `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"` // When the archive is deleted
+}
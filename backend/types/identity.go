@@ -0,0 +1,54 @@
+package types
+
+import "time"
+
+// ExternalIdentity is who an identity provider says a user logging in is.
+type ExternalIdentity struct {
+	Provider          string
+	Subject           string
+	Email             string
+	EmailVerified     bool // Whether the provider vouches for the email address
+	PreferredUsername string
+}
+
+// UserIdentity is an identity provider account linked to the user.
+type UserIdentity struct {
+	ID          int        `json:"id"`
+	Provider    string     `json:"provider"`
+	Email       *string    `json:"email"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// OIDCLogin is a login in progress at an identity provider.
+type OIDCLogin struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	RedirectPath string
+	LinkUserID   *int // Set when a logged-in user is linking the identity
+}
+
+// OIDCProvider is an identity provider users can log in with.
+type OIDCProvider struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// OIDCLinkPayload starts linking an identity provider account. Redirect is
+// the web app path to return to afterwards.
+type OIDCLinkPayload struct {
+	Redirect string `json:"redirect"`
+}
+
+// OIDCLinkResponse is the provider URL to send the user's browser to.
+type OIDCLinkResponse struct {
+	AuthorizationURL string `json:"authorizationUrl"`
+}
+
+// OIDCExchangePayload exchanges the login code from a provider login for a
+// session, or a two-factor challenge.
+type OIDCExchangePayload struct {
+	LoginCode  string  `json:"loginCode"`
+	DeviceName *string `json:"deviceName"`
+}
@@ -0,0 +1,36 @@
+package types
+
+// ImportReport describes what an import did with each file it was given.
+type ImportReport struct {
+	Created            []ImportedFile `json:"created"`
+	Skipped            []ImportedFile `json:"skipped"`
+	Errors             []ImportedFile `json:"errors"`
+	NotebooksCreated   int            `json:"notebooksCreated"`
+	AttachmentsCreated int            `json:"attachmentsCreated"`
+}
+
+// ImportedFile is what became of one file in an import.
+type ImportedFile struct {
+	Path     string   `json:"path"`
+	NoteID   *int     `json:"noteId,omitempty"` // The note created, or the one an earlier import created
+	Title    string   `json:"title,omitempty"`
+	Reason   string   `json:"reason,omitempty"`   // Why the file was skipped or failed
+	Warnings []string `json:"warnings,omitempty"` // Problems that didn't stop the file being imported, such as unresolved links
+}
+
+// CalendarImportReport describes what an import did with each entry of a calendar.
+type CalendarImportReport struct {
+	ListID  *int            `json:"listId"` // The list items were imported into; nil if none were
+	Created []ImportedEntry `json:"created"`
+	Skipped []ImportedEntry `json:"skipped"`
+	Errors  []ImportedEntry `json:"errors"`
+}
+
+// ImportedEntry is what became of one VTODO or VEVENT in an import.
+type ImportedEntry struct {
+	UID      string   `json:"uid"`
+	ItemID   *int     `json:"itemId,omitempty"` // The item created, or the one an earlier import created
+	Task     string   `json:"task,omitempty"`
+	Reason   string   `json:"reason,omitempty"`   // Why the entry was skipped or failed
+	Warnings []string `json:"warnings,omitempty"` // Problems that didn't stop the entry being imported, such as ignored exceptions
+}
@@ -9,6 +9,7 @@ type JournalEntry struct {
 	Content   string    `json:"content"`
 	Mood      *string   `json:"mood"`
 	EntryDate time.Time `json:"entryDate"`
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
@@ -1,22 +1,79 @@
 package types
 
-import "time"
+import (
+	"tempo-backend/diff"
+	"time"
+)
 
 type Note struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"userId"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID         int       `json:"id"`
+	UserID     int       `json:"userId"`
+	NotebookID int       `json:"notebookId"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	Tags       []string  `json:"tags"`
+	Version    int       `json:"version"` // Sent back as the ETag; bumped on every update
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
 }
 
 type CreateNotePayload struct {
-	Title   string `json:"title"`
-	Content string `json:"content"`
+	Title      string   `json:"title"`
+	Content    string   `json:"content"`
+	NotebookID *int     `json:"notebookId"` // Defaults to the user's default notebook
+	Tags       []string `json:"tags"`       // Tag names; missing tags are created
 }
 
 type UpdateNotePayload struct {
-	Title   *string `json:"title"`
-	Content *string `json:"content"`
+	Title      *string   `json:"title"`
+	Content    *string   `json:"content"`
+	NotebookID *int      `json:"notebookId"`
+	Tags       *[]string `json:"tags"` // Replaces all of the note's tags when present
+}
+
+// NoteSearchResult is a note matched by a full-text search. The highlight
+// fields are HTML-escaped with matches wrapped in <mark> tags.
+type NoteSearchResult struct {
+	Note
+	Rank           float64 `json:"rank"`
+	TitleHighlight string  `json:"titleHighlight"`
+	Snippet        string  `json:"snippet"`
+}
+
+// NoteRevisionSummary describes a saved state of a note without its content.
+type NoteRevisionSummary struct {
+	ID           int       `json:"id"`
+	NoteID       int       `json:"noteId"`
+	Title        string    `json:"title"`
+	Length       int       `json:"length"`       // Length of the content in characters
+	RestoredFrom *int      `json:"restoredFrom"` // Set when the revision was created by restoring another one
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// NoteRevision is a saved state of a note's title and content.
+type NoteRevision struct {
+	NoteRevisionSummary
+	Content string `json:"content"`
+}
+
+// NoteRevisionDiff compares two states of a note. To is nil when the newer
+// side is the note as it is now.
+type NoteRevisionDiff struct {
+	From    int           `json:"from"`
+	To      *int          `json:"to"`
+	Mode    string        `json:"mode"` // "line" or "word"
+	Title   []diff.Change `json:"title"`
+	Content []diff.Change `json:"content"`
+}
+
+// NoteAttachment is a file attached to a note, such as an image embedded in
+// it. Data is only loaded when the file itself is requested.
+type NoteAttachment struct {
+	ID          int       `json:"id"`
+	NoteID      int       `json:"noteId"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	SizeBytes   int       `json:"sizeBytes"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Data        []byte    `json:"-"`
 }
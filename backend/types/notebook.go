@@ -0,0 +1,40 @@
+package types
+
+import "time"
+
+type Notebook struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"userId"`
+	ParentID  *int      `json:"parentId"` // Nil for top-level notebooks
+	Name      string    `json:"name"`
+	IsDefault bool      `json:"isDefault"`
+	NoteCount int       `json:"noteCount"` // Notes directly in this notebook
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type CreateNotebookPayload struct {
+	Name     string `json:"name"`
+	ParentID *int   `json:"parentId"`
+}
+
+type UpdateNotebookPayload struct {
+	Name     *string `json:"name"`
+	ParentID *int    `json:"parentId"`
+	// MoveToTopLevel detaches the notebook from its parent. JSON null can't be
+	// told apart from a missing parentId, so this is a separate flag.
+	MoveToTopLevel bool `json:"moveToTopLevel"`
+}
+
+type Tag struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"userId"`
+	Name      string    `json:"name"`
+	NoteCount int       `json:"noteCount"`
+	ItemCount int       `json:"itemCount"` // To-do items with the tag
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type TagPayload struct {
+	Name string `json:"name"`
+}
@@ -0,0 +1,24 @@
+package types
+
+import "encoding/json"
+
+// Optional is a payload field that tells a field left out apart from an
+// explicit null, for fields where null clears the value.
+type Optional[T any] struct {
+	Set   bool // The field was present, with a value or null
+	Value *T   // Nil for null
+}
+
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
+	}
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	o.Value = &v
+	return nil
+}
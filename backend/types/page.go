@@ -0,0 +1,8 @@
+package types
+
+// Page is the body of a listing response: one page of records and, when
+// there are more, the cursor to pass as ?cursor= for the next page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
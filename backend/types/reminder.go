@@ -0,0 +1,85 @@
+package types
+
+import "time"
+
+// Reminder channels.
+const (
+	ReminderChannelInApp   = "in_app"
+	ReminderChannelEmail   = "email"
+	ReminderChannelWebhook = "webhook"
+)
+
+// Reminder notifies the user about a to-do item, either at a fixed time or a
+// number of minutes before the item is due.
+type Reminder struct {
+	ID            int                `json:"id"`
+	ItemID        int                `json:"itemId"`
+	RemindAt      *time.Time         `json:"remindAt,omitempty"`
+	OffsetMinutes *int               `json:"offsetMinutes,omitempty"` // Minutes before the item's due date and time
+	Timezone      string             `json:"timezone"`                // IANA zone the due date and time are read in
+	Channels      []string           `json:"channels"`
+	FireAt        *time.Time         `json:"fireAt"` // Nil while an offset reminder's item has no due date
+	Status        string             `json:"status"` // "pending", "fired" or "skipped"
+	CreatedAt     time.Time          `json:"createdAt"`
+	Deliveries    []ReminderDelivery `json:"deliveries"` // The latest delivery on each channel
+}
+
+// ReminderDelivery is the state of a fired reminder on one channel.
+type ReminderDelivery struct {
+	Channel     string     `json:"channel"`
+	FireAt      time.Time  `json:"fireAt"`
+	Status      string     `json:"status"` // "pending", "delivered" or "failed"
+	Attempts    int        `json:"attempts"`
+	LastError   *string    `json:"lastError,omitempty"`
+	DeliveredAt *time.Time `json:"deliveredAt,omitempty"`
+}
+
+type CreateReminderPayload struct {
+	RemindAt      *time.Time `json:"remindAt"`
+	OffsetMinutes *int       `json:"offsetMinutes"`
+	Timezone      string     `json:"timezone"` // Defaults to UTC
+	Channels      []string   `json:"channels"` // Defaults to in-app only
+}
+
+// DueReminder is a reminder delivery claimed by the scheduler, with everything
+// a channel needs to send it.
+type DueReminder struct {
+	DeliveryID    int
+	ReminderID    int
+	Channel       string
+	Attempt       int
+	FireAt        time.Time
+	ItemID        int
+	ListID        int
+	Task          string
+	DueDate       *time.Time
+	DueTime       *string
+	UserID        int
+	Username      string
+	Email         string
+	WebhookURL    *string
+	WebhookSecret *string
+}
+
+// Notification is an entry in the user's in-app notification feed.
+type Notification struct {
+	ID        int        `json:"id"`
+	Kind      string     `json:"kind"` // "reminder"
+	Title     string     `json:"title"`
+	Body      string     `json:"body"`
+	ItemID    *int       `json:"itemId,omitempty"`
+	ReadAt    *time.Time `json:"readAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// ReminderWebhookPayload sets where webhook reminders are posted.
+type ReminderWebhookPayload struct {
+	URL string `json:"url"`
+}
+
+// ReminderWebhook is the user's webhook. The secret webhook requests are signed
+// with is only returned when the webhook is set.
+type ReminderWebhook struct {
+	URL    *string `json:"url"`
+	Secret string  `json:"secret,omitempty"`
+}
@@ -0,0 +1,41 @@
+package types
+
+import "time"
+
+// Kinds of record returned by the unified search.
+const (
+	SearchKindNote    = "note"
+	SearchKindJournal = "journal"
+	SearchKindTask    = "task"
+)
+
+type SearchOptions struct {
+	Query     string
+	Kinds     []string   // Empty means every kind
+	From      *time.Time // Inclusive
+	To        *time.Time // Exclusive
+	Completed *bool      // Only applies to tasks
+	Limit     int
+	Offset    int
+}
+
+// SearchResult is one hit from the unified search. Snippet is HTML-escaped
+// with matches wrapped in <mark> tags.
+type SearchResult struct {
+	Kind        string    `json:"kind"`
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	Snippet     string    `json:"snippet"`
+	Rank        float64   `json:"rank"`
+	Date        time.Time `json:"date"` // Note updatedAt, journal entryDate, or task dueDate falling back to createdAt
+	Link        string    `json:"link"` // Web app path of the record
+	ListID      *int      `json:"listId,omitempty"`
+	IsCompleted *bool     `json:"isCompleted,omitempty"`
+}
+
+type SearchResults struct {
+	Results []SearchResult `json:"results"`
+	Total   int            `json:"total"`
+	Limit   int            `json:"limit"`
+	Offset  int            `json:"offset"`
+}
@@ -0,0 +1,72 @@
+package types
+
+import "time"
+
+type Session struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"userId"`
+	DeviceName *string    `json:"deviceName"`
+	IPAddress  string     `json:"ipAddress"`
+	UserAgent  string     `json:"userAgent"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt time.Time  `json:"lastUsedAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	Current    bool       `json:"current"` // Set by the handler for the session making the request
+}
+
+type LoginUserPayload struct {
+	Email      string  `json:"email"`
+	Password   string  `json:"password"`
+	DeviceName *string `json:"deviceName"`
+}
+
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// TokenResponse is returned by login and refresh.
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"` // Access token lifetime in seconds
+}
+
+// TwoFactorChallengeResponse is returned by login instead of tokens when the
+// user has two-factor authentication enabled. Complete the login by sending
+// the challenge token with a code to /api/users/login/2fa.
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"` // Always true
+	ChallengeToken    string `json:"challengeToken"`
+	ExpiresIn         int    `json:"expiresIn"` // Challenge lifetime in seconds
+}
+
+// TwoFactorLoginPayload completes a two-factor login. Code is a code from the
+// authenticator app or one of the recovery codes.
+type TwoFactorLoginPayload struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+}
+
+// TwoFactorCodePayload carries an authenticator or recovery code confirming a two-factor change.
+type TwoFactorCodePayload struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorSetupResponse is the secret to enroll in an authenticator app,
+// also as an otpauth:// URI to show as a QR code.
+type TwoFactorSetupResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthUri"`
+}
+
+// TwoFactorStatus reports the user's two-factor authentication state.
+type TwoFactorStatus struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recoveryCodesRemaining"`
+}
+
+// RecoveryCodesResponse lists new recovery codes. They are only ever shown once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
@@ -0,0 +1,79 @@
+package types
+
+import "encoding/json"
+
+// Sync kinds name the record types that take part in delta sync.
+const (
+	SyncKindList    = "list"
+	SyncKindItem    = "item"
+	SyncKindNote    = "note"
+	SyncKindJournal = "journal"
+)
+
+// SyncChanges is one page of changes pulled by a client. Records appear in
+// their current state whether they were created or updated since the cursor.
+type SyncChanges struct {
+	Cursor         string         `json:"cursor"`  // Pass back as ?since= to continue
+	HasMore        bool           `json:"hasMore"` // Pull again with the new cursor before relying on the result
+	Lists          []TodoList     `json:"lists"`
+	Items          []TodoItem     `json:"items"`
+	Notes          []Note         `json:"notes"`
+	JournalEntries []JournalEntry `json:"journalEntries"`
+	Deleted        []SyncDeletion `json:"deleted"`
+}
+
+// SyncDeletion is a tombstone for a deleted record. Deleting a list deletes
+// its items without a tombstone for each.
+type SyncDeletion struct {
+	Kind string `json:"kind"`
+	ID   int    `json:"id"`
+}
+
+// SyncPushPayload is a batch of mutations made by a client while offline,
+// applied in order.
+type SyncPushPayload struct {
+	Mutations []SyncMutation `json:"mutations"`
+}
+
+// SyncMutation is a single client change. MutationID is chosen by the client
+// and makes the mutation idempotent: a mutation that was already applied is
+// reported as applied again without being repeated.
+type SyncMutation struct {
+	MutationID  string          `json:"mutationId"`
+	Kind        string          `json:"kind"`
+	Op          string          `json:"op"`          // "create", "update" or "delete"
+	ID          int             `json:"id"`          // The record to update or delete
+	BaseVersion *int            `json:"baseVersion"` // Version the client last saw; stale updates and deletes conflict
+	Data        json.RawMessage `json:"data"`        // The create or update payload for the kind
+
+	// Items created offline may belong to a list or parent item that was also
+	// created offline. These name the mutation that created it instead of its ID.
+	ListID           *int   `json:"listId"`
+	ListMutationID   string `json:"listMutationId"`
+	ParentMutationID string `json:"parentMutationId"`
+}
+
+// SyncMutationResult reports the outcome of one mutation.
+type SyncMutationResult struct {
+	MutationID string      `json:"mutationId"`
+	Status     string      `json:"status"` // "applied", "conflict" or "error"
+	Kind       string      `json:"kind"`
+	ID         int         `json:"id,omitempty"`
+	Version    int         `json:"version,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Current    interface{} `json:"current,omitempty"` // The server's copy, on conflict
+}
+
+// SyncPushResult reports the outcome of each mutation in a push, in order.
+type SyncPushResult struct {
+	Results []SyncMutationResult `json:"results"`
+}
+
+// ChangeEvent is one change pushed to a client over the event stream.
+type ChangeEvent struct {
+	Cursor string      `json:"cursor"` // Resume after this event by passing it as Last-Event-ID
+	Kind   string      `json:"kind"`
+	Op     string      `json:"op"` // "created", "updated" or "deleted"
+	ID     int         `json:"id"`
+	Record interface{} `json:"record,omitempty"` // The record as it is now; absent for deletions
+}
@@ -3,34 +3,151 @@ package types
 import "time"
 
 type TodoList struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"userId"`
-	Title     string    `json:"title"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID         int       `json:"id"`
+	UserID     int       `json:"userId"`
+	Title      string    `json:"title"`
+	Color      *string   `json:"color"` // "#rrggbb"; nil for the default
+	Icon       *string   `json:"icon"`  // An icon name or emoji chosen by the client
+	IsArchived bool      `json:"isArchived"`
+	Position   int       `json:"position"` // Lists are shown by position, lowest first
+	Version    int       `json:"version"`
+	CreatedAt  time.Time `json:"createdAt"`
 }
 
 type TodoItem struct {
 	ID          int        `json:"id"`
 	ListID      int        `json:"listId"`
+	ParentID    *int       `json:"parentId"` // Nil for top-level items
 	Task        string     `json:"task"`
+	Description *string    `json:"description,omitempty"` // Free-text notes on the task
 	IsCompleted bool       `json:"isCompleted"`
-	DueDate     *time.Time `json:"dueDate,omitempty"` // Use a pointer for optional fields
-	Priority    int        `json:"priority"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"` // When it was completed; nil while open
+	DueDate     *time.Time `json:"dueDate,omitempty"`     // Use a pointer for optional fields
+	DueTime     *string    `json:"dueTime,omitempty"`     // Time of day it is due, "15:04"; reminders read it in their own timezone
+	Priority    int        `json:"priority"`              // 0 (none) to MaxPriority (high)
+	Rank        string     `json:"rank"`                  // Sorts the item within its list when arranged by hand
+	Tags        []string   `json:"tags"`                  // Tag names, shared with notes
+	Version     int        `json:"version"`
 	CreatedAt   time.Time  `json:"createdAt"`
+
+	// Recurrence, for items that roll forward to a new due date when completed.
+	RecurrenceRule  *string    `json:"recurrenceRule"`            // RFC 5545 RRULE, e.g. FREQ=WEEKLY;BYDAY=MO,TH
+	RecurrenceStart *time.Time `json:"recurrenceStart,omitempty"` // Due date of the first occurrence
+	RecurrenceIndex int        `json:"recurrenceIndex"`           // 1-based number of the current occurrence
+
+	// Subtask rollup, filled in by the handler from the direct children of the item.
+	SubtaskCount          int        `json:"subtaskCount"`
+	CompletedSubtaskCount int        `json:"completedSubtaskCount"`
+	Progress              *float64   `json:"progress,omitempty"` // Completed fraction of subtasks; nil without subtasks
+	Subtasks              []TodoItem `json:"subtasks,omitempty"` // Only populated when the tree is requested
+}
+
+// TodoItemOccurrence records one finished occurrence of a recurring item.
+type TodoItemOccurrence struct {
+	ID              int       `json:"id"`
+	ItemID          int       `json:"itemId"`
+	OccurrenceIndex int       `json:"occurrenceIndex"`
+	DueDate         time.Time `json:"dueDate"`
+	Status          string    `json:"status"` // "completed" or "skipped"
+	RecordedAt      time.Time `json:"recordedAt"`
 }
 
 // Payloads for creating data
 type CreateTodoListPayload struct {
-	Title string `json:"title"`
+	Title string  `json:"title"`
+	Color *string `json:"color"`
+	Icon  *string `json:"icon"`
+}
+
+// Payload for updating a todo list. Only the fields given are changed.
+type UpdateTodoListPayload struct {
+	Title *string `json:"title"`
+	// Color and Icon are cleared by an empty string.
+	Color      *string `json:"color"`
+	Icon       *string `json:"icon"`
+	IsArchived *bool   `json:"isArchived"`
+	Position   *int    `json:"position"`
+}
+
+// ReorderTodoListsPayload gives the order of the user's lists. Lists left
+// out keep their order after the ones given.
+type ReorderTodoListsPayload struct {
+	ListIDs []int `json:"listIds"`
+}
+
+// MaxPriority is the highest priority an item can have; 0 is none.
+const MaxPriority = 3
+
+// Orders a list's items can be returned in.
+const (
+	ItemSortManual   = "manual"   // As arranged by hand
+	ItemSortDue      = "due"      // Soonest due first, items without a due date last
+	ItemSortPriority = "priority" // Highest priority first
+	ItemSortCreated  = "created"  // Oldest first
+)
+
+// Built-in task views, which pick items across all of a user's lists by their
+// due date in the user's timezone.
+const (
+	TaskViewToday    = "today"    // Due today
+	TaskViewUpcoming = "upcoming" // Due today or in the six days after, grouped by day
+	TaskViewOverdue  = "overdue"  // Past their due date, or their due time today
+	TaskViewNoDate   = "no-date"  // Without a due date
+)
+
+// Task is an item as it appears in a view across lists.
+type Task struct {
+	TodoItem
+	ListTitle string `json:"listTitle"`
+}
+
+// TaskDay is the tasks of the upcoming view that are due on one day.
+type TaskDay struct {
+	Date  string `json:"date"` // "2006-01-02"
+	Tasks []Task `json:"tasks"`
 }
 
 type CreateTodoItemPayload struct {
-	Task    string     `json:"task"`
-	DueDate *time.Time `json:"dueDate"`
+	Task           string     `json:"task"`
+	Description    *string    `json:"description"`
+	Priority       int        `json:"priority"`
+	DueDate        *time.Time `json:"dueDate"`
+	DueTime        *string    `json:"dueTime"`
+	ParentID       *int       `json:"parentId"`
+	RecurrenceRule *string    `json:"recurrenceRule"`
+	Tags           []string   `json:"tags"` // Tag names; missing tags are created
 }
 
 // Payload for updating a todo item
 type UpdateTodoItemPayload struct {
 	Task        *string `json:"task"`
 	IsCompleted *bool   `json:"isCompleted"`
+	// CompletedAt records when the item was completed, for completions made
+	// offline. It completes the item if it isn't already.
+	CompletedAt *time.Time `json:"completedAt"`
+	// Description is cleared by an empty string.
+	Description *string `json:"description"`
+	Priority    *int    `json:"priority"`
+	// DueDate sets the day the item is due; null clears it and its due time.
+	// Moving a recurring item restarts its series from the new date.
+	DueDate Optional[time.Time] `json:"dueDate"`
+	// DueTime sets the time of day the item is due; an empty string clears it.
+	DueTime *string `json:"dueTime"`
+	// ListID moves the item and its subtasks to another of the user's lists.
+	// A subtask moved on its own becomes a top-level item there.
+	ListID *int `json:"listId"`
+	// RecurrenceRule replaces the item's rule and restarts the series from its due date.
+	RecurrenceRule *string `json:"recurrenceRule"`
+	// Tags replaces all of the item's tags when present.
+	Tags *[]string `json:"tags"`
+	// CompleteSubtasks marks every descendant complete as well when IsCompleted is true.
+	CompleteSubtasks bool `json:"completeSubtasks"`
+}
+
+// MoveTodoItemPayload puts an item right before or after another item in its
+// list, or at the end of the list if neither is given.
+type MoveTodoItemPayload struct {
+	ItemID   int  `json:"itemId"`
+	BeforeID *int `json:"beforeId"`
+	AfterID  *int `json:"afterId"`
 }
@@ -3,12 +3,14 @@ package types
 import "time"
 
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email"`
-	Password     string    `json:"-"` // Omit password from JSON responses
-	PasswordHash string    `json:"-"` // Omit hash from JSON responses
-	CreatedAt    time.Time `json:"createdAt"`
+	ID               int        `json:"id"`
+	Username         string     `json:"username"`
+	Email            string     `json:"email"`
+	Password         string     `json:"-"`               // Omit password from JSON responses
+	PasswordHash     string     `json:"-"`               // Omit hash from JSON responses
+	EmailVerifiedAt  *time.Time `json:"emailVerifiedAt"` // Nil until the user follows a verification link
+	TwoFactorEnabled bool       `json:"twoFactorEnabled"`
+	CreatedAt        time.Time  `json:"createdAt"`
 }
 
 type RegisterUserPayload struct {
@@ -16,3 +18,74 @@ type RegisterUserPayload struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+
+type SearchLanguagePayload struct {
+	Language string `json:"language"` // A PostgreSQL text search configuration, e.g. "english" or "german"
+}
+
+// RevisionRetentionPayload controls how much note history is kept. The newest
+// revision of a note is always kept.
+type RevisionRetentionPayload struct {
+	MaxRevisions int  `json:"maxRevisions"` // Revisions kept per note
+	MaxAgeDays   *int `json:"maxAgeDays"`   // Revisions older than this are pruned; null keeps them forever
+}
+
+// VerifyEmailPayload confirms an email address with the token from a verification email.
+type VerifyEmailPayload struct {
+	Token string `json:"token"`
+}
+
+// ForgotPasswordPayload asks for a password reset email.
+type ForgotPasswordPayload struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordPayload sets a new password with the token from a reset email.
+type ResetPasswordPayload struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Profile is the user's own view of their account.
+type Profile struct {
+	ID                  int        `json:"id"`
+	Username            string     `json:"username"`
+	Email               string     `json:"email"`
+	EmailVerifiedAt     *time.Time `json:"emailVerifiedAt"`
+	PendingEmail        *string    `json:"pendingEmail"` // A new address waiting to be confirmed
+	DisplayName         *string    `json:"displayName"`
+	Timezone            string     `json:"timezone"` // IANA zone, e.g. "Europe/Berlin"
+	Locale              string     `json:"locale"`   // BCP 47 language tag, e.g. "en-GB"
+	HasPassword         bool       `json:"hasPassword"`
+	TwoFactorEnabled    bool       `json:"twoFactorEnabled"`
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt"` // Set while the account is waiting to be deleted
+	CreatedAt           time.Time  `json:"createdAt"`
+}
+
+// UpdateProfilePayload changes the fields that are set. An empty displayName
+// clears it. A new email only replaces the current one once it is confirmed.
+type UpdateProfilePayload struct {
+	Username    *string `json:"username"`
+	Email       *string `json:"email"`
+	DisplayName *string `json:"displayName"`
+	Timezone    *string `json:"timezone"`
+	Locale      *string `json:"locale"`
+}
+
+// ChangePasswordPayload sets a new password. CurrentPassword may be left out
+// by users who don't have a password yet.
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// DeleteAccountPayload confirms deleting the account with the user's password,
+// if they have one.
+type DeleteAccountPayload struct {
+	Password string `json:"password"`
+}
+
+// AccountDeletion reports when a deleted account will be purged.
+type AccountDeletion struct {
+	DeletionScheduledAt time.Time `json:"deletionScheduledAt"`
+}
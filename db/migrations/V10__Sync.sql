@@ -0,0 +1,103 @@
+-- Delta Sync
+-- Every insert or update of a list, item, note or journal entry stamps the row
+-- with the next value of sync_seq, and every delete leaves a tombstone stamped
+-- the same way. A client that remembers the highest change_seq it has seen can
+-- then ask for everything after it.
+CREATE SEQUENCE sync_seq;
+
+-- sync_next_seq draws the next sequence value for a change to one of the
+-- user's records. It first takes a per-user lock held until the transaction
+-- ends, so a user's changes commit in sequence order: a reader that has seen
+-- change N can never later find a change below N committed after it looked.
+CREATE FUNCTION sync_next_seq(owner INTEGER) RETURNS BIGINT AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('sync'), owner);
+    RETURN nextval('sync_seq');
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE todo_lists ADD COLUMN change_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE todo_items ADD COLUMN change_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE notes ADD COLUMN change_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE journal_entries ADD COLUMN change_seq BIGINT NOT NULL DEFAULT 0;
+
+UPDATE todo_lists SET change_seq = nextval('sync_seq');
+UPDATE todo_items SET change_seq = nextval('sync_seq');
+UPDATE notes SET change_seq = nextval('sync_seq');
+UPDATE journal_entries SET change_seq = nextval('sync_seq');
+
+CREATE INDEX idx_todo_lists_change_seq ON todo_lists(user_id, change_seq);
+CREATE INDEX idx_todo_items_change_seq ON todo_items(change_seq);
+CREATE INDEX idx_notes_change_seq ON notes(user_id, change_seq);
+CREATE INDEX idx_journal_entries_change_seq ON journal_entries(user_id, change_seq);
+
+-- Sync Tombstones Table
+-- No foreign key to users: tombstones are written while a user's records are
+-- being deleted, and are skipped entirely when the user themselves is.
+CREATE TABLE sync_tombstones (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    kind VARCHAR(16) NOT NULL, -- 'list', 'item', 'note' or 'journal'
+    record_id INTEGER NOT NULL,
+    change_seq BIGINT NOT NULL,
+    deleted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_sync_tombstones_user_seq ON sync_tombstones(user_id, change_seq);
+
+-- Tracks changes to tables with a user_id column. TG_ARGV[0] is the sync kind.
+CREATE FUNCTION sync_track_owned() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF EXISTS (SELECT 1 FROM users WHERE id = OLD.user_id) THEN
+            INSERT INTO sync_tombstones (user_id, kind, record_id, change_seq)
+            VALUES (OLD.user_id, TG_ARGV[0], OLD.id, sync_next_seq(OLD.user_id));
+        END IF;
+        RETURN OLD;
+    END IF;
+    NEW.change_seq := sync_next_seq(NEW.user_id);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Tracks changes to to-do items, which find their owner through their list.
+-- Items deleted along with their list get no tombstones of their own: by the
+-- time they go the list is already gone, and its tombstone covers them.
+CREATE FUNCTION sync_track_item() RETURNS TRIGGER AS $$
+DECLARE
+    owner INTEGER;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        SELECT user_id INTO owner FROM todo_lists WHERE id = OLD.list_id;
+        IF owner IS NOT NULL THEN
+            INSERT INTO sync_tombstones (user_id, kind, record_id, change_seq)
+            VALUES (owner, 'item', OLD.id, sync_next_seq(owner));
+        END IF;
+        RETURN OLD;
+    END IF;
+    SELECT user_id INTO owner FROM todo_lists WHERE id = NEW.list_id;
+    NEW.change_seq := sync_next_seq(owner);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER todo_lists_sync BEFORE INSERT OR UPDATE OR DELETE ON todo_lists
+    FOR EACH ROW EXECUTE FUNCTION sync_track_owned('list');
+CREATE TRIGGER todo_items_sync BEFORE INSERT OR UPDATE OR DELETE ON todo_items
+    FOR EACH ROW EXECUTE FUNCTION sync_track_item();
+CREATE TRIGGER notes_sync BEFORE INSERT OR UPDATE OR DELETE ON notes
+    FOR EACH ROW EXECUTE FUNCTION sync_track_owned('note');
+CREATE TRIGGER journal_entries_sync BEFORE INSERT OR UPDATE OR DELETE ON journal_entries
+    FOR EACH ROW EXECUTE FUNCTION sync_track_owned('journal');
+
+-- Sync Mutations Table
+-- Client mutations that have been applied, keyed by the client's mutation ID,
+-- so a batch retried after a dropped response is not applied twice.
+CREATE TABLE sync_mutations (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    mutation_id VARCHAR(64) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    record_id INTEGER NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, mutation_id)
+);
@@ -0,0 +1,40 @@
+-- Change Notifications
+-- The sync triggers also NOTIFY tempo_changes with the owner's user ID, so
+-- every backend instance can wake the event streams open for that user. The
+-- payload is only a wake-up call: streams read what changed from the sync
+-- feed, and notifications are delivered on commit, never for rolled-back work.
+CREATE OR REPLACE FUNCTION sync_track_owned() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF EXISTS (SELECT 1 FROM users WHERE id = OLD.user_id) THEN
+            INSERT INTO sync_tombstones (user_id, kind, record_id, change_seq)
+            VALUES (OLD.user_id, TG_ARGV[0], OLD.id, sync_next_seq(OLD.user_id));
+            PERFORM pg_notify('tempo_changes', OLD.user_id::text);
+        END IF;
+        RETURN OLD;
+    END IF;
+    NEW.change_seq := sync_next_seq(NEW.user_id);
+    PERFORM pg_notify('tempo_changes', NEW.user_id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION sync_track_item() RETURNS TRIGGER AS $$
+DECLARE
+    owner INTEGER;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        SELECT user_id INTO owner FROM todo_lists WHERE id = OLD.list_id;
+        IF owner IS NOT NULL THEN
+            INSERT INTO sync_tombstones (user_id, kind, record_id, change_seq)
+            VALUES (owner, 'item', OLD.id, sync_next_seq(owner));
+            PERFORM pg_notify('tempo_changes', owner::text);
+        END IF;
+        RETURN OLD;
+    END IF;
+    SELECT user_id INTO owner FROM todo_lists WHERE id = NEW.list_id;
+    NEW.change_seq := sync_next_seq(owner);
+    PERFORM pg_notify('tempo_changes', owner::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
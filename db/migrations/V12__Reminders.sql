@@ -0,0 +1,66 @@
+-- Due Times
+-- An optional time of day for an item's due date. It has no timezone of its
+-- own: each reminder reads the due date and time in its own timezone.
+ALTER TABLE todo_items ADD COLUMN due_time TIME;
+
+-- Reminders Table
+-- A reminder fires either at a fixed instant (remind_at) or a number of minutes
+-- before the item is due (offset_minutes). fire_at is when it next fires; for
+-- offset reminders it is recomputed whenever the item's due date or time moves,
+-- which re-arms them for each occurrence of a recurring item.
+CREATE TABLE reminders (
+    id SERIAL PRIMARY KEY,
+    item_id INTEGER NOT NULL REFERENCES todo_items(id) ON DELETE CASCADE,
+    remind_at TIMESTAMP WITH TIME ZONE,
+    offset_minutes INTEGER CHECK (offset_minutes >= 0),
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    channels TEXT[] NOT NULL DEFAULT '{in_app}',
+    fire_at TIMESTAMP WITH TIME ZONE, -- NULL while an offset reminder's item has no due date
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'fired', 'skipped')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((remind_at IS NULL) <> (offset_minutes IS NULL))
+);
+
+CREATE INDEX idx_reminders_item_id ON reminders(item_id);
+CREATE INDEX idx_reminders_due ON reminders(fire_at) WHERE status = 'pending';
+
+-- Reminder Deliveries Table
+-- One row per channel each time a reminder fires. The scheduler claims pending
+-- rows by pushing next_attempt_at out by a lease, so a delivery abandoned by a
+-- crashed instance is picked up again once the lease runs out.
+CREATE TABLE reminder_deliveries (
+    id SERIAL PRIMARY KEY,
+    reminder_id INTEGER NOT NULL REFERENCES reminders(id) ON DELETE CASCADE,
+    channel VARCHAR(16) NOT NULL,
+    fire_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (reminder_id, channel, fire_at)
+);
+
+CREATE INDEX idx_reminder_deliveries_due ON reminder_deliveries(next_attempt_at) WHERE status = 'pending';
+
+-- Notifications Table
+-- The in-app notification feed. delivery_id makes delivering into the feed
+-- idempotent when a delivery is retried.
+CREATE TABLE notifications (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(32) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL DEFAULT '',
+    item_id INTEGER REFERENCES todo_items(id) ON DELETE SET NULL,
+    delivery_id INTEGER UNIQUE REFERENCES reminder_deliveries(id) ON DELETE SET NULL,
+    read_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_notifications_user ON notifications(user_id, created_at DESC);
+
+-- Where webhook reminders are posted, and the secret they are signed with.
+ALTER TABLE users ADD COLUMN reminder_webhook_url TEXT;
+ALTER TABLE users ADD COLUMN reminder_webhook_secret VARCHAR(64);
@@ -0,0 +1,22 @@
+-- Email Verification
+-- Set once the user has followed a verification link sent to their address.
+-- Accounts created before verification existed start out unverified: nobody
+-- has shown they own the address, and identity provider logins only link to
+-- verified accounts.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+-- User Tokens Table
+-- Single-use tokens emailed to the user, stored as the SHA-256 of the token
+-- like refresh tokens. A verification token is only good for the address it
+-- was sent to, in case the user changes their email in the meantime.
+CREATE TABLE user_tokens (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(32) NOT NULL CHECK (purpose IN ('verify_email', 'reset_password')),
+    email VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_user_tokens_user_purpose ON user_tokens(user_id, purpose, created_at);
@@ -0,0 +1,33 @@
+-- Two-Factor Authentication
+-- totp_secret is set when the user starts enrolling and totp_enabled_at once
+-- they have proven their authenticator works. totp_last_counter is the time
+-- step of the last code accepted, so a code can't be replayed.
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN totp_last_counter BIGINT NOT NULL DEFAULT 0;
+
+-- Recovery Codes Table
+-- Single-use codes for logging in without the authenticator, stored as SHA-256
+-- hashes. A fresh set replaces the old one.
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
+
+-- Login Challenges Table
+-- The first step of a two-factor login: the password was right, and the
+-- holder of the challenge token has a few attempts to supply a code before it
+-- expires.
+CREATE TABLE login_challenges (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_name VARCHAR(255),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
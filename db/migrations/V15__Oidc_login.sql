@@ -0,0 +1,45 @@
+-- Accounts created through an identity provider have no password until the
+-- user sets one with a password reset link.
+ALTER TABLE users ALTER COLUMN password_hash DROP NOT NULL;
+
+-- User Identities Table
+-- Accounts at OpenID Connect providers that can log in as a user. subject is
+-- the provider's ID for the account, which unlike the email never changes.
+CREATE TABLE user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    last_login_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+-- OIDC Logins Table
+-- Logins in progress at a provider, keyed by the hash of the state parameter
+-- and consumed by the callback. link_user_id is set when a logged-in user is
+-- linking the identity rather than logging in with it.
+CREATE TABLE oidc_logins (
+    state_hash CHAR(64) PRIMARY KEY,
+    provider VARCHAR(64) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    redirect_path TEXT NOT NULL DEFAULT '/',
+    link_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Login Codes Table
+-- Short-lived, single-use codes that hand a finished provider login from the
+-- callback redirect to the web app, which exchanges one for a session, so
+-- tokens never appear in a URL.
+CREATE TABLE login_codes (
+    code_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
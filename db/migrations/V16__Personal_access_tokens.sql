@@ -0,0 +1,18 @@
+-- Personal Access Tokens Table
+-- Long-lived tokens users create for scripts and integrations, stored as
+-- SHA-256 hashes. token_prefix is the start of the token, kept so users can
+-- tell their tokens apart. Each token only reaches the resources its scopes
+-- allow, e.g. {todos:read,notes:write}.
+CREATE TABLE personal_access_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_prefix VARCHAR(32) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE, -- NULL for tokens that never expire
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_personal_access_tokens_user_id ON personal_access_tokens(user_id);
@@ -0,0 +1,18 @@
+-- Profile
+-- How the user is shown, and the timezone and locale clients format their
+-- dates and text in.
+ALTER TABLE users ADD COLUMN display_name VARCHAR(100);
+ALTER TABLE users ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+ALTER TABLE users ADD COLUMN locale VARCHAR(35) NOT NULL DEFAULT 'en';
+
+-- Account Deletion
+-- A deleted account is kept until deletion_scheduled_at, so the user can
+-- change their mind, and then purged with everything it owns.
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX idx_users_deletion_scheduled_at ON users(deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
+
+-- Changing email sends a token to the new address, which becomes the user's
+-- email once the token is used.
+ALTER TABLE user_tokens DROP CONSTRAINT user_tokens_purpose_check;
+ALTER TABLE user_tokens ADD CONSTRAINT user_tokens_purpose_check
+    CHECK (purpose IN ('verify_email', 'reset_password', 'change_email'));
@@ -0,0 +1,31 @@
+-- Data Exports Table
+-- A ZIP archive of everything a user owns, built in the background. Any
+-- backend instance may build an export: it claims the row until lease_until,
+-- and another instance takes over if that passes without the export
+-- finishing. Finished archives are deleted once expires_at has passed.
+CREATE TABLE data_exports (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'ready', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    lease_until TIMESTAMP WITH TIME ZONE,
+    size_bytes BIGINT,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_data_exports_user_id ON data_exports(user_id);
+CREATE INDEX idx_data_exports_queue ON data_exports(created_at) WHERE status IN ('pending', 'running');
+
+-- Data Export Chunks Table
+-- The archive itself, in order of seq. Keeping it in the database lets any
+-- instance serve the download without loading it all into memory.
+CREATE TABLE data_export_chunks (
+    export_id INTEGER NOT NULL REFERENCES data_exports(id) ON DELETE CASCADE,
+    seq INTEGER NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (export_id, seq)
+);
@@ -0,0 +1,29 @@
+-- Note Attachments Table
+-- Files attached to a note, such as the images embedded in an imported
+-- Markdown note. They are deleted along with the note.
+CREATE TABLE note_attachments (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_note_attachments_note_id ON note_attachments(note_id);
+
+-- Note Imports Table
+-- Records each imported file by the SHA-256 hash of its contents, so
+-- importing the same file again is skipped for as long as its note exists.
+CREATE TABLE note_imports (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    content_hash CHAR(64) NOT NULL,
+    note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    source_path TEXT NOT NULL,
+    imported_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, content_hash)
+);
+
+CREATE INDEX idx_note_imports_note_id ON note_imports(note_id);
@@ -0,0 +1,24 @@
+-- Calendar Feeds Table
+-- Each user may have one secret feed URL that calendar apps subscribe to
+-- without logging in. Only the SHA-256 hash of its token is stored, so a new
+-- token replaces the old one and the URL is only shown when it is created.
+CREATE TABLE calendar_feeds (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    token_prefix VARCHAR(32) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Calendar Imports Table
+-- Records the UID of each imported calendar entry, so importing the same
+-- calendar again skips entries for as long as their items exist.
+CREATE TABLE calendar_imports (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    uid TEXT NOT NULL,
+    item_id INTEGER NOT NULL REFERENCES todo_items(id) ON DELETE CASCADE,
+    imported_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, uid)
+);
+
+CREATE INDEX idx_calendar_imports_item_id ON calendar_imports(item_id);
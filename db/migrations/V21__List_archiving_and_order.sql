@@ -0,0 +1,14 @@
+-- List Appearance, Archiving and Order
+-- Lists can have a color and an icon, be archived out of the way without
+-- being deleted, and be arranged by hand. A user's lists are shown by
+-- position, lowest first; existing lists keep their newest-first order.
+ALTER TABLE todo_lists ADD COLUMN color VARCHAR(7); -- "#rrggbb"
+ALTER TABLE todo_lists ADD COLUMN icon VARCHAR(64);
+ALTER TABLE todo_lists ADD COLUMN is_archived BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE todo_lists ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+
+UPDATE todo_lists tl SET position = ordered.n
+FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY created_at DESC, id DESC) - 1 AS n FROM todo_lists) ordered
+WHERE ordered.id = tl.id;
+
+CREATE INDEX idx_todo_lists_user_position ON todo_lists(user_id, position);
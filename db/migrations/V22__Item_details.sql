@@ -0,0 +1,26 @@
+-- Item Details
+-- Items can carry a free-text description and record when they were
+-- completed. Priorities run from 0 (none) to 3 (high).
+ALTER TABLE todo_items ADD COLUMN description TEXT;
+ALTER TABLE todo_items ADD COLUMN completed_at TIMESTAMP WITH TIME ZONE;
+-- Priorities were unchecked before, so bring any outside the range into it
+-- first; otherwise adding the constraint fails on them.
+UPDATE todo_items SET priority = LEAST(GREATEST(priority, 0), 3) WHERE priority NOT BETWEEN 0 AND 3;
+ALTER TABLE todo_items ADD CONSTRAINT todo_items_priority_range CHECK (priority BETWEEN 0 AND 3);
+
+-- Keeps completed_at in step with is_completed whoever writes the item:
+-- completing an item stamps it unless the writer gave a time, and reopening
+-- clears it. Items completed before this migration keep no time.
+CREATE FUNCTION todo_items_track_completion() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.is_completed IS NOT TRUE THEN
+        NEW.completed_at := NULL;
+    ELSIF NEW.completed_at IS NULL AND (TG_OP = 'INSERT' OR OLD.is_completed IS NOT TRUE) THEN
+        NEW.completed_at := CURRENT_TIMESTAMP;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER todo_items_completion BEFORE INSERT OR UPDATE ON todo_items
+    FOR EACH ROW EXECUTE FUNCTION todo_items_track_completion();
@@ -0,0 +1,14 @@
+-- Item Ranks
+-- Items are arranged by hand through a rank: a string that sorts them within
+-- their list, compared byte by byte. Moving an item gives it a rank between
+-- its new neighbours, so no other item changes. Existing items keep the order
+-- they were created in.
+ALTER TABLE todo_items ADD COLUMN rank TEXT COLLATE "C";
+
+UPDATE todo_items ti SET rank = lpad(ordered.n::text, 8, '0') || 'V'
+FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY list_id ORDER BY created_at, id) AS n FROM todo_items) ordered
+WHERE ordered.id = ti.id;
+
+ALTER TABLE todo_items ALTER COLUMN rank SET NOT NULL;
+
+CREATE INDEX idx_todo_items_list_rank ON todo_items(list_id, rank);
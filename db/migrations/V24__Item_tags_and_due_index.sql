@@ -0,0 +1,18 @@
+-- Item Tags
+-- Items share the user's tags with notes, so one tag can gather both.
+CREATE TABLE todo_item_tags (
+    item_id INTEGER NOT NULL REFERENCES todo_items(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (item_id, tag_id)
+);
+
+CREATE INDEX idx_todo_item_tags_tag_id ON todo_item_tags(tag_id);
+
+-- Tags now gather items as well as notes, so they get scopes of their own.
+-- Tokens that reached tags through their notes scopes keep that access.
+UPDATE personal_access_tokens SET scopes = scopes || 'tags:write'::TEXT WHERE 'notes:write' = ANY(scopes);
+UPDATE personal_access_tokens SET scopes = scopes || 'tags:read'::TEXT
+WHERE 'notes:read' = ANY(scopes) AND NOT 'notes:write' = ANY(scopes);
+
+-- Task views look up a user's items by due date across all of their lists.
+CREATE INDEX idx_todo_items_due_date ON todo_items(due_date) WHERE due_date IS NOT NULL;
@@ -0,0 +1,6 @@
+-- Subtasks
+-- An item with a parent_id is a subtask of that item. Subtasks always live in
+-- the same list as their parent and are deleted along with it.
+ALTER TABLE todo_items ADD COLUMN parent_id INTEGER REFERENCES todo_items(id) ON DELETE CASCADE;
+
+CREATE INDEX idx_todo_items_parent_id ON todo_items(parent_id);
@@ -0,0 +1,20 @@
+-- Recurring Tasks
+-- A recurring item is a single row that rolls forward: completing or skipping
+-- it moves due_date to the next occurrence and records the finished one in
+-- todo_item_occurrences. recurrence_start is the DTSTART the rule is anchored
+-- to and recurrence_index the 1-based number of the current occurrence.
+ALTER TABLE todo_items ADD COLUMN recurrence_rule TEXT;
+ALTER TABLE todo_items ADD COLUMN recurrence_start DATE;
+ALTER TABLE todo_items ADD COLUMN recurrence_index INTEGER NOT NULL DEFAULT 1;
+
+-- Occurrence History Table
+CREATE TABLE todo_item_occurrences (
+    id SERIAL PRIMARY KEY,
+    item_id INTEGER NOT NULL REFERENCES todo_items(id) ON DELETE CASCADE,
+    occurrence_index INTEGER NOT NULL,
+    due_date DATE NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('completed', 'skipped')),
+    recorded_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_todo_item_occurrences_item_id ON todo_item_occurrences(item_id);
@@ -0,0 +1,14 @@
+-- Full-Text Search for Notes
+-- Each user picks the text search configuration (language) used for stemming
+-- their notes. The note keeps a copy so the generated column can use it; the
+-- store rewrites it on every note when the user changes language.
+ALTER TABLE users ADD COLUMN search_language REGCONFIG NOT NULL DEFAULT 'english';
+
+ALTER TABLE notes ADD COLUMN search_language REGCONFIG NOT NULL DEFAULT 'english';
+
+ALTER TABLE notes ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector(search_language, COALESCE(title, '')), 'A') ||
+    setweight(to_tsvector(search_language, COALESCE(content, '')), 'B')
+) STORED;
+
+CREATE INDEX idx_notes_search_vector ON notes USING GIN (search_vector);
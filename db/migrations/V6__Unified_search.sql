@@ -0,0 +1,27 @@
+-- Full-Text Search for Journal Entries and To-Do Items
+-- Same scheme as notes (V5): each row keeps a copy of its owner's search
+-- language so the generated search_vector can be stemmed in it.
+ALTER TABLE journal_entries ADD COLUMN search_language REGCONFIG NOT NULL DEFAULT 'english';
+
+ALTER TABLE journal_entries ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector(search_language, COALESCE(title, '')), 'A') ||
+    setweight(to_tsvector(search_language, COALESCE(content, '')), 'B')
+) STORED;
+
+CREATE INDEX idx_journal_entries_search_vector ON journal_entries USING GIN (search_vector);
+
+ALTER TABLE todo_items ADD COLUMN search_language REGCONFIG NOT NULL DEFAULT 'english';
+
+ALTER TABLE todo_items ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector(search_language, COALESCE(task, '')), 'A')
+) STORED;
+
+CREATE INDEX idx_todo_items_search_vector ON todo_items USING GIN (search_vector);
+
+-- Rows created before this migration take their owner's current language.
+UPDATE journal_entries j SET search_language = u.search_language
+FROM users u WHERE u.id = j.user_id AND j.search_language <> u.search_language;
+
+UPDATE todo_items ti SET search_language = u.search_language
+FROM todo_lists tl JOIN users u ON u.id = tl.user_id
+WHERE tl.id = ti.list_id AND ti.search_language <> u.search_language;
@@ -0,0 +1,49 @@
+-- Notebooks Table
+-- Every note lives in exactly one notebook. Each user has one default
+-- notebook (is_default) that new notes go to and that cannot be deleted.
+CREATE TABLE notebooks (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id INTEGER REFERENCES notebooks(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_notebooks_user_id ON notebooks(user_id);
+CREATE INDEX idx_notebooks_parent_id ON notebooks(parent_id);
+CREATE UNIQUE INDEX idx_notebooks_one_default ON notebooks(user_id) WHERE is_default;
+
+-- Existing notes move into a default notebook for their owner.
+INSERT INTO notebooks (user_id, name, is_default)
+SELECT id, 'Notebook', TRUE FROM users;
+
+ALTER TABLE notes ADD COLUMN notebook_id INTEGER REFERENCES notebooks(id);
+
+UPDATE notes n SET notebook_id = nb.id
+FROM notebooks nb WHERE nb.user_id = n.user_id AND nb.is_default;
+
+ALTER TABLE notes ALTER COLUMN notebook_id SET NOT NULL;
+
+CREATE INDEX idx_notes_notebook_id ON notes(notebook_id);
+
+-- Tags Table
+-- Tag names are unique per user, ignoring case.
+CREATE TABLE tags (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_tags_user_name ON tags(user_id, lower(name));
+
+-- Note Tags Table
+CREATE TABLE note_tags (
+    note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (note_id, tag_id)
+);
+
+CREATE INDEX idx_note_tags_tag_id ON note_tags(tag_id);
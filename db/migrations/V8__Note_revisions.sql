@@ -0,0 +1,23 @@
+-- Note Revision History
+-- Every save of a note's title or content is kept as a revision. The newest
+-- revision always matches the note itself; saves made shortly after one
+-- another are coalesced into a single revision by the store.
+CREATE TABLE note_revisions (
+    id SERIAL PRIMARY KEY,
+    note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    title VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL DEFAULT '',
+    restored_from INTEGER, -- The revision this one was restored from, if any
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_note_revisions_note_id ON note_revisions(note_id, id DESC);
+
+-- Existing notes start their history at their current state.
+INSERT INTO note_revisions (note_id, title, content, created_at)
+SELECT id, title, COALESCE(content, ''), COALESCE(updated_at, CURRENT_TIMESTAMP) FROM notes;
+
+-- Retention: how many revisions to keep per note, and optionally for how long.
+-- The newest revision is never pruned.
+ALTER TABLE users ADD COLUMN note_revision_limit INTEGER NOT NULL DEFAULT 50;
+ALTER TABLE users ADD COLUMN note_revision_max_age_days INTEGER;
@@ -0,0 +1,7 @@
+-- Row Versions
+-- A counter bumped by every update, used as the ETag for optimistic
+-- concurrency: clients send it back in If-Match and stale writes are refused.
+ALTER TABLE notes ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE journal_entries ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE todo_lists ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE todo_items ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -7,8 +7,18 @@ export const useApiFetch: typeof useFetch = (request, opts?) => {
     baseURL: config.public.apiBase,
     // cache request
     key: request as string,
-    // set user token if connected
-    headers: authStore.token ? { Authorization: `Bearer ${authStore.token}` } : {},
+
+    // set user token if connected, refreshing it first if it is about to expire
+    onRequest: async ({ options }) => {
+      try {
+        await authStore.ensureFreshToken();
+      } catch {
+        // Refresh failed; the request goes out unauthenticated and the 401 handler below logs out.
+      }
+      if (authStore.token) {
+        options.headers.set('Authorization', `Bearer ${authStore.token}`);
+      }
+    },
 
     onResponseError: ({ response }) => {
       if (response.status === 401) {
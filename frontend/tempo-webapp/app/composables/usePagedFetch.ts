@@ -0,0 +1,37 @@
+import { useAuthStore } from '~/stores/auth';
+
+// Loads a listing that comes a page at a time, as { items, nextCursor } or a
+// record with its items and nextCursor, and appends the next page on loadMore.
+export const usePagedFetch = async (path: string) => {
+  const config = useRuntimeConfig();
+  const authStore = useAuthStore();
+  const { data, pending, error, refresh } = await useApiFetch<any>(path, {
+    lazy: true,
+    server: false,
+  });
+
+  const loadingMore = ref(false);
+  const loadMore = async () => {
+    const cursor = data.value?.nextCursor;
+    if (!cursor || loadingMore.value) return;
+
+    loadingMore.value = true;
+    try {
+      await authStore.ensureFreshToken();
+      const page = await $fetch<any>(path, {
+        baseURL: config.public.apiBase,
+        query: { cursor },
+        headers: {
+          Authorization: `Bearer ${authStore.token}`
+        }
+      });
+      data.value = { ...page, items: [...data.value.items, ...page.items] };
+    } catch (err) {
+      console.error('Failed to load more:', err);
+    } finally {
+      loadingMore.value = false;
+    }
+  };
+
+  return { data, pending, error, refresh, loadMore, loadingMore };
+};
@@ -1,10 +1,28 @@
 import { defineStore } from 'pinia'
 
 export const useAuthStore = defineStore('auth', () => {
-  const token = useCookie('tempo-token', { maxAge: 60 * 60 * 24 * 3 }); // 3 days
+  const token = useCookie('tempo-token', { maxAge: 60 * 60 * 24 * 30 }); // 30 days, matches the refresh token
+  const refreshToken = useCookie('tempo-refresh-token', { maxAge: 60 * 60 * 24 * 30 });
+  const tokenExpiresAt = useCookie<number | null>('tempo-token-expires-at', { maxAge: 60 * 60 * 24 * 30 });
 
   const isLoggedIn = computed(() => !!token.value);
 
+  // Only one refresh may be in flight: refresh tokens are single use, so two
+  // concurrent refreshes would look like token reuse and revoke the session.
+  let refreshing: Promise<void> | null = null;
+
+  function setTokens(response: { token: string, refreshToken: string, expiresIn: number }) {
+    token.value = response.token
+    refreshToken.value = response.refreshToken
+    tokenExpiresAt.value = Date.now() + response.expiresIn * 1000
+  }
+
+  function clearTokens() {
+    token.value = null
+    refreshToken.value = null
+    tokenExpiresAt.value = null
+  }
+
   async function register(username, email, password) {
     const config = useRuntimeConfig()
     try {
@@ -22,20 +40,58 @@ export const useAuthStore = defineStore('auth', () => {
   async function login(email, password) {
     const config = useRuntimeConfig()
     try {
-      const response = await $fetch<{ token: string }>('/users/login', {
+      const response = await $fetch<{ token: string, refreshToken: string, expiresIn: number }>('/users/login', {
         baseURL: config.public.apiBase,
         method: 'POST',
-        body: { email, password },
+        body: { email, password, deviceName: 'Web browser' },
       })
-      token.value = response.token
+      setTokens(response)
     } catch (error) {
       const errorMsg = error.data?.message || 'An error occurred during login.';
       throw new Error(errorMsg);
     }
   }
 
-  function logout() {
-    token.value = null
+  async function refresh() {
+    if (!refreshing) {
+      const config = useRuntimeConfig()
+      refreshing = $fetch<{ token: string, refreshToken: string, expiresIn: number }>('/users/refresh', {
+        baseURL: config.public.apiBase,
+        method: 'POST',
+        body: { refreshToken: refreshToken.value },
+      })
+        .then(setTokens)
+        .catch((error) => {
+          clearTokens()
+          throw error
+        })
+        .finally(() => { refreshing = null })
+    }
+    return refreshing
+  }
+
+  // ensureFreshToken refreshes the access token if it expires within the next minute.
+  async function ensureFreshToken() {
+    if (!refreshToken.value) return
+    if (!tokenExpiresAt.value || tokenExpiresAt.value - Date.now() < 60 * 1000) {
+      await refresh()
+    }
+  }
+
+  async function logout() {
+    const config = useRuntimeConfig()
+    if (token.value) {
+      try {
+        await $fetch('/users/logout', {
+          baseURL: config.public.apiBase,
+          method: 'POST',
+          headers: { Authorization: `Bearer ${token.value}` },
+        })
+      } catch {
+        // The session may already be gone; clearing local state is what matters.
+      }
+    }
+    clearTokens()
   }
 
   return {
@@ -43,6 +99,8 @@ export const useAuthStore = defineStore('auth', () => {
     isLoggedIn,
     register,
     login,
+    refresh,
+    ensureFreshToken,
     logout,
   }
-})
\ No newline at end of file
+})